@@ -22,6 +22,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/netflix/hal-9001/hal"
@@ -29,12 +30,23 @@ import (
 
 type Config struct{}
 
+// simState holds the room/user the next typed line will appear to come
+// from. It's a pointer field on Broker so every copy of the broker (it's
+// passed around by value, same as the other brokers) shares the same
+// current room/user instead of each copy drifting independently.
+type simState struct {
+	mut  sync.Mutex
+	room string
+	user string
+}
+
 type Broker struct {
-	User   string
-	Room   string
+	User   string // the broker's own identity; see CurrentUser for the simulated one
+	Room   string // the broker's own name; see CurrentRoom for the simulated one
 	Topic  string
 	Stdin  chan string
 	Stdout chan string
+	sim    *simState
 }
 
 type SlashReaction string
@@ -50,15 +62,51 @@ func (c Config) NewBroker(name string) Broker {
 		Room:   name,
 		Stdin:  make(chan string, 1000),
 		Stdout: make(chan string, 1000),
+		sim:    &simState{room: name, user: user},
 	}
 
 	return out
 }
 
+// CurrentRoom returns the room simulated chat input is currently
+// attributed to, switchable at runtime with the "/room <id>" slash command.
+func (cb Broker) CurrentRoom() string {
+	cb.sim.mut.Lock()
+	defer cb.sim.mut.Unlock()
+	return cb.sim.room
+}
+
+// CurrentUser returns the user simulated chat input is currently
+// attributed to, switchable at runtime with the "/user <id>" slash command.
+func (cb Broker) CurrentUser() string {
+	cb.sim.mut.Lock()
+	defer cb.sim.mut.Unlock()
+	return cb.sim.user
+}
+
+func (cb Broker) setCurrentRoom(room string) {
+	cb.sim.mut.Lock()
+	defer cb.sim.mut.Unlock()
+	cb.sim.room = room
+}
+
+func (cb Broker) setCurrentUser(user string) {
+	cb.sim.mut.Lock()
+	defer cb.sim.mut.Unlock()
+	cb.sim.user = user
+}
+
 func (cb Broker) Name() string {
 	return cb.Room
 }
 
+// FormatRelayed implements hal.BrokerFormatter, rendering a message
+// bridged in from another broker with a utf8 box-drawing prefix so it's
+// visually distinct from locally-typed lines in a terminal.
+func (cb Broker) FormatRelayed(sourceBroker, sourceRoom, sourceUser, body string) string {
+	return fmt.Sprintf("┆ %s@%s: %s", sourceUser, sourceRoom, body)
+}
+
 func (cb Broker) Send(e hal.Evt) {
 	cb.Stdout <- e.Body
 }
@@ -77,8 +125,14 @@ func (cb Broker) SetTopic(roomId, topic string) error {
 	return nil
 }
 
+// PreferredTableFormat implements hal.TableFormatter: a terminal wants
+// the box-drawing format, same as the prior hardcoded hal.Utf8Table call.
+func (cb Broker) PreferredTableFormat() hal.TableFormat {
+	return hal.TableFormatUtf8
+}
+
 func (cb Broker) SendTable(e hal.Evt, hdr []string, rows [][]string) {
-	cb.Stdout <- hal.Utf8Table(hdr, rows)
+	cb.Stdout <- hal.RenderTable(cb.PreferredTableFormat(), hdr, rows)
 }
 
 func (cb Broker) LooksLikeRoomId(room string) bool {
@@ -130,12 +184,15 @@ func (cb Broker) Stream(out chan *hal.Evt) {
 		input := <-cb.Stdin
 		now := time.Now()
 
+		room := cb.CurrentRoom()
+		user := cb.CurrentUser()
+
 		e := hal.Evt{
 			ID:       fmt.Sprintf("%d.%06d", now.Unix(), now.UnixNano()),
-			User:     cb.User,
-			UserId:   cb.User,
-			Room:     cb.Room,
-			RoomId:   cb.Room,
+			User:     user,
+			UserId:   user,
+			Room:     room,
+			RoomId:   room,
 			Body:     input,
 			Time:     now,
 			Broker:   cb,
@@ -147,6 +204,7 @@ func (cb Broker) Stream(out chan *hal.Evt) {
 			args := e.BodyAsArgv()
 
 			// detect slash commands for creating specialized event types
+			// or acting on the simulator itself
 			switch args[0] {
 			case "/reaction":
 				if len(args) == 2 {
@@ -158,6 +216,22 @@ func (cb Broker) Stream(out chan *hal.Evt) {
 					e.IsChat = true
 					e.Reply("/reaction requires exactly one argument!")
 				}
+			case "/room":
+				if len(args) == 2 {
+					cb.setCurrentRoom(args[1])
+					cb.Stdout <- fmt.Sprintf("switched to room %q\n", args[1])
+				} else {
+					cb.Stdout <- fmt.Sprintf("current room: %q\n", room)
+				}
+			case "/user":
+				if len(args) == 2 {
+					cb.setCurrentUser(args[1])
+					cb.Stdout <- fmt.Sprintf("switched to user %q\n", args[1])
+				} else {
+					cb.Stdout <- fmt.Sprintf("current user: %q\n", user)
+				}
+			default:
+				cb.Stdout <- fmt.Sprintf("unrecognized slash command %q\n", args[0])
 			}
 		} else {
 			// everything else is just a plain chat event
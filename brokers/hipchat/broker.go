@@ -1,166 +1,397 @@
 package hipchat
 
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
 import (
+	"crypto/tls"
+	"fmt"
+	"html"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mattn/go-xmpp"
 	"github.com/netflix/hal-9001/hal"
 )
 
-// Broker contains the Hipchat API handles required for interacting
-// with the hipchat service.
+// HIPCHAT_HOST is hosted Hipchat's XMPP endpoint. It's only meaningful as
+// a Config.Host default for hosted Hipchat -- an on-prem Hipchat server or
+// any other XMPP/MUC server should set Host explicitly.
+const HIPCHAT_HOST = `chat.hipchat.com:5223`
+
+// Broker talks to any MUC-capable XMPP server, hosted Hipchat included.
+// It keeps two caches: rooms (the jid->nickname map it auto-joins on
+// connect/Subscribe) and roster (occupant jid<->nickname, filled in from
+// presence stanzas as they arrive -- see Stream).
 type Broker struct {
 	Client *xmpp.Client
 	inst   string
+	rooms  map[string]string  // room jid -> our nickname in that room, see Subscribe
+	roster *hal.IdentityCache // occupant jid <-> nickname, see ChannelIdToName/UserIdToName
+
+	// pendingIq/pendingMut back GetAvatar's request/reply round trip over
+	// the single shared connection Stream reads -- see routeIq.
+	pendingIq  map[string]chan xmpp.IQ
+	pendingMut *sync.Mutex
 }
 
+// Config describes how to connect to an XMPP server and which MUC rooms
+// to join. Host/StartTLS/TLSConfig/InsecureAllowPlain together cover both
+// hosted Hipchat (direct SSL, the zero-value behavior) and on-prem/other
+// XMPP servers that only offer STARTTLS or, in the worst case, nothing at
+// all.
 type Config struct {
-	Host     string
-	Jid      string
-	Password string
-	Channels map[string]string
+	Host     string            // "host:port", e.g. chat.hipchat.com:5223 or jabber.example.com:5222
+	Jid      string            // full JID to authenticate as
+	Password string            // password for Jid
+	Resource string            // XMPP resource; defaults to "bot"
+	Channels map[string]string // room jid -> nickname, joined automatically on connect
+
+	// StartTLS negotiates TLS on a plaintext connection instead of
+	// connecting directly over SSL (hosted Hipchat's default and still
+	// the zero-value behavior here). Needed for servers that only speak
+	// STARTTLS on the standard 5222 port.
+	StartTLS bool
+	// TLSConfig overrides the default TLS config used for SSL/STARTTLS,
+	// e.g. to trust a private CA or set ServerName for a server behind a
+	// name that doesn't match its cert.
+	TLSConfig *tls.Config
+	// InsecureAllowPlain allows authenticating with no TLS at all. Only
+	// for servers with no TLS support whatsoever; StartTLS is ignored
+	// when this is set.
+	InsecureAllowPlain bool
+
+	// Register, when true, makes NewBroker perform XEP-0077 in-band
+	// registration for RegisterUsername/RegisterPassword/RegisterEmail
+	// before logging in as Jid/Password -- lets hal bootstrap its own
+	// account on a server instead of requiring one to be created by hand
+	// first. RegisterUsername is usually the same localpart as Jid.
+	Register         bool
+	RegisterUsername string
+	RegisterPassword string
+	RegisterEmail    string
 }
 
-// HIPCHAT_HOST is the only supported hipchat host.
-const HIPCHAT_HOST = `chat.hipchat.com:5223`
+// NewBroker connects to the configured XMPP server and joins every room in
+// Channels. If StartTLS is set but the server doesn't advertise/accept it,
+// NewBroker falls back to a direct SSL connection once before giving up --
+// see the retry around options.NewClient below. If Register is set,
+// NewBroker registers RegisterUsername/RegisterPassword/RegisterEmail
+// (XEP-0077) before attempting to log in; a rejected registration (e.g. the
+// username is taken) comes back as a RegistrationError rather than crashing
+// the process, since registering is expected to fail on repeat runs once
+// the account already exists.
+func (c Config) NewBroker(name string) (Broker, error) {
+	if c.Register {
+		if err := c.register(); err != nil {
+			return Broker{}, err
+		}
+	}
 
-// Hipchat is a singleton that returns an initialized and connected
-// Broker. It can be called anywhere in the bot at any time.
-// Host must be "chat.hipchat.com:5223". This requirement can go away
-// once someone takes the time to integrate and test against an on-prem
-// Hipchat server.
-func (c Config) NewBroker(name string) Broker {
-	// TODO: remove this once the TLS/SSL requirements are sorted
-	if c.Host != HIPCHAT_HOST {
-		log.Println("TODO: Only SSL and hosted Hipchat are supported at the moment.")
-		log.Printf("Hipchat host must be %q.", HIPCHAT_HOST)
+	resource := c.Resource
+	if resource == "" {
+		resource = "bot"
 	}
 
-	// for some reason Go's STARTTLS seems to be incompatible with
-	// Hipchat's or maybe Hipchat TLS is broken, so don't bother and use SSL.
 	options := xmpp.Options{
-		Host:          c.Host,
-		User:          c.Jid,
-		Debug:         false,
-		Password:      c.Password,
-		Resource:      "bot",
-		Session:       true,
-		Status:        "Available",
-		StatusMessage: "Hal-9001 online.",
+		Host:                         c.Host,
+		User:                         c.Jid,
+		Password:                     c.Password,
+		Resource:                     resource,
+		NoTLS:                        c.InsecureAllowPlain,
+		StartTLS:                     c.StartTLS && !c.InsecureAllowPlain,
+		TLSConfig:                    c.TLSConfig,
+		InsecureAllowUnencryptedAuth: c.InsecureAllowPlain,
+		Session:                      true,
+		Status:                       "Available",
+		StatusMessage:                "Hal-9001 online.",
 	}
 
 	client, err := options.NewClient()
+	if err != nil && options.StartTLS {
+		log.Printf("STARTTLS to %q failed (%s), retrying over direct SSL", c.Host, err)
+		options.StartTLS = false
+		client, err = options.NewClient()
+	}
 	if err != nil {
-		log.Fatalf("Could not connect to Hipchat over XMPP: %s\n", err)
+		log.Fatalf("Could not connect to %q over XMPP: %s\n", c.Host, err)
 	}
 
-	for jid, name := range c.Channels {
-		client.JoinMUC(jid, name)
+	hb := Broker{
+		Client:     client,
+		inst:       name,
+		rooms:      make(map[string]string, len(c.Channels)),
+		roster:     &hal.IdentityCache{}, // no TTL: presence stanzas keep it current
+		pendingIq:  make(map[string]chan xmpp.IQ),
+		pendingMut: &sync.Mutex{},
 	}
 
-	hb := Broker{
-		Client: client,
-		inst:   name,
+	for jid, nick := range c.Channels {
+		hb.Subscribe(jid, nick)
 	}
 
-	return hb
+	return hb, nil
 }
 
 func (hb Broker) Name() string {
 	return hb.inst
 }
 
+// FormatRelayed implements hal.BrokerFormatter, rendering a message
+// bridged in from another broker as Hipchat's HTML dialect: a bold
+// "user@room:" prefix ahead of the escaped body.
+func (hb Broker) FormatRelayed(sourceBroker, sourceRoom, sourceUser, body string) string {
+	return fmt.Sprintf("<b>%s@%s</b>: %s", html.EscapeString(sourceUser), html.EscapeString(sourceRoom), html.EscapeString(body))
+}
+
+// PreferredTableFormat implements hal.TableFormatter: this broker's XMPP
+// body renders as HTML (see FormatRelayed), so tables should too rather
+// than relying on monospace alignment most clients don't preserve.
+func (hb Broker) PreferredTableFormat() hal.TableFormat {
+	return hal.TableFormatHtml
+}
+
+// SendTable renders header/rows with PreferredTableFormat and sends the
+// result the same way Send() does.
+func (hb Broker) SendTable(evt hal.Evt, header []string, rows [][]string) {
+	out := evt.Clone()
+	out.Body = hal.RenderTable(hb.PreferredTableFormat(), header, rows)
+	hb.Send(out)
+}
+
+// Send delivers evt to evt.RoomId as a MUC groupchat message when it names
+// a room this broker has joined (see rooms), or as a 1:1 chat otherwise --
+// mirroring whatever evt.Channel/RoomId pointed at. Use SendDM to force a
+// 1:1 chat with a user regardless of where evt originated.
 func (hb Broker) Send(evt hal.Evt) {
+	hb.send(evt.RoomId, evt.Body, evt.Time)
+}
+
+// SendDM delivers evt as a 1:1 chat to evt.UserId, regardless of the room
+// it originated in -- e.g. for flows that shouldn't post into a shared
+// room. See hal.DMSender.
+func (hb Broker) SendDM(evt hal.Evt) {
+	hb.send(evt.UserId, evt.Body, evt.Time)
+}
+
+// send is the shared implementation behind Send/SendDM: a MUC groupchat
+// message when to is a room jid we've joined, otherwise a plain 1:1 chat.
+func (hb Broker) send(to, body string, stamp time.Time) {
+	msgType := "chat"
+	if _, isRoom := hb.rooms[to]; isRoom {
+		msgType = "groupchat"
+	}
+
 	msg := xmpp.Chat{
-		Text:  evt.Body,
-		Stamp: evt.Time,
+		Remote: to,
+		Type:   msgType,
+		Text:   body,
+		Stamp:  stamp,
 	}
 
 	_, err := hb.Client.Send(msg)
 	if err != nil {
-		log.Printf("Failed to send message to Hipchat server: %s\n", err)
+		log.Printf("Failed to send message to %q: %s\n", to, err)
 	}
 }
 
-// Subscribe joins a channel with the given alias.
-// These names are specific to how Hipchat does things.
-func (hb *Broker) Subscribe(channel, alias string) {
-	// TODO: take a channel name and somehow look up the goofy MUC name
-	// e.g. client.JoinMUC("99999_channelName@conf.hipchat.com", "Bot Name")
-	hb.Client.JoinMUC(channel, alias)
+// Subscribe joins room (a MUC jid, e.g.
+// "99999_channelName@conf.hipchat.com") under nick, remembering it so
+// Send knows to address it as a groupchat.
+func (hb *Broker) Subscribe(room, nick string) {
+	hb.Client.JoinMUC(room, nick)
+	hb.rooms[room] = nick
 }
 
-// Keepalive is a timer loop that can be fired up to periodically
-// send keepalive messages to the Hipchat server in order to prevent
-// Hipchat from shutting the connection down due to inactivity.
-func (hb *Broker) heartbeat(t time.Time) {
-	msg := xmpp.Chat{Text: "heartbeat"}
-	msg.Stamp = t
-
-	n, err := hb.Client.Send(msg)
+// heartbeat pings the server with an XMPP "iq"/"ping" stanza (XEP-0199)
+// instead of a visible chat message, so joined rooms aren't spammed with
+// a "heartbeat" text message every minute the way the original hosted
+// Hipchat client did.
+func (hb *Broker) heartbeat() {
+	_, err := hb.Client.SendOrg(fmt.Sprintf(`<iq from='%s' type='get' id='hal-heartbeat'><ping xmlns='urn:xmpp:ping'/></iq>`, hb.Client.JID()))
 	if err != nil {
-		log.Fatalf("Failed to send keepalive (%d): %s\n", n, err)
+		log.Printf("Failed to send heartbeat ping: %s\n", err)
 	}
 }
 
-// Stream is an event loop for Hipchat events.
+// Stream is an event loop for this broker's XMPP connection: chat
+// messages become ordinary hal.Evt, MUC presence stanzas update the
+// roster and become synthetic join/part hal.Evt (see IsJoin/IsPart), iq
+// stanzas are routed to whatever GetAvatar call is waiting on a matching
+// id (see routeIq), and everything else is logged and dropped.
 func (hb Broker) Stream(out chan *hal.Evt) {
 	client := hb.Client
-	incoming := make(chan *xmpp.Chat)
+	incoming := make(chan interface{})
 	timer := time.Tick(time.Minute * 1) // once a minute
 
-	// grab chat messages using the blocking Recv() and forward them
-	// on a channel so the select loop can also handle sending heartbeats
+	// grab stanzas using the blocking Recv() and forward them on a
+	// channel so the select loop can also handle sending heartbeats
 	go func() {
 		for {
 			msg, err := client.Recv()
 			if err != nil {
-				log.Printf("Error receiving from Hipchat: %s\n", err)
+				log.Printf("Error receiving from XMPP server: %s\n", err)
+				continue
 			}
 
-			switch t := msg.(type) {
-			case xmpp.Chat:
-				m := msg.(xmpp.Chat)
-				incoming <- &m
-			case xmpp.Presence:
-				continue // ignored
+			switch msg.(type) {
+			case xmpp.Chat, xmpp.Presence, xmpp.IQ:
+				incoming <- msg
 			default:
-				log.Printf("Unhandled message of type '%T': %s ", t, t)
+				log.Printf("Unhandled message of type '%T': %+v", msg, msg)
 			}
 		}
 	}()
 
 	for {
 		select {
-		case t := <-timer:
-			hb.heartbeat(t)
-		case chat := <-incoming:
-			// Remote should look like "99999_channelName@conf.hipchat.com/User Name"
-			parts := strings.SplitN(chat.Remote, "/", 2)
-
-			if len(parts) == 2 {
-				e := hal.Evt{
-					Body:      chat.Text,
-					Channel:   parts[0], // TODO: provide the human-readable name
-					ChannelId: parts[0],
-					From:      parts[1],
-					FromId:    parts[1],   // TODO: provide the JID
-					Time:      time.Now(), // m.Stamp seems to be zeroed
-					IsGeneric: true,
-					Original:  &chat,
+		case <-timer:
+			hb.heartbeat()
+		case msg := <-incoming:
+			switch t := msg.(type) {
+			case xmpp.Chat:
+				if e, ok := hb.chatToEvt(t); ok {
+					out <- &e
 				}
-
-				out <- &e
+			case xmpp.Presence:
+				out <- hb.presenceToEvt(t)
+			case xmpp.IQ:
+				hb.routeIq(t)
 			}
 		}
 	}
 }
 
-// required by interface
-// TODO: replace these with actually useful versions
-func (b Broker) ChannelIdToName(in string) string { return in }
-func (b Broker) ChannelNameToId(in string) string { return in }
-func (b Broker) UserIdToName(in string) string    { return in }
-func (b Broker) UserNameToId(in string) string    { return in }
+// chatToEvt converts an xmpp.Chat stanza into a hal.Evt. ok is false for
+// stanzas with no "/nick" resource part (e.g. room-level errors), which
+// aren't attributable to a user and are dropped.
+func (hb Broker) chatToEvt(chat xmpp.Chat) (e hal.Evt, ok bool) {
+	// Remote looks like "99999_channelName@conf.hipchat.com/User Name"
+	// for MUC messages, or a bare jid for 1:1 chats.
+	parts := strings.SplitN(chat.Remote, "/", 2)
+	if len(parts) != 2 {
+		return hal.Evt{}, false
+	}
+
+	roomId, nick := parts[0], parts[1]
+
+	e = hal.Evt{
+		Body:      chat.Text,
+		Room:      hb.ChannelIdToName(roomId),
+		RoomId:    roomId,
+		User:      nick,
+		UserId:    chat.Remote,
+		Time:      time.Now(), // chat.Stamp is usually zero on the wire
+		Brokers:   hal.Brokers{hb},
+		AvatarURL: hb.cachedAvatarURL(chat.Remote), // cache only -- see GetAvatar to force a fetch
+		Original:  chat,
+	}
+
+	return e, true
+}
+
+// presenceToEvt converts an xmpp.Presence stanza into a synthetic
+// IsJoin/IsPart hal.Evt, updating the occupant roster along the way so
+// ChannelIdToName/UserIdToName stay current.
+func (hb Broker) presenceToEvt(pres xmpp.Presence) *hal.Evt {
+	parts := strings.SplitN(pres.From, "/", 2)
+
+	roomId, nick := pres.From, ""
+	if len(parts) == 2 {
+		roomId, nick = parts[0], parts[1]
+	}
+
+	isPart := pres.Type == "unavailable"
+	if isPart {
+		hb.roster.Miss(pres.From)
+	} else {
+		hb.roster.Put(pres.From, nick)
+	}
+
+	return &hal.Evt{
+		Room:     hb.ChannelIdToName(roomId),
+		RoomId:   roomId,
+		User:     nick,
+		UserId:   pres.From,
+		Time:     time.Now(),
+		IsJoin:   !isPart,
+		IsPart:   isPart,
+		Brokers:  hal.Brokers{hb},
+		Original: pres,
+	}
+}
+
+// LooksLikeRoomId reports whether room is a jid this broker has joined,
+// as opposed to a human-readable room name.
+func (hb Broker) LooksLikeRoomId(room string) bool {
+	_, ok := hb.rooms[room]
+	return ok
+}
+
+// LooksLikeUserId reports whether user is a full occupant jid
+// ("room@conf.host/Nick") rather than a bare nickname.
+func (hb Broker) LooksLikeUserId(user string) bool {
+	return strings.Contains(user, "/")
+}
+
+// ChannelIdToName resolves a room jid to the nickname we joined it under,
+// falling back to the jid itself if it's not one of ours.
+func (hb Broker) ChannelIdToName(id string) string {
+	if nick, ok := hb.rooms[id]; ok {
+		return nick
+	}
+	return id
+}
+
+// ChannelNameToId is the inverse of ChannelIdToName over the rooms this
+// broker joined.
+func (hb Broker) ChannelNameToId(name string) string {
+	for jid, nick := range hb.rooms {
+		if nick == name {
+			return jid
+		}
+	}
+	return name
+}
+
+// RoomIdToName implements hal.Broker. See ChannelIdToName.
+func (hb Broker) RoomIdToName(id string) string {
+	return hb.ChannelIdToName(id)
+}
+
+// RoomNameToId implements hal.Broker. See ChannelNameToId.
+func (hb Broker) RoomNameToId(name string) string {
+	return hb.ChannelNameToId(name)
+}
+
+// UserIdToName resolves an occupant jid to its nickname using the
+// presence-driven roster cache, falling back to the jid itself on a miss.
+func (hb Broker) UserIdToName(id string) string {
+	if nick, ok := hb.roster.Get(id); ok {
+		return nick
+	}
+	return id
+}
+
+// UserNameToId is the inverse of UserIdToName over the roster cache.
+func (hb Broker) UserNameToId(name string) string {
+	if id, ok := hb.roster.GetByName(name); ok {
+		return id
+	}
+	return name
+}
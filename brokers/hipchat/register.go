@@ -0,0 +1,284 @@
+package hipchat
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RegistrationError is returned by Config.register when the server rejects
+// an XEP-0077 in-band registration attempt, e.g. because the requested
+// username is taken ("conflict") or the server has registration turned off
+// ("not-allowed"). Callers can match on Condition instead of string-matching
+// Error().
+type RegistrationError struct {
+	Condition string // the XMPP stanza error condition, e.g. "conflict", "not-allowed"
+	Text      string // the server's human-readable <text>, if it sent one
+}
+
+func (e RegistrationError) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("hipchat: registration failed (%s): %s", e.Condition, e.Text)
+	}
+	return fmt.Sprintf("hipchat: registration failed (%s)", e.Condition)
+}
+
+// registerIq is the subset of an XEP-0077 <iq/> we care about: either the
+// registration <query/> form the server sends back, or a stanza <error/>.
+type registerIq struct {
+	XMLName xml.Name     `xml:"iq"`
+	Type    string       `xml:"type,attr"`
+	Id      string       `xml:"id,attr"`
+	Error   *stanzaError `xml:"error"`
+}
+
+// stanzaError captures the handful of defined-condition elements XEP-0077
+// registration failures actually use (RFC 6120 section 8.3.3); anything
+// else comes back as Condition() == "unknown" rather than failing to parse.
+type stanzaError struct {
+	Conflict   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-stanzas conflict"`
+	NotAllowed *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-stanzas not-allowed"`
+	Text       string    `xml:"urn:ietf:params:xml:ns:xmpp-stanzas text"`
+}
+
+func (e *stanzaError) Condition() string {
+	switch {
+	case e.Conflict != nil:
+		return "conflict"
+	case e.NotAllowed != nil:
+		return "not-allowed"
+	default:
+		return "unknown"
+	}
+}
+
+// register performs XEP-0077 in-band registration for
+// c.RegisterUsername/RegisterPassword/RegisterEmail against c.Host, ahead
+// of the normal login NewBroker does right after. It opens its own
+// short-lived connection instead of reusing the xmpp.Client NewBroker
+// builds next: xmpp.Options.NewClient dials, authenticates, and binds a
+// session all in one call, with no hook to pause before SASL auth, and
+// in-band registration has to happen before any authentication attempt.
+// As with NewBroker, a plaintext connection is only ever used when
+// c.InsecureAllowPlain says so -- c.StartTLS gets the real STARTTLS
+// handshake (see startTLS) before RegisterUsername/Password/Email ever hit
+// the wire, and the default is a direct TLS dial, same as login.
+func (c Config) register() error {
+	conn, err := registrationConn(c)
+	if err != nil {
+		return fmt.Errorf("hipchat: registration connect to %q failed: %s", c.Host, err)
+	}
+	defer conn.Close()
+
+	dec, _, err := openStream(conn, registrationDomain(c))
+	if err != nil {
+		return fmt.Errorf("hipchat: registration stream negotiation failed: %s", err)
+	}
+
+	if _, err := fmt.Fprint(conn, `<iq type='get' id='hal-register-get'><query xmlns='jabber:iq:register'/></iq>`); err != nil {
+		return fmt.Errorf("hipchat: requesting registration form failed: %s", err)
+	}
+	if form, err := nextIq(dec); err != nil {
+		return fmt.Errorf("hipchat: reading registration form failed: %s", err)
+	} else if form.Error != nil {
+		return RegistrationError{Condition: form.Error.Condition(), Text: form.Error.Text}
+	}
+
+	submit := fmt.Sprintf(
+		`<iq type='set' id='hal-register-set'><query xmlns='jabber:iq:register'><username>%s</username><password>%s</password><email>%s</email></query></iq>`,
+		xmlEscape(c.RegisterUsername), xmlEscape(c.RegisterPassword), xmlEscape(c.RegisterEmail),
+	)
+	if _, err := fmt.Fprint(conn, submit); err != nil {
+		return fmt.Errorf("hipchat: submitting registration failed: %s", err)
+	}
+
+	reply, err := nextIq(dec)
+	if err != nil {
+		return fmt.Errorf("hipchat: reading registration reply failed: %s", err)
+	}
+	if reply.Error != nil {
+		return RegistrationError{Condition: reply.Error.Condition(), Text: reply.Error.Text}
+	}
+
+	return nil
+}
+
+// registrationConn opens the connection register() drives by hand: plain
+// TCP only when c.InsecureAllowPlain says so, a real STARTTLS upgrade (see
+// startTLS) when c.StartTLS is set, and a direct TLS dial otherwise --
+// mirroring NewBroker's own three cases so registration never sends
+// RegisterUsername/RegisterPassword/RegisterEmail in the clear unless the
+// operator explicitly asked for that.
+func registrationConn(c Config) (net.Conn, error) {
+	if c.InsecureAllowPlain {
+		return net.Dial("tcp", c.Host)
+	}
+
+	if c.StartTLS {
+		conn, err := net.Dial("tcp", c.Host)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn, err := startTLS(conn, c)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+
+	cfg := c.TLSConfig
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	return tls.Dial("tcp", c.Host, cfg)
+}
+
+// startTLS performs the actual XEP-0035/RFC 6120 STARTTLS handshake over
+// conn: open a stream, confirm the server's <stream:features/> actually
+// offers <starttls/>, send it, wait for <proceed/>, then hand the raw
+// connection to crypto/tls. Returns an error (instead of silently falling
+// back to plaintext) if the server doesn't offer STARTTLS or rejects it,
+// since that's the one case registering at all would leak credentials.
+func startTLS(conn net.Conn, c Config) (net.Conn, error) {
+	_, features, err := openStream(conn, registrationDomain(c))
+	if err != nil {
+		return nil, fmt.Errorf("negotiating stream before STARTTLS failed: %s", err)
+	}
+	if features.StartTLS == nil {
+		return nil, fmt.Errorf("server at %q does not offer STARTTLS", c.Host)
+	}
+
+	if _, err := fmt.Fprint(conn, `<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>`); err != nil {
+		return nil, fmt.Errorf("sending starttls failed: %s", err)
+	}
+
+	// a fresh single-byte-buffered decoder: anything xml.Decoder reads
+	// ahead past </proceed> would be lost once crypto/tls starts reading
+	// conn directly below, so keep it from reading ahead at all.
+	dec := xml.NewDecoder(bufio.NewReaderSize(conn, 1))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("reading starttls response failed: %s", err)
+	}
+	if start, ok := tok.(xml.StartElement); !ok || start.Name.Local != "proceed" {
+		return nil, fmt.Errorf("server refused STARTTLS: %+v", tok)
+	}
+
+	cfg := c.TLSConfig
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %s", err)
+	}
+
+	return tlsConn, nil
+}
+
+// registrationDomain is the "to" attribute for register()/startTLS's
+// stream opens: the domain part of RegisterUsername if set, else Jid's.
+func registrationDomain(c Config) string {
+	domain := jidDomain(c.RegisterUsername)
+	if domain == "" {
+		domain = jidDomain(c.Jid)
+	}
+	return domain
+}
+
+// jidDomain returns the domain part of a bare or full JID.
+func jidDomain(jid string) string {
+	at := strings.IndexByte(jid, '@')
+	if at < 0 {
+		return ""
+	}
+	domain := jid[at+1:]
+	if slash := strings.IndexByte(domain, '/'); slash >= 0 {
+		domain = domain[:slash]
+	}
+	return domain
+}
+
+func xmlEscape(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// streamFeatures is the subset of <stream:features/> register()/startTLS
+// care about: whether the server is offering STARTTLS.
+type streamFeatures struct {
+	XMLName  xml.Name  `xml:"features"`
+	StartTLS *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+}
+
+// openStream sends our <stream:stream> open tag on conn, reads the
+// server's own open tag (never a clean single element, since it's
+// deliberately never closed) and its <stream:features/>, and returns a
+// decoder positioned to read whatever comes next plus the parsed features.
+func openStream(conn net.Conn, domain string) (*xml.Decoder, *streamFeatures, error) {
+	if _, err := fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", xmlEscape(domain)); err != nil {
+		return nil, nil, err
+	}
+
+	// single-byte buffering: a STARTTLS upgrade may immediately follow on
+	// this same conn (see startTLS), and anything read ahead of the
+	// decoder's logical position would be lost once crypto/tls takes over
+	// reading conn directly.
+	dec := xml.NewDecoder(bufio.NewReaderSize(conn, 1))
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "features" {
+			continue
+		}
+		var features streamFeatures
+		if err := dec.DecodeElement(&features, &start); err != nil {
+			return nil, nil, err
+		}
+		return dec, &features, nil
+	}
+}
+
+// nextIq scans forward for the next top-level <iq/> stanza and decodes it.
+func nextIq(dec *xml.Decoder) (*registerIq, error) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "iq" {
+			continue
+		}
+		var iq registerIq
+		if err := dec.DecodeElement(&iq, &start); err != nil {
+			return nil, err
+		}
+		return &iq, nil
+	}
+}
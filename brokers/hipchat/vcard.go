@@ -0,0 +1,174 @@
+package hipchat
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mattn/go-xmpp"
+	"github.com/netflix/hal-9001/hal"
+)
+
+// avatarKV namespaces this broker's avatar cache in hal.GetKV/SetKV.
+// hal.Pref, which the rest of the codebase reaches for first, is
+// referenced throughout hal but never actually defined (see
+// hal/ansi.UserColors) -- hal.GetKV is the real persistence available,
+// namespaced per JID.
+func avatarKV(jid string) string {
+	return "hipchat.avatar." + jid
+}
+
+// vCardPhoto is the subset of vcard-temp (XEP-0153/0054) we read and write:
+// a single PNG photo, base64-encoded on the wire.
+type vCardPhoto struct {
+	XMLName xml.Name `xml:"vcard-temp vCard"`
+	Type    string   `xml:"PHOTO>TYPE"`
+	BinVal  string   `xml:"PHOTO>BINVAL"`
+}
+
+// SetAvatar publishes png as this broker's vCard-temp photo (XEP-0153) and
+// broadcasts its SHA-1 hash in presence (XEP-0084's vcard-temp:x:update
+// convention) so contacts know to re-fetch it. The hash is also what
+// GetAvatar's callers should compare against a cached copy before
+// re-fetching, though this broker doesn't do that bookkeeping itself.
+func (hb Broker) SetAvatar(png []byte) error {
+	b64 := base64.StdEncoding.EncodeToString(png)
+
+	setVCard := fmt.Sprintf(
+		`<iq type='set' id='hal-vcard-set'><vCard xmlns='vcard-temp'><PHOTO><TYPE>image/png</TYPE><BINVAL>%s</BINVAL></PHOTO></vCard></iq>`,
+		b64,
+	)
+	if _, err := hb.Client.SendOrg(setVCard); err != nil {
+		return fmt.Errorf("hipchat: publishing vCard photo failed: %s", err)
+	}
+
+	hash := sha1.Sum(png)
+	presence := fmt.Sprintf(
+		`<presence><x xmlns='vcard-temp:x:update'><photo>%x</photo></x></presence>`,
+		hash,
+	)
+	if _, err := hb.Client.SendOrg(presence); err != nil {
+		return fmt.Errorf("hipchat: broadcasting avatar hash failed: %s", err)
+	}
+
+	if err := hal.SetKV(avatarKV(hb.Client.JID()), b64, 0); err != nil {
+		log.Printf("Failed to cache own avatar under %q: %s\n", avatarKV(hb.Client.JID()), err)
+	}
+
+	return nil
+}
+
+// GetAvatar returns jid's vCard-temp photo, preferring a cached copy (see
+// SetAvatar/avatarKV) and falling back to an XEP-0054 vCard fetch, which it
+// then caches for next time.
+func (hb Broker) GetAvatar(jid string) ([]byte, error) {
+	if cached, err := hal.GetKV(avatarKV(jid)); err == nil && cached != "" {
+		png, err := base64.StdEncoding.DecodeString(cached)
+		if err == nil {
+			return png, nil
+		}
+		log.Printf("Discarding unreadable cached avatar for %q: %s\n", jid, err)
+	}
+
+	id := fmt.Sprintf("hal-vcard-get-%d", time.Now().UnixNano())
+	ch := hb.awaitIq(id)
+	defer hb.cancelAwaitIq(id)
+
+	getVCard := fmt.Sprintf(`<iq type='get' id='%s' to='%s'><vCard xmlns='vcard-temp'/></iq>`, id, xmlEscape(jid))
+	if _, err := hb.Client.SendOrg(getVCard); err != nil {
+		return nil, fmt.Errorf("hipchat: requesting vCard for %q failed: %s", jid, err)
+	}
+
+	select {
+	case reply := <-ch:
+		var card vCardPhoto
+		if err := xml.Unmarshal(reply.Query, &card); err != nil {
+			return nil, fmt.Errorf("hipchat: parsing vCard for %q failed: %s", jid, err)
+		}
+		if card.BinVal == "" {
+			return nil, fmt.Errorf("hipchat: %q has no vCard photo set", jid)
+		}
+
+		png, err := base64.StdEncoding.DecodeString(card.BinVal)
+		if err != nil {
+			return nil, fmt.Errorf("hipchat: decoding vCard photo for %q failed: %s", jid, err)
+		}
+
+		if err := hal.SetKV(avatarKV(jid), card.BinVal, 24*time.Hour); err != nil {
+			log.Printf("Failed to cache avatar for %q: %s\n", jid, err)
+		}
+
+		return png, nil
+	case <-time.After(10 * time.Second):
+		return nil, fmt.Errorf("hipchat: timed out waiting for vCard reply from %q", jid)
+	}
+}
+
+// cachedAvatarURL returns a data: URL for jid's cached avatar, or "" if
+// nothing is cached yet -- used to populate hal.Evt.AvatarURL without
+// blocking event delivery on a live vCard fetch. See GetAvatar to force a
+// fetch.
+func (hb Broker) cachedAvatarURL(jid string) string {
+	cached, err := hal.GetKV(avatarKV(jid))
+	if err != nil || cached == "" {
+		return ""
+	}
+	return "data:image/png;base64," + cached
+}
+
+// awaitIq registers a channel that routeIq delivers the next <iq id="id">
+// reply to. Callers must call cancelAwaitIq once they're done waiting,
+// whether or not a reply ever arrived.
+func (hb Broker) awaitIq(id string) chan xmpp.IQ {
+	ch := make(chan xmpp.IQ, 1)
+	hb.pendingMut.Lock()
+	hb.pendingIq[id] = ch
+	hb.pendingMut.Unlock()
+	return ch
+}
+
+func (hb Broker) cancelAwaitIq(id string) {
+	hb.pendingMut.Lock()
+	delete(hb.pendingIq, id)
+	hb.pendingMut.Unlock()
+}
+
+// routeIq delivers an incoming <iq/> reply to whatever GetAvatar (or a
+// future IQ-reply caller) is waiting on its id, if anything is. Stream
+// calls this for every xmpp.IQ it receives instead of treating them all as
+// unhandled, since vCard fetches are the one place this broker needs a
+// request/reply round trip over the same shared connection Stream reads.
+func (hb Broker) routeIq(iq xmpp.IQ) {
+	hb.pendingMut.Lock()
+	ch, ok := hb.pendingIq[iq.Id]
+	hb.pendingMut.Unlock()
+
+	if !ok {
+		log.Printf("Unhandled iq id=%q from=%q: %+v", iq.Id, iq.From, iq)
+		return
+	}
+
+	select {
+	case ch <- iq:
+	default:
+	}
+}
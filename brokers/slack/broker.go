@@ -34,24 +34,47 @@ import (
 	"github.com/nlopes/slack"
 )
 
+// identityCacheTTL/identityCacheMissTTL/identityCacheMaxEntries tune the
+// hal.IdentityCache instances backing user/room lookups: positive hits are
+// trusted for an hour, negative hits (users/rooms we confirmed don't exist)
+// for a minute, and the cache won't grow past 50k entries of each kind
+// regardless of team size.
+const (
+	identityCacheTTL        = time.Hour
+	identityCacheMissTTL    = time.Minute
+	identityCacheMaxEntries = 50000
+)
+
 // Broker interacts with the slack service.
-// TODO: consider using the hal.Cache() for [iuc]2[iuc]
-// TODO: add a miss cache to avoid hammering the room/user info apis
 type Broker struct {
-	Client  *slack.Client     // slack API object
-	RTM     *slack.RTM        // slack RTM object
-	UserId  string            // slack Bot user ID (for preventing loops)
-	inst    string            // broker instance name
-	i2u     map[string]string // id->name cache
-	i2c     map[string]string // id->name cache
-	u2i     map[string]string // name->id cache
-	c2i     map[string]string // name->id cache
-	imcs    map[string]string // userId -> channelId im channels
-	idRegex *regexp.Regexp    // compiled RE to match user/room ids
+	Client        *slack.Client      // slack API object
+	RTM           *slack.RTM         // slack RTM object (Mode == "rtm")
+	UserId        string             // slack Bot user ID (for preventing loops)
+	inst          string             // broker instance name
+	token         string             // bot token, used for web API calls made outside *slack.Client (see upload_v2.go)
+	limiter       *rateLimiter       // per-tier token buckets guarding calls made via sb.call() (see ratelimit.go)
+	mode          string             // "rtm" (default), "socket", or "events"
+	appToken      string             // xapp-... token, required for Mode == "socket"
+	signingSecret string             // app signing secret, required for ServeInteractive/ServeEventsAPI
+	users         *hal.IdentityCache // user id<->name cache (TTL + negative caching + LRU)
+	rooms         *hal.IdentityCache // room id<->name cache (TTL + negative caching + LRU)
+	imcs          map[string]string  // userId -> channelId im channels
+	idRegex       *regexp.Regexp     // compiled RE to match user/room ids
 }
 
+// Config holds the settings needed to construct a Broker.
+//
+// Mode selects the transport used to receive events from Slack:
+//   - "rtm" (default): the legacy RTM websocket API. Deprecated by Slack for
+//     new apps but kept as the default for backward compatibility.
+//   - "socket": Socket Mode, Slack's replacement for RTM. Requires AppToken
+//     (an app-level token, xapp-...) in addition to Token.
+//   - "events": the HTTP Events API. Requires a broker-owned HTTP endpoint;
+//     see ServeEventsAPI.
 type Config struct {
-	Token string
+	Token    string
+	Mode     string
+	AppToken string
 }
 
 var LooksLikeIdRE *regexp.Regexp
@@ -62,25 +85,42 @@ func init() {
 
 func (c Config) NewBroker(name string) Broker {
 	client := slack.New(c.Token)
-	// TODO: check for failures and log.Fatalf()
-	rtm := client.NewRTM()
+
+	mode := c.Mode
+	if mode == "" {
+		mode = "rtm"
+	}
+
+	users := &hal.IdentityCache{TTL: identityCacheTTL, MissTTL: identityCacheMissTTL, MaxEntries: identityCacheMaxEntries}
+	users.WriteThrough = func(id, name string) {
+		hal.Directory().PutNode(id, "slack-user")
+	}
 
 	sb := Broker{
-		Client: client,
-		RTM:    rtm,
-		inst:   name,
-		i2u:    make(map[string]string),
-		i2c:    make(map[string]string),
-		u2i:    make(map[string]string),
-		c2i:    make(map[string]string),
-		imcs:   make(map[string]string),
+		Client:   client,
+		inst:     name,
+		mode:     mode,
+		token:    c.Token,
+		appToken: c.AppToken,
+		limiter:  newRateLimiter(),
+		users:    users,
+		rooms:    &hal.IdentityCache{TTL: identityCacheTTL, MissTTL: identityCacheMissTTL, MaxEntries: identityCacheMaxEntries},
+		imcs:     make(map[string]string),
+	}
+
+	if mode == "socket" && c.AppToken == "" {
+		log.Fatalf("brokers/slack: Mode \"socket\" requires an AppToken (xapp-...)")
 	}
 
 	// fill the caches at startup to cut down on API requests
 	sb.FillUserCache()
 	sb.FillRoomCache()
 
-	go rtm.ManageConnection()
+	if mode == "rtm" {
+		// TODO: check for failures and log.Fatalf()
+		sb.RTM = client.NewRTM()
+		go sb.RTM.ManageConnection()
+	}
 
 	return sb
 }
@@ -90,6 +130,13 @@ func (sb Broker) Name() string {
 	return sb.inst
 }
 
+// FormatRelayed implements hal.BrokerFormatter, rendering a message
+// bridged in from another broker as Slack mrkdwn: a bold "user@room:"
+// prefix ahead of the plain body.
+func (sb Broker) FormatRelayed(sourceBroker, sourceRoom, sourceUser, body string) string {
+	return fmt.Sprintf("*%s@%s*: %s", sourceUser, sourceRoom, body)
+}
+
 func (sb Broker) Send(evt hal.Evt) {
 	// Slack refuses messages over 4000 characters. Most of the time that's
 	// probably data so post it as a file. Using len instead of rune count since
@@ -112,13 +159,13 @@ func (sb Broker) SendAsSnippet(evt hal.Evt) {
 	f.WriteString(evt.Body)
 	f.Close()
 
-	// upload the file
-	params := slack.FileUploadParameters{
+	// upload the file via the v2 files.upload flow (files.upload itself is
+	// deprecated - see upload_v2.go)
+	err = sb.uploadFileV2(uploadFileV2Params{
 		File:     f.Name(),
 		Filename: "reply.txt",
 		Channels: []string{evt.RoomId},
-	}
-	_, err = sb.Client.UploadFile(params)
+	})
 	if err != nil {
 		evt.Replyf("Could not upload snippet file: %s", err)
 	}
@@ -130,14 +177,42 @@ func (sb Broker) SendAsIs(evt hal.Evt) {
 	// a rich message in the body with params that need to be posted to the web API
 	// rather than going through RTM.
 	// See: https://api.slack.com/bot-users
-	switch evt.Original.(type) {
+	switch orig := evt.Original.(type) {
 	case *slack.PostMessageParameters:
-		params := evt.Original.(*slack.PostMessageParameters)
+		params := orig
 		params.AsUser = true // if we've gotten here, we always want this
-		sb.Client.PostMessage(evt.RoomId, evt.Body, *params)
+		applyThread(params, evt)
+		sb.call(Tier3, func() error {
+			_, _, err := sb.Client.PostMessage(evt.RoomId, evt.Body, *params)
+			return err
+		})
 	default:
-		om := sb.RTM.NewOutgoingMessage(evt.Body, evt.RoomId)
-		sb.RTM.SendMessage(om)
+		if sb.mode == "rtm" && evt.ThreadID == "" {
+			om := sb.RTM.NewOutgoingMessage(evt.Body, evt.RoomId)
+			sb.RTM.SendMessage(om)
+		} else {
+			// Socket Mode / Events API have no persistent connection to push
+			// outbound messages over, and RTM's NewOutgoingMessage has no
+			// thread support - everything that needs thread placement goes
+			// through the web API.
+			params := slack.NewPostMessageParameters()
+			params.AsUser = true
+			applyThread(&params, evt)
+			sb.call(Tier3, func() error {
+				_, _, err := sb.Client.PostMessage(evt.RoomId, evt.Body, params)
+				return err
+			})
+		}
+	}
+}
+
+// applyThread copies evt's thread fields onto params, if set.
+func applyThread(params *slack.PostMessageParameters, evt hal.Evt) {
+	if evt.ThreadID != "" {
+		params.ThreadTimestamp = evt.ThreadID
+	}
+	if evt.ThreadBroadcast {
+		params.ReplyBroadcast = true
 	}
 }
 
@@ -153,12 +228,11 @@ func (sb Broker) SendDM(evt hal.Evt) {
 		evt.RoomId = roomId
 	} else {
 		// try to open the channel, cache it if it works
-		_, _, roomId, err := sb.RTM.OpenIMChannel(evt.UserId)
+		_, _, roomId, err := sb.Client.OpenIMChannel(evt.UserId)
 		if err != nil {
 			log.Printf("Error from RTM.OpenIMChannel(%q): %s", evt.UserId, err)
 		} else {
 			sb.imcs[evt.UserId] = roomId
-			sb.i2c[roomId] = evt.UserId // TODO: verify this isn't a stupid idea
 			evt.RoomId = roomId
 		}
 	}
@@ -182,21 +256,112 @@ func (sb Broker) SetTopic(roomId, topic string) error {
 	return err
 }
 
+// PreferredTableFormat implements hal.TableFormatter. Slack's mrkdwn has
+// no native table rendering, so every table.format pref below (image,
+// snippet, attachment, plain text) wraps a monospace box-drawing table
+// rather than a Markdown one.
+func (sb Broker) PreferredTableFormat() hal.TableFormat {
+	return hal.TableFormatUtf8
+}
+
 func (sb Broker) SendTable(evt hal.Evt, hdr []string, rows [][]string) {
 	out := evt.Clone()
-	out.Body = hal.Utf8Table(hdr, rows)
+	out.Body = hal.RenderTable(sb.PreferredTableFormat(), hdr, rows)
 
 	tblFmt := hal.FindPrefs("", "", "", "", "table.format").One()
 
-	if tblFmt.Value == "image" {
+	switch tblFmt.Value {
+	case "image":
 		sb.SendAsImage(out)
-	} else if tblFmt.Value == "snippet" {
+	case "ansi":
+		// ansi is brokers/sshchat's truecolor table format (see hal/ansi) --
+		// mrkdwn can't render escape codes, so fall back to the plain
+		// UTF-8 table already in out.Body, same as the unrecognized-value
+		// default below, so the API stays uniform across brokers.
+		sb.SendAsIs(out)
+	case "snippet":
 		sb.SendAsSnippet(out)
-	} else {
+	case "attachment":
+		sb.SendAttachments(out, []slack.Attachment{{
+			Color: hal.SeverityGood,
+			Text:  "```" + out.Body + "```",
+		}})
+	default:
 		sb.SendAsIs(out)
 	}
 }
 
+// SendAttachments posts evt.Body (if non-empty) along with the given Slack
+// attachments, via the web API's chat.postMessage.
+func (sb Broker) SendAttachments(evt hal.Evt, attachments []slack.Attachment) {
+	params := slack.NewPostMessageParameters()
+	params.AsUser = true
+	params.Attachments = attachments
+
+	err := sb.call(Tier3, func() error {
+		_, _, err := sb.Client.PostMessage(evt.RoomId, evt.Body, params)
+		return err
+	})
+	if err != nil {
+		log.Printf("brokers/slack: SendAttachments failed: %s", err)
+	}
+}
+
+// SendBlocks posts evt.Body (if non-empty) along with the given Block Kit
+// blocks, via the web API's chat.postMessage.
+func (sb Broker) SendBlocks(evt hal.Evt, blocks []slack.Block) {
+	params := slack.NewPostMessageParameters()
+	params.AsUser = true
+	params.Blocks = blocks
+
+	err := sb.call(Tier3, func() error {
+		_, _, err := sb.Client.PostMessage(evt.RoomId, evt.Body, params)
+		return err
+	})
+	if err != nil {
+		log.Printf("brokers/slack: SendBlocks failed: %s", err)
+	}
+}
+
+// SendRich implements hal.RichSender, translating a hal.RichContent into a
+// single Slack attachment. Plugins that want Slack-specific formatting
+// beyond what RichContent expresses should use SendAttachments/SendBlocks
+// directly instead.
+func (sb Broker) SendRich(evt hal.Evt, rc hal.RichContent) {
+	att := slack.Attachment{
+		Title:     rc.Title,
+		TitleLink: rc.TitleLink,
+		Text:      rc.Body,
+		Color:     rc.Color,
+		MarkdownIn: func() []string {
+			if rc.Markdown {
+				return []string{"text", "fields"}
+			}
+			return nil
+		}(),
+	}
+
+	for _, f := range rc.Fields {
+		att.Fields = append(att.Fields, slack.AttachmentField{
+			Title: f.Title,
+			Value: f.Value,
+			Short: f.Short,
+		})
+	}
+
+	for _, a := range rc.Actions {
+		att.Actions = append(att.Actions, slack.AttachmentAction{
+			Name:  a.Name,
+			Text:  a.Text,
+			Value: a.Value,
+			Type:  "button",
+			Style: a.Style,
+		})
+	}
+
+	sb.SendAttachments(evt, []slack.Attachment{att})
+}
+
 // SendAsImage sends the body of the event as a png file. The png is rendered
 // using hal's FixedFont facility.
 // This is useful for making sure pre-formatted text stays legible in
@@ -253,20 +418,20 @@ func (sb Broker) SendAsImage(evt hal.Evt) {
 	png.Encode(f, img)
 	f.Close()
 
-	// upload the file
-	params := slack.FileUploadParameters{
+	// upload the file via the v2 files.upload flow (files.upload itself is
+	// deprecated - see upload_v2.go)
+	err = sb.uploadFileV2(uploadFileV2Params{
 		File:     f.Name(),
 		Filename: "text.png",
 		Channels: []string{evt.RoomId},
-	}
-	_, err = sb.Client.UploadFile(params)
+	})
 	if err != nil {
 		evt.Replyf("Could not upload image: %s", err)
 	}
 }
 
 func (sb Broker) LooksLikeRoomId(room string) bool {
-	if _, exists := sb.i2c[room]; exists {
+	if _, ok := sb.rooms.Get(room); ok {
 		return true
 	}
 
@@ -274,7 +439,7 @@ func (sb Broker) LooksLikeRoomId(room string) bool {
 }
 
 func (sb Broker) LooksLikeUserId(user string) bool {
-	if _, exists := sb.i2u[user]; exists {
+	if _, ok := sb.users.Get(user); ok {
 		return true
 	}
 
@@ -284,18 +449,29 @@ func (sb Broker) LooksLikeUserId(user string) bool {
 // checks the cache to see if the room is known to this broker
 func (sb Broker) HasRoom(room string) bool {
 	if LooksLikeIdRE.MatchString(room) {
-		_, exists := sb.i2c[room]
-		return exists
-	} else {
-		_, exists := sb.c2i[room]
-		return exists
+		_, ok := sb.rooms.Get(room)
+		return ok
 	}
+
+	_, ok := sb.rooms.GetByName(room)
+	return ok
 }
 
-// Stream is an event loop for Slack events & messages from the RTM API.
-// Events are copied to a hal.Evt and forwarded to the exchange where they
-// can be processed by registered handlers.
+// Stream is an event loop for Slack events & messages. Depending on sb.mode
+// it is fed either by the RTM websocket, a Socket Mode websocket, or the
+// HTTP Events API (see socketmode.go and events_api.go). In all three cases
+// the raw slack event is normalized to a hal.Evt and forwarded to out so the
+// rest of hal never has to care which transport delivered it.
 func (sb Broker) Stream(out chan *hal.Evt) {
+	switch sb.mode {
+	case "socket":
+		sb.streamSocketMode(out)
+		return
+	case "events":
+		sb.streamEventsAPI(out)
+		return
+	}
+
 	for {
 		select {
 		case msg := <-sb.RTM.IncomingEvents:
@@ -311,34 +487,12 @@ func (sb Broker) Stream(out chan *hal.Evt) {
 
 			case *slack.MessageEvent:
 				m := msg.Data.(*slack.MessageEvent)
-				isChat := true
 
 				log.Printf("MessageEvent.Text: %q", m.Text)
 
-				if m.User == sb.UserId {
-					log.Printf("ignoring MessageEvent from bot with id %s", sb.UserId)
-					continue // ignore bot-created events
-				}
-
-				// the slack server sends join/part messages as chat events in addition to
-				// the presence events - mark these as not chat
-				if strings.HasSuffix(m.Text, " has joined the channel") ||
-					strings.HasSuffix(m.Text, " has left the channel") {
-					isChat = false
-				}
-
-				// slack channels = hal rooms, see hal-9001/hal/event.go
-				e := hal.Evt{
-					ID:       m.Timestamp,
-					Body:     m.Text,
-					Room:     sb.RoomIdToName(m.Channel),
-					RoomId:   m.Channel,
-					User:     sb.UserIdToName(m.User),
-					UserId:   m.User,
-					Broker:   sb,
-					Time:     slackTime(m.Timestamp),
-					IsChat:   isChat,
-					Original: m,
+				e, ok := sb.messageToEvt(m)
+				if !ok {
+					continue
 				}
 
 				// let everyone know the bot is working if it appears to be a command
@@ -475,6 +629,43 @@ func (sb Broker) Stream(out chan *hal.Evt) {
 	}
 }
 
+// messageToEvt normalizes a *slack.MessageEvent into a hal.Evt, shared by
+// all three transports (RTM, Socket Mode, Events API) so the conversion
+// logic only has to be maintained in one place. ok is false when the event
+// should be dropped (e.g. it originated from the bot itself).
+func (sb Broker) messageToEvt(m *slack.MessageEvent) (e hal.Evt, ok bool) {
+	if m.User == sb.UserId {
+		log.Printf("ignoring MessageEvent from bot with id %s", sb.UserId)
+		return hal.Evt{}, false
+	}
+
+	isChat := true
+
+	// the slack server sends join/part messages as chat events in addition to
+	// the presence events - mark these as not chat
+	if strings.HasSuffix(m.Text, " has joined the channel") ||
+		strings.HasSuffix(m.Text, " has left the channel") {
+		isChat = false
+	}
+
+	// slack channels = hal rooms, see hal-9001/hal/event.go
+	e = hal.Evt{
+		ID:       m.Timestamp,
+		Body:     m.Text,
+		Room:     sb.RoomIdToName(m.Channel),
+		RoomId:   m.Channel,
+		User:     sb.UserIdToName(m.User),
+		UserId:   m.User,
+		Broker:   sb,
+		Time:     slackTime(m.Timestamp),
+		IsChat:   isChat,
+		ThreadID: m.ThreadTimestamp,
+		Original: m,
+	}
+
+	return e, true
+}
+
 // slackTime converts the timestamp string to time.Time
 func slackTime(t string) time.Time {
 	if t == "" {
@@ -493,173 +684,176 @@ func slackTime(t string) time.Time {
 }
 
 func (sb *Broker) FillUserCache() {
-	users, err := sb.Client.GetUsers()
+	var users []slack.User
+	err := sb.call(Tier2, func() error {
+		var cerr error
+		users, cerr = sb.Client.GetUsers()
+		return cerr
+	})
 	if err != nil {
 		log.Printf("brokers/slack failed to fetch user list: %s", err)
 		return
 	}
 
 	for _, user := range users {
-		sb.u2i[user.Name] = user.ID
-		sb.i2u[user.ID] = user.Name
-	}
-
-	// push the users into the directory async so it doesn't hold up bot
-	// startup (FillUserCache is called preemptively at startup)
-	go func() {
-		for _, user := range users {
-			attrs := map[string]string{
-				"username": user.Name,
-				"name":     user.RealName,
-				"email":    user.Profile.Email,
-			}
-			hal.Directory().Put(user.ID, "slack-user", attrs, []string{"email"})
-		}
-	}()
+		sb.users.Put(user.ID, user.Name)
+	}
 }
 
 func (sb *Broker) FillRoomCache() {
-	rooms, err := sb.Client.GetChannels(true)
+	var rooms []slack.Channel
+	err := sb.call(Tier2, func() error {
+		var cerr error
+		rooms, cerr = sb.Client.GetChannels(true)
+		return cerr
+	})
 	if err != nil {
 		log.Printf("brokers/slack failed to fetch room list: %s", err)
 		return
 	}
 
 	for _, room := range rooms {
-		sb.c2i[room.Name] = room.ID
-		sb.i2c[room.ID] = room.Name
+		sb.rooms.Put(room.ID, room.Name)
 	}
 }
 
-// UserIdToName gets the human-readable username for a user ID using an
-// in-memory cache that falls through to the Slack API
+// UserIdToName gets the human-readable username for a user ID using a
+// TTL'd identity cache (see hal.IdentityCache) that falls through to the
+// Slack API, with a negative-cache entry recorded on a confirmed miss.
 func (sb Broker) UserIdToName(id string) string {
 	if id == "" {
 		log.Println("broker/slack/UserIdToName(): Cannot look up empty string!")
 		return ""
 	}
 
-	if name, exists := sb.i2u[id]; exists {
+	if name, ok := sb.users.Get(id); ok {
 		return name
-	} else {
-		user, err := sb.Client.GetUserInfo(id)
-		if err != nil {
-			log.Printf("brokers/slack could not retrieve user info for '%s' via API: %s\n", id, err)
-			return ""
-		}
+	}
 
-		// TODO: verify if room/user names are enforced unique in slack or if this is madness
-		// remove this if it proves unnecessary (tobert/2016-03-02)
-		if _, exists := sb.u2i[user.Name]; exists {
-			if sb.u2i[user.Name] != user.ID {
-				log.Fatalf("BUG(brokers/slack): found a non-unique user name:ID pair. Had: %q/%q. Got: %q/%q",
-					user.Name, sb.u2i[user.Name], user.Name, user.ID)
-			}
-		}
+	if sb.users.IsMiss(id) {
+		return ""
+	}
 
-		sb.i2u[user.ID] = user.Name
-		sb.i2u[user.Name] = user.ID
+	var user *slack.User
+	err := sb.call(Tier4, func() error {
+		var cerr error
+		user, cerr = sb.Client.GetUserInfo(id)
+		return cerr
+	})
+	if err != nil {
+		log.Printf("brokers/slack could not retrieve user info for '%s' via API: %s\n", id, err)
+		sb.users.Miss(id)
+		return ""
+	}
 
-		attrs := map[string]string{
-			"username": user.Name,
-			"name":     user.RealName,
-			"email":    user.Profile.Email,
-		}
-		hal.Directory().Put(user.ID, "slack-user", attrs, []string{"email"})
+	sb.users.Put(user.ID, user.Name)
 
-		return user.Name
+	attrs := map[string]string{
+		"username": user.Name,
+		"name":     user.RealName,
+		"email":    user.Profile.Email,
 	}
+	hal.Directory().Put(user.ID, "slack-user", attrs, []string{"email"})
+
+	return user.Name
 }
 
-// RoomIdToName gets the human-readable room name for a user ID using an
-// in-memory cache that falls through to the Slack API
+// RoomIdToName gets the human-readable room name for a room ID using a
+// TTL'd identity cache that falls through to the Slack API, with a
+// negative-cache entry recorded on a confirmed miss.
 func (sb Broker) RoomIdToName(id string) string {
 	if id == "" {
 		log.Println("broker/slack/RoomIdToName(): Cannot look up empty string!")
 		return ""
 	}
 
-	if name, exists := sb.i2c[id]; exists {
+	if name, ok := sb.rooms.Get(id); ok {
 		return name
-	} else {
-		var name string
-
-		// private channels are on a different endpoint
-		if strings.HasPrefix(id, "G") {
-			grp, err := sb.Client.GetGroupInfo(id)
-			if err != nil {
-				log.Printf("brokers/slack could not retrieve room info for '%s' via API: %s\n", id, err)
-				return ""
-			}
-			name = grp.Name
-		} else if strings.HasPrefix(id, "D") {
-			log.Println("brokers/slack DM CHANNELS ARE A WORK IN PROGRESS")
-			//log.Printf("brokers/slack could not retrieve room info for '%s' via API: %s\n", id, err)
-		} else {
-			room, err := sb.Client.GetChannelInfo(id)
-			if err != nil {
-				log.Printf("brokers/slack could not retrieve room info for '%s' via API: %s\n", id, err)
-				return ""
-			}
-			name = room.Name
-		}
+	}
 
-		// TODO: verify if room/user names are enforced unique in slack or if this is madness
-		// remove this if it proves unnecessary (tobert/2016-03-02)
-		if _, exists := sb.c2i[name]; exists {
-			if sb.c2i[name] != id {
-				log.Fatalf("BUG(brokers/slack): found a non-unique room name:ID pair. Had: %q/%q. Got: %q/%q",
-					name, sb.c2i[name], name, id)
-			}
-		}
+	if sb.rooms.IsMiss(id) {
+		return ""
+	}
 
-		sb.i2c[id] = name
-		sb.c2i[name] = id
+	var name string
 
-		return name
+	// private channels are on a different endpoint
+	if strings.HasPrefix(id, "G") {
+		var grp *slack.Group
+		err := sb.call(Tier3, func() error {
+			var cerr error
+			grp, cerr = sb.Client.GetGroupInfo(id)
+			return cerr
+		})
+		if err != nil {
+			log.Printf("brokers/slack could not retrieve room info for '%s' via API: %s\n", id, err)
+			sb.rooms.Miss(id)
+			return ""
+		}
+		name = grp.Name
+	} else if strings.HasPrefix(id, "D") {
+		log.Println("brokers/slack DM CHANNELS ARE A WORK IN PROGRESS")
+		return ""
+	} else {
+		var room *slack.Channel
+		err := sb.call(Tier3, func() error {
+			var cerr error
+			room, cerr = sb.Client.GetChannelInfo(id)
+			return cerr
+		})
+		if err != nil {
+			log.Printf("brokers/slack could not retrieve room info for '%s' via API: %s\n", id, err)
+			sb.rooms.Miss(id)
+			return ""
+		}
+		name = room.Name
 	}
+
+	sb.rooms.Put(id, name)
+
+	return name
 }
 
-// UserNameToId gets the human-readable username for a user ID using an
-// in-memory cache that falls through to the Slack API
+// UserNameToId gets the user ID for a human-readable username using a
+// TTL'd identity cache that falls through to a full user list refresh.
 func (sb Broker) UserNameToId(name string) string {
 	if name == "" {
 		log.Println("broker/slack/UserNameToId(): Cannot look up empty string!")
 		return ""
 	}
 
-	if id, exists := sb.u2i[name]; exists {
+	if id, ok := sb.users.GetByName(name); ok {
 		return id
-	} else {
-		// there doesn't seem to be a name->id lookup so refresh the cache
-		// and try again if we get here
-		sb.FillUserCache()
-		if id, exists := sb.u2i[name]; exists {
-			return id
-		}
+	}
 
-		log.Printf("brokers/slack service does not seem to have knowledge of username %q", name)
-		return ""
+	// there doesn't seem to be a name->id lookup so refresh the cache
+	// and try again if we get here
+	sb.FillUserCache()
+	if id, ok := sb.users.GetByName(name); ok {
+		return id
 	}
+
+	log.Printf("brokers/slack service does not seem to have knowledge of username %q", name)
+	return ""
 }
 
-// RoomNameToId gets the human-readable room name for a user ID using an
-// in-memory cache that falls through to the Slack API
+// RoomNameToId gets the room ID for a human-readable room name using a
+// TTL'd identity cache that falls through to a full room list refresh.
 func (sb Broker) RoomNameToId(name string) string {
 	if name == "" {
 		log.Println("broker/slack/RoomNameToId(): Cannot look up empty string!")
 		return ""
 	}
 
-	if id, exists := sb.c2i[name]; exists {
+	if id, ok := sb.rooms.GetByName(name); ok {
 		return id
-	} else {
-		sb.FillRoomCache()
-		if id, exists := sb.c2i[name]; exists {
-			return id
-		}
+	}
 
-		log.Printf("brokers/slack service does not seem to have knowledge of room name %q", name)
-		return ""
+	sb.FillRoomCache()
+	if id, ok := sb.rooms.GetByName(name); ok {
+		return id
 	}
+
+	log.Printf("brokers/slack service does not seem to have knowledge of room name %q", name)
+	return ""
 }
@@ -0,0 +1,127 @@
+package slack
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/netflix/hal-9001/hal"
+	"github.com/nlopes/slack"
+)
+
+// eventsAPIBacklog is how many parsed events can queue up waiting for
+// streamEventsAPI to forward them to Router before ServeEventsAPI starts
+// dropping them.
+const eventsAPIBacklog = 100
+
+// eventsAPIEnvelope is the outer request body Slack POSTs for Events API
+// subscriptions. Type "url_verification" is the one-time handshake Slack
+// uses to confirm ownership of the endpoint; "event_callback" carries an
+// actual event.
+type eventsAPIEnvelope struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// streamEventsAPI forwards events queued up by ServeEventsAPI to out. It's
+// only meaningful when Config.Mode == "events", since there's nothing else
+// to stream from - the HTTP handler does the actual receiving.
+func (sb Broker) streamEventsAPI(out chan *hal.Evt) {
+	for raw := range sb.eventsAPIQueue() {
+		var common eventCommon
+		if err := json.Unmarshal(raw, &common); err != nil {
+			log.Printf("brokers/slack: could not unmarshal events api event: %s", err)
+			continue
+		}
+
+		if common.Type != "message" {
+			continue
+		}
+
+		var m slack.MessageEvent
+		if err := json.Unmarshal(raw, &m); err != nil {
+			log.Printf("brokers/slack: could not unmarshal message event: %s", err)
+			continue
+		}
+
+		if e, ok := sb.messageToEvt(&m); ok {
+			out <- &e
+		}
+	}
+}
+
+// eventsAPIQueues holds the per-broker-instance channel ServeEventsAPI
+// writes to and streamEventsAPI reads from, keyed by broker instance name
+// since Broker is passed around by value.
+var eventsAPIQueues = make(map[string]chan json.RawMessage)
+
+func (sb Broker) eventsAPIQueue() chan json.RawMessage {
+	if ch, exists := eventsAPIQueues[sb.inst]; exists {
+		return ch
+	}
+
+	ch := make(chan json.RawMessage, eventsAPIBacklog)
+	eventsAPIQueues[sb.inst] = ch
+	return ch
+}
+
+// ServeEventsAPI is an http.HandlerFunc that receives Slack's Events API
+// POSTs for this broker instance: it verifies the request signature,
+// answers the one-time url_verification handshake and otherwise queues the
+// inner event for streamEventsAPI to forward through Stream(). Callers are
+// responsible for mounting it, e.g.
+// http.HandleFunc("/slack/events", sb.ServeEventsAPI).
+func (sb Broker) ServeEventsAPI(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+
+	if !sb.verifySlackSignature(r, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var env eventsAPIEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		http.Error(w, "could not parse body", http.StatusBadRequest)
+		return
+	}
+
+	switch env.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(env.Challenge))
+
+	case "event_callback":
+		w.WriteHeader(http.StatusOK)
+		select {
+		case sb.eventsAPIQueue() <- env.Event:
+		default:
+			log.Println("brokers/slack: events api queue full, dropping event")
+		}
+
+	default:
+		log.Printf("brokers/slack: events api ignoring envelope type %q", env.Type)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,161 @@
+package slack
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// signingSecretMaxSkew bounds how old an X-Slack-Request-Timestamp can be
+// before a request is rejected as a possible replay, per Slack's own
+// verification guidance.
+const signingSecretMaxSkew = 5 * time.Minute
+
+// SetSigningSecret sets the broker's signing secret, used to verify the
+// X-Slack-Signature header on inbound interactive component payloads. It
+// must be set (from the app's "Signing Secret" page) before ServeInteractive
+// is mounted.
+func (sb *Broker) SetSigningSecret(secret string) {
+	sb.signingSecret = secret
+}
+
+// verifySlackSignature implements Slack's v0 request signing scheme:
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func (sb Broker) verifySlackSignature(r *http.Request, body []byte) bool {
+	if sb.signingSecret == "" {
+		log.Println("brokers/slack: SigningSecret is not set, refusing to trust unsigned interactive payload")
+		return false
+	}
+
+	ts := r.Header.Get("X-Slack-Request-Timestamp")
+	sig := r.Header.Get("X-Slack-Signature")
+	if ts == "" || sig == "" {
+		return false
+	}
+
+	tsInt, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if time.Since(time.Unix(tsInt, 0)).Abs() > signingSecretMaxSkew {
+		log.Println("brokers/slack: interactive payload timestamp outside allowed skew, rejecting")
+		return false
+	}
+
+	base := "v0:" + ts + ":" + string(body)
+	mac := hmac.New(sha256.New, []byte(sb.signingSecret))
+	mac.Write([]byte(base))
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
+
+// slackInteractionPayload is the subset of Slack's interaction payload
+// (AttachmentActionCallback for legacy message buttons/menus,
+// InteractionCallback for Block Kit) that ServeInteractive needs.
+type slackInteractionPayload struct {
+	Type        string `json:"type"`
+	CallbackId  string `json:"callback_id"`
+	ResponseURL string `json:"response_url"`
+	TriggerId   string `json:"trigger_id"`
+	User        struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"user"`
+	Channel struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	Actions []struct {
+		Name            string `json:"name"`
+		Value           string `json:"value"`
+		SelectedOptions []struct {
+			Value string `json:"value"`
+		} `json:"selected_options"`
+	} `json:"actions"`
+}
+
+// ServeInteractive is an http.HandlerFunc that receives Slack's interactive
+// component POSTs (buttons, select menus, modal submissions): it verifies
+// the request signature, decodes the payload into a hal.InteractionEvt, and
+// dispatches it through hal.InteractionRegistry so the plugin that
+// originally registered the callback_id can handle it. Callers mount it
+// themselves, e.g. http.HandleFunc("/slack/interactive", sb.ServeInteractive).
+func (sb Broker) ServeInteractive(w http.ResponseWriter, r *http.Request) {
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+
+	if !sb.verifySlackSignature(r, rawBody) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(rawBody))
+	if err != nil {
+		http.Error(w, "could not parse form body", http.StatusBadRequest)
+		return
+	}
+	raw := form.Get("payload")
+
+	var p slackInteractionPayload
+	if err := json.Unmarshal([]byte(raw), &p); err != nil {
+		http.Error(w, "could not parse payload", http.StatusBadRequest)
+		return
+	}
+
+	ie := hal.InteractionEvt{
+		CallbackId:  p.CallbackId,
+		User:        p.User.Name,
+		UserId:      p.User.Id,
+		Room:        p.Channel.Name,
+		RoomId:      p.Channel.Id,
+		ResponseURL: p.ResponseURL,
+		TriggerId:   p.TriggerId,
+		Broker:      sb,
+		Original:    p,
+	}
+
+	if len(p.Actions) > 0 {
+		a := p.Actions[0]
+		ie.ActionName = a.Name
+		if len(a.SelectedOptions) > 0 {
+			ie.Value = a.SelectedOptions[0].Value
+		} else {
+			ie.Value = a.Value
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if !hal.InteractionRegistry().Dispatch(ie) {
+		log.Printf("brokers/slack: no handler registered for callback_id %q, ignoring", p.CallbackId)
+	}
+}
@@ -0,0 +1,187 @@
+package slack
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Slack's documented rate-limit tiers (https://api.slack.com/docs/rate-limits);
+// tierRatePerMinute maps each to its requests/minute. Tier1 is the most restrictive.
+const (
+	Tier1 = 1
+	Tier2 = 2
+	Tier3 = 3
+	Tier4 = 4
+)
+
+var tierRatePerMinute = map[int]int{
+	Tier1: 1,
+	Tier2: 20,
+	Tier3: 50,
+	Tier4: 100,
+}
+
+// tokenBucket is a simple refill-on-demand bucket: Take blocks until a
+// token is available, refilling lazily based on elapsed time rather than a
+// background goroutine.
+type tokenBucket struct {
+	mut        sync.Mutex
+	tokens     float64
+	max        float64
+	perSecond  float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(perMinute int) *tokenBucket {
+	rate := float64(perMinute) / 60.0
+	return &tokenBucket{
+		tokens:     float64(perMinute),
+		max:        float64(perMinute),
+		perSecond:  rate,
+		lastRefill: time.Now(),
+	}
+}
+
+// Take blocks until a token is available, then consumes it.
+func (tb *tokenBucket) Take() {
+	for {
+		tb.mut.Lock()
+		now := time.Now()
+		elapsed := now.Sub(tb.lastRefill).Seconds()
+		tb.tokens = minF(tb.max, tb.tokens+elapsed*tb.perSecond)
+		tb.lastRefill = now
+
+		if tb.tokens >= 1 {
+			tb.tokens--
+			tb.mut.Unlock()
+			return
+		}
+
+		wait := time.Duration((1 - tb.tokens) / tb.perSecond * float64(time.Second))
+		tb.mut.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+func minF(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// rateLimiter keeps one tokenBucket per Slack API tier, shared across all
+// calls made by a Broker instance.
+type rateLimiter struct {
+	mut     sync.Mutex
+	buckets map[int]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[int]*tokenBucket)}
+}
+
+func (rl *rateLimiter) bucket(tier int) *tokenBucket {
+	rl.mut.Lock()
+	defer rl.mut.Unlock()
+
+	b, exists := rl.buckets[tier]
+	if !exists {
+		b = newTokenBucket(tierRatePerMinute[tier])
+		rl.buckets[tier] = b
+	}
+
+	return b
+}
+
+// slackRateLimitError is the interface nlopes/slack's *slack.RateLimitedError
+// satisfies (RetryAfter()); declared locally so this file doesn't have to
+// import the concrete type in every call site.
+type slackRateLimitError interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// maxRetries bounds how many times call() will retry a 429 before giving up
+// and returning the error to the caller.
+const maxRetries = 5
+
+// call runs fn, pre-gating it on the tier's token bucket and retrying with
+// the server-provided Retry-After delay (or exponential backoff, if the
+// error doesn't expose one) on rate-limit errors.
+func (sb Broker) call(tier int, fn func() error) error {
+	bucket := sb.limiter.bucket(tier)
+
+	var err error
+	backoff := time.Second
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		bucket.Take()
+
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		if rle, ok := err.(slackRateLimitError); ok {
+			wait := rle.RetryAfter()
+			if wait <= 0 {
+				wait = backoff
+				backoff *= 2
+			}
+			log.Printf("brokers/slack: tier %d call rate-limited, retrying in %s", tier, wait)
+			time.Sleep(wait)
+			continue
+		}
+
+		// some client versions surface 429s as a plain error with the
+		// Retry-After value embedded in the message; best-effort parse it
+		if secs, ok := parseRetryAfterFromError(err); ok {
+			wait := time.Duration(secs) * time.Second
+			log.Printf("brokers/slack: tier %d call rate-limited (parsed), retrying in %s", tier, wait)
+			time.Sleep(wait)
+			continue
+		}
+
+		return err
+	}
+
+	return err
+}
+
+// parseRetryAfterFromError is a last-resort fallback for client errors that
+// don't implement slackRateLimitError but do mention a numeric
+// "retry-after" style value; it's intentionally conservative and only
+// fires on an exact, well-known message shape.
+func parseRetryAfterFromError(err error) (seconds int, ok bool) {
+	const prefix = "slack rate limit exceeded, retry after "
+	msg := err.Error()
+	if len(msg) <= len(prefix) || msg[:len(prefix)] != prefix {
+		return 0, false
+	}
+
+	n, perr := strconv.Atoi(msg[len(prefix):])
+	if perr != nil {
+		return 0, false
+	}
+
+	return n, true
+}
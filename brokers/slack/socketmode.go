@@ -0,0 +1,196 @@
+package slack
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/netflix/hal-9001/hal"
+	"github.com/nlopes/slack"
+)
+
+// socketModeOpenURL is the web API method used to obtain a one-shot
+// Socket Mode websocket URL. The URL it returns is only valid for a single
+// connection and expires quickly, so it must be fetched fresh on every
+// (re)connect.
+const socketModeOpenURL = "https://slack.com/api/apps.connections.open"
+
+// socketEnvelope is the outer message wrapper Socket Mode sends over the
+// websocket. Payload is re-unmarshaled based on Type once the envelope
+// itself has been decoded.
+type socketEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeId string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// socketAck is sent back for every envelope that carries an EnvelopeId, as
+// required by the Socket Mode protocol to avoid Slack re-delivering it.
+type socketAck struct {
+	EnvelopeId string `json:"envelope_id"`
+}
+
+// eventsAPIPayload is the subset of the Events API "payload" wrapper that
+// Socket Mode forwards for type=events_api envelopes.
+type eventsAPIPayload struct {
+	Event json.RawMessage `json:"event"`
+}
+
+// eventCommon is enough of the inner Events API event to dispatch on Type.
+type eventCommon struct {
+	Type string `json:"type"`
+}
+
+// streamSocketMode connects to Slack's Socket Mode websocket, acking and
+// decoding envelopes as they arrive, and reconnects (with backoff) whenever
+// the connection drops - the URL returned by apps.connections.open is only
+// good for one connection.
+func (sb Broker) streamSocketMode(out chan *hal.Evt) {
+	backoff := time.Second
+
+	for {
+		wsURL, err := sb.openSocketModeURL()
+		if err != nil {
+			log.Printf("brokers/slack: apps.connections.open failed: %s (retrying in %s)", err, backoff)
+			time.Sleep(backoff)
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		conn, err := websocket.Dial(wsURL, "", "https://slack.com/")
+		if err != nil {
+			log.Printf("brokers/slack: socket mode dial failed: %s (retrying in %s)", err, backoff)
+			time.Sleep(backoff)
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		log.Println("brokers/slack: socket mode connected")
+		backoff = time.Second
+
+		sb.readSocketModeConn(conn, out)
+
+		log.Println("brokers/slack: socket mode connection closed, reconnecting")
+	}
+}
+
+// readSocketModeConn reads envelopes from conn until it errors out or closes.
+func (sb Broker) readSocketModeConn(conn *websocket.Conn, out chan *hal.Evt) {
+	defer conn.Close()
+
+	for {
+		var env socketEnvelope
+		if err := websocket.JSON.Receive(conn, &env); err != nil {
+			log.Printf("brokers/slack: socket mode receive error: %s", err)
+			return
+		}
+
+		if env.EnvelopeId != "" {
+			if err := websocket.JSON.Send(conn, socketAck{EnvelopeId: env.EnvelopeId}); err != nil {
+				log.Printf("brokers/slack: socket mode ack failed: %s", err)
+			}
+		}
+
+		switch env.Type {
+		case "hello":
+			log.Println("brokers/slack: socket mode hello")
+		case "disconnect":
+			log.Println("brokers/slack: socket mode server requested disconnect")
+			return
+		case "events_api":
+			sb.handleSocketModeEvent(env.Payload, out)
+		default:
+			log.Printf("brokers/slack: socket mode ignoring envelope type %q", env.Type)
+		}
+	}
+}
+
+// handleSocketModeEvent unwraps the events_api envelope payload and
+// converts the event it carries into a hal.Evt when it's a type we handle.
+func (sb Broker) handleSocketModeEvent(raw json.RawMessage, out chan *hal.Evt) {
+	var p eventsAPIPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		log.Printf("brokers/slack: could not unmarshal events_api payload: %s", err)
+		return
+	}
+
+	var common eventCommon
+	if err := json.Unmarshal(p.Event, &common); err != nil {
+		log.Printf("brokers/slack: could not unmarshal events_api event: %s", err)
+		return
+	}
+
+	if common.Type != "message" {
+		return
+	}
+
+	var m slack.MessageEvent
+	if err := json.Unmarshal(p.Event, &m); err != nil {
+		log.Printf("brokers/slack: could not unmarshal message event: %s", err)
+		return
+	}
+
+	if e, ok := sb.messageToEvt(&m); ok {
+		out <- &e
+	}
+}
+
+// openSocketModeURL calls apps.connections.open with the broker's app-level
+// token to obtain a fresh, single-use websocket URL.
+func (sb Broker) openSocketModeURL() (string, error) {
+	req, err := http.NewRequest("POST", socketModeOpenURL, bytes.NewBufferString(""))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+sb.appToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Ok    bool   `json:"ok"`
+		Url   string `json:"url"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	if !body.Ok {
+		return "", fmt.Errorf("apps.connections.open: %s", body.Error)
+	}
+
+	return body.Url, nil
+}
+
+func nextBackoff(cur time.Duration) time.Duration {
+	next := cur * 2
+	if next > 30*time.Second {
+		return 30 * time.Second
+	}
+	return next
+}
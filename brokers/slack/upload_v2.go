@@ -0,0 +1,181 @@
+package slack
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// uploadV2GetURLEndpoint/uploadV2CompleteEndpoint are the two web API calls
+// that bracket the actual file PUT in Slack's v2 upload flow, replacing the
+// deprecated files.upload endpoint UploadFile() (FileUploadParameters)
+// drives.
+const (
+	uploadV2GetURLEndpoint  = "https://slack.com/api/files.getUploadURLExternal"
+	uploadV2CompleteEndpoint = "https://slack.com/api/files.completeUploadExternal"
+)
+
+// uploadFileV2Params mirrors slack.FileUploadParameters closely enough that
+// callers can swap between the two upload paths with minimal churn.
+type uploadFileV2Params struct {
+	File     string   // path to the file on disk
+	Filename string   // filename to show in Slack
+	Title    string   // optional title for the uploaded file
+	Channels []string // channel ids to share the file to; only the first is used
+}
+
+// uploadFileV2 implements Slack's getUploadURLExternal -> PUT -> completeUploadExternal
+// flow: request a presigned upload URL sized for the file, stream the file
+// to it over HTTP PUT, then tell Slack the upload is done and which
+// channels to share it to.
+func (sb Broker) uploadFileV2(p uploadFileV2Params) error {
+	info, err := os.Stat(p.File)
+	if err != nil {
+		return fmt.Errorf("could not stat upload file: %w", err)
+	}
+
+	fileId, uploadURL, err := sb.getUploadURLExternal(p.Filename, int(info.Size()))
+	if err != nil {
+		return err
+	}
+
+	if err := putUploadFile(uploadURL, p.File); err != nil {
+		return err
+	}
+
+	return sb.completeUploadExternal(fileId, p.Title, p.Channels)
+}
+
+// getUploadURLExternal requests a presigned, single-use upload URL for a
+// file of the given name/length.
+func (sb Broker) getUploadURLExternal(filename string, length int) (fileId, uploadURL string, err error) {
+	form := url.Values{
+		"filename": {filename},
+		"length":   {fmt.Sprintf("%d", length)},
+	}
+
+	req, err := http.NewRequest("POST", uploadV2GetURLEndpoint, bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+sb.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Ok        bool   `json:"ok"`
+		Error     string `json:"error"`
+		FileId    string `json:"file_id"`
+		UploadURL string `json:"upload_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", "", err
+	}
+	if !body.Ok {
+		return "", "", fmt.Errorf("files.getUploadURLExternal: %s", body.Error)
+	}
+
+	return body.FileId, body.UploadURL, nil
+}
+
+// putUploadFile streams the local file to the presigned upload URL.
+func putUploadFile(uploadURL, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upload PUT returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// completeUploadExternal tells Slack the upload identified by fileId is
+// finished and shares it to channels[0], if any; further channels are not
+// used.
+func (sb Broker) completeUploadExternal(fileId, title string, channels []string) error {
+	payload := struct {
+		Files []struct {
+			Id    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"files"`
+		ChannelId string `json:"channel_id,omitempty"`
+	}{
+		Files: []struct {
+			Id    string `json:"id"`
+			Title string `json:"title"`
+		}{{Id: fileId, Title: title}},
+	}
+	if len(channels) > 0 {
+		payload.ChannelId = channels[0]
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", uploadV2CompleteEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+sb.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var respBody struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
+		return err
+	}
+	if !respBody.Ok {
+		return fmt.Errorf("files.completeUploadExternal: %s", respBody.Error)
+	}
+
+	return nil
+}
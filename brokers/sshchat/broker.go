@@ -22,42 +22,116 @@ import (
 	"io"
 	"io/ioutil"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/tobert/hal-9001/hal"
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/netflix/hal-9001/hal"
+	"github.com/netflix/hal-9001/hal/ansi"
 	"golang.org/x/crypto/ssh"
 )
 
 const ansiCleanReSrc = "[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))"
 const parseMsgReSrc = `^\[\w+\] (\w+): (.*)\s*$`
 
-var log hal.Logger
-var ansiCleanRe, parseMsgRe *regexp.Regexp
+// fingerprintReSrc matches the ssh.FingerprintSHA256 form LooksLikeUserId
+// checks server-mode user ids against, e.g. "SHA256:47DEQpj8HBSa+...".
+const fingerprintReSrc = `^SHA256:[A-Za-z0-9+/]{43}$`
 
-// Broker interacts with the sshchat service.
+var log hal.Logger
+var ansiCleanRe, parseMsgRe, fingerprintRe *regexp.Regexp
+
+// Broker interacts with the sshchat service, either as a client dialing
+// out to one (Config.Mode == "client", the original and still-default
+// behavior) or as an embedded server accepting inbound connections
+// (Config.Mode == "server", see newServerBroker). The two modes share
+// Name/FormatRelayed/PreferredTableFormat but otherwise implement the
+// Broker interface's methods independently, gated on mode.
 type Broker struct {
-	inst       string // the instance name of the broker
+	inst string // the instance name of the broker
+	mode string // "client" or "server", see Config.Mode
+
+	// client mode
 	sshConfig  *ssh.ClientConfig
 	sshClient  *ssh.Client
 	sshSession *ssh.Session
 	stdin      chan string
 	stdout     chan string
 	stderr     chan string
+
+	// server mode
+	sshServer           *gliderssh.Server
+	adminFingerprints   []string
+	allowedFingerprints []string
+	motd                *string // swappable via SetTopic, read by new sessions
+	sessMut             *sync.Mutex
+	sessions            map[string]*chatSession // fingerprint -> session
+	events              chan *hal.Evt           // fed by each session's read loop, drained by Stream
+	termWidth           map[string]int          // fingerprint -> last reported terminal width, see setTermWidth
+}
+
+// defaultTermWidth is used for SendAsImage/SendTable sizing until a
+// session reports otherwise, via its PTY's window size or "!termsize".
+const defaultTermWidth = 80
+
+// chatSession is one connected server-mode client: the underlying
+// gliderlabs/ssh session to write replies to, and the stable identity
+// LooksLikeUserId/UserIdToName key off of.
+type chatSession struct {
+	fingerprint string
+	nick        string
+	sess        gliderssh.Session
 }
 
 type Config struct {
-	SSHUsername string // the ssh username
-	SSHKeyFile  string // path to the private ssh key
+	SSHUsername string // the ssh username, client mode only
+	SSHKeyFile  string // path to the private ssh key, client mode only
+
+	// Mode selects "client" (dial out to an existing sshchat server, the
+	// default/original behavior) or "server" (embed one via
+	// github.com/gliderlabs/ssh, accepting inbound connections directly).
+	Mode string
+
+	// server mode only, below
+
+	ListenAddr  string // e.g. ":2022"
+	HostKeyFile string // path to the server's host private key
+
+	// AdminFingerprints are ssh.FingerprintSHA256 values granted admin
+	// privileges (topic changes, etc) -- mirrors shazow/ssh-chat's
+	// --admin flag.
+	AdminFingerprints []string
+
+	// AllowedFingerprints, if non-empty, restricts inbound connections to
+	// keys whose ssh.FingerprintSHA256 appears in the list -- shazow/
+	// ssh-chat's whitelist mode. Empty means any public key is accepted,
+	// same as ssh-chat's default open mode.
+	AllowedFingerprints []string
+
+	MOTD string // shown to a session on connect and via SetTopic
 }
 
 func init() {
 	log.SetPrefix("brokers/sshchat")
 	ansiCleanRe = regexp.MustCompile(ansiCleanReSrc)
 	parseMsgRe = regexp.MustCompile(parseMsgReSrc)
+	fingerprintRe = regexp.MustCompile(fingerprintReSrc)
 }
 
+// NewBroker dials out to an existing sshchat server (Config.Mode ==
+// "client", the default, for backward compat with existing configs) or
+// embeds one (Config.Mode == "server") depending on Config.Mode.
 func (c Config) NewBroker(name string) Broker {
+	if c.Mode == "server" {
+		return c.newServerBroker(name)
+	}
+
+	return c.newClientBroker(name)
+}
+
+func (c Config) newClientBroker(name string) Broker {
 	var sshConf ssh.ClientConfig
 
 	sshConf.SetDefaults()
@@ -97,6 +171,7 @@ func (c Config) NewBroker(name string) Broker {
 
 	return Broker{
 		inst:       name,
+		mode:       "client",
 		sshConfig:  &sshConf,
 		sshClient:  client,
 		sshSession: sess,
@@ -106,6 +181,129 @@ func (c Config) NewBroker(name string) Broker {
 	}
 }
 
+// newServerBroker builds a Broker that embeds an ssh server via
+// github.com/gliderlabs/ssh instead of dialing out to one, authenticating
+// inbound connections by public key fingerprint and presenting each
+// session a line-based chat PTY -- every line a user sends becomes a
+// hal.Evt directly, with no ANSI stripping or regex parsing since we
+// control both ends of the wire.
+func (c Config) newServerBroker(name string) Broker {
+	motd := c.MOTD
+
+	b := Broker{
+		inst:                name,
+		mode:                "server",
+		adminFingerprints:   c.AdminFingerprints,
+		allowedFingerprints: c.AllowedFingerprints,
+		motd:                &motd,
+		sessMut:             &sync.Mutex{},
+		sessions:            make(map[string]*chatSession),
+		events:              make(chan *hal.Evt, 64),
+		termWidth:           make(map[string]int),
+	}
+
+	srv := &gliderssh.Server{
+		Addr: c.ListenAddr,
+		PublicKeyHandler: func(ctx gliderssh.Context, key gliderssh.PublicKey) bool {
+			if len(b.allowedFingerprints) == 0 {
+				return true
+			}
+
+			fp := gliderssh.FingerprintSHA256(key)
+			for _, want := range b.allowedFingerprints {
+				if fp == want {
+					return true
+				}
+			}
+
+			return false
+		},
+		Handler: func(sess gliderssh.Session) {
+			b.handleSession(sess)
+		},
+	}
+
+	if c.HostKeyFile != "" {
+		if err := srv.SetOption(gliderssh.HostKeyFile(c.HostKeyFile)); err != nil {
+			log.Fatalf("Could not load ssh host key %q: %s", c.HostKeyFile, err)
+		}
+	}
+
+	b.sshServer = srv
+
+	go func() {
+		log.Printf("sshchat server mode listening on %s", c.ListenAddr)
+		if err := srv.ListenAndServe(); err != nil {
+			log.Fatalf("sshchat server stopped: %s", err)
+		}
+	}()
+
+	return b
+}
+
+// handleSession is the gliderlabs/ssh Handler for a single connected
+// client: it registers the session, emits synthetic join/part hal.Evts
+// around a line-reading loop, and removes the session on disconnect.
+func (b Broker) handleSession(sess gliderssh.Session) {
+	key := sess.PublicKey()
+	if key == nil {
+		io.WriteString(sess, "a public key is required to chat here\r\n")
+		sess.Exit(1)
+		return
+	}
+
+	fp := gliderssh.FingerprintSHA256(key)
+
+	cs := &chatSession{fingerprint: fp, nick: b.UserIdToName(fp), sess: sess}
+
+	b.sessMut.Lock()
+	b.sessions[fp] = cs
+	b.sessMut.Unlock()
+
+	if *b.motd != "" {
+		io.WriteString(sess, *b.motd+"\r\n")
+	}
+
+	if pty, winCh, isPty := sess.Pty(); isPty {
+		b.setTermWidth(fp, pty.Window.Width)
+		go func() {
+			for win := range winCh {
+				b.setTermWidth(fp, win.Width)
+			}
+		}()
+	}
+
+	b.events <- &hal.Evt{
+		User: cs.nick, UserId: fp, Room: "lobby", RoomId: "lobby",
+		Time: time.Now(), IsJoin: true, Brokers: hal.Brokers{b}, Original: sess,
+	}
+
+	scanner := bufio.NewScanner(sess)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if w, ok := parseTermSizeCmd(line); ok {
+			b.setTermWidth(fp, w)
+			io.WriteString(sess, fmt.Sprintf("term width set to %d\r\n", w))
+			continue
+		}
+
+		b.events <- &hal.Evt{
+			Body: line, User: cs.nick, UserId: fp, Room: "lobby", RoomId: "lobby",
+			Time: time.Now(), Brokers: hal.Brokers{b}, Original: sess,
+		}
+	}
+
+	b.sessMut.Lock()
+	delete(b.sessions, fp)
+	b.sessMut.Unlock()
+
+	b.events <- &hal.Evt{
+		User: cs.nick, UserId: fp, Room: "lobby", RoomId: "lobby",
+		Time: time.Now(), IsPart: true, Brokers: hal.Brokers{b}, Original: sess,
+	}
+}
+
 // also cleans ansi text to return plain text
 func forwardReaderToChan(reader io.Reader, ch chan string) {
 	scanner := bufio.NewScanner(reader)
@@ -139,55 +337,239 @@ func (b Broker) Name() string {
 	return b.inst
 }
 
+// FormatRelayed implements hal.BrokerFormatter, rendering a message
+// bridged in from another broker as sshchat's plain IRC-style text: a
+// "<user@room>" prefix ahead of the body, with no markup of any kind.
+func (b Broker) FormatRelayed(sourceBroker, sourceRoom, sourceUser, body string) string {
+	return fmt.Sprintf("<%s@%s> %s", sourceUser, sourceRoom, body)
+}
+
 func (b Broker) Send(evt hal.Evt) {
+	if b.mode == "server" {
+		b.broadcast(evt.Body)
+		return
+	}
+
 	lines := strings.Split(evt.Body, "\n")
 	for _, line := range lines {
 		b.stdin <- line
 	}
 }
 
+// broadcast writes body to every connected server-mode session, one
+// ssh-chat-style line per session.
+func (b Broker) broadcast(body string) {
+	b.sessMut.Lock()
+	defer b.sessMut.Unlock()
+
+	for _, cs := range b.sessions {
+		io.WriteString(cs.sess, body+"\r\n")
+	}
+}
+
+// termsizeCmdRe matches the "!termsize <width>" fallback sessions without
+// a PTY (or a client that doesn't forward window-change) can use to
+// report their terminal width; see setTermWidth.
+var termsizeCmdRe = regexp.MustCompile(`^!termsize\s+(\d+)\s*$`)
+
+// parseTermSizeCmd reports whether line is a "!termsize <width>" command
+// and, if so, the width it set.
+func parseTermSizeCmd(line string) (int, bool) {
+	m := termsizeCmdRe.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+
+	w, err := strconv.Atoi(m[1])
+	if err != nil || w <= 0 {
+		return 0, false
+	}
+
+	return w, true
+}
+
+// setTermWidth records fingerprint's last-known terminal width, from
+// either a PTY window-change event or a "!termsize" command.
+func (b Broker) setTermWidth(fingerprint string, width int) {
+	if width <= 0 {
+		return
+	}
+
+	b.sessMut.Lock()
+	b.termWidth[fingerprint] = width
+	b.sessMut.Unlock()
+}
+
+// termWidthFor returns fingerprint's last-known terminal width, or
+// defaultTermWidth if it's never reported one.
+func (b Broker) termWidthFor(fingerprint string) int {
+	b.sessMut.Lock()
+	w, ok := b.termWidth[fingerprint]
+	b.sessMut.Unlock()
+
+	if !ok || w <= 0 {
+		return defaultTermWidth
+	}
+
+	return w
+}
+
 func (b Broker) SendAsSnippet(evt hal.Evt) {
 	log.Panic("SendAsSnippet() not implemented yet")
 }
 
-// SendAsIs directly sends a message without considering it for posting as a snippet.
+// SendAsIs directly sends a message without considering it for posting as
+// a snippet. Server mode just broadcasts evt.Body like Send does; client
+// mode's stub predates this broker gaining two modes and is unchanged
+// here.
 func (b Broker) SendAsIs(evt hal.Evt) {
+	if b.mode == "server" {
+		b.broadcast(evt.Body)
+		return
+	}
+
 	log.Panic("SendAsIs() not implemented yet")
 }
 
+// SendDM delivers evt.Body to the single server-mode session identified by
+// evt.UserId (a fingerprint, see LooksLikeUserId); client mode has no
+// per-user channel to target, so it's unimplemented there as before.
 func (b Broker) SendDM(evt hal.Evt) {
-	log.Panic("SendDM() not implemented yet")
+	if b.mode != "server" {
+		log.Panic("SendDM() not implemented yet")
+	}
+
+	b.sessMut.Lock()
+	cs, ok := b.sessions[evt.UserId]
+	b.sessMut.Unlock()
+
+	if !ok {
+		log.Printf("SendDM(): no connected session for %q", evt.UserId)
+		return
+	}
+
+	io.WriteString(cs.sess, evt.Body+"\r\n")
 }
 
+// Leave disconnects every session in roomId -- in server mode there's
+// only ever one room ("lobby"), so this is a kick-everyone, used e.g. by
+// an operator shutting the chat down without killing the whole broker.
 func (b Broker) Leave(roomId string) error {
-	log.Panic("Leave() not implemented yet")
-	return fmt.Errorf("nope")
+	if b.mode != "server" {
+		log.Panic("Leave() not implemented yet")
+		return fmt.Errorf("nope")
+	}
+
+	if roomId != "lobby" {
+		return fmt.Errorf("sshchat: no such room %q", roomId)
+	}
+
+	b.sessMut.Lock()
+	defer b.sessMut.Unlock()
+
+	for _, cs := range b.sessions {
+		cs.sess.Close()
+	}
+
+	return nil
 }
 
 func (b Broker) GetTopic(roomId string) (string, error) {
+	if b.mode == "server" && roomId == "lobby" {
+		return *b.motd, nil
+	}
+
 	log.Panic("GetTopic() not implemented yet")
 	return "", fmt.Errorf("nope")
 }
 
+// SetTopic updates the server-mode MOTD shown to new sessions and
+// broadcasts the change to everyone currently connected.
 func (b Broker) SetTopic(roomId, topic string) error {
-	log.Panic("SetTopic() not implemented yet")
-	return fmt.Errorf("nope")
+	if b.mode != "server" {
+		log.Panic("SetTopic() not implemented yet")
+		return fmt.Errorf("nope")
+	}
+
+	if roomId != "lobby" {
+		return fmt.Errorf("sshchat: no such room %q", roomId)
+	}
+
+	*b.motd = topic
+	b.broadcast("* topic changed: " + topic)
+
+	return nil
 }
 
+// PreferredTableFormat implements hal.TableFormatter: ssh clients are
+// terminals, so box-drawing is the right format, same as Utf8Table was
+// hardcoded to before.
+func (b Broker) PreferredTableFormat() hal.TableFormat {
+	return hal.TableFormatUtf8
+}
+
+// SendTable renders hdr/rows as an hal/ansi truecolor table for server
+// mode, using evt.UserId's image.fg/image.bg preferences (see
+// ansi.UserColors) for a zebra-striped, colored header rendering instead
+// of the plain box-drawing table client mode still falls back to.
 func (b Broker) SendTable(evt hal.Evt, hdr []string, rows [][]string) {
 	out := evt.Clone()
-	out.Body = hal.Utf8Table(hdr, rows)
 
-	// in other brokers this might allow sending an image but that
-	// doesn't matter here since we can count on monospace rendering
+	if b.mode == "server" {
+		fg, headerBg := ansi.UserColors(evt.UserId)
+		out.Body = ansi.Table(hdr, rows, fg, headerBg, ansi.DefaultZebraBG)
+	} else {
+		out.Body = hal.RenderTable(b.PreferredTableFormat(), hdr, rows)
+	}
+
 	b.SendAsIs(out)
 }
 
-// TODO: it might be fun to do ANSI formatting and support the image.fg and bg
-// preferences like the Slack broker does, but for color terminals.
+// SendAsImage renders evt.Body as a truecolor ANSI block sized to
+// evt.UserId's last-reported terminal width (see termWidthFor): a
+// braille bar chart (hal/ansi.Sparkline) if the body is a bare numeric
+// series, or the body's own lines colorized with the user's image.fg/
+// image.bg preferences otherwise. Client mode has no color terminal to
+// render for, so it keeps forwarding the plain body as before.
 func (b Broker) SendAsImage(evt hal.Evt) {
-	// just forward, same reason as SendTable
-	b.SendAsIs(evt)
+	if b.mode != "server" {
+		b.SendAsIs(evt)
+		return
+	}
+
+	fg, bg := ansi.UserColors(evt.UserId)
+	out := evt.Clone()
+
+	if series, ok := parseNumericSeries(evt.Body); ok {
+		width := b.termWidthFor(evt.UserId)
+		out.Body = bg.BG() + fg.FG() + ansi.Sparkline(series, width) + ansi.Reset
+	} else {
+		out.Body = ansi.ColorizeLines(evt.Body, fg, bg)
+	}
+
+	b.SendAsIs(out)
+}
+
+// parseNumericSeries reports whether body is nothing but whitespace/
+// comma-separated numbers, returning them if so.
+func parseNumericSeries(body string) ([]float64, bool) {
+	fields := strings.FieldsFunc(strings.TrimSpace(body), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+	if len(fields) == 0 {
+		return nil, false
+	}
+
+	values := make([]float64, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, false
+		}
+		values = append(values, v)
+	}
+
+	return values, true
 }
 
 // usernames and ids are the same in sshchat
@@ -195,18 +577,41 @@ func (b Broker) LooksLikeRoomId(room string) bool {
 	return true
 }
 
+// LooksLikeUserId reports whether user is shaped like a server-mode user
+// id (an ssh.FingerprintSHA256 value, see handleSession); client mode
+// nicknames and ids are interchangeable strings, so anything matches
+// there, same as before.
 func (b Broker) LooksLikeUserId(user string) bool {
+	if b.mode == "server" {
+		return fingerprintRe.MatchString(user)
+	}
+
 	return true
 }
 
-// checks the cache to see if the room is known to this broker
+// HasRoom reports whether room is known to this broker -- server mode has
+// exactly one room, "lobby"; client mode has never tracked a room list.
 func (b Broker) HasRoom(room string) bool {
+	if b.mode == "server" {
+		return room == "lobby"
+	}
+
 	log.Panic("HasRoom() not implemented yet")
 	return false
 }
 
-// Stream is an event loop for messages from the ssh channel.
+// Stream is an event loop for messages from the ssh channel. In server
+// mode it simply drains b.events, already-built by handleSession; in
+// client mode it parses sshchat's "[room] user: body" lines scraped off
+// the ssh session's stdout, same as before.
 func (b Broker) Stream(out chan *hal.Evt) {
+	if b.mode == "server" {
+		for e := range b.events {
+			out <- e
+		}
+		return
+	}
+
 	log.Printf("listening on sshchat...")
 
 	for {
@@ -224,15 +629,13 @@ func (b Broker) Stream(out chan *hal.Evt) {
 			body := matches[2]
 
 			e := hal.Evt{
-				ID:       fmt.Sprintf("%d.%06d", now.Unix(), now.UnixNano()),
 				User:     user,
 				UserId:   user,
 				Room:     "lobby",
 				RoomId:   "lobby",
 				Body:     body,
 				Time:     now,
-				Broker:   b,
-				IsChat:   true,
+				Brokers:  hal.Brokers{b},
 				Original: &msg,
 			}
 
@@ -241,19 +644,41 @@ func (b Broker) Stream(out chan *hal.Evt) {
 			log.Printf("Server stderr: %q", msg)
 		}
 	}
-
-	log.Printf("no longer listening on sshchat...")
 }
 
+// userNickKVPrefix namespaces a server-mode user's stable nickname in
+// hal's KV store, keyed by fingerprint -- hal.Pref would be the more
+// obvious fit (and is what a config-driven nickname might eventually use)
+// but, as noted in hal/rpc's Server.prefKV, hal.Pref is referenced
+// throughout the codebase yet never defined, so GetKV/SetKV is the
+// closest real persistence this tree has.
+const userNickKVPrefix = "sshchat.nick."
+
+// UserIdToName resolves a server-mode fingerprint to its persisted
+// nickname (see userNickKVPrefix), falling back to the fingerprint itself
+// for a user who hasn't set one. Client mode ids and nicknames have
+// always been the same string, so it's returned unchanged there.
 func (b Broker) UserIdToName(id string) string {
 	if id == "" {
 		log.Debugf("UserIdToName(): Cannot look up empty string!")
 		return ""
 	}
 
+	if b.mode == "server" {
+		if nick, err := hal.GetKV(userNickKVPrefix + id); err == nil && nick != "" {
+			return nick
+		}
+	}
+
 	return id
 }
 
+// SetNick persists nick as fingerprint's stable server-mode nickname (see
+// userNickKVPrefix), surfaced so a chat plugin can offer a "!nick" command.
+func (b Broker) SetNick(fingerprint, nick string) error {
+	return hal.SetKV(userNickKVPrefix+fingerprint, nick, 0)
+}
+
 func (b Broker) RoomIdToName(id string) string {
 	if id == "" {
 		log.Debugf("RoomIdToName(): Cannot look up empty string!")
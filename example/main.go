@@ -106,7 +106,10 @@ func main() {
 			hipchatRoomJid: hipchatRoomName,
 		},
 	}
-	hc := hconf.NewBroker("hipchat")
+	hc, err := hconf.NewBroker("hipchat")
+	if err != nil {
+		log.Fatalf("Could not set up the Hipchat broker: %s", err)
+	}
 
 	// configure the Slack broker
 	sconf := slack.Config{
@@ -18,7 +18,8 @@ package main
 
 import (
 	"fmt"
-	"time"
+	"os"
+	"path/filepath"
 
 	"github.com/chzyer/readline"
 
@@ -34,13 +35,38 @@ import (
 // a simple bot that only implements generic plugins on a repl
 // possibly a basis for a command-line client for Slack, etc....
 
-func main() {
-	rl, err := readline.New("hal> ")
-	if err != nil {
-		panic(err)
+// historyFile returns where readline persists line history between runs of
+// the repl, falling back to the current directory if $HOME isn't set.
+func historyFile() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ".hal9001_repl_history"
+	}
+	return filepath.Join(home, ".hal9001_repl_history")
+}
+
+// buildCompleter turns every hal.Cmd tree registered via hal.CmdRegistry
+// (e.g. "!pref" -> set/list/rm) into readline tab-completion, alongside the
+// console broker's own "/room", "/user", and "/reaction" slash commands.
+func buildCompleter() *readline.PrefixCompleter {
+	items := []readline.PrefixCompleterInterface{
+		readline.PcItem("/room"),
+		readline.PcItem("/user"),
+		readline.PcItem("/reaction"),
+	}
+
+	for _, cmd := range hal.CmdRegistry().List() {
+		subItems := make([]readline.PrefixCompleterInterface, 0, len(cmd.ListSubCmds()))
+		for _, sub := range cmd.ListSubCmds() {
+			subItems = append(subItems, readline.PcItem(sub.Token()))
+		}
+		items = append(items, readline.PcItem("!"+cmd.Token(), subItems...))
 	}
-	defer rl.Close()
 
+	return readline.NewPrefixCompleter(items...)
+}
+
+func main() {
 	bconf := console.Config{}
 	broker := bconf.NewBroker("cli")
 
@@ -57,6 +83,18 @@ func main() {
 	prefmgr.Register()
 	uptime.Register()
 
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          "hal> ",
+		HistoryFile:     historyFile(),
+		AutoComplete:    buildCompleter(),
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer rl.Close()
+
 	pr := hal.PluginRegistry()
 	pmp, _ := pr.GetPlugin("pluginmgr")
 	pmp.Instance(broker.Room, broker).Register()
@@ -69,10 +107,6 @@ func main() {
 
 	go func() {
 		for {
-			// prevent the prompt from being printed at the wrong time
-			// this is awful but good 'nuf for a demo
-			time.Sleep(time.Second/4)
-
 			line, err := rl.Readline()
 			if err != nil {
 				return
@@ -85,7 +119,11 @@ func main() {
 	for {
 		select {
 		case line := <-broker.Stdout:
-			fmt.Println(line)
+			// Clean/Refresh bracket the print so it can't land in the
+			// middle of whatever the user is currently typing.
+			rl.Clean()
+			fmt.Print(line)
+			rl.Refresh()
 		case line := <-lines:
 			broker.Stdin <- line
 		}
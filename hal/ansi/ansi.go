@@ -0,0 +1,129 @@
+// Package ansi renders the same header/rows shape hal.Utf8Table takes,
+// plus a plain numeric series, as 24-bit truecolor ANSI escape sequences
+// for brokers with a real color terminal behind them -- see
+// brokers/sshchat's server mode, which this package was built for.
+package ansi
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// Reset clears any FG/BG colors set by this package.
+const Reset = "\x1b[0m"
+
+// Color is a parsed 24-bit RGB color.
+type Color struct {
+	R, G, B uint8
+}
+
+// FG returns the truecolor foreground escape sequence for c.
+func (c Color) FG() string {
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", c.R, c.G, c.B)
+}
+
+// BG returns the truecolor background escape sequence for c.
+func (c Color) BG() string {
+	return fmt.Sprintf("\x1b[48;2;%d;%d;%dm", c.R, c.G, c.B)
+}
+
+// Defaults, chosen to be legible against both light and dark terminal
+// backgrounds without a user preference set.
+var (
+	DefaultFG       = Color{R: 0xd0, G: 0xd0, B: 0xd0}
+	DefaultHeaderBG = Color{R: 0x00, G: 0x3a, B: 0x6b}
+	DefaultZebraBG  = Color{R: 0x1c, G: 0x1c, B: 0x1c}
+)
+
+// ParseColor parses a "#rrggbb" or "rrggbb" hex string into a Color,
+// returning def unchanged if s doesn't parse -- a bad preference value
+// should degrade to the default color, not break the whole render.
+func ParseColor(s string, def Color) Color {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) != 6 {
+		return def
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return def
+	}
+
+	return Color{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}
+}
+
+// UserColors looks up a user's "image.fg"/"image.bg" hex color
+// preferences, the same preference names the Slack broker's SendAsImage
+// already uses via hal.FindPrefs. hal.Pref/hal.FindPrefs would be the
+// obvious fit here, but as established elsewhere in this codebase (see
+// hal/rpc.Server.prefKV), hal.Pref is referenced throughout yet never
+// actually defined -- hal.GetKV is the closest real persistence
+// available, namespaced per user as "image.fg.<userid>"/"image.bg.<userid>".
+func UserColors(userId string) (fg, bg Color) {
+	fg, bg = DefaultFG, DefaultHeaderBG
+
+	if raw, err := hal.GetKV("image.fg." + userId); err == nil && raw != "" {
+		fg = ParseColor(raw, fg)
+	}
+	if raw, err := hal.GetKV("image.bg." + userId); err == nil && raw != "" {
+		bg = ParseColor(raw, bg)
+	}
+
+	return fg, bg
+}
+
+// Table renders header/rows with hal.Utf8Table's exact column layout,
+// then colors it: fg throughout, headerBg behind the header row, and
+// zebraBg behind every other data row.
+func Table(header []string, rows [][]string, fg, headerBg, zebraBg Color) string {
+	lines := strings.Split(hal.Utf8Table(header, rows), "\n")
+	if len(lines) == 0 {
+		return ""
+	}
+
+	// line 0 is the top border, 1 is the header, 2 is the separator,
+	// 3..3+len(rows)-1 are data rows, and the last line is the bottom
+	// border -- see hal.Utf8Table.
+	for i, line := range lines {
+		switch {
+		case i == 1:
+			lines[i] = headerBg.BG() + fg.FG() + line + Reset
+		case i >= 3 && i < 3+len(rows) && (i-3)%2 == 1:
+			lines[i] = zebraBg.BG() + fg.FG() + line + Reset
+		default:
+			lines[i] = fg.FG() + line + Reset
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ColorizeLines wraps each line of body in fg/bg, for plain pre-formatted
+// text that isn't a table or a numeric series.
+func ColorizeLines(body string, fg, bg Color) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		lines[i] = bg.BG() + fg.FG() + line + Reset
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,138 @@
+package ansi
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import "strings"
+
+// brailleBase is U+2800, the empty braille pattern. Setting bit N of
+// (brailleBase | bits) turns on dot N -- see dotBits.
+const brailleBase = 0x2800
+
+// dotBits maps (sub-column, row) to the braille dot bit that lives there.
+// Braille cells are a 2-wide, 4-tall dot matrix, conventionally numbered:
+//
+//	1 4
+//	2 5
+//	3 6
+//	7 8
+//
+// which is why the bit for row 3 (the bottom row) is dot 7/8 rather than
+// 4/8 in sequence -- Unicode's braille block encodes historical Braille
+// cell numbering, not raster order.
+var dotBits = [2][4]int{
+	{0x01, 0x02, 0x04, 0x40}, // left column, rows 0 (top) .. 3 (bottom)
+	{0x08, 0x10, 0x20, 0x80}, // right column
+}
+
+// brailleColumn returns the bits for filling column col (0=left, 1=right)
+// from the bottom up to height dots (0-4).
+func brailleColumn(col, height int) int {
+	bits := 0
+	for row := 3; row > 3-height; row-- {
+		bits |= dotBits[col][row]
+	}
+	return bits
+}
+
+// Sparkline renders values as a braille-dot bar chart (U+2800-U+28FF)
+// sized to fit width terminal columns. Each braille character packs 2
+// sub-columns and 4 vertical levels of resolution, so a chart can show
+// 2*width data points at full resolution; longer series are downsampled
+// by averaging into 2*width buckets first.
+func Sparkline(values []float64, width int) string {
+	if width <= 0 {
+		width = 1
+	}
+	if len(values) == 0 {
+		return ""
+	}
+
+	buckets := resample(values, width*2)
+
+	min, max := buckets[0], buckets[0]
+	for _, v := range buckets {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	span := max - min
+	if span == 0 {
+		span = 1
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(buckets); i += 2 {
+		left := levelOf(buckets[i], min, span)
+		right := 0
+		if i+1 < len(buckets) {
+			right = levelOf(buckets[i+1], min, span)
+		}
+
+		buf.WriteRune(rune(brailleBase | brailleColumn(0, left) | brailleColumn(1, right)))
+	}
+
+	return buf.String()
+}
+
+// levelOf maps v into a 0-4 dot height within [min, min+span], nudging an
+// above-minimum value that would otherwise round down to 0 up to 1 so it
+// stays visible on the baseline.
+func levelOf(v, min, span float64) int {
+	level := int(((v - min) / span) * 4)
+
+	if level == 0 && v > min {
+		level = 1
+	}
+	if level > 4 {
+		level = 4
+	}
+	if level < 0 {
+		level = 0
+	}
+
+	return level
+}
+
+// resample averages values down to exactly n buckets, or returns them
+// unchanged if there are already n or fewer.
+func resample(values []float64, n int) []float64 {
+	if len(values) <= n {
+		return values
+	}
+
+	out := make([]float64, n)
+
+	for i := 0; i < n; i++ {
+		lo := i * len(values) / n
+		hi := (i + 1) * len(values) / n
+		if hi <= lo {
+			hi = lo + 1
+		}
+
+		var sum float64
+		for _, v := range values[lo:hi] {
+			sum += v
+		}
+		out[i] = sum / float64(hi-lo)
+	}
+
+	return out
+}
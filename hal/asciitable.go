@@ -0,0 +1,71 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import "strings"
+
+// AsciiTable renders header/rows the same way Utf8Table does -- headers
+// left-aligned, data right-aligned, ragged rows padded blank -- but with
+// plain "+-|" borders instead of box-drawing characters, for output
+// that's going to cross a channel that mangles anything outside ASCII
+// (e.g. HelpBlock.String(), rendered for brokers without SendTable).
+func AsciiTable(header []string, rows [][]string) string {
+	widths := columnWidths(header, rows)
+
+	var buf strings.Builder
+
+	buf.WriteString(asciiTableBorder(widths))
+	buf.WriteString("\n")
+	buf.WriteString(asciiTableRow(header, widths, padRight))
+	buf.WriteString("\n")
+	buf.WriteString(asciiTableBorder(widths))
+	buf.WriteString("\n")
+
+	for _, row := range rows {
+		buf.WriteString(asciiTableRow(row, widths, padLeft))
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString(asciiTableBorder(widths))
+
+	return buf.String()
+}
+
+func asciiTableBorder(widths []int) string {
+	var buf strings.Builder
+
+	buf.WriteString("+")
+	for _, w := range widths {
+		buf.WriteString(strings.Repeat("-", w+2))
+		buf.WriteString("+")
+	}
+
+	return buf.String()
+}
+
+func asciiTableRow(row []string, widths []int, pad func(string, int) string) string {
+	var buf strings.Builder
+
+	buf.WriteString("|")
+	for i, w := range widths {
+		buf.WriteString(" ")
+		buf.WriteString(pad(cellAt(row, i), w))
+		buf.WriteString(" |")
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,274 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity classifies an AuditEntry for filtering -- see
+// SetAuditMinSeverity/ConfigureAuditFromEnv.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarn
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarn:
+		return "warn"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// AuditSink receives every AuditEntry that passes the configured
+// filters, the same way CacheBackend/KVBackend/SecretsBackend let their
+// subsystems swap persistence -- see SetAuditSinks.
+type AuditSink interface {
+	// Write delivers entry. A non-nil error is logged but otherwise
+	// ignored -- a sink that's down shouldn't take the others with it.
+	Write(entry AuditEntry) error
+	// Name identifies the sink in logs, e.g. "file", "syslog", "webhook".
+	Name() string
+}
+
+// auditBufSize is the dispatch channel depth. A burst larger than this
+// starts dropping the oldest queued entry to make room, the same
+// drop-oldest-on-full semantics eventBus uses in events.go, so a slow or
+// unreachable sink falls behind rather than blocking whatever called
+// Audit.
+const auditBufSize = 1000
+
+type auditDispatcher struct {
+	mut         sync.Mutex
+	init        sync.Once
+	ch          chan AuditEntry
+	sinks       []AuditSink
+	dropped     uint64
+	minSeverity Severity
+	excluded    map[string]bool
+}
+
+var auditSingleton auditDispatcher
+
+func audit() *auditDispatcher {
+	auditSingleton.init.Do(func() {
+		auditSingleton.ch = make(chan AuditEntry, auditBufSize)
+		auditSingleton.sinks = []AuditSink{logAuditSink{}}
+		go auditSingleton.run()
+	})
+
+	return &auditSingleton
+}
+
+func (d *auditDispatcher) run() {
+	for entry := range d.ch {
+		d.mut.Lock()
+		sinks := make([]AuditSink, len(d.sinks))
+		copy(sinks, d.sinks)
+		d.mut.Unlock()
+
+		for _, sink := range sinks {
+			if err := sink.Write(entry); err != nil {
+				log.Printf("hal: audit sink %q failed to write entry: %s", sink.Name(), err)
+			}
+		}
+	}
+}
+
+// SetAuditSinks replaces the full set of configured AuditSinks. Called
+// with no arguments, it reverts to the default: every AuditEntry JSON-
+// logged through the standard logger, which is how AuditMiddleware
+// behaved before AuditSink existed.
+func SetAuditSinks(sinks ...AuditSink) {
+	d := audit()
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if len(sinks) == 0 {
+		d.sinks = []AuditSink{logAuditSink{}}
+		return
+	}
+
+	d.sinks = sinks
+}
+
+// AuditDropped returns how many AuditEntry records have been discarded
+// because the dispatch channel was full.
+func AuditDropped() uint64 {
+	d := audit()
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	return d.dropped
+}
+
+// Audit enqueues entry for asynchronous delivery to every configured
+// AuditSink and never blocks the caller. Entries below the configured
+// minimum Severity (see SetAuditMinSeverity) and entries from an excluded
+// plugin (see SetAuditExcludedPlugins) are dropped before they're ever
+// queued.
+func Audit(entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	d := audit()
+
+	d.mut.Lock()
+	minSeverity := d.minSeverity
+	excluded := entry.Plugin != "" && d.excluded[entry.Plugin]
+	d.mut.Unlock()
+
+	if entry.Severity < minSeverity || excluded {
+		return
+	}
+
+	select {
+	case d.ch <- entry:
+	default:
+		// drop-oldest: make room rather than lose entry
+		select {
+		case <-d.ch:
+		default:
+		}
+
+		select {
+		case d.ch <- entry:
+		default:
+		}
+
+		d.mut.Lock()
+		d.dropped++
+		d.mut.Unlock()
+	}
+}
+
+// SetAuditMinSeverity filters out every Audit call below min, e.g. to
+// quiet a noisy sink down to SeverityError in production.
+func SetAuditMinSeverity(min Severity) {
+	d := audit()
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.minSeverity = min
+}
+
+// SetAuditExcludedPlugins stops AuditEntries whose Plugin field matches
+// one of names from ever reaching a sink -- e.g. a chatty health-check
+// plugin nobody wants cluttering the audit trail. Entries with no Plugin
+// set (router- and pagerduty-sourced ones) are never excluded this way.
+func SetAuditExcludedPlugins(names ...string) {
+	d := audit()
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.excluded = make(map[string]bool, len(names))
+	for _, name := range names {
+		d.excluded[name] = true
+	}
+}
+
+// AuditMinSeverityEnvVar, when set, picks the minimum Severity Audit
+// entries must meet to reach a sink: "info" (the default), "warn", or
+// "error". AuditMinSeverityKey is the equivalent KV key, checked when the
+// env var isn't set; it is read each time ConfigureAuditFromEnv runs, so a
+// value changed with KV tooling takes effect on the next call. hal has no Prefs type of its own to hang
+// per-deployment audit filter config on (see GetKV/SetKV), so this
+// mirrors the env-var-with-KV-fallback convention ConfigureStorageFromEnv
+// already uses for Store.
+const AuditMinSeverityEnvVar = "HAL_AUDIT_MIN_SEVERITY"
+const AuditMinSeverityKey = "hal.audit.min-severity"
+
+// AuditExcludePluginsEnvVar is a comma-separated list of plugin names to
+// pass to SetAuditExcludedPlugins. AuditExcludePluginsKey is the
+// equivalent KV key, checked when the env var isn't set.
+const AuditExcludePluginsEnvVar = "HAL_AUDIT_EXCLUDE_PLUGINS"
+const AuditExcludePluginsKey = "hal.audit.exclude-plugins"
+
+// ConfigureAuditFromEnv installs the minimum Severity and excluded-plugin
+// list named by AuditMinSeverityEnvVar/AuditExcludePluginsEnvVar (falling
+// back to their KV equivalents, then SeverityInfo/none excluded).
+func ConfigureAuditFromEnv() error {
+	severity := os.Getenv(AuditMinSeverityEnvVar)
+	if severity == "" {
+		if v, err := GetKV(AuditMinSeverityKey); err == nil {
+			severity = v
+		}
+	}
+
+	switch strings.ToLower(severity) {
+	case "", "info":
+		SetAuditMinSeverity(SeverityInfo)
+	case "warn", "warning":
+		SetAuditMinSeverity(SeverityWarn)
+	case "error":
+		SetAuditMinSeverity(SeverityError)
+	default:
+		return fmt.Errorf("hal: unknown %s value %q (want \"info\", \"warn\", or \"error\")", AuditMinSeverityEnvVar, severity)
+	}
+
+	excluded := os.Getenv(AuditExcludePluginsEnvVar)
+	if excluded == "" {
+		if v, err := GetKV(AuditExcludePluginsKey); err == nil {
+			excluded = v
+		}
+	}
+
+	if excluded == "" {
+		SetAuditExcludedPlugins()
+	} else {
+		SetAuditExcludedPlugins(strings.Split(excluded, ",")...)
+	}
+
+	return nil
+}
+
+// logAuditSink is the zero-config default: every entry JSON-logged
+// through the standard logger, exactly what AuditMiddleware did before
+// AuditSink existed.
+type logAuditSink struct{}
+
+func (logAuditSink) Name() string { return "log" }
+
+func (logAuditSink) Write(entry AuditEntry) error {
+	js, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("hal.audit: %s", js)
+	return nil
+}
@@ -0,0 +1,63 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileAuditSink appends one JSON line per AuditEntry to a file, e.g. for
+// a deployment that already ships local logs to a SIEM via a file-tailing
+// agent instead of wanting hal to talk to it directly.
+type FileAuditSink struct {
+	mut sync.Mutex
+	f   *os.File
+}
+
+// NewFileAuditSink opens (creating if necessary) path for appending and
+// returns a FileAuditSink that writes to it.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditSink{f: f}, nil
+}
+
+func (s *FileAuditSink) Name() string { return "file" }
+
+// Write implements AuditSink.
+func (s *FileAuditSink) Write(entry AuditEntry) error {
+	js, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	_, err = s.f.Write(append(js, '\n'))
+	return err
+}
+
+// Close closes the underlying file.
+func (s *FileAuditSink) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,75 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding/json"
+)
+
+// PluginAuditTable persists every AuditEntry SQLAuditSink is given, so an
+// operator can reconstruct who did what and when -- including plugin
+// lifecycle changes bridged in by StartPluginEventAuditing -- with a SQL
+// query instead of grepping log files. Source/Action/Target/User/Room are
+// broken out as columns for querying; payload carries the full entry as
+// JSON for anything else.
+const PluginAuditTable = `
+CREATE TABLE IF NOT EXISTS plugin_audit (
+	ts      TIMESTAMP,
+	source  VARCHAR(191) NOT NULL DEFAULT "",
+	action  VARCHAR(191) NOT NULL DEFAULT "",
+	target  VARCHAR(191) NOT NULL DEFAULT "",
+	user    VARCHAR(191) NOT NULL DEFAULT "",
+	room    VARCHAR(191) NOT NULL DEFAULT "",
+	outcome VARCHAR(191) NOT NULL DEFAULT "",
+	payload TEXT,
+	PRIMARY KEY(ts, source, action, target)
+)`
+
+// SQLAuditSink persists every AuditEntry to the plugin_audit table via
+// hal.SqlDB(), the same database every other SQL-backed subsystem in hal
+// uses -- so an operator who's already set up MySQL for prefs/secrets/etc.
+// gets durable audit history for free, without standing up a syslog
+// daemon or managing a log file (see SyslogAuditSink/FileAuditSink for
+// those alternatives).
+type SQLAuditSink struct{}
+
+// NewSQLAuditSink creates the plugin_audit table if it doesn't already
+// exist and returns a SQLAuditSink ready to pass to SetAuditSinks.
+func NewSQLAuditSink() (*SQLAuditSink, error) {
+	if err := SqlInit(PluginAuditTable); err != nil {
+		return nil, err
+	}
+
+	return &SQLAuditSink{}, nil
+}
+
+func (s *SQLAuditSink) Name() string { return "sql" }
+
+// Write implements AuditSink.
+func (s *SQLAuditSink) Write(entry AuditEntry) error {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	db := SqlDB()
+	_, err = db.Exec(`INSERT INTO plugin_audit
+	                   (ts, source, action, target, user, room, outcome, payload)
+	                   VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Time, entry.Source, entry.Action, entry.Target, entry.User, entry.Room, entry.Outcome, payload)
+	return err
+}
@@ -0,0 +1,66 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogAuditSink forwards every AuditEntry to a syslog daemon as one
+// JSON message per entry, at a priority derived from entry.Severity.
+type SyslogAuditSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogAuditSink dials network/raddr the same way syslog.Dial does
+// ("" network means the local syslog daemon) and tags every message with
+// tag.
+func NewSyslogAuditSink(network, raddr, tag string) (*SyslogAuditSink, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyslogAuditSink{w: w}, nil
+}
+
+func (s *SyslogAuditSink) Name() string { return "syslog" }
+
+// Write implements AuditSink.
+func (s *SyslogAuditSink) Write(entry AuditEntry) error {
+	js, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	msg := string(js)
+
+	switch entry.Severity {
+	case SeverityError:
+		return s.w.Err(msg)
+	case SeverityWarn:
+		return s.w.Warning(msg)
+	default:
+		return s.w.Info(msg)
+	}
+}
+
+// Close closes the underlying syslog connection.
+func (s *SyslogAuditSink) Close() error {
+	return s.w.Close()
+}
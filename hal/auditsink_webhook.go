@@ -0,0 +1,62 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const webhookAuditTimeout = 10 * time.Second
+
+// WebhookAuditSink POSTs every AuditEntry as JSON to url, for shipping
+// the audit trail to an in-house log/SIEM ingester that already speaks
+// HTTP/JSON.
+type WebhookAuditSink struct {
+	url    string
+	client http.Client
+}
+
+// NewWebhookAuditSink returns a WebhookAuditSink that POSTs to url.
+func NewWebhookAuditSink(url string) *WebhookAuditSink {
+	return &WebhookAuditSink{url: url, client: http.Client{Timeout: webhookAuditTimeout}}
+}
+
+func (s *WebhookAuditSink) Name() string { return "webhook" }
+
+// Write implements AuditSink.
+func (s *WebhookAuditSink) Write(entry AuditEntry) error {
+	js, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook POST to %s returned %d", s.url, resp.StatusCode)
+	}
+
+	return nil
+}
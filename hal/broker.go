@@ -12,3 +12,52 @@ type Broker interface {
 	UserNameToId(name string) (id string)
 	Stream(out chan *Evt)
 }
+
+// RichSender is an optional interface a Broker can implement to render a
+// RichContent using its native formatting (e.g. Slack attachments/blocks)
+// instead of the plain-text fallback RichContent.String() provides. Callers
+// should type-assert for it and fall back to evt.Reply(rc.String()) when a
+// broker doesn't implement it.
+type RichSender interface {
+	SendRich(evt Evt, rc RichContent)
+}
+
+// BrokerFormatter is an optional interface a Broker can implement to
+// render a message being relayed in from another broker (see
+// plugins/cross_the_streams) in its own native dialect -- Slack mrkdwn,
+// HipChat HTML, IRC-style plain text, etc. -- instead of a bridge
+// guessing at broker-specific escaping. Callers should type-assert for
+// it and fall back to a plain "user@room: body" string on brokers that
+// haven't implemented one.
+type BrokerFormatter interface {
+	FormatRelayed(sourceBroker, sourceRoom, sourceUser, body string) string
+}
+
+// DMSender is an optional interface a Broker can implement to deliver evt
+// as a direct message to evt.UserId instead of back to evt.Room (e.g. for
+// pagerduty's "!pagerduty login" flow, which hands out an OAuth URL that
+// shouldn't be posted in a shared room). Callers should type-assert for it
+// and fall back to evt.Reply() -- or an explicit "DMs aren't supported
+// here" error -- on brokers that haven't implemented it (brokers/sshchat's
+// SendDM works in server mode only and panics in client mode).
+type DMSender interface {
+	SendDM(evt Evt)
+}
+
+// TopicSetter is an optional interface a Broker can implement to change a
+// room's topic (Slack, HipChat, console all have one; sshchat's works in
+// server mode only and panics in client mode). Callers should type-assert for it and report "not supported
+// here" on brokers that haven't implemented it.
+type TopicSetter interface {
+	SetTopic(roomId, topic string) error
+}
+
+// TableFormatter is an optional interface a Broker can implement to pick
+// which TableFormat its SendTable should render with -- e.g. Markdown for
+// a broker whose client renders GFM, Html for one that embeds a browser,
+// or Utf8 (the default, see RenderTable) for a plain monospace terminal.
+// A Broker typically calls this on itself from inside SendTable rather
+// than type-asserting, since it always knows its own formatting.
+type TableFormatter interface {
+	PreferredTableFormat() TableFormat
+}
@@ -0,0 +1,119 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisCacheInvalidateChannel is the pub/sub channel RedisCacheBackend
+// publishes a key name to on every Set/Delete, so peer instances sharing
+// the same Redis server can drop their own in-process (Cache()'s L1 map)
+// copy instead of serving it stale until its ttl expires.
+const RedisCacheInvalidateChannel = "hal:cache:invalidate"
+
+// RedisCacheBackend implements CacheBackend on top of Redis, so several
+// hal-9001 instances behind a load balancer share one cache instead of
+// each recomputing values like pagerduty.escalation_policies. Whatever
+// process creates one also subscribes it to RedisCacheInvalidateChannel
+// and, on message, drops the named key from Cache()'s own in-process map
+// -- Get/Set/Delete still work exactly as they did before a CacheBackend
+// existed, see hal/ttlcache.go.
+type RedisCacheBackend struct {
+	client *redis.Client
+}
+
+// NewRedisCacheBackend connects to the Redis server at addr (e.g.
+// "127.0.0.1:6379"), subscribes to RedisCacheInvalidateChannel in the
+// background, and returns a CacheBackend ready for SetCacheBackend.
+func NewRedisCacheBackend(addr string) (*RedisCacheBackend, error) {
+	cli := redis.NewClient(&redis.Options{Addr: addr})
+
+	if err := cli.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+
+	rb := &RedisCacheBackend{client: cli}
+	rb.subscribeInvalidations()
+
+	return rb, nil
+}
+
+// subscribeInvalidations drops Cache()'s in-process copy of any key a peer
+// (or this instance's own Set/Delete, which also publishes) announces on
+// RedisCacheInvalidateChannel.
+func (rb *RedisCacheBackend) subscribeInvalidations() {
+	pubsub := rb.client.Subscribe(context.Background(), RedisCacheInvalidateChannel)
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			Cache().invalidateLocal(msg.Payload)
+		}
+	}()
+}
+
+// Get implements CacheBackend.
+func (rb *RedisCacheBackend) Get(key string) ([]byte, time.Duration, error) {
+	ctx := context.Background()
+
+	data, err := rb.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, 0, ErrKVNotFound
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	ttl, err := rb.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, ttl, nil
+}
+
+// Set implements CacheBackend using SET key value PX <ttl-ms>, then
+// publishes key on RedisCacheInvalidateChannel so peers drop their stale
+// in-process copy rather than waiting out its ttl.
+func (rb *RedisCacheBackend) Set(key string, data []byte, ttl time.Duration) error {
+	ctx := context.Background()
+
+	if err := rb.client.Set(ctx, key, data, ttl).Err(); err != nil {
+		return err
+	}
+
+	return rb.client.Publish(ctx, RedisCacheInvalidateChannel, key).Err()
+}
+
+// Delete implements CacheBackend, also publishing key on
+// RedisCacheInvalidateChannel so peers drop it immediately.
+func (rb *RedisCacheBackend) Delete(key string) error {
+	ctx := context.Background()
+
+	if err := rb.client.Del(ctx, key).Err(); err != nil {
+		return err
+	}
+
+	if err := rb.client.Publish(ctx, RedisCacheInvalidateChannel, key).Err(); err != nil {
+		log.Printf("hal: failed to publish cache invalidation for %q: %s", key, err)
+	}
+
+	return nil
+}
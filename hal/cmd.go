@@ -3,8 +3,11 @@ package hal
 import (
 	"fmt"
 	"log"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -32,15 +35,23 @@ var TimeFormats = [...]string{
 // unlikely to be much higher, KISS.
 // TODO: switc to maps for (kv|bool|idx)params and maybe subCmds
 type Cmd struct {
-	token      string // * => slurp everything remaining
-	usage      string
-	subCmds    []*SubCmd
-	kvparams   []*KVParam
-	boolparams []*BoolParam
-	idxparams  map[int]*IdxParam
-	aliases    []string
-	prev       *Cmd // parent command, nil for root
-	mustSubCmd bool // a subcommand is always required
+	token                 string // * => slurp everything remaining
+	usage                 string
+	subCmds               []*SubCmd
+	kvparams              []*KVParam
+	boolparams            []*BoolParam
+	idxparams             map[int]*IdxParam
+	prefixparams          []*PrefixParam
+	persistentParams      []*KVParam // see AddPersistentParam
+	aliases               []string
+	deprecatedAliases     map[string]string    // alias -> reason, see AddDeprecatedAlias
+	deprecatedReason      string               // set by Deprecated(), "" if not deprecated
+	prev                  *Cmd                 // parent command, nil for root
+	mustSubCmd            bool                 // a subcommand is always required
+	allowAbbrev           bool                 // see AllowAbbrev
+	subCmdPrefixIndex     map[string][]*SubCmd // lazily built, see resolveSubCmdToken
+	subCmdPrefixIndexOnce sync.Once            // guards the lazy build above against concurrent Process calls
+	valueMapper           func(string) string  // see SetValueMapper/resolvedValueMapper
 }
 
 type SubCmd struct {
@@ -49,27 +60,49 @@ type SubCmd struct {
 }
 
 type CmdInst struct {
-	cmd            *Cmd
-	subCmdInst     *SubCmdInst
-	kvparaminsts   []*KVParamInst
-	boolparaminsts []*BoolParamInst
-	idxparaminsts  map[int]*IdxParamInst
-	remainder      []string // args left over after parsing, usually empty
+	cmd              *Cmd
+	subCmdInst       *SubCmdInst
+	kvparaminsts     []*KVParamInst
+	boolparaminsts   []*BoolParamInst
+	idxparaminsts    map[int]*IdxParamInst
+	prefixparaminsts []*PrefixParamInst
+	remainder        []string // args left over after parsing, usually empty
+	helpRequested    bool     // argv asked for --help/-h, see (*CmdInst).HelpRequested
+	helpText         string   // rendered help, set alongside helpRequested, see (*CmdInst).HelpText
+	warnings         []string // soft-fail notices, e.g. deprecated tokens/params, see Warnings
 }
 
 type SubCmdInst struct {
 	subCmd *SubCmd
+	parent paramInstLookup // the level this subcommand was parsed under, see AddPersistentParam
 	CmdInst
 }
 
+// paramInstLookup is implemented by CmdInst and SubCmdInst. It lets
+// SubCmdInst.GetKVParamInstIfSet walk up an arbitrary number of levels
+// looking for a persistent param's value, regardless of which level in
+// the chain actually parsed it.
+type paramInstLookup interface {
+	GetKVParamInstIfSet(key string) *KVParamInst
+}
+
 // key/value parameters, e.g. "--foo=bar", "foo=bar", "-f bar", "--foo bar"
 type KVParam struct {
-	key      string   // the "foo" in --foo, -f, foo=bar
-	aliases  []string // parameter aliases, e.g. foo => f
-	usage    string   // usage string for generating help
-	required bool     // whether or not this parameter is required
-	cmd      *Cmd     // the (top-level) command the param is attached to
-	subcmd   *SubCmd  // the subcommand the param is attached to
+	key              string      // the "foo" in --foo, -f, foo=bar
+	short            rune        // one-character alias, e.g. 'f' for -f, see Short
+	aliases          []string    // parameter aliases, e.g. foo => f
+	usage            string      // usage string for generating help
+	required         bool        // whether or not this parameter is required
+	validre          string      // regex valid values must match, shown in Help() and enforced by Process() (see checkValidRE)
+	def              string      // default value shown in Help()
+	prefKey          string      // pref key consulted by (*KVParamInst).Resolve, see FromPref
+	envVar           string      // env var consulted by Resolve/Def*, see FromEnv
+	cmd              *Cmd        // the (top-level) command the param is attached to
+	subcmd           *SubCmd     // the subcommand the param is attached to
+	deprecatedReason string      // set by Deprecated(), "" if not deprecated
+	repeatable       string      // join delimiter set by Repeatable(), "" if not repeatable
+	shadow           bool        // set by Shadow(), see KVParamInst.shadows
+	parser           ParamParser // custom converter set by Parser(), see (*KVParamInst).Custom
 }
 
 // keyed parameters that are boolean (flags), e.g. "--foo", "-f", "foo=true"
@@ -81,9 +114,42 @@ type BoolParam struct {
 
 // positional parameters (0 indexed)
 type IdxParam struct {
-	idx      int // positional arg index
+	idx              int // positional arg index
+	usage            string
+	required         bool
+	validre          string // regex valid values must match, shown in Help() and enforced by Process() (see checkValidRE)
+	def              string // default value shown in Help()
+	cmd              *Cmd
+	subcmd           *SubCmd
+	deprecatedReason string      // set by Deprecated(), "" if not deprecated
+	parser           ParamParser // custom converter set by Parser(), see (*IdxParamInst).Custom
+}
+
+// prefixParamKind distinguishes the handful of ways a PrefixParam can match
+// an arg, see AddPrefixParam/AddAnySuffixParam/AddPassFlagParam/
+// AddPrefixPredParam.
+type prefixParamKind int
+
+const (
+	prefixKindPrefix    prefixParamKind = iota // "-Wfoo" -> prefix "-W", value "foo"
+	prefixKindAnySuffix                        // like prefixKindPrefix, but value is the whole arg
+	prefixKindPassFlag                         // arg must match prefix exactly, value is the whole arg
+	prefixKindPred                             // arg matches if pred(arg), value is the whole arg
+)
+
+// PrefixParam matches a whole argv token rather than a "--key value" or
+// "key=value" pair, e.g. "-Wno-foo", "+debug", or anything a caller-supplied
+// predicate accepts. It's a declarative, data-only counterpart to GHC's
+// Prefix/OptPrefix/AnySuffix/PassFlag/PrefixPred OptKinds: there's no
+// handler to invoke here, a caller reads the matched PrefixParamInst after
+// Process the same way it reads a KVParamInst or IdxParamInst.
+type PrefixParam struct {
+	kind     prefixParamKind
+	prefix   string // the literal prefix (Prefix/AnySuffix) or exact flag (PassFlag)
+	pred     func(string) bool
 	usage    string
 	required bool
+	def      string // default value shown in Help()
 	cmd      *Cmd
 	subcmd   *SubCmd
 }
@@ -97,6 +163,7 @@ type KVParamInst struct {
 	arg        string // the original/unmodified argument (e.g. --foo, -f)
 	key        string // the key, e.g. "foo"
 	value      string
+	shadows    []*KVParamInst // later occurrences when param.shadow is enabled, see Shadows
 }
 
 // BoolParamInst represents a flag/boolean parameter found in the command
@@ -120,6 +187,20 @@ type IdxParamInst struct {
 	value      string
 }
 
+// PrefixParamInst represents a PrefixParam matched in the command. key is
+// the matched prefix/flag (PrefixParam.prefix); value is either the
+// stripped suffix (prefixKindPrefix) or the whole matched arg (every other
+// kind), see PrefixParam.valueFor.
+type PrefixParamInst struct {
+	cmdinst    *CmdInst
+	subcmdinst *SubCmdInst
+	param      *PrefixParam
+	found      bool
+	arg        string
+	key        string
+	value      string
+}
+
 // tmpParamInst used by the parser to hold keyed parameters before attaching to commands/subcommands.
 type tmpParamInst struct {
 	cmd        *Cmd
@@ -149,12 +230,15 @@ type cmdorsubcmd interface {
 	HasKVParam(string) bool
 	HasBoolParam(string) bool
 	HasIdxParam(int) bool
+	HasPrefixParam(string) bool
 	GetKVParam(string) *KVParam
 	GetBoolParam(string) *BoolParam
 	GetIdxParam(int) *IdxParam
+	GetPrefixParam(string) *PrefixParam
 	appendKVParamInst(*KVParamInst)
 	appendBoolParamInst(*BoolParamInst)
 	appendIdxParamInst(*IdxParamInst)
+	appendPrefixParamInst(*PrefixParamInst)
 }
 
 // RequiredParamNotFound is returned when a parameter has Required=true
@@ -175,11 +259,108 @@ func (e RequiredParamNotFound) Error() string {
 		name = e.Param.(BoolParam).key
 	case IdxParam:
 		name = strconv.Itoa(e.Param.(IdxParam).idx)
+	case PrefixParam:
+		name = e.Param.(PrefixParam).prefix
+	case string:
+		// Bind (cmd_bind.go) only has a bindTag's name/key on hand, not a
+		// live KVParam/BoolParam, when it reports a required field as
+		// missing.
+		name = e.Param.(string)
 	}
 
 	return fmt.Sprintf("Parameter %q is required but not set.", name)
 }
 
+// InvalidParamValue is returned when a parameter's value does not match
+// its ValidRE.
+type InvalidParamValue struct {
+	Key   string
+	Value string
+	RE    string
+}
+
+// Error fulfills the Error interface.
+func (e InvalidParamValue) Error() string {
+	return fmt.Sprintf("value %q for parameter %q does not match the expected pattern %q", e.Value, e.Key, e.RE)
+}
+
+// MissingSubCmd is returned when Cmd.MustSubCmd() is true but argv did not
+// contain one.
+type MissingSubCmd struct {
+	Cmd *Cmd
+}
+
+// Error fulfills the Error interface.
+func (e MissingSubCmd) Error() string {
+	return fmt.Sprintf("%q requires a subcommand", e.Cmd.token)
+}
+
+// UnknownParam is returned when an argument looked like a parameter (or
+// was a bare positional arg) but didn't match any KVParam/BoolParam/IdxParam
+// defined anywhere in the matched Cmd/SubCmd chain.
+type UnknownParam struct {
+	Arg string
+}
+
+// Error fulfills the Error interface.
+func (e UnknownParam) Error() string {
+	return fmt.Sprintf("%q is not a recognized parameter", e.Arg)
+}
+
+// AmbiguousSubCmdError is returned when AllowAbbrev is enabled and an
+// abbreviated subcommand token is a prefix of more than one subcommand's
+// token, e.g. "!prefs l" when both "list" and "log" are defined.
+type AmbiguousSubCmdError struct {
+	Arg        string
+	Candidates []string
+}
+
+// Error fulfills the Error interface.
+func (e AmbiguousSubCmdError) Error() string {
+	return fmt.Sprintf("%q is ambiguous, could be short for: %s", e.Arg, strings.Join(e.Candidates, ", "))
+}
+
+// InvalidBoolValue is returned when a BoolParam's value can't be parsed
+// as a bool (anything strconv.ParseBool rejects, e.g. "--foo=maybe").
+type InvalidBoolValue struct {
+	Key   string
+	Value string
+}
+
+// Error fulfills the Error interface.
+func (e InvalidBoolValue) Error() string {
+	return fmt.Sprintf("value %q for parameter %q is not a valid bool (try true/false)", e.Value, e.Key)
+}
+
+// DuplicateParamError is returned when a KVParam appears more than once in
+// a single command (e.g. "--label a --label b") and KVParam.Shadow(true)
+// wasn't opted into for it. Without Shadow, a repeat is treated as a
+// mistake rather than silently keeping only the first or last occurrence.
+type DuplicateParamError struct {
+	Key string
+}
+
+// Error fulfills the Error interface.
+func (e DuplicateParamError) Error() string {
+	return fmt.Sprintf("parameter %q was set more than once; use Shadow(true) on it to allow repeats", e.Key)
+}
+
+// ValidationErrors aggregates every problem Process found with argv
+// (missing required params, ValidRE mismatches, a missing subcommand,
+// unrecognized params) so a plugin can show the user everything wrong with
+// a command in one reply instead of only the first.
+type ValidationErrors []error
+
+// Error fulfills the Error interface.
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
 // UnsupportedTimeFormatError is returned when a provided time string cannot
 // be parsed with one of the pre-defined time formats.
 type UnsupportedTimeFormatError struct {
@@ -233,6 +414,24 @@ func (c *Cmd) _idxparams() map[int]*IdxParam {
 	return c.idxparams
 }
 
+// _prefixparams makes sure the _prefixparams list is initialized and returns the list.
+func (c *Cmd) _prefixparams() []*PrefixParam {
+	if c.prefixparams == nil {
+		c.prefixparams = make([]*PrefixParam, 0)
+	}
+
+	return c.prefixparams
+}
+
+// _deprecatedAliases makes sure the deprecatedAliases map is initialized and returns it.
+func (c *Cmd) _deprecatedAliases() map[string]string {
+	if c.deprecatedAliases == nil {
+		c.deprecatedAliases = make(map[string]string)
+	}
+
+	return c.deprecatedAliases
+}
+
 // Aliases makes sure the Aliases list is initialized and returns the list.
 func (c *Cmd) Aliases() []string {
 	if c.aliases == nil {
@@ -308,6 +507,103 @@ func (c *Cmd) AddIdxParam(position int, required bool) *IdxParam {
 	return &p
 }
 
+// AddPrefixParam adds a parameter matching any arg with the literal prefix
+// string, e.g. AddPrefixParam("-W", false) matches "-Wno-foo" with a
+// PrefixParamInst value of "no-foo" (the prefix stripped off).
+func (c *Cmd) AddPrefixParam(prefix string, required bool) *PrefixParam {
+	p := PrefixParam{kind: prefixKindPrefix, prefix: prefix, required: required}
+	p.cmd = c.Cmd()
+
+	c.prefixparams = append(c._prefixparams(), &p)
+
+	return &p
+}
+
+// AddAnySuffixParam is like AddPrefixParam, except the PrefixParamInst's
+// value is the whole matched arg rather than the prefix stripped off --
+// useful when the prefix itself is meaningful, e.g. "+debug" vs "-debug".
+func (c *Cmd) AddAnySuffixParam(prefix string) *PrefixParam {
+	p := PrefixParam{kind: prefixKindAnySuffix, prefix: prefix}
+	p.cmd = c.Cmd()
+
+	c.prefixparams = append(c._prefixparams(), &p)
+
+	return &p
+}
+
+// AddPassFlagParam adds a parameter matching one exact arg, e.g. "+debug",
+// passed through verbatim as the PrefixParamInst's value so a plugin can
+// tell which of several exact flags fired.
+func (c *Cmd) AddPassFlagParam(key string) *PrefixParam {
+	p := PrefixParam{kind: prefixKindPassFlag, prefix: key}
+	p.cmd = c.Cmd()
+
+	c.prefixparams = append(c._prefixparams(), &p)
+
+	return &p
+}
+
+// AddPrefixPredParam adds a parameter matching any arg for which pred
+// returns true, for match rules too irregular for a literal prefix, e.g.
+// accepting any of a fixed set of single-character flags.
+func (c *Cmd) AddPrefixPredParam(pred func(string) bool, required bool) *PrefixParam {
+	p := PrefixParam{kind: prefixKindPred, pred: pred, required: required}
+	p.cmd = c.Cmd()
+
+	c.prefixparams = append(c._prefixparams(), &p)
+
+	return &p
+}
+
+// AddPersistentParam declares a key/value parameter on c that is
+// automatically available to every descendant subcommand's CmdInst after
+// Process, without needing to redeclare it at each level -- e.g. a
+// top-level --room or --format flag that every subcommand should accept.
+// It's stored separately from the regular KVParams added with AddKVParam
+// (see Cmd.persistentParams) and is looked up by walking from the
+// matched Cmd/SubCmd up through Parent() (see getPersistentParam,
+// SubCmdInst.GetKVParamInstIfSet).
+func (c *Cmd) AddPersistentParam(key string, required bool) *KVParam {
+	p := KVParam{key: key}
+	p.required = required
+	p.cmd = c.Cmd()
+
+	c.persistentParams = append(c._persistentParams(), &p)
+
+	return &p
+}
+
+// _persistentParams makes sure the persistentParams list is initialized
+// and returns it.
+func (c *Cmd) _persistentParams() []*KVParam {
+	if c.persistentParams == nil {
+		c.persistentParams = make([]*KVParam, 0)
+	}
+
+	return c.persistentParams
+}
+
+// getPersistentParam looks up a persistent param by key, walking from c
+// up through its ancestors (see AddPersistentParam). Returns nil if none
+// of them declared it.
+func (c *Cmd) getPersistentParam(key string) *KVParam {
+	for cur := c; cur != nil; cur = cur.prev {
+		for _, p := range cur._persistentParams() {
+			if p.matches(key) {
+				return p
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasPersistentParam reports whether key is declared as a persistent
+// param on c or any ancestor.
+func (c *Cmd) hasPersistentParam(key string) bool {
+	return c.getPersistentParam(key) != nil
+}
+
 // AddKVParam creates and adds a key/value parameter to the subcommand
 // and returns the new parameter.
 func (c *SubCmd) AddKVParam(key string, required bool) *KVParam {
@@ -360,6 +656,140 @@ func (c *SubCmd) AddIdxParam(position int, required bool) *IdxParam {
 	return &p
 }
 
+// AddPrefixParam adds a parameter matching any arg with the literal prefix
+// string to the subcommand. See (*Cmd).AddPrefixParam.
+func (c *SubCmd) AddPrefixParam(prefix string, required bool) *PrefixParam {
+	p := PrefixParam{kind: prefixKindPrefix, prefix: prefix, required: required}
+	p.cmd = c.cmd
+	p.subcmd = c
+
+	c.prefixparams = append(c._prefixparams(), &p)
+
+	return &p
+}
+
+// AddAnySuffixParam adds a parameter to the subcommand whose value is the
+// whole matched arg. See (*Cmd).AddAnySuffixParam.
+func (c *SubCmd) AddAnySuffixParam(prefix string) *PrefixParam {
+	p := PrefixParam{kind: prefixKindAnySuffix, prefix: prefix}
+	p.cmd = c.cmd
+	p.subcmd = c
+
+	c.prefixparams = append(c._prefixparams(), &p)
+
+	return &p
+}
+
+// AddPassFlagParam adds a parameter to the subcommand matching one exact
+// arg. See (*Cmd).AddPassFlagParam.
+func (c *SubCmd) AddPassFlagParam(key string) *PrefixParam {
+	p := PrefixParam{kind: prefixKindPassFlag, prefix: key}
+	p.cmd = c.cmd
+	p.subcmd = c
+
+	c.prefixparams = append(c._prefixparams(), &p)
+
+	return &p
+}
+
+// AddPrefixPredParam adds a parameter to the subcommand matching any arg
+// for which pred returns true. See (*Cmd).AddPrefixPredParam.
+func (c *SubCmd) AddPrefixPredParam(pred func(string) bool, required bool) *PrefixParam {
+	p := PrefixParam{kind: prefixKindPred, pred: pred, required: required}
+	p.cmd = c.cmd
+	p.subcmd = c
+
+	c.prefixparams = append(c._prefixparams(), &p)
+
+	return &p
+}
+
+// Deprecated marks the command deprecated: Process still accepts it, but
+// attaches a Warning quoting reason (usually the replacement command) to
+// the CmdInst so a plugin can surface it without failing. See
+// (*CmdInst).Warnings.
+func (c *Cmd) Deprecated(reason string) *Cmd {
+	c.deprecatedReason = reason
+	return c
+}
+
+// Deprecated marks the subcommand deprecated. See (*Cmd).Deprecated.
+func (s *SubCmd) Deprecated(reason string) *SubCmd {
+	s.deprecatedReason = reason
+	return s
+}
+
+// AllowAbbrev enables (or disables, the default) accepting an abbreviated
+// subcommand token that's a unique prefix of exactly one of c's immediate
+// subcommands, e.g. "!prefs li" resolving to "list" when nothing else
+// starts with "li". Ambiguous abbreviations are reported via
+// AmbiguousSubCmdError rather than guessed at.
+func (c *Cmd) AllowAbbrev(enable bool) *Cmd {
+	c.allowAbbrev = enable
+	return c
+}
+
+// AllowAbbrev enables abbreviation matching for the subcommand's own
+// immediate subcommands. See (*Cmd).AllowAbbrev.
+func (s *SubCmd) AllowAbbrev(enable bool) *SubCmd {
+	s.allowAbbrev = enable
+	return s
+}
+
+// SetValueMapper installs fn to transform every parameter value token
+// attached under c before it's stored on a KVParamInst/IdxParamInst/
+// BoolParamInst (and before Int/Float/Bool/Duration/Time convert it).
+// This mirrors go-ini's ValueMapper: installing os.ExpandEnv lets users
+// write "--token=$DEPLOY_TOKEN" in chat and have the command receive the
+// expanded value. A subcommand inherits the nearest ancestor's mapper
+// unless it sets its own with this same method; see resolvedValueMapper.
+// Use RouterCTX.SetValueMapper to install one for every Cmd in the
+// process, e.g. to redact secrets via a broker-provided lookup.
+func (c *Cmd) SetValueMapper(fn func(string) string) *Cmd {
+	c.valueMapper = fn
+	return c
+}
+
+// SetValueMapper installs fn for the subcommand's own parameters. See
+// (*Cmd).SetValueMapper.
+func (s *SubCmd) SetValueMapper(fn func(string) string) *SubCmd {
+	s.valueMapper = fn
+	return s
+}
+
+// resolvedValueMapper returns the ValueMapper that applies to c: c's own
+// (SetValueMapper), the nearest ancestor's if c didn't set one, or the
+// router-wide default (RouterCTX.SetValueMapper) if no Cmd in the chain
+// set one. Always returns a non-nil func, falling back to identity.
+func (c *Cmd) resolvedValueMapper() func(string) string {
+	for cur := c; cur != nil; cur = cur.prev {
+		if cur.valueMapper != nil {
+			return cur.valueMapper
+		}
+	}
+
+	if dm := Router().ValueMapper(); dm != nil {
+		return dm
+	}
+
+	return func(s string) string { return s }
+}
+
+// cmdFor returns the *Cmd metadata backing whatever -- a *CmdInst's own
+// Cmd, or a *SubCmdInst's SubCmd.Cmd -- so callers like attachKeyParam can
+// resolve tree-wide settings (e.g. ValueMapper) without their own type
+// switch.
+func cmdFor(whatever cmdorsubcmd) *Cmd {
+	switch w := whatever.(type) {
+	case *CmdInst:
+		return w.cmd
+	case *SubCmdInst:
+		return &w.subCmd.Cmd
+	default:
+		return nil
+	}
+}
+
 // AddAlias adds an alias to the command and returns the paramter.
 func (c *Cmd) AddAlias(alias string) *Cmd {
 	c.aliases = append(c.Aliases(), alias)
@@ -371,12 +801,204 @@ func (s *SubCmd) AddAlias(alias string) *SubCmd {
 	return s
 }
 
+// AddDeprecatedAlias adds an alias that still matches (so old muscle
+// memory keeps working) but causes Process to attach a Warning explaining
+// reason (usually the replacement token) to the CmdInst instead of
+// silently accepting it. See (*CmdInst).Warnings.
+func (c *Cmd) AddDeprecatedAlias(alias, reason string) *Cmd {
+	c.aliases = append(c.Aliases(), alias)
+	c._deprecatedAliases()[alias] = reason
+	return c
+}
+
+// AddDeprecatedAlias adds a deprecated alias to the subcommand. See
+// (*Cmd).AddDeprecatedAlias.
+func (s *SubCmd) AddDeprecatedAlias(alias, reason string) *SubCmd {
+	s.aliases = append(s.Aliases(), alias)
+	s._deprecatedAliases()[alias] = reason
+	return s
+}
+
 // AddAlias adds an alias to the parameter and returns the paramter.
 func (p *KVParam) AddAlias(alias string) *KVParam {
 	p.aliases = append(p.Aliases(), alias)
 	return p
 }
 
+// Short sets a one-character alias for the parameter (e.g. -f for
+// --foo), shown as "-f, --foo" in Help(). It's a convenience over
+// AddAlias(string(r)) that also remembers the rune for rendering.
+func (p *KVParam) Short(r rune) *KVParam {
+	p.short = r
+	p.aliases = append(p.Aliases(), string(r))
+	return p
+}
+
+// Short sets a one-character alias for the parameter. See (*KVParam).Short.
+func (p *BoolParam) Short(r rune) *BoolParam {
+	p.short = r
+	p.aliases = append(p.Aliases(), string(r))
+	return p
+}
+
+// Repeatable marks p as accumulating across repeated occurrences on the
+// command line (e.g. "--tag foo --tag bar") into a single KVParamInst,
+// joining each occurrence's value with delim instead of the last one
+// overwriting the others. Use Strings(delim)/Ints(delim)/Floats(delim)/
+// Bools(delim) on the resulting KVParamInst to split the joined value back
+// into typed elements.
+func (p *KVParam) Repeatable(delim string) *KVParam {
+	p.repeatable = delim
+	return p
+}
+
+// Shadow enables (or disables, the default) accepting more than one
+// occurrence of the parameter in a single command, e.g. "--label a --label
+// b". Borrowed from go-ini's shadow keys: the first occurrence remains
+// the KVParamInst Process returns from GetKVParamInst/String/Int/etc, and
+// every later occurrence is recorded as a shadow on it instead -- see
+// (*KVParamInst).Shadows/HasShadows/ValueWithShadows. Without this, a
+// repeated occurrence is a DuplicateParamError rather than silently
+// overwriting or being dropped.
+func (p *KVParam) Shadow(enable bool) *KVParam {
+	p.shadow = enable
+	return p
+}
+
+// Parser installs parser as p's custom value converter, consulted by
+// (*KVParamInst).Custom/DefCustom instead of the built-in String/Int/
+// Float/Bool conversions. See ParamParser and its built-in implementations
+// (IPParser, URLParser, UUIDParser, ByteSizeParser, EnumParser).
+func (p *KVParam) Parser(parser ParamParser) *KVParam {
+	p.parser = parser
+	return p
+}
+
+// matches reports whether key is p's canonical Key or one of its
+// Aliases/Short.
+func (p *KVParam) matches(key string) bool {
+	if p.key == key {
+		return true
+	}
+
+	return hasString(p.aliases, key)
+}
+
+// matchesToken reports whether arg is sc's canonical token or one of its
+// Aliases (deprecated or not).
+func (sc *SubCmd) matchesToken(arg string) bool {
+	if sc.token == arg {
+		return true
+	}
+
+	return hasString(sc.aliases, arg)
+}
+
+// deprecationWarning returns a Warning string if sc itself is deprecated
+// or arg matched one of sc's deprecated aliases, or "" if neither applies.
+func (sc *SubCmd) deprecationWarning(arg string) string {
+	if sc.deprecatedReason != "" {
+		return fmt.Sprintf("subcommand %q is deprecated: %s", sc.token, sc.deprecatedReason)
+	}
+
+	if reason, ok := sc._deprecatedAliases()[arg]; ok {
+		return fmt.Sprintf("%q is a deprecated alias for %q: %s", arg, sc.token, reason)
+	}
+
+	return ""
+}
+
+// _subCmdPrefixIndex lazily builds (once) and caches a prefix -> matching
+// SubCmds index used by AllowAbbrev lookups: every non-empty prefix of
+// each subcommand's token maps to the list of subcommands whose token
+// starts with it, so resolveSubCmdToken's abbreviation lookup is a single
+// map access instead of scanning every subcommand on every arg. The build
+// is guarded by subCmdPrefixIndexOnce since Process (and therefore this)
+// can be called concurrently from multiple goroutines against the same
+// shared, registered *Cmd tree.
+func (c *Cmd) _subCmdPrefixIndex() map[string][]*SubCmd {
+	c.subCmdPrefixIndexOnce.Do(func() {
+		idx := make(map[string][]*SubCmd)
+		for _, sc := range c.ListSubCmds() {
+			for i := 1; i <= len(sc.token); i++ {
+				prefix := sc.token[:i]
+				idx[prefix] = append(idx[prefix], sc)
+			}
+		}
+
+		c.subCmdPrefixIndex = idx
+	})
+
+	return c.subCmdPrefixIndex
+}
+
+// resolveSubCmdToken looks up arg against c's immediate subcommands: an
+// exact token/alias match always wins, otherwise -- if AllowAbbrev is set
+// -- arg is tried as an abbreviation via the prefix index. Returns (nil,
+// nil) if arg doesn't match anything, and (nil, AmbiguousSubCmdError) if
+// arg is a prefix of more than one subcommand's token.
+func (c *Cmd) resolveSubCmdToken(arg string) (*SubCmd, error) {
+	for _, sc := range c.ListSubCmds() {
+		if sc.matchesToken(arg) {
+			return sc, nil
+		}
+	}
+
+	if !c.allowAbbrev || arg == "" {
+		return nil, nil
+	}
+
+	switch candidates := c._subCmdPrefixIndex()[arg]; len(candidates) {
+	case 0:
+		return nil, nil
+	case 1:
+		return candidates[0], nil
+	default:
+		tokens := make([]string, len(candidates))
+		for i, sc := range candidates {
+			tokens[i] = sc.token
+		}
+		return nil, AmbiguousSubCmdError{Arg: arg, Candidates: tokens}
+	}
+}
+
+// hasString reports whether list contains s.
+func hasString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesArg reports whether arg matches p, per its kind -- an exact match
+// for PassFlag, pred(arg) for PrefixPred, and a literal prefix match
+// otherwise (Prefix/AnySuffix).
+func (p *PrefixParam) matchesArg(arg string) bool {
+	switch p.kind {
+	case prefixKindPassFlag:
+		return arg == p.prefix
+	case prefixKindPred:
+		return p.pred != nil && p.pred(arg)
+	default:
+		return strings.HasPrefix(arg, p.prefix)
+	}
+}
+
+// valueFor returns what a PrefixParamInst's value should be for a matched
+// arg: the prefix stripped off for prefixKindPrefix, the whole arg for
+// every other kind (AnySuffix/PassFlag/PrefixPred all care about the arg
+// itself, not just what follows the prefix).
+func (p *PrefixParam) valueFor(arg string) string {
+	if p.kind == prefixKindPrefix {
+		return strings.TrimPrefix(arg, p.prefix)
+	}
+
+	return arg
+}
+
 func (c *Cmd) Parent() *Cmd {
 	return c.prev
 }
@@ -386,9 +1008,12 @@ func (c *Cmd) MustSubCmd() bool {
 	return c.mustSubCmd
 }
 
-// Usage returns the auto-generated usage string.
+// Usage returns the auto-generated usage string: a synopsis, c's own
+// description (if set via SetUsage), and SUBCOMMANDS/PARAMETERS sections.
+// Rendered with PlainRenderer; use Help() directly with another
+// UsageRenderer (e.g. MarkdownRenderer) for a broker-specific format.
 func (c *Cmd) Usage() string {
-	return "not implemented yet"
+	return PlainRenderer{}.Render(c.Help())
 }
 
 // SetUsage sets the usage string for the command. Returns the command.
@@ -420,6 +1045,10 @@ func (p *IdxParam) Usage() string {
 	return p.usage
 }
 
+func (p *PrefixParam) Usage() string {
+	return p.usage
+}
+
 // SetUsage sets the usage string for the paremeter. Returns the parameter.
 func (p *KVParam) SetUsage(usage string) *KVParam {
 	p.usage = usage
@@ -438,36 +1067,130 @@ func (p *IdxParam) SetUsage(usage string) *IdxParam {
 	return p
 }
 
-func (p *KVParam) Key() string {
-	return p.key
+// SetUsage sets the usage string for the paremeter. Returns the parameter.
+func (p *PrefixParam) SetUsage(usage string) *PrefixParam {
+	p.usage = usage
+	return p
 }
 
-func (p *BoolParam) Key() string {
-	return p.key
+// SetValidRE sets a regex that valid values must match. It is shown in
+// Help() output and enforced by Process() (see checkValidRE).
+func (p *KVParam) SetValidRE(re string) *KVParam {
+	p.validre = re
+	return p
 }
 
-func (p *IdxParam) Idx() int {
-	return p.idx
+// SetValidRE sets a regex that valid values must match. It is shown in
+// Help() output and enforced by Process() (see checkValidRE).
+func (p *IdxParam) SetValidRE(re string) *IdxParam {
+	p.validre = re
+	return p
 }
 
-func (p *KVParamInst) Key() string {
-	return p.key
+// SetDefault sets the default value shown in Help() output.
+func (p *KVParam) SetDefault(def string) *KVParam {
+	p.def = def
+	return p
 }
 
-func (p *BoolParamInst) Key() string {
-	return p.key
+// SetDefault sets the default value shown in Help() output.
+func (p *IdxParam) SetDefault(def string) *IdxParam {
+	p.def = def
+	return p
 }
 
-func (p *IdxParamInst) Idx() int {
-	return p.idx
+// Parser installs parser as p's custom value converter. See
+// (*KVParam).Parser/(*IdxParamInst).Custom.
+func (p *IdxParam) Parser(parser ParamParser) *IdxParam {
+	p.parser = parser
+	return p
 }
 
-// Cmd returns the command the parameter belongs to. Panics if no command is attached.
-func (p *KVParam) Cmd() *Cmd {
-	if p.cmd == nil {
-		panic("Can't call Cmd() on this KVParam because it is not attached to a Cmd!")
-	}
-
+// Deprecated marks the parameter deprecated: Process still accepts it, but
+// attaches a Warning quoting reason (usually the replacement) to the
+// CmdInst so a plugin can surface it without failing the command.
+func (p *KVParam) Deprecated(reason string) *KVParam {
+	p.deprecatedReason = reason
+	return p
+}
+
+// Deprecated marks the parameter deprecated. See (*KVParam).Deprecated.
+func (p *BoolParam) Deprecated(reason string) *BoolParam {
+	p.deprecatedReason = reason
+	return p
+}
+
+// Deprecated marks the parameter deprecated. See (*KVParam).Deprecated.
+func (p *IdxParam) Deprecated(reason string) *IdxParam {
+	p.deprecatedReason = reason
+	return p
+}
+
+// FromPref sets the pref key (*KVParamInst).Resolve consults when the
+// parameter wasn't set on the command line (or was set to "*"), looked up
+// against the invoking evt's room/user scope. See Resolve.
+func (p *KVParam) FromPref(key string) *KVParam {
+	p.prefKey = key
+	return p
+}
+
+// FromEnv sets the environment variable Resolve/Def* fall back to after
+// the CLI value and FromPref, before the caller-supplied default.
+func (p *KVParam) FromEnv(name string) *KVParam {
+	p.envVar = name
+	return p
+}
+
+func (p *KVParam) ValidRE() string {
+	return p.validre
+}
+
+func (p *IdxParam) ValidRE() string {
+	return p.validre
+}
+
+func (p *KVParam) Default() string {
+	return p.def
+}
+
+func (p *IdxParam) Default() string {
+	return p.def
+}
+
+func (p *KVParam) Key() string {
+	return p.key
+}
+
+func (p *BoolParam) Key() string {
+	return p.key
+}
+
+func (p *IdxParam) Idx() int {
+	return p.idx
+}
+
+func (p *KVParamInst) Key() string {
+	return p.key
+}
+
+func (p *BoolParamInst) Key() string {
+	return p.key
+}
+
+func (p *IdxParamInst) Idx() int {
+	return p.idx
+}
+
+func (p *PrefixParamInst) Key() string {
+	return p.key
+}
+
+// Cmd returns the command the parameter belongs to. Panics if no command is attached.
+func (p *KVParam) Cmd() *Cmd {
+	if p.cmd == nil {
+		panic("Can't call Cmd() on this KVParam because it is not attached to a Cmd!")
+	}
+
 	return p.cmd
 }
 
@@ -489,6 +1212,15 @@ func (p *IdxParam) Cmd() *Cmd {
 	return p.cmd
 }
 
+// Cmd returns the command the parameter belongs to. Panics if no command is attached.
+func (p *PrefixParam) Cmd() *Cmd {
+	if p.cmd == nil {
+		panic("Can't call Cmd() on this PrefixParam because it is not attached to a Cmd!")
+	}
+
+	return p.cmd
+}
+
 func (p *KVParam) SubCmd() *SubCmd {
 	if p.subcmd == nil {
 		panic("Can't call SubCmd() on this KVParam because it is not attached to a SubCmd!")
@@ -524,6 +1256,15 @@ func (p *IdxParamInst) Cmd() *Cmd {
 	return p.param.Cmd()
 }
 
+// Cmd returns the command the parameter belongs to. Panics if no command is attached.
+func (p *PrefixParamInst) Cmd() *Cmd {
+	if p.param == nil {
+		panic("Can't call Cmd() on this PrefixParamInst because it is not attached to a PrefixParam!")
+	}
+
+	return p.param.Cmd()
+}
+
 func (p *KVParamInst) SubCmdInst() *SubCmdInst {
 	if p.subcmdinst == nil {
 		panic("Can't call SubCmdInst() on this KVParamInst because it is not attached to a SubCmdInst!")
@@ -548,6 +1289,14 @@ func (p *IdxParamInst) SubCmdInst() *SubCmdInst {
 	return p.subcmdinst
 }
 
+func (p *PrefixParamInst) SubCmdInst() *SubCmdInst {
+	if p.subcmdinst == nil {
+		panic("Can't call SubCmdInst() on this PrefixParamInst because it is not attached to a SubCmdInst!")
+	}
+
+	return p.subcmdinst
+}
+
 func (p *KVParamInst) Found() bool {
 	return p.found
 }
@@ -560,6 +1309,10 @@ func (p *IdxParamInst) Found() bool {
 	return p.found
 }
 
+func (p *PrefixParamInst) Found() bool {
+	return p.found
+}
+
 func (p *KVParamInst) Required() bool {
 	return p.param.required
 }
@@ -572,6 +1325,10 @@ func (p *IdxParamInst) Required() bool {
 	return p.param.required
 }
 
+func (p *PrefixParamInst) Required() bool {
+	return p.param.required
+}
+
 func (p *KVParamInst) Param() *KVParam {
 	return p.param
 }
@@ -584,6 +1341,10 @@ func (p *IdxParamInst) Param() *IdxParam {
 	return p.param
 }
 
+func (p *PrefixParamInst) Param() *PrefixParam {
+	return p.param
+}
+
 // errParam is used to get an interface{} handle to return in errors.
 // See: RequiredParamNotFound
 func (p *KVParamInst) errParam() interface{} {
@@ -600,6 +1361,11 @@ func (p *IdxParamInst) errParam() interface{} {
 	return p.param
 }
 
+// errParam is used to get an interface{} handle to return in errors.
+func (p *PrefixParamInst) errParam() interface{} {
+	return p.param
+}
+
 // Cmd returns the command it was called on. It does nothing and exists to
 // make it possible to format chained calls nicely.
 func (c *Cmd) Cmd() *Cmd {
@@ -627,17 +1393,17 @@ func (c *Cmd) AddSubCmd(token string) *SubCmd {
 
 func (c *Cmd) GetKVParam(key string) *KVParam {
 	for _, p := range c._kvparams() {
-		if p.key == key {
+		if p.matches(key) {
 			return p
 		}
 	}
 
-	return nil
+	return c.getPersistentParam(key)
 }
 
 func (c *Cmd) GetBoolParam(key string) *BoolParam {
 	for _, p := range c._boolparams() {
-		if p.key == key {
+		if p.matches(key) {
 			return p
 		}
 	}
@@ -657,8 +1423,12 @@ func (c *Cmd) GetIdxParam(idx int) *IdxParam {
 }
 
 func (c *Cmd) HasKVParam(key string) bool {
+	if c.hasPersistentParam(key) {
+		return true
+	}
+
 	for _, p := range c._kvparams() {
-		if p.key == key {
+		if p.matches(key) {
 			return true
 		}
 	}
@@ -668,7 +1438,7 @@ func (c *Cmd) HasKVParam(key string) bool {
 
 func (c *Cmd) HasBoolParam(key string) bool {
 	for _, p := range c._boolparams() {
-		if p.key == key {
+		if p.matches(key) {
 			return true
 		}
 	}
@@ -682,19 +1452,33 @@ func (c *Cmd) HasIdxParam(idx int) bool {
 	return exists
 }
 
+// GetPrefixParam returns the first PrefixParam matching arg, or nil.
+func (c *Cmd) GetPrefixParam(arg string) *PrefixParam {
+	for _, p := range c._prefixparams() {
+		if p.matchesArg(arg) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// HasPrefixParam reports whether any PrefixParam matches arg.
+func (c *Cmd) HasPrefixParam(arg string) bool {
+	return c.GetPrefixParam(arg) != nil
+}
+
 func (c *Cmd) SubCmds() []*SubCmd {
 	return c.ListSubCmds()
 }
 
-// GetSubCmd gets a subcommand by its token. Returns nil for no match.
+// GetSubCmd gets a subcommand by its token, one of its aliases, or (if
+// AllowAbbrev is set) an unambiguous abbreviation. Returns nil for no
+// match, including an ambiguous abbreviation -- see resolveSubCmdToken
+// and Process's use of it for the error-returning equivalent.
 func (c *Cmd) GetSubCmd(token string) *SubCmd {
-	for _, s := range c.ListSubCmds() {
-		if s.token == token {
-			return s
-		}
-	}
-
-	return nil
+	sc, _ := c.resolveSubCmdToken(token)
+	return sc
 }
 
 // parse a list of argv-style strings (0 is always the command name e.g. []string{"prefs"})
@@ -702,10 +1486,16 @@ func (c *Cmd) GetSubCmd(token string) *SubCmd {
 // foo --bar baz --version
 // foo bar=baz
 // foo x=y z=q init --foo baz
-// TODO: automatic emdash cleanup
-// TODO: enforce MustSubCmd
-// TODO: return errors instead of nil/panic
-func (c *Cmd) Process(argv []string) *CmdInst {
+// for a raw chat message instead of a pre-split argv, see ProcessString,
+// which also normalizes the em/en-dash autocorrect chat clients apply to
+// "--" (see Tokenize).
+//
+// Process returns a ValidationErrors (required params missing, ValidRE
+// mismatches, a missing subcommand, or unrecognized params) instead of
+// panicking, so a plugin can surface it back to the chat room. The
+// returned *CmdInst is never nil, even on error, so callers that want to
+// render Help() on failure still can.
+func (c *Cmd) Process(argv []string) (*CmdInst, error) {
 	// a hand-coded argument processor that evaluates the provided argv list
 	// against the command definition and returns a CmdInst with all of the
 	// available data parsed and ready to use with CmdInst/ParamInst methods.
@@ -715,13 +1505,35 @@ func (c *Cmd) Process(argv []string) *CmdInst {
 
 	// no arguments were provided
 	if len(argv) == 1 {
-		return &topInst
+		if c.mustSubCmd {
+			return &topInst, ValidationErrors{MissingSubCmd{Cmd: c}}
+		}
+		return &topInst, nil
+	}
+
+	// expand GNU-style bundled/attached short options ("-abc" / "-fvalue")
+	// before anything else runs, scoped to whatever subcommand argv itself
+	// names (see subCmdScope) so a short flag's meaning on one subcommand
+	// can't be clobbered by a different meaning of the same short on an
+	// unrelated sibling.
+	argv = expandShortFlags(argv, c)
+
+	// --help/-h short-circuits parsing entirely rather than being treated
+	// as an unrecognized param/positional arg -- the caller checks
+	// HelpRequested() and replies with Help() instead of running the command.
+	for _, arg := range argv[1:] {
+		if arg == "--help" || arg == "-h" {
+			topInst.helpRequested = true
+			topInst.helpText = PlainRenderer{}.Render(c.Help())
+			return &topInst, nil
+		}
 	}
 
 	var curSubCmdInst *SubCmdInst // the current subcommand - changes during parsing
 	var curSubCmdIdx int          // the idx the subcommand found in argv
 	var skipNext bool
 	var looseParams []*tmpParamInst
+	var errs ValidationErrors
 
 	// first pass: extract subcommands and parameters
 	for i, arg := range argv[1:] {
@@ -747,7 +1559,11 @@ func (c *Cmd) Process(argv []string) *CmdInst {
 				found:   true,
 				idx:     i - 1,
 				param:   c.GetIdxParam(i - 1),
-				value:   arg,
+				value:   c.resolvedValueMapper()(arg),
+			}
+
+			if pi.param.deprecatedReason != "" {
+				topInst.warnings = append(topInst.Warnings(), fmt.Sprintf("positional argument %d is deprecated: %s", pi.idx, pi.param.deprecatedReason))
 			}
 
 			topInst.appendIdxParamInst(&pi)
@@ -761,10 +1577,31 @@ func (c *Cmd) Process(argv []string) *CmdInst {
 				found:      true,
 				idx:        paramIdx,
 				param:      curSubCmdInst.GetIdxParam(paramIdx),
-				value:      arg,
+				value:      curSubCmdInst.subCmd.resolvedValueMapper()(arg),
+			}
+
+			if pi.param.deprecatedReason != "" {
+				topInst.warnings = append(topInst.Warnings(), fmt.Sprintf("positional argument %d is deprecated: %s", pi.idx, pi.param.deprecatedReason))
 			}
 
 			curSubCmdInst.appendIdxParamInst(&pi)
+		} else if whatever, pp := matchPrefixParam(curSubCmdInst, &topInst, arg); pp != nil {
+			// subcommands get first shot here too, same as key params below
+			pi := PrefixParamInst{
+				cmdinst: &topInst,
+				param:   pp,
+				found:   true,
+				arg:     arg,
+				key:     pp.prefix,
+				value:   pp.valueFor(arg),
+			}
+
+			if sci, ok := whatever.(*SubCmdInst); ok {
+				pi.subcmdinst = sci
+			}
+
+			whatever.appendPrefixParamInst(&pi)
+			continue
 		} else if strings.Contains(arg, "=") {
 			// looks like a key=value or --key=value parameter
 			// could be --foo=bar but all that matters is the "foo"
@@ -782,36 +1619,47 @@ func (c *Cmd) Process(argv []string) *CmdInst {
 				skipNext = true
 			}
 			// falls through, further processing below this if block...
-		} else if curSubCmdInst == nil && c.HasSubCmdToken(arg) {
+		} else if sc, scErr := topSubCmdToken(c, curSubCmdInst, arg); sc != nil || scErr != nil {
 			// the first subcommand - the "foo" in "!command foo bar --baz"
-			for _, sc := range topInst.cmd.ListSubCmds() {
-				if sc.token == arg {
-					sci := SubCmdInst{subCmd: sc}
-					sci.cmd = c
-					curSubCmdInst = &sci
-					topInst.subCmdInst = &sci
-					break
-				}
+			if scErr != nil {
+				errs = append(errs, scErr)
+				continue
+			}
+
+			sci := SubCmdInst{subCmd: sc}
+			sci.cmd = c
+			sci.parent = &topInst
+			curSubCmdInst = &sci
+			topInst.subCmdInst = &sci
+
+			if w := sc.deprecationWarning(arg); w != "" {
+				topInst.warnings = append(topInst.Warnings(), w)
 			}
 
 			continue // processed a subcommand, move onto the next arg
-		} else if curSubCmdInst != nil && curSubCmdInst.subCmd.HasSubCmdToken(arg) {
+		} else if sc, scErr := nestedSubCmdToken(curSubCmdInst, arg); sc != nil || scErr != nil {
 			// sub-subcommands - the "bar" or "blargh" in "!command foo bar blargh --baz"
-			for _, sc := range curSubCmdInst.subCmd.ListSubCmds() {
-				if arg == sc.token {
-					sci := SubCmdInst{subCmd: sc}
-					sci.cmd = c
+			if scErr != nil {
+				errs = append(errs, scErr)
+				continue
+			}
+
+			sci := SubCmdInst{subCmd: sc}
+			sci.cmd = c
+			sci.parent = &curSubCmdInst.CmdInst
 
-					// point the current subcommand to the new one
-					curSubCmdInst.subCmdInst = &sci
+			// point the current subcommand to the new one
+			curSubCmdInst.subCmdInst = &sci
 
-					// advance "current" to the new subcommand
-					curSubCmdInst = &sci
+			// advance "current" to the new subcommand
+			curSubCmdInst = &sci
 
-					// set the index where the subcommand was discovered for use
-					// in extracting postitional parameters (above)
-					curSubCmdIdx = i
-				}
+			// set the index where the subcommand was discovered for use
+			// in extracting postitional parameters (above)
+			curSubCmdIdx = i
+
+			if w := sc.deprecationWarning(arg); w != "" {
+				topInst.warnings = append(topInst.Warnings(), w)
 			}
 
 			continue // processed a subcommand, move onto the next arg
@@ -837,10 +1685,14 @@ func (c *Cmd) Process(argv []string) *CmdInst {
 			// the parameter belongs to the subcommand
 			pinst.subcmd = curSubCmdInst.subCmd
 			pinst.subcmdinst = curSubCmdInst
-			pinst.attachKeyParam(curSubCmdInst)
+			if err := pinst.attachKeyParam(curSubCmdInst); err != nil {
+				errs = append(errs, err)
+			}
 		} else if c.HasKeyParam(key) {
 			// the parameter belongs to the command
-			pinst.attachKeyParam(&topInst)
+			if err := pinst.attachKeyParam(&topInst); err != nil {
+				errs = append(errs, err)
+			}
 		} else {
 			// store (likely) out-of-order parameters to process after all args &
 			// subcommands are discovered
@@ -848,173 +1700,610 @@ func (c *Cmd) Process(argv []string) *CmdInst {
 		}
 	}
 
-	// find a home for out-of-order parameters, panic if that fails since it's a bug
+	// find a home for out-of-order parameters; any that don't belong to
+	// the matched command/subcommand chain become UnknownParam errors
+	// instead of a panic
 	for _, linst := range looseParams {
 		if topInst.subCmdInst == nil {
-			panic("found out-of-order params but no subcommand! Maybe bug, maybe I need to put a better error here...")
+			errs = append(errs, UnknownParam{Arg: linst.arg})
+			continue
+		}
+
+		found, err := linst.findAndAttachKeyParam(topInst.subCmdInst)
+		if err != nil {
+			errs = append(errs, err)
+		} else if !found {
+			errs = append(errs, UnknownParam{Arg: linst.arg})
 		}
-		linst.findAndAttachKeyParam(topInst.subCmdInst)
 	}
 
-	return &topInst
-}
+	// a subcommand was required but argv had nothing left over that could
+	// have been a free-text search/remainder either -- MissingSubCmd
+	// covers this alongside completely empty argv (above). Once the
+	// caller typed *something* unrecognized, that's still treated as a
+	// search/remainder case rather than a hard usage error (see oncall's
+	// free-text search), same as before this check existed.
+	if c.mustSubCmd && topInst.subCmdInst == nil && len(topInst.Remainder()) == 0 {
+		errs = append(errs, MissingSubCmd{Cmd: c})
+	}
 
-// looksLikeBool checks to see if the provided value contains "true" or "false"
-// in any case combination.
-func looksLikeBool(val string) bool {
-	lcval := strings.ToLower(val)
+	// enforce Required/ValidRE at the top level, then walk down the matched
+	// subcommand chain doing the same.
+	errs = append(errs, validateParamLevel(c, &topInst)...)
 
-	if strings.Contains(lcval, "true") {
-		return true
+	for sci := topInst.subCmdInst; sci != nil; sci = sci.subCmdInst {
+		errs = append(errs, validateParamLevel(&sci.subCmd.Cmd, &sci.CmdInst)...)
 	}
 
-	if strings.Contains(lcval, "false") {
-		return true
+	// a persistent param may have been parsed at any level in the matched
+	// chain (e.g. --room before or after the subcommand token), so Required
+	// is enforced against "found anywhere" rather than a single level.
+	for _, p := range c._persistentParams() {
+		if p.required && deepGetKVParamInst(&topInst, p.key) == nil {
+			errs = append(errs, RequiredParamNotFound{*p})
+		}
 	}
 
-	return false
-}
-
-// looksLikeParam returns true if there is a leading - or an = in the string.
-func looksLikeParam(key string) bool {
-	if strings.HasPrefix(key, "-") {
-		return true
-	} else if strings.Contains(key, "=") {
-		return true
-	} else {
-		return false
+	if len(errs) > 0 {
+		return &topInst, errs
 	}
-}
 
-func (tmp *tmpParamInst) attachKeyParam(whatever cmdorsubcmd) {
-	if whatever.HasKVParam(tmp.key) {
-		p := whatever.GetKVParam(tmp.key)
-		pi := KVParamInst{
-			arg:        tmp.arg,
-			cmdinst:    tmp.cmdinst,
-			found:      tmp.found,
-			key:        tmp.key,
-			param:      p,
-			subcmdinst: tmp.subcmdinst,
-			value:      tmp.value,
-		}
+	return &topInst, nil
+}
 
-		switch whatever.(type) {
-		case *CmdInst:
-			ci := whatever.(*CmdInst)
-			ci.kvparaminsts = append(ci.ListKVParamInsts(), &pi)
-		case *SubCmdInst:
-			sci := whatever.(*SubCmdInst)
-			sci.kvparaminsts = append(sci.ListKVParamInsts(), &pi)
-		}
-	} else if whatever.HasBoolParam(tmp.key) {
-		val, err := strconv.ParseBool(tmp.value)
-		if err != nil {
-			log.Panicf("invalid bool value %q for key %q", tmp.value, tmp.key)
-		}
+// validateParamLevel enforces Required and ValidRE for one level's worth
+// of static param definitions (static) against the instances actually
+// parsed into inst (found) -- one call per Cmd/SubCmd in the matched
+// chain, since each level only validates its own parameters.
+func validateParamLevel(static *Cmd, inst *CmdInst) ValidationErrors {
+	var errs ValidationErrors
 
-		p := whatever.GetBoolParam(tmp.key)
-		pi := BoolParamInst{
-			arg:        tmp.arg,
-			cmdinst:    tmp.cmdinst,
-			found:      tmp.found,
-			key:        tmp.key,
-			param:      p,
-			subcmdinst: tmp.subcmdinst,
-			value:      val,
+	for _, p := range static._kvparams() {
+		pi := inst.GetKVParamInstIfSet(p.key)
+		if pi == nil {
+			if p.required {
+				errs = append(errs, RequiredParamNotFound{*p})
+			}
+			continue
 		}
 
-		switch whatever.(type) {
-		case *CmdInst:
-			ci := whatever.(*CmdInst)
-			ci.boolparaminsts = append(ci.ListBoolParamInsts(), &pi)
-		case *SubCmdInst:
-			sci := whatever.(*SubCmdInst)
-			sci.boolparaminsts = append(sci.ListBoolParamInsts(), &pi)
+		if err := p.checkValidRE(pi.value); err != nil {
+			errs = append(errs, err)
 		}
-	} else {
-		log.Panicf("BUG: arg %q does not have a matching parameter for key %q", tmp.arg, tmp.key)
 	}
-}
 
-func (tmp *tmpParamInst) findAndAttachKeyParam(sub *SubCmdInst) {
-	if sub.HasBoolParam(tmp.key) || sub.HasKVParam(tmp.key) {
-		tmp.attachKeyParam(sub)
-	} else if sub.subCmdInst != nil {
-		tmp.findAndAttachKeyParam(sub.subCmdInst)
+	for _, p := range static._boolparams() {
+		if inst.GetBoolParamInstIfSet(p.key) == nil && p.required {
+			errs = append(errs, RequiredParamNotFound{*p})
+		}
 	}
-}
 
-// HasSubCmdToken returns whether or not the proivded token is defined as a subcommand.
-func (c *Cmd) HasSubCmdToken(token string) bool {
-	if c == nil {
-		return false
-	}
+	for _, idx := range sortedIdxKeys(static._idxparams()) {
+		p := static.idxparams[idx]
+		pi, found := inst.idxparaminsts[idx]
+		if !found {
+			if p.required {
+				errs = append(errs, RequiredParamNotFound{*p})
+			}
+			continue
+		}
 
-	for _, sc := range c.ListSubCmds() {
-		if token == sc.token {
-			return true
+		if err := p.checkValidRE(pi.value); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
-	return false
-}
-
-// HasKeyParam returns true if there are any parameters defined with
-// the provided key of either key type (bool or kv).
-func (c *Cmd) HasKeyParam(key string) bool {
-	if c == nil {
-		return false
-	}
+	for _, p := range static._prefixparams() {
+		if !p.required {
+			continue
+		}
 
-	for _, p := range c._boolparams() {
-		if key == p.key {
-			return true
+		found := false
+		for _, pi := range inst.ListPrefixParamInsts() {
+			if pi.param == p {
+				found = true
+				break
+			}
 		}
-	}
 
-	for _, p := range c._kvparams() {
-		if key == p.key {
-			return true
+		if !found {
+			errs = append(errs, RequiredParamNotFound{*p})
 		}
 	}
 
-	return false
+	return errs
 }
 
-// SubCmdToken returns the subcommand's token string. Returns empty string
-// if there is no subcommand.
-func (c *CmdInst) SubCmdToken() string {
-	if c.subCmdInst != nil {
-		return c.subCmdInst.subCmd.token
-	}
+// checkValidRE lazily compiles ValidRE and matches it against value.
+// ValidRE is informational-only until this point; an empty ValidRE always
+// passes.
+func (p *KVParam) checkValidRE(value string) error {
+	return checkValidRE(p.key, p.validre, value)
+}
 
-	return ""
+func (p *IdxParam) checkValidRE(value string) error {
+	return checkValidRE(fmt.Sprintf("arg%d", p.idx), p.validre, value)
 }
 
-func (c *SubCmdInst) SubCmdToken() string {
-	if c.subCmdInst != nil {
-		return c.subCmdInst.subCmd.token
+func checkValidRE(key, validre, value string) error {
+	if validre == "" {
+		return nil
 	}
 
-	return ""
-}
+	re, err := regexp.Compile(validre)
+	if err != nil {
+		return fmt.Errorf("BUG: parameter %q has an invalid ValidRE %q: %s", key, validre, err)
+	}
 
-func (c *CmdInst) SubCmdInst() *SubCmdInst {
-	return c.subCmdInst
+	if !re.MatchString(value) {
+		return InvalidParamValue{Key: key, Value: value, RE: validre}
+	}
+
+	return nil
 }
 
-func (c *CmdInst) HasKVParamInst(key string) bool {
+// GetKVParamInstIfSet returns the KVParamInst for key, or nil if it was
+// never set -- unlike GetKVParamInst, which panics, this is safe to call
+// without checking HasKVParamInst first.
+func (c *CmdInst) GetKVParamInstIfSet(key string) *KVParamInst {
 	for _, p := range c.ListKVParamInsts() {
 		if p.key == key {
-			return true
+			return p
 		}
 	}
 
-	return false
+	return nil
 }
 
-func (c *CmdInst) HasKVParam(key string) bool {
+// GetKVParamInstIfSet returns the KVParamInst for key, checking c's own
+// params first and falling back to c.parent (and its own parent, and so
+// on) -- this is what makes a persistent param declared on a parent Cmd
+// resolvable from any descendant SubCmdInst regardless of which level in
+// the chain actually parsed its value. See AddPersistentParam.
+func (c *SubCmdInst) GetKVParamInstIfSet(key string) *KVParamInst {
+	for _, p := range c.ListKVParamInsts() {
+		if p.key == key {
+			return p
+		}
+	}
+
+	if c.parent != nil {
+		return c.parent.GetKVParamInstIfSet(key)
+	}
+
+	return nil
+}
+
+// deepGetKVParamInst searches topInst and every subcommand level below it
+// for key, regardless of which level actually parsed the value -- used to
+// enforce Required on a persistent param declared higher up the chain.
+func deepGetKVParamInst(topInst *CmdInst, key string) *KVParamInst {
+	if pi := topInst.GetKVParamInstIfSet(key); pi != nil {
+		return pi
+	}
+
+	for sci := topInst.subCmdInst; sci != nil; sci = sci.subCmdInst {
+		for _, pi := range sci.ListKVParamInsts() {
+			if pi.key == key {
+				return pi
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetBoolParamInstIfSet returns the BoolParamInst for key, or nil if it was
+// never set -- unlike GetBoolParamInst, which returns nil either way, this
+// name makes the "not found" case explicit at call sites that care.
+func (c *CmdInst) GetBoolParamInstIfSet(key string) *BoolParamInst {
+	for _, p := range c.ListBoolParamInsts() {
+		if p.key == key {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// looksLikeBool checks to see if the provided value contains "true" or "false"
+// in any case combination.
+func looksLikeBool(val string) bool {
+	lcval := strings.ToLower(val)
+
+	if strings.Contains(lcval, "true") {
+		return true
+	}
+
+	if strings.Contains(lcval, "false") {
+		return true
+	}
+
+	return false
+}
+
+// expandShortFlags rewrites GNU-style bundled/attached short options in
+// argv against the parameter tree of the subcommand argv actually invokes
+// (see subCmdScope) -- not the whole tree, since a short that's a KVParam
+// on one subcommand and a BoolParam on an unrelated sibling would
+// otherwise look ambiguous and silently stop being expanded for either
+// one. "-abc" becomes "-a", "-b", "-c" when a, b, and c are all BoolParam
+// shorts in scope; "-fvalue" becomes "-f", "value" when f is a KVParam
+// short and not also a BoolParam short in scope. Anything else is passed
+// through unchanged and left for Process's normal unknown-param handling.
+func expandShortFlags(argv []string, c *Cmd) []string {
+	scope := subCmdScope(c, argv)
+	out := make([]string, 0, len(argv))
+
+	for _, arg := range argv {
+		if !looksLikeShortBundle(arg) {
+			out = append(out, arg)
+			continue
+		}
+
+		rest := arg[1:]
+
+		if allBoolShorts(scope, rest) {
+			for _, ch := range rest {
+				out = append(out, "-"+string(ch))
+			}
+			continue
+		}
+
+		first := rest[:1]
+		if hasKVShortAnywhere(scope, first) && !hasBoolShortAnywhere(scope, first) {
+			out = append(out, "-"+first, rest[1:])
+			continue
+		}
+
+		out = append(out, arg)
+	}
+
+	return out
+}
+
+// subCmdScope walks argv[1:] (argv[0] is the base command token) looking
+// for tokens that resolve to one of c's subcommands, descending into each
+// match in turn, so expandShortFlags can check short-flag ambiguity
+// against just the (sub)command argv is actually invoking. Tokens that
+// don't resolve to a subcommand (params, values, an ambiguous abbreviation)
+// are skipped rather than treated as an error here -- Process's normal
+// parsing reports those. Returns c itself if argv names no subcommand.
+func subCmdScope(c *Cmd, argv []string) *Cmd {
+	cur := c
+
+	if len(argv) > 1 {
+		for _, arg := range argv[1:] {
+			sc, err := cur.resolveSubCmdToken(arg)
+			if err != nil || sc == nil {
+				continue
+			}
+			cur = &sc.Cmd
+		}
+	}
+
+	return cur
+}
+
+// looksLikeShortBundle returns true for a single-dash, multi-character
+// argument like "-abc" that isn't a long option ("--foo") or a key=value
+// pair.
+func looksLikeShortBundle(arg string) bool {
+	if !strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "--") {
+		return false
+	}
+
+	return len(arg) > 2 && !strings.Contains(arg, "=")
+}
+
+// allBoolShorts reports whether every character in chars resolves to a
+// BoolParam somewhere in c's tree.
+func allBoolShorts(c *Cmd, chars string) bool {
+	for _, ch := range chars {
+		if !hasBoolShortAnywhere(c, string(ch)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasBoolShortAnywhere/hasKVShortAnywhere search c and every (nested)
+// subcommand for a Bool/KVParam matching key -- see expandShortFlags.
+func hasBoolShortAnywhere(c *Cmd, key string) bool {
+	if c.HasBoolParam(key) {
+		return true
+	}
+
+	for _, sc := range c.ListSubCmds() {
+		if hasBoolShortAnywhere(&sc.Cmd, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasKVShortAnywhere(c *Cmd, key string) bool {
+	if c.HasKVParam(key) {
+		return true
+	}
+
+	for _, sc := range c.ListSubCmds() {
+		if hasKVShortAnywhere(&sc.Cmd, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// looksLikeParam returns true if there is a leading - or an = in the string.
+func looksLikeParam(key string) bool {
+	if strings.HasPrefix(key, "-") {
+		return true
+	} else if strings.Contains(key, "=") {
+		return true
+	} else {
+		return false
+	}
+}
+
+// existingKVParamInst returns whatever's already-attached KVParamInst for
+// key, if any. attachKeyParam uses this to accumulate a Repeatable param's
+// occurrences into one instance instead of appending a new one each time.
+func existingKVParamInst(whatever cmdorsubcmd, key string) *KVParamInst {
+	var insts []*KVParamInst
+
+	switch w := whatever.(type) {
+	case *CmdInst:
+		insts = w.ListKVParamInsts()
+	case *SubCmdInst:
+		insts = w.ListKVParamInsts()
+	}
+
+	for _, pi := range insts {
+		if pi.key == key {
+			return pi
+		}
+	}
+
+	return nil
+}
+
+// attachKeyParam finds whether tmp.key matches a KVParam or BoolParam on
+// whatever and, if so, parses and attaches a matching instance. It returns
+// an error rather than panicking if tmp.value can't be parsed as the
+// matched param's type (currently only possible for BoolParam), or if
+// nothing matches at all -- which should be impossible, since every caller
+// checks HasKVParam/HasBoolParam (or, for findAndAttachKeyParam, walks the
+// chain looking for one) before calling this, but is still handled rather
+// than trusted.
+func (tmp *tmpParamInst) attachKeyParam(whatever cmdorsubcmd) error {
+	value := tmp.value
+	if mapper := cmdFor(whatever).resolvedValueMapper(); mapper != nil {
+		value = mapper(tmp.value)
+	}
+
+	if whatever.HasKVParam(tmp.key) {
+		p := whatever.GetKVParam(tmp.key)
+
+		if p.repeatable != "" {
+			if existing := existingKVParamInst(whatever, p.key); existing != nil {
+				existing.value = existing.value + p.repeatable + value
+				return nil
+			}
+		} else if existing := existingKVParamInst(whatever, p.key); existing != nil {
+			if !p.shadow {
+				return DuplicateParamError{Key: p.key}
+			}
+
+			existing.shadows = append(existing.shadows, &KVParamInst{
+				arg:        tmp.arg,
+				cmdinst:    tmp.cmdinst,
+				found:      tmp.found,
+				key:        p.key,
+				param:      p,
+				subcmdinst: tmp.subcmdinst,
+				value:      value,
+			})
+			return nil
+		}
+
+		pi := KVParamInst{
+			arg:        tmp.arg,
+			cmdinst:    tmp.cmdinst,
+			found:      tmp.found,
+			key:        p.key, // canonical key, so -f and --foo resolve the same instance
+			param:      p,
+			subcmdinst: tmp.subcmdinst,
+			value:      value,
+		}
+
+		switch whatever.(type) {
+		case *CmdInst:
+			ci := whatever.(*CmdInst)
+			ci.kvparaminsts = append(ci.ListKVParamInsts(), &pi)
+		case *SubCmdInst:
+			sci := whatever.(*SubCmdInst)
+			sci.kvparaminsts = append(sci.ListKVParamInsts(), &pi)
+		}
+
+		if p.deprecatedReason != "" {
+			tmp.cmdinst.warnings = append(tmp.cmdinst.Warnings(), fmt.Sprintf("--%s is deprecated: %s", p.key, p.deprecatedReason))
+		}
+
+		return nil
+	} else if whatever.HasBoolParam(tmp.key) {
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return InvalidBoolValue{Key: tmp.key, Value: value}
+		}
+
+		p := whatever.GetBoolParam(tmp.key)
+		pi := BoolParamInst{
+			arg:        tmp.arg,
+			cmdinst:    tmp.cmdinst,
+			found:      tmp.found,
+			key:        p.key, // canonical key, so -f and --foo resolve the same instance
+			param:      p,
+			subcmdinst: tmp.subcmdinst,
+			value:      val,
+		}
+
+		switch whatever.(type) {
+		case *CmdInst:
+			ci := whatever.(*CmdInst)
+			ci.boolparaminsts = append(ci.ListBoolParamInsts(), &pi)
+		case *SubCmdInst:
+			sci := whatever.(*SubCmdInst)
+			sci.boolparaminsts = append(sci.ListBoolParamInsts(), &pi)
+		}
+
+		if p.deprecatedReason != "" {
+			tmp.cmdinst.warnings = append(tmp.cmdinst.Warnings(), fmt.Sprintf("--%s is deprecated: %s", p.key, p.deprecatedReason))
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("BUG: arg %q does not have a matching parameter for key %q", tmp.arg, tmp.key)
+}
+
+// findAndAttachKeyParam walks down the subcommand chain looking for a
+// definition matching tmp.key, attaching it and returning true on the
+// first match. Returns false (with a nil error) if no subcommand in the
+// chain defines it -- that's the caller's cue to treat tmp as an
+// UnknownParam rather than a real failure. A non-nil error means a match
+// was found but attachKeyParam itself failed (e.g. an invalid bool value).
+func (tmp *tmpParamInst) findAndAttachKeyParam(sub *SubCmdInst) (bool, error) {
+	if sub.HasBoolParam(tmp.key) || sub.HasKVParam(tmp.key) {
+		return true, tmp.attachKeyParam(sub)
+	} else if sub.subCmdInst != nil {
+		return tmp.findAndAttachKeyParam(sub.subCmdInst)
+	}
+
+	return false, nil
+}
+
+// matchPrefixParam looks for a PrefixParam matching arg, checking the
+// current subcommand first (same "subcommands get first shot" precedence
+// as key params) and falling back to the top-level command. Returns the
+// cmdorsubcmd the match belongs to (for appendPrefixParamInst) alongside
+// the match itself; both are nil if nothing matched.
+func matchPrefixParam(curSubCmdInst *SubCmdInst, topInst *CmdInst, arg string) (cmdorsubcmd, *PrefixParam) {
+	if curSubCmdInst != nil {
+		if p := curSubCmdInst.GetPrefixParam(arg); p != nil {
+			return curSubCmdInst, p
+		}
+	}
+
+	if p := topInst.GetPrefixParam(arg); p != nil {
+		return topInst, p
+	}
+
+	return nil, nil
+}
+
+// topSubCmdToken resolves arg as c's first subcommand token, but only
+// while none has matched yet (curSubCmdInst == nil) -- once a subcommand
+// is chosen, further tokens are tried against its own subcommands via
+// nestedSubCmdToken instead.
+func topSubCmdToken(c *Cmd, curSubCmdInst *SubCmdInst, arg string) (*SubCmd, error) {
+	if curSubCmdInst != nil {
+		return nil, nil
+	}
+
+	return c.resolveSubCmdToken(arg)
+}
+
+// nestedSubCmdToken resolves arg as a sub-subcommand of curSubCmdInst's
+// matched subcommand. nil-safe: returns (nil, nil) if no subcommand has
+// matched yet.
+func nestedSubCmdToken(curSubCmdInst *SubCmdInst, arg string) (*SubCmd, error) {
+	if curSubCmdInst == nil {
+		return nil, nil
+	}
+
+	return curSubCmdInst.subCmd.resolveSubCmdToken(arg)
+}
+
+// HasSubCmdToken returns whether or not the proivded token is defined as a subcommand.
+func (c *Cmd) HasSubCmdToken(token string) bool {
+	if c == nil {
+		return false
+	}
+
+	sc, _ := c.resolveSubCmdToken(token)
+
+	return sc != nil
+}
+
+// HasKeyParam returns true if there are any parameters defined with
+// the provided key of either key type (bool or kv).
+func (c *Cmd) HasKeyParam(key string) bool {
+	if c == nil {
+		return false
+	}
+
+	if c.hasPersistentParam(key) {
+		return true
+	}
+
+	for _, p := range c._boolparams() {
+		if p.matches(key) {
+			return true
+		}
+	}
+
+	for _, p := range c._kvparams() {
+		if p.matches(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SubCmdToken returns the subcommand's token string. Returns empty string
+// if there is no subcommand.
+func (c *CmdInst) SubCmdToken() string {
+	if c.subCmdInst != nil {
+		return c.subCmdInst.subCmd.token
+	}
+
+	return ""
+}
+
+func (c *SubCmdInst) SubCmdToken() string {
+	if c.subCmdInst != nil {
+		return c.subCmdInst.subCmd.token
+	}
+
+	return ""
+}
+
+func (c *CmdInst) SubCmdInst() *SubCmdInst {
+	return c.subCmdInst
+}
+
+func (c *CmdInst) HasKVParamInst(key string) bool {
+	for _, p := range c.ListKVParamInsts() {
+		if p.key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasKVParamInst reports whether key was found, checking c.parent too --
+// see GetKVParamInstIfSet.
+func (c *SubCmdInst) HasKVParamInst(key string) bool {
+	return c.GetKVParamInstIfSet(key) != nil
+}
+
+func (c *CmdInst) HasKVParam(key string) bool {
 	return c.cmd.HasKVParam(key)
 }
 
@@ -1050,6 +2339,14 @@ func (c *SubCmdInst) HasIdxParam(idx int) bool {
 	return c.subCmd.HasIdxParam(idx)
 }
 
+func (c *CmdInst) HasPrefixParam(arg string) bool {
+	return c.cmd.HasPrefixParam(arg)
+}
+
+func (c *SubCmdInst) HasPrefixParam(arg string) bool {
+	return c.subCmd.HasPrefixParam(arg)
+}
+
 // GetKVParamInst gets a key/value parameter instance by its key.
 func (c *CmdInst) GetKVParamInst(key string) *KVParamInst {
 	for _, p := range c.ListKVParamInsts() {
@@ -1064,24 +2361,36 @@ func (c *CmdInst) GetKVParamInst(key string) *KVParamInst {
 	return nil
 }
 
+// GetKVParamInst gets a key/value parameter instance by its key, falling
+// back to c.parent -- see GetKVParamInstIfSet.
+func (c *SubCmdInst) GetKVParamInst(key string) *KVParamInst {
+	if pi := c.GetKVParamInstIfSet(key); pi != nil {
+		return pi
+	}
+
+	log.Panicf("GetKVParamInst(%q) failed to find an entry. Did you test with HasKVParamInst first?", key)
+
+	return nil
+}
+
 func (c *CmdInst) GetKVParam(key string) *KVParam {
 	for _, p := range c.cmd._kvparams() {
-		if p.key == key {
+		if p.matches(key) {
 			return p
 		}
 	}
 
-	return nil
+	return c.cmd.getPersistentParam(key)
 }
 
 func (c *SubCmdInst) GetKVParam(key string) *KVParam {
 	for _, p := range c.subCmd._kvparams() {
-		if p.key == key {
+		if p.matches(key) {
 			return p
 		}
 	}
 
-	return nil
+	return c.subCmd.getPersistentParam(key)
 }
 
 // GetBoolParamInst gets a key/value parameter instance by its key.
@@ -1097,7 +2406,7 @@ func (c *CmdInst) GetBoolParamInst(key string) *BoolParamInst {
 
 func (c *CmdInst) GetBoolParam(key string) *BoolParam {
 	for _, p := range c.cmd._boolparams() {
-		if p.key == key {
+		if p.matches(key) {
 			return p
 		}
 	}
@@ -1107,7 +2416,7 @@ func (c *CmdInst) GetBoolParam(key string) *BoolParam {
 
 func (c *SubCmdInst) GetBoolParam(key string) *BoolParam {
 	for _, p := range c.subCmd._boolparams() {
-		if p.key == key {
+		if p.matches(key) {
 			return p
 		}
 	}
@@ -1143,6 +2452,14 @@ func (c *SubCmdInst) GetIdxParam(idx int) *IdxParam {
 	return nil
 }
 
+func (c *CmdInst) GetPrefixParam(arg string) *PrefixParam {
+	return c.cmd.GetPrefixParam(arg)
+}
+
+func (c *SubCmdInst) GetPrefixParam(arg string) *PrefixParam {
+	return c.subCmd.GetPrefixParam(arg)
+}
+
 func (c *CmdInst) appendKVParamInst(pi *KVParamInst) {
 	c.kvparaminsts = append(c.ListKVParamInsts(), pi)
 }
@@ -1156,6 +2473,10 @@ func (c *CmdInst) appendIdxParamInst(pi *IdxParamInst) {
 	ipis[pi.idx] = pi
 }
 
+func (c *CmdInst) appendPrefixParamInst(pi *PrefixParamInst) {
+	c.prefixparaminsts = append(c.ListPrefixParamInsts(), pi)
+}
+
 // ListKVParamInsts initializes the kvparaminsts list on the fly and returns it.
 func (c *CmdInst) ListKVParamInsts() []*KVParamInst {
 	if c.kvparaminsts == nil {
@@ -1171,56 +2492,158 @@ func (c *CmdInst) ListBoolParamInsts() []*BoolParamInst {
 		c.boolparaminsts = make([]*BoolParamInst, 0)
 	}
 
-	return c.boolparaminsts
+	return c.boolparaminsts
+}
+
+// mapIdxParamInsts initializes the idxparaminsts list on the fly and returns it.
+func (c *CmdInst) mapIdxParamInsts() map[int]*IdxParamInst {
+	if c.idxparaminsts == nil {
+		c.idxparaminsts = make(map[int]*IdxParamInst)
+	}
+
+	return c.idxparaminsts
+}
+
+func (c *CmdInst) ListIdxParamInsts() []*IdxParamInst {
+	ipis := c.mapIdxParamInsts()
+	out := make([]*IdxParamInst, len(ipis))
+
+	for i, pi := range ipis {
+		out[i] = pi
+	}
+
+	return out
+}
+
+// ListPrefixParamInsts initializes the prefixparaminsts list on the fly and returns it.
+func (c *CmdInst) ListPrefixParamInsts() []*PrefixParamInst {
+	if c.prefixparaminsts == nil {
+		c.prefixparaminsts = make([]*PrefixParamInst, 0)
+	}
+
+	return c.prefixparaminsts
+}
+
+// Remainder initializes the remainder list on the fly and returns it.
+func (c *CmdInst) Remainder() []string {
+	if c.remainder == nil {
+		c.remainder = make([]string, 0)
+	}
+
+	return c.remainder
+}
+
+// Warnings initializes the warnings list on the fly and returns it. Process
+// populates it with soft-fail notices (deprecated commands/subcommands/
+// aliases/params it still accepted) that a plugin can surface to the user
+// alongside a successful result, rather than failing the command outright.
+func (c *CmdInst) Warnings() []string {
+	if c.warnings == nil {
+		c.warnings = make([]string, 0)
+	}
+
+	return c.warnings
+}
+
+// Aliases initializes the aliases list on the fly and returns it.
+func (p *KVParam) Aliases() []string {
+	if p.aliases == nil {
+		p.aliases = make([]string, 0)
+	}
+
+	return p.aliases
+}
+
+func (p *KVParamInst) Value() string {
+	return p.value
+}
+
+// HasShadows reports whether p has any shadow occurrences recorded. See
+// KVParam.Shadow.
+func (p *KVParamInst) HasShadows() bool {
+	return len(p.shadows) > 0
+}
+
+// Shadows returns every occurrence of p's parameter after the first, in
+// the order they appeared in argv. Empty unless KVParam.Shadow(true) was
+// set on p's param.
+func (p *KVParamInst) Shadows() []*KVParamInst {
+	return p.shadows
 }
 
-// mapIdxParamInsts initializes the idxparaminsts list on the fly and returns it.
-func (c *CmdInst) mapIdxParamInsts() map[int]*IdxParamInst {
-	if c.idxparaminsts == nil {
-		c.idxparaminsts = make(map[int]*IdxParamInst)
+// ValueWithShadows returns every occurrence's value in argv order: p's own
+// Value() first, then each Shadows() entry's.
+func (p *KVParamInst) ValueWithShadows() []string {
+	out := make([]string, 0, len(p.shadows)+1)
+	out = append(out, p.value)
+
+	for _, s := range p.shadows {
+		out = append(out, s.value)
 	}
 
-	return c.idxparaminsts
+	return out
 }
 
-func (c *CmdInst) ListIdxParamInsts() []*IdxParamInst {
-	ipis := c.mapIdxParamInsts()
-	out := make([]*IdxParamInst, len(ipis))
+// IntsWithShadows converts every ValueWithShadows() entry with
+// strconv.ParseInt.
+func (p *KVParamInst) IntsWithShadows() ([]int, error) {
+	vals := p.ValueWithShadows()
+	out := make([]int, len(vals))
 
-	for i, pi := range ipis {
-		out[i] = pi
+	for i, v := range vals {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = int(n)
 	}
 
-	return out
+	return out, nil
 }
 
-// Remainder initializes the remainder list on the fly and returns it.
-func (c *CmdInst) Remainder() []string {
-	if c.remainder == nil {
-		c.remainder = make([]string, 0)
+// FloatsWithShadows converts every ValueWithShadows() entry with
+// strconv.ParseFloat.
+func (p *KVParamInst) FloatsWithShadows() ([]float64, error) {
+	vals := p.ValueWithShadows()
+	out := make([]float64, len(vals))
+
+	for i, v := range vals {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = f
 	}
 
-	return c.remainder
+	return out, nil
 }
 
-// Aliases initializes the aliases list on the fly and returns it.
-func (p *KVParam) Aliases() []string {
-	if p.aliases == nil {
-		p.aliases = make([]string, 0)
+// BoolsWithShadows converts every ValueWithShadows() entry with
+// strconv.ParseBool.
+func (p *KVParamInst) BoolsWithShadows() ([]bool, error) {
+	vals := p.ValueWithShadows()
+	out := make([]bool, len(vals))
+
+	for i, v := range vals {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
 	}
 
-	return p.aliases
+	return out, nil
 }
 
-func (p *KVParamInst) Value() string {
+func (p *BoolParamInst) Value() bool {
 	return p.value
 }
 
-func (p *BoolParamInst) Value() bool {
+func (p *IdxParamInst) Value() string {
 	return p.value
 }
 
-func (p *IdxParamInst) Value() string {
+func (p *PrefixParamInst) Value() string {
 	return p.value
 }
 
@@ -1255,6 +2678,15 @@ func (p *IdxParamInst) String() (string, error) {
 	return p.value, nil
 }
 
+// String returns the value as a string.
+func (p *PrefixParamInst) String() (string, error) {
+	if !p.found && p.param.required {
+		return "", RequiredParamNotFound{p.param}
+	}
+
+	return p.value, nil
+}
+
 // String returns the value as an int. If the param is required and it was
 // not set, RequiredParamNotFound is returned. Additionally, any errors in
 // conversion are returned.
@@ -1279,6 +2711,10 @@ func (p *IdxParamInst) Int() (int, error) {
 	return intParam(p)
 }
 
+func (p *PrefixParamInst) Int() (int, error) {
+	return intParam(p)
+}
+
 // Float returns the value of the parameter as a float. If the value cannot
 // be converted, an error will be returned. See: strconv.ParseFloat
 func floatParam(p stringValuedParamInst) (float64, error) {
@@ -1301,6 +2737,10 @@ func (p *IdxParamInst) Float() (float64, error) {
 	return floatParam(p)
 }
 
+func (p *PrefixParamInst) Float() (float64, error) {
+	return floatParam(p)
+}
+
 // Bool returns the value of the parameter as a bool.
 // If the value is required and not set, returns RequiredParamNotFound.
 // If the value cannot be converted, an error will be returned.
@@ -1326,6 +2766,174 @@ func (p *IdxParamInst) Bool() (bool, error) {
 	return boolParam(p)
 }
 
+func (p *PrefixParamInst) Bool() (bool, error) {
+	return boolParam(p)
+}
+
+// customParamInst narrows stringValuedParamInst down to the two types
+// whose param carries a ParamParser (KVParam/IdxParam; PrefixParam and
+// BoolParam don't have one).
+type customParamInst interface {
+	stringValuedParamInst
+	parser() ParamParser
+}
+
+// Custom runs p's value through its configured ParamParser (see
+// KVParam.Parser/IdxParam.Parser). If the param is required and not set,
+// returns RequiredParamNotFound. If no ParamParser was configured, returns
+// an error rather than silently falling back to the raw string.
+func customParam(p customParamInst) (interface{}, error) {
+	if !p.Found() {
+		if p.Required() {
+			return nil, RequiredParamNotFound{p.errParam()}
+		}
+		return nil, nil
+	}
+
+	parser := p.parser()
+	if parser == nil {
+		return nil, fmt.Errorf("no ParamParser configured for parameter %v", p.errParam())
+	}
+
+	return parser.Parse(p.Value())
+}
+
+func (p *KVParamInst) Custom() (interface{}, error) {
+	return customParam(p)
+}
+
+func (p *IdxParamInst) Custom() (interface{}, error) {
+	return customParam(p)
+}
+
+func (p *KVParamInst) parser() ParamParser {
+	return p.param.parser
+}
+
+func (p *IdxParamInst) parser() ParamParser {
+	return p.param.parser
+}
+
+// splitDelim splits raw on delim, trimming surrounding whitespace and a
+// matched pair of single/double quotes from each token. Following go-ini's
+// Key.Strings, this is the tokenizer Strings/Ints/Floats/Bools build on.
+func splitDelim(raw, delim string) []string {
+	fields := strings.Split(raw, delim)
+	out := make([]string, len(fields))
+
+	for i, f := range fields {
+		out[i] = strings.Trim(strings.TrimSpace(f), `'"`)
+	}
+
+	return out
+}
+
+// Strings splits the value on delim, trimming whitespace and surrounding
+// quotes from each token. Following go-ini's Key.Strings. If the value is
+// required and not set, returns RequiredParamNotFound.
+func stringsParam(p stringValuedParamInst, delim string) ([]string, error) {
+	if !p.Found() {
+		if p.Required() {
+			return nil, RequiredParamNotFound{p.errParam()}
+		}
+		return nil, nil
+	}
+
+	return splitDelim(p.Value(), delim), nil
+}
+
+func (p *KVParamInst) Strings(delim string) ([]string, error) {
+	return stringsParam(p, delim)
+}
+
+func (p *IdxParamInst) Strings(delim string) ([]string, error) {
+	return stringsParam(p, delim)
+}
+
+// Ints splits the value on delim like Strings, converting each token with
+// strconv.ParseInt.
+func intsParam(p stringValuedParamInst, delim string) ([]int, error) {
+	toks, err := stringsParam(p, delim)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]int, len(toks))
+	for i, t := range toks {
+		v, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = int(v)
+	}
+
+	return out, nil
+}
+
+func (p *KVParamInst) Ints(delim string) ([]int, error) {
+	return intsParam(p, delim)
+}
+
+func (p *IdxParamInst) Ints(delim string) ([]int, error) {
+	return intsParam(p, delim)
+}
+
+// Floats splits the value on delim like Strings, converting each token with
+// strconv.ParseFloat.
+func floatsParam(p stringValuedParamInst, delim string) ([]float64, error) {
+	toks, err := stringsParam(p, delim)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]float64, len(toks))
+	for i, t := range toks {
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+
+	return out, nil
+}
+
+func (p *KVParamInst) Floats(delim string) ([]float64, error) {
+	return floatsParam(p, delim)
+}
+
+func (p *IdxParamInst) Floats(delim string) ([]float64, error) {
+	return floatsParam(p, delim)
+}
+
+// Bools splits the value on delim like Strings, converting each token with
+// strconv.ParseBool.
+func boolsParam(p stringValuedParamInst, delim string) ([]bool, error) {
+	toks, err := stringsParam(p, delim)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]bool, len(toks))
+	for i, t := range toks {
+		v, err := strconv.ParseBool(t)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+
+	return out, nil
+}
+
+func (p *KVParamInst) Bools(delim string) ([]bool, error) {
+	return boolsParam(p, delim)
+}
+
+func (p *IdxParamInst) Bools(delim string) ([]bool, error) {
+	return boolsParam(p, delim)
+}
+
 // Duration returns the value of the parameter as a Go time.Duration.
 // Day and Week (e.g. "1w", "1d") are converted to 168 and 24 hours respectively.
 // If the value is required and not set, returns RequiredParamNotFound.
@@ -1369,6 +2977,10 @@ func (p *IdxParamInst) Duration() (time.Duration, error) {
 	return durationParam(p)
 }
 
+func (p *PrefixParamInst) Duration() (time.Duration, error) {
+	return durationParam(p)
+}
+
 // Time returns the value of the parameter as a Go time.Time.
 // Many formats are attempted before giving up.
 // If the value is required and not set, returns RequiredParamNotFound.
@@ -1457,10 +3069,54 @@ func defStringParam(p stringValuedParamInst, def string) string {
 	return out
 }
 
+// DefString consults FromEnv's environment variable, if the CLI didn't
+// supply a real value, before falling back to defStringParam's rules. See
+// Resolve for the full chain, which also consults FromPref.
 func (p *KVParamInst) DefString(def string) string {
+	if v, ok := p.lookupEnv(); ok {
+		return v
+	}
+
 	return defStringParam(p, def)
 }
 
+// lookupEnv returns FromEnv's environment variable, if set on p's param
+// and the CLI didn't supply a real value (found and not "*").
+func (p *KVParamInst) lookupEnv() (string, bool) {
+	if p.Found() && p.Value() != "*" {
+		return "", false
+	}
+
+	if p.param == nil || p.param.envVar == "" {
+		return "", false
+	}
+
+	return os.LookupEnv(p.param.envVar)
+}
+
+// Resolve layers value sources in order: the explicit CLI value (unless
+// it's "*") -> evt's Pref store, if FromPref was set -> FromEnv's
+// environment variable -> def. It's the full chain FromPref/FromEnv
+// exist for; Def* only consult FromEnv, since they have no Evt to look a
+// Pref up against.
+func (p *KVParamInst) Resolve(evt *Evt, def string) string {
+	if p.Found() && p.Value() != "*" {
+		return p.value
+	}
+
+	if p.param != nil && p.param.prefKey != "" && evt != nil {
+		if pref := evt.FindPrefs().FindKey(p.param.prefKey).One(); pref.Success && pref.Value != "" {
+			return pref.Value
+		}
+	}
+
+	if v, ok := p.lookupEnv(); ok {
+		return v
+	}
+
+	return def
+}
+
 func (p *IdxParamInst) DefString(def string) string {
 	return defStringParam(p, def)
 }
@@ -1484,7 +3140,15 @@ func defIntParam(p stringValuedParamInst, def int) int {
 	return out
 }
 
+// DefInt is DefString's rules with FromEnv's value parsed as an int. An
+// unparseable env value is ignored, falling through to defIntParam.
 func (p *KVParamInst) DefInt(def int) int {
+	if v, ok := p.lookupEnv(); ok {
+		if iv, err := strconv.Atoi(v); err == nil {
+			return iv
+		}
+	}
+
 	return defIntParam(p, def)
 }
 
@@ -1511,6 +3175,21 @@ func defFloatParam(p stringValuedParamInst, def float64) float64 {
 	return out
 }
 
+// DefFloat is DefString's rules with FromEnv's value parsed as a float.
+func (p *KVParamInst) DefFloat(def float64) float64 {
+	if v, ok := p.lookupEnv(); ok {
+		if fv, err := strconv.ParseFloat(v, 64); err == nil {
+			return fv
+		}
+	}
+
+	return defFloatParam(p, def)
+}
+
+func (p *IdxParamInst) DefFloat(def float64) float64 {
+	return defFloatParam(p, def)
+}
+
 // DefBool returns the value as a bool. See DefString for the rules.
 func defBoolParam(p stringValuedParamInst, def bool) bool {
 	if !p.Found() {
@@ -1529,3 +3208,153 @@ func defBoolParam(p stringValuedParamInst, def bool) bool {
 	}
 	return out
 }
+
+// DefBool is DefString's rules with FromEnv's value parsed as a bool.
+func (p *KVParamInst) DefBool(def bool) bool {
+	if v, ok := p.lookupEnv(); ok {
+		if bv, err := strconv.ParseBool(strings.Trim(v, `'"`)); err == nil {
+			return bv
+		}
+	}
+
+	return defBoolParam(p, def)
+}
+
+func (p *IdxParamInst) DefBool(def bool) bool {
+	return defBoolParam(p, def)
+}
+
+// DefCustom runs the value through Custom's ParamParser. See DefString for
+// the not-set/"*" rules; a missing ParamParser or a conversion error both
+// fall back to def, same as any other Def* method.
+func defCustomParam(p customParamInst, def interface{}) interface{} {
+	if !p.Found() {
+		if p.Required() {
+			return def
+		}
+		return nil
+	} else if p.Value() == "*" {
+		return def
+	}
+
+	out, err := customParam(p)
+	if err != nil {
+		return def
+	}
+	return out
+}
+
+func (p *KVParamInst) DefCustom(def interface{}) interface{} {
+	return defCustomParam(p, def)
+}
+
+func (p *IdxParamInst) DefCustom(def interface{}) interface{} {
+	return defCustomParam(p, def)
+}
+
+// DefStrings returns the value split on delim. See DefString for the rules.
+func defStringsParam(p stringValuedParamInst, delim string, def []string) []string {
+	if !p.Found() {
+		if p.Required() {
+			return def
+		}
+		return nil
+	} else if p.Value() == "*" {
+		return def
+	}
+
+	out, err := stringsParam(p, delim)
+	if err != nil {
+		return def
+	}
+	return out
+}
+
+func (p *KVParamInst) DefStrings(delim string, def []string) []string {
+	return defStringsParam(p, delim, def)
+}
+
+func (p *IdxParamInst) DefStrings(delim string, def []string) []string {
+	return defStringsParam(p, delim, def)
+}
+
+// DefInts returns the value split on delim and parsed as ints. See
+// DefString for the rules.
+func defIntsParam(p stringValuedParamInst, delim string, def []int) []int {
+	if !p.Found() {
+		if p.Required() {
+			return def
+		}
+		return nil
+	} else if p.Value() == "*" {
+		return def
+	}
+
+	out, err := intsParam(p, delim)
+	if err != nil {
+		return def
+	}
+	return out
+}
+
+func (p *KVParamInst) DefInts(delim string, def []int) []int {
+	return defIntsParam(p, delim, def)
+}
+
+func (p *IdxParamInst) DefInts(delim string, def []int) []int {
+	return defIntsParam(p, delim, def)
+}
+
+// DefFloats returns the value split on delim and parsed as floats. See
+// DefString for the rules.
+func defFloatsParam(p stringValuedParamInst, delim string, def []float64) []float64 {
+	if !p.Found() {
+		if p.Required() {
+			return def
+		}
+		return nil
+	} else if p.Value() == "*" {
+		return def
+	}
+
+	out, err := floatsParam(p, delim)
+	if err != nil {
+		return def
+	}
+	return out
+}
+
+func (p *KVParamInst) DefFloats(delim string, def []float64) []float64 {
+	return defFloatsParam(p, delim, def)
+}
+
+func (p *IdxParamInst) DefFloats(delim string, def []float64) []float64 {
+	return defFloatsParam(p, delim, def)
+}
+
+// DefBools returns the value split on delim and parsed as bools. See
+// DefString for the rules.
+func defBoolsParam(p stringValuedParamInst, delim string, def []bool) []bool {
+	if !p.Found() {
+		if p.Required() {
+			return def
+		}
+		return nil
+	} else if p.Value() == "*" {
+		return def
+	}
+
+	out, err := boolsParam(p, delim)
+	if err != nil {
+		return def
+	}
+	return out
+}
+
+func (p *KVParamInst) DefBools(delim string, def []bool) []bool {
+	return defBoolsParam(p, delim, def)
+}
+
+func (p *IdxParamInst) DefBools(delim string, def []bool) []bool {
+	return defBoolsParam(p, delim, def)
+}
@@ -0,0 +1,357 @@
+package hal
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bind populates the struct v points at from c's parsed parameter
+// instances, using a `hal:"..."` tag on each field to say where its value
+// comes from:
+//
+//	`hal:"kv,name=level,default=info"` - a KVParam's value (name defaults
+//	                                      to the field name, lowercased)
+//	`hal:"bool,name=verbose"`          - a BoolParam's value
+//	`hal:"idx,pos=0"`                  - a positional IdxParam
+//	`hal:"remainder"`                  - CmdInst.Remainder()
+//
+// Supported field types: string, int/int64, float64, bool, time.Duration,
+// time.Time, and (kv fields only) slices of the first four, populated from
+// the KVParamInst's shadow occurrences -- see KVParam.Shadow. This mirrors
+// go-ini's MapTo/ReflectFrom and replaces the HasKVParamInst/
+// GetKVParamInst/Int() boilerplate plugins otherwise repeat per parameter.
+//
+// A field tagged "required" that wasn't set on the command returns a
+// BindFieldError wrapping RequiredParamNotFound and naming the field.
+func (c *CmdInst) Bind(v interface{}) error {
+	return bind(c, v)
+}
+
+// Bind populates v from the subcommand instance's own parameters,
+// following the same persistent-param fallback chain GetKVParamInst does.
+// See (*CmdInst).Bind.
+func (c *SubCmdInst) Bind(v interface{}) error {
+	return bind(c, v)
+}
+
+// paramInstSource is the subset of CmdInst/SubCmdInst that bind needs to
+// look up parameter instances by name/position. Both *CmdInst and
+// *SubCmdInst satisfy it -- SubCmdInst overrides the KV lookups to walk
+// its persistent-param parent chain, and inherits the rest unmodified via
+// its embedded CmdInst, the same split GetKVParamInst/GetBoolParamInst use
+// elsewhere in this file.
+type paramInstSource interface {
+	HasKVParamInst(string) bool
+	GetKVParamInst(string) *KVParamInst
+	HasBoolParamInst(string) bool
+	GetBoolParamInst(string) *BoolParamInst
+	HasIdxParamInst(int) bool
+	GetIdxParamInst(int) *IdxParamInst
+	Remainder() []string
+}
+
+// BindFieldError wraps an error Bind encountered populating a struct
+// field, naming the field so a caller debugging a failed Bind knows where
+// to look rather than just which parameter was at fault.
+type BindFieldError struct {
+	Field string
+	Err   error
+}
+
+// Error fulfills the Error interface.
+func (e BindFieldError) Error() string {
+	return fmt.Sprintf("field %q: %s", e.Field, e.Err)
+}
+
+// Unwrap lets errors.As/errors.Is see through to Err, e.g.
+// errors.As(err, &hal.RequiredParamNotFound{}).
+func (e BindFieldError) Unwrap() error {
+	return e.Err
+}
+
+// bindTag is the parsed form of a `hal:"..."` struct tag.
+type bindTag struct {
+	kind     string // "kv", "bool", "idx", "remainder"
+	name     string // KVParam/BoolParam key, kv/bool only
+	pos      int    // IdxParam position, idx only
+	required bool
+	def      string
+	hasDef   bool
+}
+
+// parseBindTag parses fieldName's `hal:"..."` tag value. name defaults to
+// fieldName lowercased for kv/bool kinds unless overridden with "name=".
+func parseBindTag(raw, fieldName string) (bindTag, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) == 0 || parts[0] == "" {
+		return bindTag{}, fmt.Errorf("empty hal tag")
+	}
+
+	bt := bindTag{kind: parts[0]}
+
+	switch bt.kind {
+	case "kv", "bool":
+		bt.name = strings.ToLower(fieldName)
+	case "idx", "remainder":
+		// no name to default
+	default:
+		return bindTag{}, fmt.Errorf("unknown hal tag kind %q", bt.kind)
+	}
+
+	for _, p := range parts[1:] {
+		switch {
+		case p == "required":
+			bt.required = true
+		case strings.HasPrefix(p, "name="):
+			bt.name = strings.TrimPrefix(p, "name=")
+		case strings.HasPrefix(p, "pos="):
+			pos, err := strconv.Atoi(strings.TrimPrefix(p, "pos="))
+			if err != nil {
+				return bindTag{}, fmt.Errorf("invalid pos: %s", err)
+			}
+			bt.pos = pos
+		case strings.HasPrefix(p, "default="):
+			bt.def = strings.TrimPrefix(p, "default=")
+			bt.hasDef = true
+		}
+	}
+
+	return bt, nil
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// bind is the shared implementation behind (*CmdInst).Bind and
+// (*SubCmdInst).Bind.
+func bind(src paramInstSource, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("hal: Bind requires a non-nil pointer to a struct, got %T", v)
+	}
+
+	sv := rv.Elem()
+	st := sv.Type()
+
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+
+		raw, ok := sf.Tag.Lookup("hal")
+		if !ok {
+			continue
+		}
+
+		if sf.PkgPath != "" {
+			return BindFieldError{Field: sf.Name, Err: fmt.Errorf("unexported field can't be bound")}
+		}
+
+		bt, err := parseBindTag(raw, sf.Name)
+		if err != nil {
+			return BindFieldError{Field: sf.Name, Err: err}
+		}
+
+		if err := bindField(src, sv.Field(i), bt); err != nil {
+			return BindFieldError{Field: sf.Name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// bindField populates one struct field per bt's tag.
+func bindField(src paramInstSource, field reflect.Value, bt bindTag) error {
+	switch bt.kind {
+	case "remainder":
+		if field.Kind() != reflect.Slice || field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("remainder field must be []string, got %s", field.Type())
+		}
+		field.Set(reflect.ValueOf(src.Remainder()))
+		return nil
+
+	case "bool":
+		found := src.HasBoolParamInst(bt.name)
+
+		if !found {
+			if bt.required {
+				return RequiredParamNotFound{bt.name}
+			}
+			if bt.hasDef {
+				v, err := strconv.ParseBool(bt.def)
+				if err != nil {
+					return err
+				}
+				field.SetBool(v)
+			}
+			return nil
+		}
+
+		field.SetBool(src.GetBoolParamInst(bt.name).Value())
+		return nil
+
+	case "idx":
+		found := src.HasIdxParamInst(bt.pos)
+		if !found {
+			return bindMissing(field, bt)
+		}
+
+		pi := src.GetIdxParamInst(bt.pos)
+		return setScalar(field, pi.Value())
+
+	case "kv":
+		found := src.HasKVParamInst(bt.name)
+		if !found {
+			if field.Kind() == reflect.Slice {
+				if bt.required {
+					return RequiredParamNotFound{bt.name}
+				}
+				return nil
+			}
+			return bindMissing(field, bt)
+		}
+
+		pi := src.GetKVParamInst(bt.name)
+
+		if field.Kind() == reflect.Slice {
+			return setSlice(field, pi)
+		}
+
+		return setScalar(field, pi.Value())
+
+	default:
+		return fmt.Errorf("BUG: unhandled hal tag kind %q", bt.kind)
+	}
+}
+
+// bindMissing applies bt's required/default rules for a kv/idx field that
+// wasn't found on the command.
+func bindMissing(field reflect.Value, bt bindTag) error {
+	if bt.required {
+		return RequiredParamNotFound{bt.name}
+	}
+	if bt.hasDef {
+		return setScalar(field, bt.def)
+	}
+	return nil
+}
+
+// setScalar converts raw into field's type: string, int/int64, float64,
+// bool, time.Duration, or time.Time.
+func setScalar(field reflect.Value, raw string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := durationParam(rawValueParamInst(raw))
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+
+	case field.Type() == timeType:
+		t, err := timeParam(rawValueParamInst(raw))
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
+
+// setSlice populates a slice field from pi's shadow occurrences (see
+// KVParamInst.ValueWithShadows/IntsWithShadows/FloatsWithShadows/
+// BoolsWithShadows), converting element-by-element to match field's
+// element type.
+func setSlice(field reflect.Value, pi *KVParamInst) error {
+	switch field.Type().Elem().Kind() {
+	case reflect.String:
+		vals := pi.ValueWithShadows()
+		out := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+		for i, v := range vals {
+			out.Index(i).SetString(v)
+		}
+		field.Set(out)
+
+	case reflect.Int, reflect.Int64:
+		vals, err := pi.IntsWithShadows()
+		if err != nil {
+			return err
+		}
+		out := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+		for i, v := range vals {
+			out.Index(i).SetInt(int64(v))
+		}
+		field.Set(out)
+
+	case reflect.Float64:
+		vals, err := pi.FloatsWithShadows()
+		if err != nil {
+			return err
+		}
+		out := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+		for i, v := range vals {
+			out.Index(i).SetFloat(v)
+		}
+		field.Set(out)
+
+	case reflect.Bool:
+		vals, err := pi.BoolsWithShadows()
+		if err != nil {
+			return err
+		}
+		out := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+		for i, v := range vals {
+			out.Index(i).SetBool(v)
+		}
+		field.Set(out)
+
+	default:
+		return fmt.Errorf("unsupported slice element type %s", field.Type().Elem())
+	}
+
+	return nil
+}
+
+// rawValueParamInst adapts a plain string (e.g. a tag's default=) to the
+// stringValuedParamInst interface durationParam/timeParam expect, so
+// setScalar can reuse their parsing rules instead of duplicating them.
+type rawValueParamInst string
+
+func (r rawValueParamInst) Found() bool             { return true }
+func (r rawValueParamInst) Required() bool          { return false }
+func (r rawValueParamInst) Value() string           { return string(r) }
+func (r rawValueParamInst) String() (string, error) { return string(r), nil }
+func (r rawValueParamInst) Int() (int, error)       { return strconv.Atoi(string(r)) }
+func (r rawValueParamInst) Float() (float64, error) { return strconv.ParseFloat(string(r), 64) }
+func (r rawValueParamInst) Bool() (bool, error)     { return strconv.ParseBool(string(r)) }
+func (r rawValueParamInst) errParam() interface{}   { return string(r) }
@@ -0,0 +1,155 @@
+package hal
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBindPopulatesAllFieldKinds covers the chunk11-4 struct-tag Bind:
+// kv/bool/remainder fields, a kv field with a default, and a repeated kv
+// field bound into a slice via shadow occurrences.
+func TestBindPopulatesAllFieldKinds(t *testing.T) {
+	root := NewCmd("deploy", false)
+	root.AddKVParam("env", true)
+	root.AddKVParam("timeout", false)
+	root.AddBoolParam("verbose", false)
+	root.AddKVParam("tag", false).Shadow(true)
+
+	argv := strings.Split("deploy --env staging --verbose=true --tag a --tag b extra", " ")
+	res, err := root.Process(argv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type opts struct {
+		Env     string        `hal:"kv,name=env,required"`
+		Timeout time.Duration `hal:"kv,name=timeout,default=30s"`
+		Verbose bool          `hal:"bool,name=verbose"`
+		Tags    []string      `hal:"kv,name=tag"`
+		Extra   []string      `hal:"remainder"`
+	}
+
+	var o opts
+	if err := res.Bind(&o); err != nil {
+		t.Fatal(err)
+	}
+
+	if o.Env != "staging" {
+		t.Errorf("Env = %q, want %q", o.Env, "staging")
+	}
+	if o.Timeout != 30*time.Second {
+		t.Errorf("Timeout = %s, want %s", o.Timeout, 30*time.Second)
+	}
+	if !o.Verbose {
+		t.Error("Verbose = false, want true")
+	}
+	if !reflect.DeepEqual(o.Tags, []string{"a", "b"}) {
+		t.Errorf("Tags = %#v, want %#v", o.Tags, []string{"a", "b"})
+	}
+	if !reflect.DeepEqual(o.Extra, []string{"extra"}) {
+		t.Errorf("Extra = %#v, want %#v", o.Extra, []string{"extra"})
+	}
+}
+
+// TestBindIdxField covers binding a positional IdxParam into a struct
+// field. The IdxParamInst is attached directly rather than through
+// Process, since a Cmd can't mix positional and key/value parameters (see
+// assertZeroKeyParams/assertZeroIdxParams) and this is only exercising
+// bindField's "idx" case, not Process's own positional-argument parsing.
+func TestBindIdxField(t *testing.T) {
+	root := NewCmd("widget", false)
+	p := root.AddIdxParam(0, true)
+
+	inst := &CmdInst{cmd: root}
+	inst.appendIdxParamInst(&IdxParamInst{cmdinst: inst, found: true, idx: 0, param: p, value: "4"})
+
+	type opts struct {
+		ID string `hal:"idx,pos=0"`
+	}
+
+	var o opts
+	if err := inst.Bind(&o); err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != "4" {
+		t.Errorf("ID = %q, want %q", o.ID, "4")
+	}
+}
+
+// TestBindRequiredFieldErrorNamesParam covers the chunk11-4 review fix: a
+// required kv field that's missing must surface a BindFieldError naming
+// the struct field, wrapping a RequiredParamNotFound that names the param
+// itself rather than rendering "BUG(unknown)".
+func TestBindRequiredFieldErrorNamesParam(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("name", false)
+
+	type opts struct {
+		Name string `hal:"kv,name=name,required"`
+	}
+
+	res, err := root.Process([]string{"!widget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var o opts
+	err = res.Bind(&o)
+	if err == nil {
+		t.Fatal("expected a required-field error")
+	}
+
+	var bfe BindFieldError
+	if !errors.As(err, &bfe) {
+		t.Fatalf("expected a BindFieldError, got %T: %s", err, err)
+	}
+	if bfe.Field != "Name" {
+		t.Errorf("BindFieldError.Field = %q, want %q", bfe.Field, "Name")
+	}
+
+	var rpnf RequiredParamNotFound
+	if !errors.As(err, &rpnf) {
+		t.Fatalf("expected a wrapped RequiredParamNotFound, got %s", err)
+	}
+	if strings.Contains(err.Error(), "BUG(unknown)") || !strings.Contains(err.Error(), "name") {
+		t.Errorf("error should name the missing param, got %q", err.Error())
+	}
+}
+
+// TestBindRejectsUnexportedField and TestBindRejectsNonStructPointer cover
+// Bind's input-validation paths.
+func TestBindRejectsUnexportedField(t *testing.T) {
+	root := NewCmd("widget", false)
+	res, err := root.Process([]string{"!widget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type opts struct {
+		name string `hal:"kv,name=name"`
+	}
+
+	var o opts
+	if err := res.Bind(&o); err == nil {
+		t.Error("expected an error binding into an unexported field")
+	}
+}
+
+func TestBindRejectsNonStructPointer(t *testing.T) {
+	root := NewCmd("widget", false)
+	res, err := root.Process([]string{"!widget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s string
+	if err := res.Bind(&s); err == nil {
+		t.Error("expected an error binding into a non-struct pointer")
+	}
+	if err := res.Bind(s); err == nil {
+		t.Error("expected an error binding into a non-pointer")
+	}
+}
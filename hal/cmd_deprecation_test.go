@@ -0,0 +1,61 @@
+package hal
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDeprecationWarnings covers the chunk10-3 soft-warn channel: a
+// deprecated subcommand, a deprecated alias, and a deprecated KVParam each
+// add a Warning explaining the replacement instead of failing Process.
+func TestDeprecationWarnings(t *testing.T) {
+	root := NewCmd("widget", true)
+	root.AddSubCmd("list").Deprecated("use 'ls' instead")
+	root.AddSubCmd("show").AddDeprecatedAlias("display", "renamed to 'show'")
+	root.AddSubCmd("make").AddKVParam("color", false).Deprecated("use --colour instead")
+
+	res, err := root.Process([]string{"!widget", "list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsSubstring(res.Warnings(), "list", "deprecated", "use 'ls' instead") {
+		t.Errorf("expected a deprecated-subcommand warning, got %#v", res.Warnings())
+	}
+
+	res, err = root.Process([]string{"!widget", "display"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SubCmdToken() != "show" {
+		t.Errorf("expected the deprecated alias to still resolve to 'show', got %q", res.SubCmdToken())
+	}
+	if !containsSubstring(res.Warnings(), "display", "renamed to 'show'") {
+		t.Errorf("expected a deprecated-alias warning, got %#v", res.Warnings())
+	}
+
+	res, err = root.Process([]string{"!widget", "make", "--color", "red"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsSubstring(res.Warnings(), "color", "deprecated", "use --colour instead") {
+		t.Errorf("expected a deprecated-param warning, got %#v", res.Warnings())
+	}
+}
+
+// containsSubstring reports whether some entry in warnings contains every
+// one of substrs.
+func containsSubstring(warnings []string, substrs ...string) bool {
+	for _, w := range warnings {
+		all := true
+		for _, s := range substrs {
+			if !strings.Contains(w, s) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
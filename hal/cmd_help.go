@@ -0,0 +1,322 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HelpBlock is the rendered output of (*Cmd).Help()/(*CmdInst).Help(): a
+// one-line synopsis, a parameter table (Header/Rows, ready to hand to
+// Broker.SendTable), and the command's immediate subcommands. String()
+// renders all three as a single text block for brokers that haven't
+// implemented SendTable.
+type HelpBlock struct {
+	Synopsis    string
+	Description string // c's own SetUsage text, "" if unset
+	Header      []string
+	Rows        [][]string
+	Subcommands []SubcommandInfo
+}
+
+// SubcommandInfo describes one of a Cmd's immediate subcommands for
+// HelpBlock.Subcommands.
+type SubcommandInfo struct {
+	Token   string
+	Usage   string
+	Aliases []string
+}
+
+// String renders h as plain text, for evt.Reply() on brokers without
+// Broker.SendTable.
+func (h *HelpBlock) String() string {
+	buf := bytes.NewBufferString(h.Synopsis)
+	buf.WriteString("\n")
+
+	if h.Description != "" {
+		fmt.Fprintf(buf, "\n%s\n", h.Description)
+	}
+
+	if len(h.Rows) > 0 {
+		buf.WriteString("\n")
+		buf.WriteString(AsciiTable(h.Header, h.Rows))
+	}
+
+	if len(h.Subcommands) > 0 {
+		buf.WriteString("\nsubcommands:\n")
+		for _, sc := range h.Subcommands {
+			fmt.Fprintf(buf, "  %-20s %s\n", sc.Token, sc.Usage)
+		}
+	}
+
+	return buf.String()
+}
+
+// Help returns c's auto-generated help: a synopsis line built from c's
+// position in the command tree, a parameter table (key, aliases,
+// required/optional, ValidRE hint, default, usage), and c's immediate
+// subcommands. It's assembled from the same metadata Process() parses
+// against, so the two can't drift out of sync.
+func (c *Cmd) Help() *HelpBlock {
+	h := &HelpBlock{
+		Synopsis:    c.synopsis(),
+		Description: c.usage,
+		Header:      []string{"param", "aliases", "required", "valid", "default", "usage"},
+	}
+
+	for _, p := range c._kvparams() {
+		h.Rows = append(h.Rows, paramHelpRow(paramName(p.key, p.short), p.aliases, p.required, p.validre, p.def, p.usage))
+	}
+
+	for cur := c; cur != nil; cur = cur.prev {
+		for _, p := range cur._persistentParams() {
+			h.Rows = append(h.Rows, paramHelpRow(paramName(p.key, p.short), p.aliases, p.required, p.validre, p.def, p.usage))
+		}
+	}
+
+	for _, p := range c._boolparams() {
+		h.Rows = append(h.Rows, paramHelpRow(paramName(p.key, p.short), p.aliases, p.required, p.validre, p.def, p.usage))
+	}
+
+	for _, idx := range sortedIdxKeys(c._idxparams()) {
+		p := c.idxparams[idx]
+		h.Rows = append(h.Rows, paramHelpRow(fmt.Sprintf("arg%d", idx), nil, p.required, p.validre, p.def, p.usage))
+	}
+
+	for _, sc := range c.ListSubCmds() {
+		h.Subcommands = append(h.Subcommands, SubcommandInfo{
+			Token:   sc.token,
+			Usage:   sc.usage,
+			Aliases: sc.Aliases(),
+		})
+	}
+
+	return h
+}
+
+// Help returns the auto-generated help for the command this instance was
+// created from. See (*Cmd).Help.
+func (c *CmdInst) Help() *HelpBlock {
+	return c.cmd.Help()
+}
+
+// HelpRequested reports whether Process() stopped short of parsing
+// because argv asked for --help/-h. A plugin should check this before
+// doing anything else with a *CmdInst, and Reply with Help() instead of
+// running the command.
+func (c *CmdInst) HelpRequested() bool {
+	return c.helpRequested
+}
+
+// HelpText returns the rendered usage string Process() generated (via
+// PlainRenderer) when argv asked for --help/-h. Only meaningful when
+// HelpRequested() is true; "" otherwise.
+func (c *CmdInst) HelpText() string {
+	return c.helpText
+}
+
+// UsageRenderer formats a *HelpBlock for a particular target. PlainRenderer
+// (what (*Cmd).Usage() and Process's automatic --help/-h use) produces
+// unstyled text with uppercase section headers; MarkdownRenderer targets
+// brokers like Slack/HipChat that render a markdown dialect; TerminalRenderer
+// adds ANSI color for CLI adapters. Implement this interface to support
+// another broker's formatting conventions.
+type UsageRenderer interface {
+	Render(h *HelpBlock) string
+}
+
+// PlainRenderer renders a HelpBlock as plain, unstyled text with SUBCOMMANDS
+// and PARAMETERS section headers. It's the default used by (*Cmd).Usage()
+// and Process's automatic --help/-h handling.
+type PlainRenderer struct{}
+
+// Render fulfills the UsageRenderer interface.
+func (PlainRenderer) Render(h *HelpBlock) string {
+	buf := bytes.NewBufferString(h.Synopsis)
+	buf.WriteString("\n")
+
+	if h.Description != "" {
+		fmt.Fprintf(buf, "\n%s\n", h.Description)
+	}
+
+	if len(h.Rows) > 0 {
+		buf.WriteString("\nPARAMETERS\n")
+		buf.WriteString(AsciiTable(h.Header, h.Rows))
+	}
+
+	if len(h.Subcommands) > 0 {
+		buf.WriteString("\nSUBCOMMANDS\n")
+		for _, sc := range h.Subcommands {
+			name := sc.Token
+			if len(sc.Aliases) > 0 {
+				name = fmt.Sprintf("%s (%s)", sc.Token, strings.Join(sc.Aliases, ", "))
+			}
+			fmt.Fprintf(buf, "  %-30s %s\n", name, sc.Usage)
+		}
+	}
+
+	return buf.String()
+}
+
+// MarkdownRenderer renders a HelpBlock using the markdown dialect Slack/
+// HipChat accept in messages: *bold* headers, a backtick-quoted param
+// table, and a bulleted subcommand list.
+type MarkdownRenderer struct{}
+
+// Render fulfills the UsageRenderer interface.
+func (MarkdownRenderer) Render(h *HelpBlock) string {
+	buf := bytes.NewBufferString(fmt.Sprintf("*%s*\n", h.Synopsis))
+
+	if h.Description != "" {
+		fmt.Fprintf(buf, "\n%s\n", h.Description)
+	}
+
+	if len(h.Rows) > 0 {
+		buf.WriteString("\n*PARAMETERS*\n")
+		for _, row := range h.Rows {
+			fmt.Fprintf(buf, "`%s` - %s\n", row[0], row[len(row)-1])
+		}
+	}
+
+	if len(h.Subcommands) > 0 {
+		buf.WriteString("\n*SUBCOMMANDS*\n")
+		for _, sc := range h.Subcommands {
+			fmt.Fprintf(buf, "- `%s` - %s\n", sc.Token, sc.Usage)
+			if len(sc.Aliases) > 0 {
+				fmt.Fprintf(buf, "  (aliases: %s)\n", strings.Join(sc.Aliases, ", "))
+			}
+		}
+	}
+
+	return buf.String()
+}
+
+// ANSI escape codes used by TerminalRenderer. Kept unexported since they're
+// an implementation detail of that one renderer.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiCyan  = "\x1b[36m"
+)
+
+// TerminalRenderer renders a HelpBlock with ANSI color/bold escapes, for
+// CLI adapters driving a real terminal rather than a chat broker.
+type TerminalRenderer struct{}
+
+// Render fulfills the UsageRenderer interface.
+func (TerminalRenderer) Render(h *HelpBlock) string {
+	buf := bytes.NewBufferString(fmt.Sprintf("%s%s%s\n", ansiBold, h.Synopsis, ansiReset))
+
+	if h.Description != "" {
+		fmt.Fprintf(buf, "\n%s\n", h.Description)
+	}
+
+	if len(h.Rows) > 0 {
+		fmt.Fprintf(buf, "\n%s%sPARAMETERS%s\n", ansiBold, ansiCyan, ansiReset)
+		buf.WriteString(AsciiTable(h.Header, h.Rows))
+	}
+
+	if len(h.Subcommands) > 0 {
+		fmt.Fprintf(buf, "\n%s%sSUBCOMMANDS%s\n", ansiBold, ansiCyan, ansiReset)
+		for _, sc := range h.Subcommands {
+			name := sc.Token
+			if len(sc.Aliases) > 0 {
+				name = fmt.Sprintf("%s (%s)", sc.Token, strings.Join(sc.Aliases, ", "))
+			}
+			fmt.Fprintf(buf, "  %s%-30s%s %s\n", ansiCyan, name, ansiReset, sc.Usage)
+		}
+	}
+
+	return buf.String()
+}
+
+// synopsis renders c's position in the command tree (root down to c,
+// space separated) followed by a short summary of its parameters and
+// whether it takes a subcommand.
+func (c *Cmd) synopsis() string {
+	var path []string
+	for cur := c; cur != nil; cur = cur.prev {
+		path = append([]string{cur.token}, path...)
+	}
+
+	parts := []string{strings.Join(path, " ")}
+
+	for _, p := range c._kvparams() {
+		parts = append(parts, paramSynopsis("--"+p.key+" <value>", p.required))
+	}
+
+	for _, p := range c._boolparams() {
+		parts = append(parts, paramSynopsis("--"+p.key, p.required))
+	}
+
+	for _, idx := range sortedIdxKeys(c._idxparams()) {
+		parts = append(parts, paramSynopsis(fmt.Sprintf("<arg%d>", idx), c.idxparams[idx].required))
+	}
+
+	if len(c.ListSubCmds()) > 0 {
+		if c.mustSubCmd {
+			parts = append(parts, "<subcommand>")
+		} else {
+			parts = append(parts, "[subcommand]")
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// paramName renders a KV/BoolParam's name for Help()'s table, e.g.
+// "-f, --foo" when a Short alias is set, otherwise plain "--foo".
+func paramName(long string, short rune) string {
+	if short == 0 {
+		return "--" + long
+	}
+
+	return fmt.Sprintf("-%c, --%s", short, long)
+}
+
+func paramSynopsis(token string, required bool) string {
+	if required {
+		return token
+	}
+
+	return "[" + token + "]"
+}
+
+func paramHelpRow(name string, aliases []string, required bool, validre, def, usage string) []string {
+	req := "optional"
+	if required {
+		req = "required"
+	}
+
+	return []string{name, strings.Join(aliases, ", "), req, validre, def, usage}
+}
+
+// sortedIdxKeys returns m's keys in ascending order, so Help()/synopsis()
+// render positional parameters in argument order instead of map order.
+func sortedIdxKeys(m map[int]*IdxParam) []int {
+	out := make([]int, 0, len(m))
+	for idx := range m {
+		out = append(out, idx)
+	}
+
+	sort.Ints(out)
+
+	return out
+}
@@ -0,0 +1,74 @@
+package hal
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCmdHelp covers the chunk5-1 auto-generated Help(): the synopsis names
+// the command's position in the tree and its params, the table has one row
+// per kv/bool/idx param, and subcommands are listed with their aliases.
+func TestCmdHelp(t *testing.T) {
+	root := NewCmd("widget", true)
+	root.AddKVParam("color", true).SetUsage("paint color")
+	root.AddBoolParam("verbose", false)
+	root.AddSubCmd("make").SetUsage("make a widget").AddAlias("mk")
+
+	h := root.Help()
+
+	if !strings.Contains(h.Synopsis, "widget") || !strings.Contains(h.Synopsis, "--color") {
+		t.Errorf("Synopsis = %q, want it to mention widget and --color", h.Synopsis)
+	}
+	if !strings.Contains(h.Synopsis, "<subcommand>") {
+		t.Errorf("Synopsis = %q, want a mandatory <subcommand> marker", h.Synopsis)
+	}
+
+	if len(h.Rows) != 2 {
+		t.Fatalf("expected 2 param rows (color, verbose), got %d: %#v", len(h.Rows), h.Rows)
+	}
+
+	if len(h.Subcommands) != 1 || h.Subcommands[0].Token != "make" {
+		t.Fatalf("expected one 'make' subcommand, got %#v", h.Subcommands)
+	}
+	if len(h.Subcommands[0].Aliases) != 1 || h.Subcommands[0].Aliases[0] != "mk" {
+		t.Errorf("expected make's alias 'mk' to be listed, got %#v", h.Subcommands[0].Aliases)
+	}
+}
+
+// TestHelpRequested covers Process's --help/-h short-circuit: either token
+// anywhere in argv stops parsing and sets HelpRequested/HelpText instead of
+// returning a parse error.
+func TestHelpRequested(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("color", true)
+
+	res, err := root.Process([]string{"!widget", "--help"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.HelpRequested() {
+		t.Fatal("expected HelpRequested() to be true")
+	}
+	if !strings.Contains(res.HelpText(), "widget") {
+		t.Errorf("HelpText() = %q, want it to mention widget", res.HelpText())
+	}
+
+	res, err = root.Process([]string{"!widget", "-h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.HelpRequested() {
+		t.Fatal("expected -h to also set HelpRequested()")
+	}
+
+	res, err = root.Process([]string{"!widget", "--color", "red"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.HelpRequested() {
+		t.Error("expected HelpRequested() to be false when --help/-h wasn't passed")
+	}
+	if res.HelpText() != "" {
+		t.Errorf("HelpText() = %q, want empty when help wasn't requested", res.HelpText())
+	}
+}
@@ -0,0 +1,180 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParamParser converts a parameter's raw string value into an arbitrary Go
+// value. It's the extension point behind KVParam.Parser/IdxParam.Parser
+// and (*KVParamInst/IdxParamInst).Custom/DefCustom, for plugins that need
+// a conversion this package doesn't ship as a named type/method (net.IP,
+// *url.URL, etc already have one; everything else was ad-hoc strconv calls
+// scattered across plugins before this). Implement it for anything else,
+// e.g. a plugin-specific id format.
+type ParamParser interface {
+	Parse(raw string) (interface{}, error)
+}
+
+// IPParser parses raw with net.ParseIP, returning a net.IP.
+type IPParser struct{}
+
+// Parse fulfills the ParamParser interface.
+func (IPParser) Parse(raw string) (interface{}, error) {
+	ip := net.ParseIP(raw)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not a valid IP address", raw)
+	}
+
+	return ip, nil
+}
+
+// URLParser parses raw as an absolute URL with net/url.Parse, returning
+// *url.URL.
+type URLParser struct{}
+
+// Parse fulfills the ParamParser interface.
+func (URLParser) Parse(raw string) (interface{}, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.IsAbs() {
+		return nil, fmt.Errorf("%q is not an absolute URL", raw)
+	}
+
+	return u, nil
+}
+
+// UUID is a 16-byte RFC 4122 UUID, the value UUIDParser.Parse returns. hal
+// has no UUID dependency of its own, so this is the minimal type Parse
+// needs rather than an import of one.
+type UUID [16]byte
+
+// String renders u in canonical 8-4-4-4-12 hex form.
+func (u UUID) String() string {
+	var buf [36]byte
+
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:36], u[10:16])
+
+	return string(buf[:])
+}
+
+// uuidRE matches the canonical 8-4-4-4-12 hex UUID representation.
+var uuidRE = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// UUIDParser parses a canonical 8-4-4-4-12 UUID string, returning a UUID.
+type UUIDParser struct{}
+
+// Parse fulfills the ParamParser interface.
+func (UUIDParser) Parse(raw string) (interface{}, error) {
+	if !uuidRE.MatchString(raw) {
+		return nil, fmt.Errorf("%q is not a valid UUID", raw)
+	}
+
+	var u UUID
+	if _, err := hex.Decode(u[:], []byte(strings.ReplaceAll(raw, "-", ""))); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// byteSizeUnits maps alecthomas/units-style suffixes to their multiplier in
+// bytes, binary (KiB/MiB/...) and decimal (KB/MB/...) alike. Order matters:
+// longer suffixes must be checked before the shorter suffixes they end with
+// (e.g. "KiB" before "B"), so this is a slice, not a map.
+var byteSizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ByteSizeParser parses a human byte-size string like "512MB" or "2GiB"
+// into its value in bytes, returned as an int64. See byteSizeUnits for the
+// recognized suffixes.
+type ByteSizeParser struct{}
+
+// Parse fulfills the ParamParser interface.
+func (ByteSizeParser) Parse(raw string) (interface{}, error) {
+	trimmed := strings.TrimSpace(raw)
+
+	for _, u := range byteSizeUnits {
+		if !strings.HasSuffix(trimmed, u.suffix) {
+			continue
+		}
+
+		numStr := strings.TrimSpace(strings.TrimSuffix(trimmed, u.suffix))
+
+		n, err := strconv.ParseFloat(numStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid byte size: %s", raw, err)
+		}
+
+		return int64(n * float64(u.mult)), nil
+	}
+
+	return nil, fmt.Errorf("%q has no recognized byte-size suffix (B, KB/MB/GB/TB or KiB/MiB/GiB/TiB)", raw)
+}
+
+// EnumParser validates a value against a fixed set of allowed tokens,
+// returning it unmodified (as a string) when it matches. See NewEnumParser.
+type EnumParser struct {
+	allowed []string
+}
+
+// NewEnumParser builds an EnumParser that accepts exactly the tokens in
+// allowed.
+func NewEnumParser(allowed ...string) EnumParser {
+	return EnumParser{allowed: allowed}
+}
+
+// Parse fulfills the ParamParser interface.
+func (e EnumParser) Parse(raw string) (interface{}, error) {
+	for _, a := range e.allowed {
+		if raw == a {
+			return raw, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%q is not one of the allowed values: %s", raw, strings.Join(e.allowed, ", "))
+}
@@ -0,0 +1,39 @@
+package hal
+
+import "testing"
+
+// TestPersistentParam covers the chunk5-3 persistent-param feature: a
+// KVParam declared with AddPersistentParam on a root Cmd is resolvable from
+// a matched SubCmdInst regardless of whether it was parsed before or after
+// the subcommand token, and Required is enforced against "found anywhere"
+// in the matched chain rather than a single level.
+func TestPersistentParam(t *testing.T) {
+	root := NewCmd("widget", true)
+	root.AddPersistentParam("room", true)
+	root.AddSubCmd("make")
+
+	// persistent param parsed before the subcommand token
+	res, err := root.Process([]string{"!widget", "--room", "#ops", "make"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.SubCmdInst().GetKVParamInstIfSet("room").MustString(); got != "#ops" {
+		t.Errorf("room (before subcommand) = %q, want %q", got, "#ops")
+	}
+
+	// persistent param parsed after the subcommand token
+	res, err = root.Process([]string{"!widget", "make", "--room", "#ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.SubCmdInst().GetKVParamInstIfSet("room").MustString(); got != "#ops" {
+		t.Errorf("room (after subcommand) = %q, want %q", got, "#ops")
+	}
+
+	// required and missing entirely -> ValidationErrors naming "room"
+	if _, err := root.Process([]string{"!widget", "make"}); err == nil {
+		t.Fatal("expected a required-param error when --room is missing")
+	} else if !containsSubstring([]string{err.Error()}, "room") {
+		t.Errorf("expected the error to name room, got %s", err)
+	}
+}
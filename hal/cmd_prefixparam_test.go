@@ -0,0 +1,42 @@
+package hal
+
+import "testing"
+
+// TestPrefixParamKinds covers the chunk10-2 richer parameter kinds: a
+// literal Prefix (value is what follows the prefix), an AnySuffix/PassFlag
+// (value is the whole matched arg), and a predicate-based PrefixPred.
+func TestPrefixParamKinds(t *testing.T) {
+	root := NewCmd("ghc", false)
+	root.AddPrefixParam("-W", false)
+	root.AddAnySuffixParam("+debug")
+	root.AddPassFlagParam("--verbose")
+	root.AddPrefixPredParam(func(arg string) bool { return len(arg) == 2 && arg[0] == '-' }, false)
+
+	res, err := root.Process([]string{"!ghc", "-Wno-unused", "+debug", "--verbose", "-x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insts := res.ListPrefixParamInsts()
+	if len(insts) != 4 {
+		t.Fatalf("expected 4 matched PrefixParamInsts, got %d: %#v", len(insts), insts)
+	}
+
+	// PrefixPred has no literal prefix, so its PrefixParamInst.key is "" --
+	// only its value (the whole matched arg) identifies what fired.
+	want := map[string]string{
+		"-W":        "no-unused",
+		"+debug":    "+debug",
+		"--verbose": "--verbose",
+		"":          "-x",
+	}
+	got := make(map[string]string, len(insts))
+	for _, pi := range insts {
+		got[pi.key] = pi.value
+	}
+	for key, wantVal := range want {
+		if got[key] != wantVal {
+			t.Errorf("PrefixParamInst for %q = %q, want %q", key, got[key], wantVal)
+		}
+	}
+}
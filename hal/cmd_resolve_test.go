@@ -0,0 +1,75 @@
+package hal
+
+import (
+	"os"
+	"testing"
+)
+
+// TestKVParamInstDefString covers the chunk5-4 layered defaults: an
+// explicit CLI value wins, then FromEnv's variable, then the caller's
+// default. A bare "*" is this package's documented way of asking for the
+// fallback chain explicitly (defStringParam treats it the same as unset)
+// while still producing a real KVParamInst to call DefString on -- there's
+// no accessor that returns one for a param that was never on the command
+// line at all (GetKVParamInst panics, see GetKVParamInstIfSet's doc comment).
+func TestKVParamInstDefString(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("region", false).FromEnv("WIDGET_REGION")
+
+	res, err := root.Process([]string{"!widget", "--region", "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.GetKVParamInst("region").DefString("fallback"); got != "us-east-1" {
+		t.Errorf("DefString with a CLI value = %q, want %q", got, "us-east-1")
+	}
+
+	os.Setenv("WIDGET_REGION", "us-west-2")
+	defer os.Unsetenv("WIDGET_REGION")
+
+	res, err = root.Process([]string{"!widget", "--region", "*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.GetKVParamInst("region").DefString("fallback"); got != "us-west-2" {
+		t.Errorf("DefString with \"*\" but FromEnv set = %q, want %q", got, "us-west-2")
+	}
+
+	os.Unsetenv("WIDGET_REGION")
+	if got := res.GetKVParamInst("region").DefString("fallback"); got != "fallback" {
+		t.Errorf("DefString with neither a real value nor env = %q, want %q", got, "fallback")
+	}
+}
+
+// TestKVParamInstResolve covers Resolve's CLI->env->def fallback (see
+// TestKVParamInstDefString for why "*" stands in for "unset"). evt is left
+// nil throughout, so the FromPref layer is skipped -- it needs a live
+// Prefs backend to exercise meaningfully.
+func TestKVParamInstResolve(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("region", false).FromEnv("WIDGET_REGION")
+
+	res, err := root.Process([]string{"!widget", "--region", "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.GetKVParamInst("region").Resolve(nil, "fallback"); got != "us-east-1" {
+		t.Errorf("Resolve with a CLI value = %q, want %q", got, "us-east-1")
+	}
+
+	os.Setenv("WIDGET_REGION", "us-west-2")
+	defer os.Unsetenv("WIDGET_REGION")
+
+	res, err = root.Process([]string{"!widget", "--region", "*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.GetKVParamInst("region").Resolve(nil, "fallback"); got != "us-west-2" {
+		t.Errorf("Resolve with \"*\" but FromEnv set = %q, want %q", got, "us-west-2")
+	}
+
+	os.Unsetenv("WIDGET_REGION")
+	if got := res.GetKVParamInst("region").Resolve(nil, "fallback"); got != "fallback" {
+		t.Errorf("Resolve with neither a real value, pref, nor env = %q, want %q", got, "fallback")
+	}
+}
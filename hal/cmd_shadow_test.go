@@ -0,0 +1,67 @@
+package hal
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestDuplicateParamWithoutShadow covers the chunk11-3 default: a KVParam
+// appearing more than once without Shadow(true) is a DuplicateParamError.
+func TestDuplicateParamWithoutShadow(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("label", false)
+
+	_, err := root.Process([]string{"!widget", "--label", "a", "--label", "b"})
+	if err == nil {
+		t.Fatal("expected a duplicate-param error")
+	}
+
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("expected a ValidationErrors, got %T: %s", err, err)
+	}
+
+	var dup DuplicateParamError
+	var found bool
+	for _, e := range verrs {
+		if errors.As(e, &dup) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected a DuplicateParamError, got %v", verrs)
+	}
+}
+
+// TestKVParamShadows covers Shadow(true): later occurrences are recorded on
+// HasShadows/Shadows/ValueWithShadows/IntsWithShadows instead of erroring.
+func TestKVParamShadows(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("port", false).Shadow(true)
+
+	res, err := root.Process([]string{"!widget", "--port", "80", "--port", "443", "--port", "8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pi := res.GetKVParamInst("port")
+	if !pi.HasShadows() {
+		t.Fatal("expected HasShadows() to be true")
+	}
+	if len(pi.Shadows()) != 2 {
+		t.Fatalf("expected 2 shadow occurrences, got %d: %#v", len(pi.Shadows()), pi.Shadows())
+	}
+
+	vals := pi.ValueWithShadows()
+	if want := []string{"80", "443", "8080"}; len(vals) != len(want) || vals[0] != want[0] || vals[1] != want[1] || vals[2] != want[2] {
+		t.Errorf("ValueWithShadows() = %v, want %v", vals, want)
+	}
+
+	ints, err := pi.IntsWithShadows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{80, 443, 8080}; len(ints) != len(want) || ints[0] != want[0] || ints[2] != want[2] {
+		t.Errorf("IntsWithShadows() = %v, want %v", ints, want)
+	}
+}
@@ -0,0 +1,73 @@
+package hal
+
+import "testing"
+
+// TestRepeatableKVParam covers the chunk11-1 Repeatable() feature: repeated
+// occurrences of a KVParam are joined with the given delimiter into a
+// single value instead of raising a DuplicateParamError, so Strings/Ints
+// can split them back out.
+func TestRepeatableKVParam(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("tag", false).Repeatable(",")
+
+	res, err := root.Process([]string{"!widget", "--tag", "1", "--tag", "2", "--tag", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pi := res.GetKVParamInst("tag")
+	if got, want := pi.Value(), "1,2,3"; got != want {
+		t.Errorf("Value() = %q, want %q", got, want)
+	}
+
+	ints, err := pi.Ints(",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3}; len(ints) != len(want) || ints[0] != want[0] || ints[1] != want[1] || ints[2] != want[2] {
+		t.Errorf("Ints(\",\") = %v, want %v", ints, want)
+	}
+}
+
+// TestKVParamInstTypedSlices covers Strings/Floats/Bools splitting a single
+// delimited value into a typed slice, and surfacing a conversion error for
+// a token that doesn't parse.
+func TestKVParamInstTypedSlices(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("ratios", false)
+	root.AddKVParam("flags", false)
+	root.AddKVParam("bogus", false)
+
+	res, err := root.Process([]string{"!widget", "--ratios", "1.5:2.25:3", "--flags", "true,false,true", "--bogus", "a,b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strs, err := res.GetKVParamInst("ratios").Strings(":")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.5", "2.25", "3"}; len(strs) != len(want) || strs[0] != want[0] {
+		t.Errorf("Strings(\":\") = %v, want %v", strs, want)
+	}
+
+	floats, err := res.GetKVParamInst("ratios").Floats(":")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1.5, 2.25, 3}; len(floats) != len(want) || floats[1] != want[1] {
+		t.Errorf("Floats(\":\") = %v, want %v", floats, want)
+	}
+
+	bools, err := res.GetKVParamInst("flags").Bools(",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, false, true}; len(bools) != len(want) || bools[1] != want[1] {
+		t.Errorf("Bools(\",\") = %v, want %v", bools, want)
+	}
+
+	if _, err := res.GetKVParamInst("bogus").Ints(","); err == nil {
+		t.Error("expected Ints(\",\") on non-numeric tokens to return an error")
+	}
+}
@@ -1,7 +1,9 @@
 package hal
 
 import (
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -154,3 +156,74 @@ func TestCmd(t *testing.T) {
 		res.SubCmdInst.GetPParamInst(0)
 	*/
 }
+
+// TestExpandShortFlagsCrossSubCmdCollision covers the case where the same
+// short flag character means different things in two sibling subcommands:
+// -f is a KVParam short under "a" and a BoolParam short under "b". Before
+// expandShortFlags scoped its ambiguity check to the subcommand argv is
+// actually invoking, "-fvalue" under "a" silently passed through
+// unexpanded because -f's bool meaning in the unrelated "b" subcommand
+// made the whole tree look ambiguous.
+func TestExpandShortFlagsCrossSubCmdCollision(t *testing.T) {
+	root := NewCmd("widget", true)
+	root.AddSubCmd("a").AddKVParam("file", true).Short('f')
+	root.AddSubCmd("b").AddBoolParam("force", false).Short('f')
+
+	// "a"'s own expansion must not be disabled just because "f" also
+	// happens to be a BoolParam short on the unrelated sibling "b".
+	got := expandShortFlags([]string{"!widget", "a", "-fhello.txt"}, root)
+	want := []string{"!widget", "a", "-f", "hello.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandShortFlags under 'a' = %#v, want %#v", got, want)
+	}
+
+	// and it has to work end to end too.
+	res, err := root.Process([]string{"!widget", "a", "-fhello.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SubCmdToken() != "a" {
+		t.Fatalf("wrong subcommand. Expected %q, got %q", "a", res.SubCmdToken())
+	}
+	sub := res.SubCmdInst()
+	if sub == nil {
+		t.Fatal("SubCmdInst is nil for 'a'")
+	}
+	if got := sub.GetKVParamInst("file").MustString(); got != "hello.txt" {
+		t.Errorf("-fhello.txt should expand to -f hello.txt under 'a', got file=%q", got)
+	}
+
+	// "b"'s own "f" stays a plain BoolParam short and is never treated as
+	// a KVParam short needing its value split off.
+	got = expandShortFlags([]string{"!widget", "b", "-f"}, root)
+	want = []string{"!widget", "b", "-f"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandShortFlags under 'b' = %#v, want %#v", got, want)
+	}
+}
+
+// TestSubCmdPrefixIndexConcurrentBuild covers the chunk10-4 fix: Process is
+// dispatched concurrently per incoming event against the same registered
+// *Cmd tree, so the first AllowAbbrev lookup must not race while lazily
+// building and caching subCmdPrefixIndex.
+func TestSubCmdPrefixIndexConcurrentBuild(t *testing.T) {
+	root := NewCmd("widget", true)
+	root.AllowAbbrev(true)
+	root.AddSubCmd("alpha")
+	root.AddSubCmd("bravo")
+	root.AddSubCmd("charlie")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			root._subCmdPrefixIndex()
+		}()
+	}
+	wg.Wait()
+
+	if idx := root._subCmdPrefixIndex(); len(idx) == 0 {
+		t.Fatal("expected a populated prefix index")
+	}
+}
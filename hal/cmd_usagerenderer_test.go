@@ -0,0 +1,42 @@
+package hal
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestUsageRenderers covers the chunk10-5 UsageRenderer implementations:
+// PlainRenderer's uppercase section headers, MarkdownRenderer's markdown
+// dialect, and TerminalRenderer's ANSI escapes, all rendering the same
+// HelpBlock.
+func TestUsageRenderers(t *testing.T) {
+	root := NewCmd("widget", true)
+	root.AddKVParam("color", true).SetUsage("paint color")
+	root.AddSubCmd("make").SetUsage("make a widget")
+
+	h := root.Help()
+
+	plain := PlainRenderer{}.Render(h)
+	if !strings.Contains(plain, "PARAMETERS") || !strings.Contains(plain, "SUBCOMMANDS") {
+		t.Errorf("PlainRenderer output missing section headers: %q", plain)
+	}
+	if strings.Contains(plain, "*") || strings.Contains(plain, ansiBold) {
+		t.Errorf("PlainRenderer output should be unstyled, got %q", plain)
+	}
+
+	md := MarkdownRenderer{}.Render(h)
+	if !strings.Contains(md, "*PARAMETERS*") || !strings.Contains(md, "*SUBCOMMANDS*") {
+		t.Errorf("MarkdownRenderer output missing bold section headers: %q", md)
+	}
+	if !strings.Contains(md, "`"+paramName("color", 0)+"`") {
+		t.Errorf("MarkdownRenderer output missing backtick-quoted param: %q", md)
+	}
+	if !strings.Contains(md, "`make`") {
+		t.Errorf("MarkdownRenderer output missing backtick-quoted subcommand: %q", md)
+	}
+
+	term := TerminalRenderer{}.Render(h)
+	if !strings.Contains(term, ansiBold) || !strings.Contains(term, ansiCyan) || !strings.Contains(term, ansiReset) {
+		t.Errorf("TerminalRenderer output missing ANSI escapes: %q", term)
+	}
+}
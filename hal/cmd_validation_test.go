@@ -0,0 +1,59 @@
+package hal
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestValidationErrors covers the chunk5-2 Required/ValidRE enforcement:
+// Process aggregates every problem with argv into a single ValidationErrors
+// instead of stopping at the first one, and each offending param surfaces
+// as its own RequiredParamNotFound/InvalidParamValue.
+func TestValidationErrors(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("color", true).SetValidRE(`^(red|blue)$`)
+	root.AddKVParam("size", true)
+
+	_, err := root.Process([]string{"!widget", "--color", "green"})
+	if err == nil {
+		t.Fatal("expected a validation error")
+	}
+
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("expected a ValidationErrors, got %T: %s", err, err)
+	}
+
+	var invalid InvalidParamValue
+	var missing RequiredParamNotFound
+	var sawInvalid, sawMissing bool
+	for _, e := range verrs {
+		if errors.As(e, &invalid) {
+			sawInvalid = true
+		}
+		if errors.As(e, &missing) {
+			sawMissing = true
+		}
+	}
+	if !sawInvalid {
+		t.Errorf("expected an InvalidParamValue for color, got %v", verrs)
+	}
+	if !sawMissing {
+		t.Errorf("expected a RequiredParamNotFound for size, got %v", verrs)
+	}
+
+	if _, err := root.Process([]string{"!widget", "--color", "red", "--size", "large"}); err != nil {
+		t.Fatalf("expected valid argv to pass, got %s", err)
+	}
+}
+
+// TestValidRENotEnforcedWhenEmpty covers checkValidRE's documented
+// short-circuit: an unset ValidRE always passes, regardless of value.
+func TestValidRENotEnforcedWhenEmpty(t *testing.T) {
+	root := NewCmd("widget", false)
+	root.AddKVParam("color", false)
+
+	if _, err := root.Process([]string{"!widget", "--color", "anything at all"}); err != nil {
+		t.Fatalf("expected no validation error with no ValidRE set, got %s", err)
+	}
+}
@@ -0,0 +1,41 @@
+package hal
+
+import "testing"
+
+// TestValueMapper covers the chunk11-2 ValueMapper hook: a mapper set on a
+// Cmd transforms every KVParam value attached under it before it's stored,
+// a subcommand inherits its parent's mapper unless it sets its own, and a
+// Cmd with no mapper in its chain falls back to the identity function.
+func TestValueMapper(t *testing.T) {
+	expand := map[string]string{
+		"$TOKEN": "s3cr3t",
+	}
+	mapper := func(s string) string {
+		if v, ok := expand[s]; ok {
+			return v
+		}
+		return s
+	}
+
+	root := NewCmd("deploy", true)
+	root.SetValueMapper(mapper)
+	root.AddSubCmd("to").AddKVParam("token", false)
+
+	res, err := root.Process([]string{"!deploy", "to", "--token", "$TOKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.SubCmdInst().GetKVParamInst("token").MustString(); got != "s3cr3t" {
+		t.Errorf("inherited ValueMapper: token = %q, want %q", got, "s3cr3t")
+	}
+
+	plain := NewCmd("noop", false)
+	plain.AddKVParam("token", false)
+	res, err = plain.Process([]string{"!noop", "--token", "$TOKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.GetKVParamInst("token").MustString(); got != "$TOKEN" {
+		t.Errorf("no mapper set: token = %q, want the raw value %q", got, "$TOKEN")
+	}
+}
@@ -0,0 +1,56 @@
+package hal
+
+import "sync"
+
+// cmdRegistry is the singleton mapping a root Cmd's token (e.g. "pref") to
+// the Cmd tree itself, so generic tooling - e.g. example/repl's readline
+// tab-completion - can enumerate every plugin's subcommands without each
+// plugin wiring up completion by hand. It mirrors interactionRegistry's
+// shape (mutex-guarded map, sync.Once init).
+type cmdRegistry struct {
+	cmds map[string]*Cmd
+	mut  sync.Mutex
+	init sync.Once
+}
+
+var cmdRegSingleton cmdRegistry
+
+// CmdRegistry returns the process-wide Cmd registry.
+func CmdRegistry() *cmdRegistry {
+	cmdRegSingleton.init.Do(func() {
+		cmdRegSingleton.cmds = make(map[string]*Cmd)
+	})
+
+	return &cmdRegSingleton
+}
+
+// Register adds cmd to the registry, keyed by its own Token(). Plugins
+// that build a hal.Cmd in init() should call this alongside it.
+func (r *cmdRegistry) Register(cmd *Cmd) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.cmds[cmd.Token()] = cmd
+}
+
+// Get returns the root Cmd registered under token, if any.
+func (r *cmdRegistry) Get(token string) (*Cmd, bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	cmd, exists := r.cmds[token]
+	return cmd, exists
+}
+
+// List returns every registered root Cmd.
+func (r *cmdRegistry) List() []*Cmd {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	out := make([]*Cmd, 0, len(r.cmds))
+	for _, cmd := range r.cmds {
+		out = append(out, cmd)
+	}
+
+	return out
+}
@@ -0,0 +1,68 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Credentials carries optional per-user overrides for a single Evt -- e.g.
+// a Pagerduty user token a broker fetched from hal.Secrets() for the
+// sender's Slack user id -- so a plugin can prefer it over its shared,
+// bot-wide credential without every call site needing to know how it got
+// there. It's nil on most events; a missing key should be treated the
+// same as an empty bag, which is why Get is defined on the value rather
+// than requiring callers to nil-check first.
+type Credentials map[string]string
+
+// Get returns the value stored under name, or "" if c is nil or has
+// nothing under that name.
+func (c Credentials) Get(name string) string {
+	if c == nil {
+		return ""
+	}
+
+	return c[name]
+}
+
+// Fingerprint returns a short, stable digest of c's contents, for code
+// like pagerduty's getOncallCache that caches data fetched using a
+// credential and needs a cache key that can't be shared across different
+// users/tokens. An empty Credentials fingerprints to "", so a cache key
+// built as base+fingerprint is unchanged from before Credentials existed
+// whenever no per-user override is in play.
+func (c Credentials) Fingerprint() string {
+	if len(c) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, c[k])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,133 @@
+package hal
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// default deadline applied to a plugin dispatch when the "plugin-timeout"
+// pref hasn't been set for the instance/room/broker.
+const defaultPluginTimeout = 30 * time.Second
+
+// dispatchRegistry tracks the cancel func for every in-flight plugin
+// dispatch so "!cancel <plugin>" can reach in and stop it.
+type dispatchRegistry struct {
+	mut     sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+var dispatches dispatchRegistry
+
+func init() {
+	dispatches.cancels = make(map[string]context.CancelFunc)
+}
+
+// pluginTimeout reads the "plugin-timeout" pref for the instance, falling
+// back to defaultPluginTimeout when it's unset or unparseable.
+func pluginTimeout(inst *Instance) time.Duration {
+	pref := GetPref("", inst.BrokerName(), inst.ChannelId, inst.Plugin.Name, "plugin-timeout", "")
+	if pref.Value == "" {
+		return defaultPluginTimeout
+	}
+
+	d, err := time.ParseDuration(pref.Value)
+	if err != nil {
+		log.Printf("hal: invalid plugin-timeout pref %q for plugin %q: %s", pref.Value, inst.Plugin.Name, err)
+		return defaultPluginTimeout
+	}
+
+	return d
+}
+
+// dispatchKey identifies one in-flight dispatch for !cancel purposes.
+// Multiple concurrent dispatches of the same plugin share a key, so
+// cancelling by plugin name cancels all of its current work, which matches
+// "!cancel <plugin>" rather than a per-message handle the user can't see.
+func dispatchKey(pluginName string) string {
+	return pluginName
+}
+
+// dispatchWithContext derives a per-dispatch context with a deadline from
+// the plugin-timeout pref, registers its cancel func so "!cancel <plugin>"
+// can reach it, and invokes fn. If fn panics it is recovered by the caller
+// (RouterCTX.dispatch already does this for the whole middleware chain).
+func dispatchWithContext(inst *Instance, fn func(context.Context)) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout(inst))
+
+	key := dispatchKey(inst.Plugin.Name)
+	dispatches.mut.Lock()
+	dispatches.cancels[key] = cancel
+	dispatches.mut.Unlock()
+
+	defer func() {
+		dispatches.mut.Lock()
+		delete(dispatches.cancels, key)
+		dispatches.mut.Unlock()
+		cancel()
+	}()
+
+	fn(ctx)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("hal: plugin %q timed out after %s", inst.Plugin.Name, pluginTimeout(inst))
+		Events().Publish(PluginEvent{Type: BrokerMessageDispatched, Plugin: inst.Plugin, Instance: inst, Broker: inst.BrokerName()})
+	}
+}
+
+// CancelPlugin cancels the context of any in-flight dispatch(es) for the
+// named plugin, used to implement "!cancel <plugin>". Returns false if
+// nothing was in flight for that plugin.
+func CancelPlugin(name string) bool {
+	dispatches.mut.Lock()
+	defer dispatches.mut.Unlock()
+
+	cancel, exists := dispatches.cancels[dispatchKey(name)]
+	if !exists {
+		return false
+	}
+
+	cancel()
+	delete(dispatches.cancels, dispatchKey(name))
+	return true
+}
+
+// handleCancelCommand implements "!cancel <plugin>" for the router. Returns
+// true if it handled the event (whether or not a matching dispatch existed).
+func handleCancelCommand(evt *Evt) bool {
+	argv := evt.BodyAsArgv()
+	if len(argv) < 2 || argv[0] != "!cancel" {
+		return false
+	}
+
+	name := argv[1]
+	if CancelPlugin(name) {
+		evt.Replyf("cancelled in-flight work for plugin %q", name)
+	} else {
+		evt.Replyf("no in-flight work found for plugin %q", name)
+	}
+
+	return true
+}
+
+// CtxEvt bundles a Context with an Evt for plugins that opt into
+// cancellable, deadline-aware dispatch via Plugin.CtxFunc.
+type CtxEvt struct {
+	Ctx context.Context
+	Evt Evt
+}
+
+// parseTimeoutArg is a small helper for plugins threading a user-supplied
+// "--timeout 5s" style argument onto a context derived from evt handling.
+// A bare integer is taken as seconds; anything else goes to
+// time.ParseDuration, so unlike durationParam in cmd.go it does not accept
+// "1d"/"1w" shorthand.
+func parseTimeoutArg(val string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(val); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+
+	return time.ParseDuration(val)
+}
@@ -0,0 +1,336 @@
+package hal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// dirNode is a single node in the directory graph: an id/type pair with a
+// bag of string attributes (e.g. pd-user "atobey" => {email: "...", ...}).
+type dirNode struct {
+	id    string
+	typ   string
+	attrs map[string]string
+}
+
+// dirEdge is a directed edge between two nodes. label is not set by PutEdge
+// or Put; an edge Put creates from an attribute has that attribute's key
+// (e.g. "email", "pd-team-id") as its target node type.
+type dirEdge struct {
+	fromId, fromTyp string
+	toId, toTyp     string
+	label           string
+}
+
+// directory is the singleton graph store backing hal.Directory(). Ingesters
+// like pagerduty populate it with typed nodes/edges; the directory plugin
+// and anything else that wants to traverse relationships reads from it.
+type directory struct {
+	nodes map[string]*dirNode // keyed by typ+"/"+id
+	edges []*dirEdge
+	mut   sync.Mutex
+	init  sync.Once
+}
+
+var directorySingleton directory
+
+// Directory returns the process-wide directory graph singleton.
+func Directory() *directory {
+	directorySingleton.init.Do(func() {
+		directorySingleton.nodes = make(map[string]*dirNode)
+		directorySingleton.edges = make([]*dirEdge, 0)
+	})
+
+	return &directorySingleton
+}
+
+func nodeKey(id, typ string) string {
+	return typ + "/" + id
+}
+
+// PutNode inserts or updates a node, leaving any existing attributes intact.
+func (d *directory) PutNode(id, typ string) error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	key := nodeKey(id, typ)
+	if _, exists := d.nodes[key]; !exists {
+		d.nodes[key] = &dirNode{id: id, typ: typ, attrs: make(map[string]string)}
+	}
+
+	return nil
+}
+
+// PutEdge inserts a directed edge between two (possibly not-yet-existing)
+// nodes, creating the endpoints if necessary.
+func (d *directory) PutEdge(fromId, fromTyp, toId, toTyp string) error {
+	d.PutNode(fromId, fromTyp)
+	d.PutNode(toId, toTyp)
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.edges = append(d.edges, &dirEdge{
+		fromId: fromId, fromTyp: fromTyp,
+		toId: toId, toTyp: toTyp,
+	})
+
+	return nil
+}
+
+// Put upserts a node's attributes and, for each key named in edgeAttrs that
+// is present in attrs, adds an edge from this node to a node of the same
+// type as the attribute key, keyed by the attribute's value. This is how
+// ingesters like pagerduty expose e.g. "email" or "pd-team-id" as
+// traversable edges without a separate PutEdge call per attribute.
+func (d *directory) Put(id, typ string, attrs map[string]string, edgeAttrs []string) error {
+	d.mut.Lock()
+	key := nodeKey(id, typ)
+	node, exists := d.nodes[key]
+	if !exists {
+		node = &dirNode{id: id, typ: typ, attrs: make(map[string]string)}
+		d.nodes[key] = node
+	}
+	for k, v := range attrs {
+		node.attrs[k] = v
+	}
+	d.mut.Unlock()
+
+	for _, ea := range edgeAttrs {
+		if val, exists := attrs[ea]; exists && val != "" {
+			d.PutEdge(id, typ, val, ea)
+		}
+	}
+
+	return nil
+}
+
+// RemoveNode deletes a node and any edges that reference it. Ingesters use
+// this for tombstone GC: once a full refresh completes, anything that was
+// not re-Put()/PutNode()'d this pass is gone upstream and should be dropped.
+func (d *directory) RemoveNode(id, typ string) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	delete(d.nodes, nodeKey(id, typ))
+
+	kept := d.edges[:0]
+	for _, e := range d.edges {
+		if (e.fromId == id && e.fromTyp == typ) || (e.toId == id && e.toTyp == typ) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	d.edges = kept
+}
+
+// NodesOfType returns a snapshot of every node of the given type, used by
+// ingesters to diff "what's here now" against "what did we just fetch" for
+// tombstone GC.
+func (d *directory) NodesOfType(typ string) []DirNode {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	out := make([]DirNode, 0)
+	for _, node := range d.nodes {
+		if node.typ != typ {
+			continue
+		}
+
+		attrs := make(map[string]string, len(node.attrs))
+		for k, v := range node.attrs {
+			attrs[k] = v
+		}
+
+		out = append(out, DirNode{Id: node.id, Typ: node.typ, Attrs: attrs})
+	}
+
+	return out
+}
+
+// GetNode returns the attributes for a node, or nil if it doesn't exist.
+func (d *directory) GetNode(id, typ string) map[string]string {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if node, exists := d.nodes[nodeKey(id, typ)]; exists {
+		out := make(map[string]string, len(node.attrs))
+		for k, v := range node.attrs {
+			out[k] = v
+		}
+		return out
+	}
+
+	return nil
+}
+
+// DirNode is a node returned from a Query, with its id/type and attributes.
+type DirNode struct {
+	Id    string
+	Typ   string
+	Attrs map[string]string
+}
+
+// DirQuery is a builder for BFS traversals of the directory graph, following
+// the fluent style used elsewhere in hal (e.g. Cmd/Param builders):
+//   Directory().Query("atobey", "pd-user").Follow("pd-team").Where("pd-team", "sre").Nodes()
+// Also parseable from the text DSL: "pd-user:atobey -> pd-team -> pd-service"
+type DirQuery struct {
+	d         *directory
+	startId   string
+	startTyp  string
+	hops      []string // edge/node types to follow, in order
+	wheres    map[string]string
+	maxDepth  int
+}
+
+const defaultMaxDepth = 10
+
+// Query starts a traversal from the node identified by (start, typ).
+func (d *directory) Query(start, typ string) *DirQuery {
+	return &DirQuery{
+		d:        d,
+		startId:  start,
+		startTyp: typ,
+		hops:     make([]string, 0),
+		wheres:   make(map[string]string),
+		maxDepth: defaultMaxDepth,
+	}
+}
+
+// Follow adds a hop: traverse any edge leading to a node of edgeType.
+func (q *DirQuery) Follow(edgeType string) *DirQuery {
+	q.hops = append(q.hops, edgeType)
+	return q
+}
+
+// Where filters the final result set to nodes whose attr equals value.
+func (q *DirQuery) Where(attr, value string) *DirQuery {
+	q.wheres[attr] = value
+	return q
+}
+
+// MaxDepth overrides the default BFS depth limit (10 hops).
+func (q *DirQuery) MaxDepth(n int) *DirQuery {
+	q.maxDepth = n
+	return q
+}
+
+// Nodes executes the BFS traversal and returns the matching nodes.
+func (q *DirQuery) Nodes() []DirNode {
+	q.d.mut.Lock()
+	defer q.d.mut.Unlock()
+
+	frontier := []string{nodeKey(q.startId, q.startTyp)}
+	visited := map[string]bool{frontier[0]: true}
+
+	for depth, wantTyp := range q.hops {
+		if depth >= q.maxDepth {
+			break
+		}
+
+		next := make([]string, 0)
+		nextSeen := make(map[string]bool)
+
+		for _, key := range frontier {
+			for _, e := range q.d.edges {
+				var candidate string
+
+				if nodeKey(e.fromId, e.fromTyp) == key && e.toTyp == wantTyp {
+					candidate = nodeKey(e.toId, e.toTyp)
+				} else if nodeKey(e.toId, e.toTyp) == key && e.fromTyp == wantTyp {
+					candidate = nodeKey(e.fromId, e.fromTyp)
+				} else {
+					continue
+				}
+
+				if visited[candidate] || nextSeen[candidate] {
+					continue // cycle detection: never revisit a node
+				}
+
+				nextSeen[candidate] = true
+				next = append(next, candidate)
+			}
+		}
+
+		for k := range nextSeen {
+			visited[k] = true
+		}
+
+		frontier = next
+	}
+
+	out := make([]DirNode, 0, len(frontier))
+	for _, key := range frontier {
+		node, exists := q.d.nodes[key]
+		if !exists {
+			continue
+		}
+
+		if !matchesWhere(node, q.wheres) {
+			continue
+		}
+
+		attrs := make(map[string]string, len(node.attrs))
+		for k, v := range node.attrs {
+			attrs[k] = v
+		}
+
+		out = append(out, DirNode{Id: node.id, Typ: node.typ, Attrs: attrs})
+	}
+
+	return out
+}
+
+func matchesWhere(node *dirNode, wheres map[string]string) bool {
+	for attr, val := range wheres {
+		if node.attrs[attr] != val {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseDirDSL parses the small text DSL used by the directory plugin, e.g.
+// "pd-user:atobey -> pd-team -> pd-service", into a DirQuery against d.
+// The first segment must be "typ:id"; subsequent segments are hop types.
+func (d *directory) ParseDirDSL(dsl string) (*DirQuery, error) {
+	segments := splitDSL(dsl)
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty directory query")
+	}
+
+	startTyp, startId, err := splitTypId(segments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	q := d.Query(startId, startTyp)
+	for _, hop := range segments[1:] {
+		q.Follow(hop)
+	}
+
+	return q, nil
+}
+
+func splitDSL(dsl string) []string {
+	out := make([]string, 0)
+	for _, part := range strings.Split(dsl, "->") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func splitTypId(segment string) (typ, id string, err error) {
+	parts := strings.SplitN(segment, ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("expected typ:id, got %q", segment)
+	}
+
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
@@ -0,0 +1,43 @@
+package hal
+
+import (
+	"testing"
+)
+
+func TestDirectoryQueryTraversal(t *testing.T) {
+	d := Directory()
+
+	d.Put("atobey", "pd-user", map[string]string{"email": "atobey@example.com"}, []string{"email"})
+	d.PutEdge("sre", "pd-team", "atobey", "pd-user")
+	d.PutEdge("sre", "pd-team", "widgets", "pd-service")
+
+	nodes := d.Query("atobey", "pd-user").Follow("pd-team").Nodes()
+	if len(nodes) != 1 || nodes[0].Id != "sre" {
+		t.Fatalf("expected to find the sre team, got %+v", nodes)
+	}
+
+	services := d.Query("atobey", "pd-user").Follow("pd-team").Follow("pd-service").Nodes()
+	if len(services) != 1 || services[0].Id != "widgets" {
+		t.Fatalf("expected to find the widgets service, got %+v", services)
+	}
+
+	byEmail := d.Query("atobey@example.com", "email").Follow("pd-user").Nodes()
+	if len(byEmail) != 1 || byEmail[0].Id != "atobey" {
+		t.Fatalf("expected to find atobey by email, got %+v", byEmail)
+	}
+}
+
+func TestDirectoryParseDSL(t *testing.T) {
+	d := Directory()
+	d.PutEdge("sre", "pd-team", "widgets", "pd-service")
+
+	q, err := d.ParseDirDSL("pd-team:sre -> pd-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := q.Nodes()
+	if len(nodes) != 1 || nodes[0].Id != "widgets" {
+		t.Fatalf("expected to find the widgets service via DSL, got %+v", nodes)
+	}
+}
@@ -13,34 +13,44 @@ import (
 // isn't copied, at a minimum.
 // The original event should usually be attached to the Original
 type Evt struct {
-	Body     string      `json:"body"`    // body of the event, regardless of source
-	Room     string      `json:"room"`    // the room where the event originated
-	RoomId   string      `json:"room_id"` // the room id from the source broker
-	User     string      `json:"user"`    // the username that created the event
-	UserId   string      `json:"user_id"` // the user id from the source broker
-	Time     time.Time   `json:"time"`    // timestamp of the event
-	Brokers  Brokers     `json:"brokers"` // the stack of brokers the event has passed through
-	Original interface{} // the original message container (e.g. slack.MessageEvent)
-	instance *Instance   // used by the broker to provide plugin instance metadata
+	Body            string      `json:"body"`                 // body of the event, regardless of source
+	Room            string      `json:"room"`                 // the room where the event originated
+	RoomId          string      `json:"room_id"`              // the room id from the source broker
+	User            string      `json:"user"`                 // the username that created the event
+	UserId          string      `json:"user_id"`              // the user id from the source broker
+	Time            time.Time   `json:"time"`                 // timestamp of the event
+	Brokers         Brokers     `json:"brokers"`              // the stack of brokers the event has passed through
+	ThreadID        string      `json:"thread_id"`            // broker-native id of the thread this event belongs to, if any
+	ThreadBroadcast bool        `json:"thread_broadcast"`     // also surface this reply outside the thread (e.g. Slack's reply_broadcast)
+	IsJoin          bool        `json:"is_join,omitempty"`    // synthetic event: User/RoomId joined (e.g. MUC presence), not a chat message
+	IsPart          bool        `json:"is_part,omitempty"`    // synthetic event: User/RoomId left, not a chat message
+	AvatarURL       string      `json:"avatar_url,omitempty"` // sender's avatar, if the broker has one cached (e.g. brokers/hipchat's vCard cache)
+	Credentials     Credentials `json:"-"`                    // optional per-user credential overrides; never persisted/logged, see Credentials
+	Original        interface{} // the original message container (e.g. slack.MessageEvent)
+	instance        *Instance   // used by the broker to provide plugin instance metadata
 }
 
 // Clone() returns a copy of the event with the same broker/room/user
 // and a current timestamp. Body and Original will be empty.
 func (e *Evt) Clone() Evt {
 	out := Evt{
-		Room:    e.Room,
-		RoomId:  e.RoomId,
-		User:    e.User,
-		UserId:  e.UserId,
-		Time:    time.Now(),
-		Brokers: e.Brokers.Clone(), // TODO: consider reverting this back to just a single Broker:
+		Room:        e.Room,
+		RoomId:      e.RoomId,
+		User:        e.User,
+		UserId:      e.UserId,
+		Time:        time.Now(),
+		Brokers:     e.Brokers.Clone(), // TODO: consider reverting this back to just a single Broker:
+		ThreadID:    e.ThreadID,        // replies default to staying in the source thread
+		Credentials: e.Credentials,     // preserve the sender's credential override across Reply/etc.
 	}
 
 	return out
 }
 
 // Reply is a helper that crafts a new event from the provided string
-// and initiates the reply on the broker attached to the event.
+// and initiates the reply on the broker attached to the event. If the
+// source event was part of a thread, the reply stays in that thread -
+// see Clone().
 func (e *Evt) Reply(msg string) {
 	out := e.Clone()
 	out.Body = msg
@@ -53,6 +63,25 @@ func (e *Evt) Replyf(msg string, a ...interface{}) {
 	e.Reply(fmt.Sprintf(msg, a...))
 }
 
+// ReplyBroadcast is like Reply but, when the source event was in a thread,
+// asks the broker to also surface the reply outside the thread (Slack's
+// reply_broadcast). On brokers without threads this is equivalent to Reply.
+func (e *Evt) ReplyBroadcast(msg string) {
+	out := e.Clone()
+	out.Body = msg
+	out.ThreadBroadcast = true
+	e.Brokers.Last().Send(out)
+}
+
+// SendInThread is like Reply but targets a specific thread rather than
+// defaulting to the source event's thread (or top-level).
+func (e *Evt) SendInThread(msg, threadTs string) {
+	out := e.Clone()
+	out.Body = msg
+	out.ThreadID = threadTs
+	e.Brokers.Last().Send(out)
+}
+
 // BrokerName returns the text name of current broker.
 func (e *Evt) BrokerName() string {
 	return e.Brokers.Last().Name()
@@ -0,0 +1,295 @@
+package hal
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies the kind of PluginEvent on the bus.
+type EventType int
+
+const (
+	PluginRegistered EventType = iota
+	InstanceRegistered
+	InstanceUnregistered
+	InstanceLoadedFromDB
+	InstanceSaved
+	BrokerMessageDispatched
+	SettingsChanged
+	PluginError
+	PluginReplaced
+	PluginEnabled
+	PluginDisabled
+	PluginUpgraded
+	PluginRemoved
+	InstanceAttached
+	InstanceDetached
+)
+
+func (t EventType) String() string {
+	switch t {
+	case PluginRegistered:
+		return "PluginRegistered"
+	case InstanceRegistered:
+		return "InstanceRegistered"
+	case InstanceUnregistered:
+		return "InstanceUnregistered"
+	case InstanceLoadedFromDB:
+		return "InstanceLoadedFromDB"
+	case InstanceSaved:
+		return "InstanceSaved"
+	case BrokerMessageDispatched:
+		return "BrokerMessageDispatched"
+	case SettingsChanged:
+		return "SettingsChanged"
+	case PluginError:
+		return "PluginError"
+	case PluginReplaced:
+		return "PluginReplaced"
+	case PluginEnabled:
+		return "PluginEnabled"
+	case PluginDisabled:
+		return "PluginDisabled"
+	case PluginUpgraded:
+		return "PluginUpgraded"
+	case PluginRemoved:
+		return "PluginRemoved"
+	case InstanceAttached:
+		return "InstanceAttached"
+	case InstanceDetached:
+		return "InstanceDetached"
+	default:
+		return "Unknown"
+	}
+}
+
+// PluginEvent is published to the event bus whenever something interesting
+// happens to a plugin, instance, or broker dispatch. Plugin/Instance/Broker
+// are filled in as appropriate for the EventType and may be nil. Err is
+// only set for PluginError. ActorUser/ActorRoom identify who triggered the
+// event and from where, when it was the result of a user-issued command
+// (e.g. pluginmgr's attach/detach/install/upgrade) rather than something
+// hal did on its own (e.g. InstanceLoadedFromDB at startup) -- both are
+// blank in the latter case. StartPluginEventAuditing records ActorUser/
+// ActorRoom alongside every event so operators can reconstruct who did what.
+type PluginEvent struct {
+	Type      EventType
+	Time      time.Time
+	Plugin    *Plugin
+	Instance  *Instance
+	Broker    string
+	Err       error
+	ActorUser string
+	ActorRoom string
+}
+
+// EventFilter decides whether a subscriber is interested in evt. A nil
+// filter matches everything.
+type EventFilter func(evt PluginEvent) bool
+
+// eventSubscriberBufSize is the per-subscriber channel depth. Subscribers
+// that fall behind have their oldest buffered event dropped to make room,
+// so a slow reader sees a gap in history rather than stalling publishers.
+const eventSubscriberBufSize = 100
+
+// eventLogSize is how many recently-published events EventLog() keeps
+// around for callers that want recent history without having subscribed
+// in time to catch it live.
+const eventLogSize = 200
+
+type eventSubscriber struct {
+	ch     chan PluginEvent
+	filter EventFilter
+}
+
+type eventBus struct {
+	mut     sync.Mutex
+	init    sync.Once
+	subs    map[string]*eventSubscriber
+	dropped map[string]uint64
+	log     []PluginEvent // ring buffer, oldest first
+	logPos  int
+	logFull bool
+}
+
+var eventBusSingleton eventBus
+
+// Events returns the process-wide plugin lifecycle event bus singleton.
+func Events() *eventBus {
+	eventBusSingleton.init.Do(func() {
+		eventBusSingleton.subs = make(map[string]*eventSubscriber)
+		eventBusSingleton.dropped = make(map[string]uint64)
+		eventBusSingleton.log = make([]PluginEvent, eventLogSize)
+	})
+
+	return &eventBusSingleton
+}
+
+// Subscribe registers a named subscriber and returns a buffered channel of
+// every event published. Subscribing twice with the same name replaces the
+// previous channel. The name is used for logging slow-subscriber drops.
+func (eb *eventBus) Subscribe(name string) <-chan PluginEvent {
+	return eb.SubscribeFiltered(name, nil)
+}
+
+// SubscribeFiltered is like Subscribe but only delivers events for which
+// filter returns true, e.g. Events().SubscribeFiltered("health-check",
+// func(e PluginEvent) bool { return e.Type == PluginError }).
+func (eb *eventBus) SubscribeFiltered(name string, filter EventFilter) <-chan PluginEvent {
+	eb.mut.Lock()
+	defer eb.mut.Unlock()
+
+	ch := make(chan PluginEvent, eventSubscriberBufSize)
+	eb.subs[name] = &eventSubscriber{ch: ch, filter: filter}
+	eb.dropped[name] = 0
+
+	return ch
+}
+
+// anonSubscriberSeq hands out unique subscriber names to
+// SubscribePluginEvents callers that have no natural name of their own and
+// don't intend to Unsubscribe by name -- e.g. a plugin that just wants to
+// react to events for as long as it's registered.
+var anonSubscriberSeq uint64
+
+// SubscribePluginEvents is a convenience wrapper around
+// Events().SubscribeFiltered for callers that want a stream of plugin
+// lifecycle events (e.g. the pagerduty plugin reacting to PluginUpgraded,
+// or an HTTP handler streaming events to a dashboard) without managing a
+// subscriber name themselves. filter may be nil to receive every event.
+func SubscribePluginEvents(filter EventFilter) <-chan PluginEvent {
+	name := fmt.Sprintf("anon-%d", atomic.AddUint64(&anonSubscriberSeq, 1))
+	return Events().SubscribeFiltered(name, filter)
+}
+
+// Unsubscribe removes a subscriber and closes its channel.
+func (eb *eventBus) Unsubscribe(name string) {
+	eb.mut.Lock()
+	defer eb.mut.Unlock()
+
+	if sub, exists := eb.subs[name]; exists {
+		close(sub.ch)
+		delete(eb.subs, name)
+		delete(eb.dropped, name)
+	}
+}
+
+// Dropped returns the number of events dropped for a subscriber because its
+// channel was full.
+func (eb *eventBus) Dropped(name string) uint64 {
+	eb.mut.Lock()
+	defer eb.mut.Unlock()
+
+	return eb.dropped[name]
+}
+
+// Publish records evt in the recent-history ring buffer (see EventLog) and
+// fans it out to every subscriber whose filter matches. A subscriber whose
+// channel is full has its oldest buffered event dropped to make room for
+// evt, rather than blocking the publisher or silently discarding evt.
+func (eb *eventBus) Publish(evt PluginEvent) {
+	eb.mut.Lock()
+	defer eb.mut.Unlock()
+
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+
+	eb.log[eb.logPos] = evt
+	eb.logPos++
+	if eb.logPos == len(eb.log) {
+		eb.logPos = 0
+		eb.logFull = true
+	}
+
+	for name, sub := range eb.subs {
+		if sub.filter != nil && !sub.filter(evt) {
+			continue
+		}
+
+		select {
+		case sub.ch <- evt:
+		default:
+			// drop-oldest: make room rather than lose the new event
+			select {
+			case <-sub.ch:
+			default:
+			}
+
+			select {
+			case sub.ch <- evt:
+			default:
+			}
+
+			eb.dropped[name]++
+			log.Printf("hal.Events(): dropped oldest event for slow subscriber %q (%d dropped total)",
+				name, eb.dropped[name])
+		}
+	}
+}
+
+// EventLog returns a snapshot of the most recently published events, oldest
+// first, up to eventLogSize entries. It's independent of Subscribe, so a
+// caller that starts watching late (e.g. a freshly-loaded health-check
+// plugin) can still see recent history.
+func (eb *eventBus) EventLog() []PluginEvent {
+	eb.mut.Lock()
+	defer eb.mut.Unlock()
+
+	if !eb.logFull {
+		out := make([]PluginEvent, eb.logPos)
+		copy(out, eb.log[:eb.logPos])
+		return out
+	}
+
+	out := make([]PluginEvent, len(eb.log))
+	copy(out, eb.log[eb.logPos:])
+	copy(out[len(eb.log)-eb.logPos:], eb.log[:eb.logPos])
+	return out
+}
+
+// pluginAuditSubscriberName is the fixed Subscribe name used by
+// StartPluginEventAuditing, so calling it twice replaces the previous
+// subscription (see Subscribe) instead of leaking one.
+const pluginAuditSubscriberName = "plugin-event-audit"
+
+// StartPluginEventAuditing subscribes to every PluginEvent and republishes
+// it as an AuditEntry via Audit(), so events like InstanceAttached or
+// PluginUpgraded flow into whatever AuditSinks are configured (see
+// SetAuditSinks/SQLAuditSink) the same way command-dispatch and secret
+// audit entries already do. It's meant to be called once at startup --
+// e.g. alongside pluginmgr.Register() -- and runs for the life of the
+// process.
+func StartPluginEventAuditing() {
+	ch := Events().SubscribeFiltered(pluginAuditSubscriberName, nil)
+
+	go func() {
+		for evt := range ch {
+			target := ""
+			if evt.Plugin != nil {
+				target = evt.Plugin.Name
+			}
+
+			outcome, severity := "ok", SeverityInfo
+			if evt.Err != nil {
+				outcome, severity = "error", SeverityError
+			}
+
+			Audit(AuditEntry{
+				Time:     evt.Time,
+				Source:   "plugin_lifecycle",
+				Plugin:   target,
+				Room:     evt.ActorRoom,
+				Broker:   evt.Broker,
+				User:     evt.ActorUser,
+				Action:   evt.Type.String(),
+				Target:   target,
+				Outcome:  outcome,
+				Severity: severity,
+			})
+		}
+	}()
+}
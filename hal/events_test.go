@@ -0,0 +1,75 @@
+package hal
+
+import (
+	"testing"
+)
+
+func TestEventsSubscribePublish(t *testing.T) {
+	ch := Events().Subscribe("test-events-subscribe-publish")
+	defer Events().Unsubscribe("test-events-subscribe-publish")
+
+	Events().Publish(PluginEvent{Type: PluginRegistered})
+
+	select {
+	case evt := <-ch:
+		if evt.Type != PluginRegistered {
+			t.Errorf("expected PluginRegistered, got %s", evt.Type)
+		}
+	default:
+		t.Error("expected an event to be immediately available on the subscriber channel")
+	}
+}
+
+func TestEventsSlowSubscriberDrop(t *testing.T) {
+	name := "test-events-slow-subscriber"
+	Events().Subscribe(name)
+	defer Events().Unsubscribe(name)
+
+	// fill the buffer and then some, without reading, to force drops
+	for i := 0; i < eventSubscriberBufSize+5; i++ {
+		Events().Publish(PluginEvent{Type: InstanceRegistered})
+	}
+
+	if Events().Dropped(name) == 0 {
+		t.Error("expected some events to be dropped for a subscriber that never reads")
+	}
+}
+
+func TestEventsSubscribeFiltered(t *testing.T) {
+	name := "test-events-subscribe-filtered"
+	ch := Events().SubscribeFiltered(name, func(evt PluginEvent) bool {
+		return evt.Type == PluginError
+	})
+	defer Events().Unsubscribe(name)
+
+	Events().Publish(PluginEvent{Type: PluginRegistered})
+	Events().Publish(PluginEvent{Type: PluginError})
+
+	select {
+	case evt := <-ch:
+		if evt.Type != PluginError {
+			t.Errorf("expected only PluginError to pass the filter, got %s", evt.Type)
+		}
+	default:
+		t.Error("expected the matching event to be immediately available on the subscriber channel")
+	}
+
+	select {
+	case evt := <-ch:
+		t.Errorf("expected the filter to drop PluginRegistered, got %s", evt.Type)
+	default:
+	}
+}
+
+func TestEventsEventLog(t *testing.T) {
+	Events().Publish(PluginEvent{Type: SettingsChanged})
+
+	log := Events().EventLog()
+	if len(log) == 0 {
+		t.Fatal("expected EventLog() to return at least the event just published")
+	}
+
+	if log[len(log)-1].Type != SettingsChanged {
+		t.Errorf("expected the most recent entry to be SettingsChanged, got %s", log[len(log)-1].Type)
+	}
+}
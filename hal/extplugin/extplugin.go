@@ -0,0 +1,64 @@
+// Package extplugin discovers, launches, and supervises out-of-process hal
+// plugins -- executables dropped into a directory that connect back to the
+// bot over the hal/rpc gRPC transport, following the handshake/restart
+// model hashicorp/go-plugin popularized for Terraform providers. hal/rpc
+// (see its package doc) already owns the wire protocol and the bridging of
+// a remote registration into a real hal.Plugin, so Router().Route() never
+// needs to know a given Instance's Func is actually forwarding over a
+// socket; this package is the operational layer on top of it: deciding
+// which binaries in Dir are trusted, launching them with the env vars they
+// need to dial in, and relaunching them if they exit.
+//
+// A supervised binary is a normal hal/rpc client: on startup it reads
+// AddrEnv/PluginNameEnv/ProtocolVersionEnv from its environment, confirms
+// HandshakeCookieKey/HandshakeCookieValue are set (refusing to run
+// otherwise, the same "don't just hang on stdin" check go-plugin's
+// clients do), then rpc.Dial(os.Getenv(AddrEnv)) and rpc.Conn.Register.
+package extplugin
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+const (
+	// HandshakeCookieKey/HandshakeCookieValue are set in every supervised
+	// subprocess's environment so it can tell it was launched by a real
+	// Supervisor and not started by hand (or by something else that
+	// happens to share its argv0) -- mirrors go-plugin's
+	// MagicCookieKey/MagicCookieValue, which exists purely so a
+	// misinvoked binary prints a clear error instead of hanging on stdin.
+	HandshakeCookieKey   = "HAL_PLUGIN_COOKIE"
+	HandshakeCookieValue = "hal-9001-extplugin"
+
+	// ProtocolVersionEnv carries ProtocolVersion to the subprocess so it
+	// can refuse to start against a host speaking an incompatible wire
+	// protocol instead of misbehaving silently.
+	ProtocolVersionEnv = "HAL_PLUGIN_PROTOCOL_VERSION"
+
+	// ProtocolVersion is the handshake/wire protocol version this package
+	// speaks. Bump it if hal/rpc's wire messages ever change
+	// incompatibly.
+	ProtocolVersion = 1
+
+	// AddrEnv is the hal/rpc.Server's listen address (always a Unix
+	// socket path -- see Config.SocketPath) the subprocess should
+	// rpc.Dial.
+	AddrEnv = "HAL_PLUGIN_ADDR"
+
+	// PluginNameEnv is the name the subprocess is expected to pass as
+	// RegisterPluginRequest.PluginName, so a binary with no other
+	// configuration still registers under the name its filename implies.
+	PluginNameEnv = "HAL_PLUGIN_NAME"
+)
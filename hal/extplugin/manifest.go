@@ -0,0 +1,107 @@
+package extplugin
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// manifestSuffix is appended to a plugin binary's path to find its signed
+// manifest, e.g. "plugins/uptime" -> "plugins/uptime.manifest.json".
+const manifestSuffix = ".manifest.json"
+
+// Manifest is the signed-manifest sidecar for an external plugin binary:
+// the operator's public key (one authorized_keys-format line) and a
+// signature over the binary's
+// bytes made with the matching private key. Supervisor.Start consults it
+// in fingerprint mode (see Config.Fingerprints) before ever exec'ing the
+// binary it describes.
+type Manifest struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"` // authorized_keys format
+	Format    string `json:"format"`     // e.g. "ssh-ed25519", the ssh.Signature.Format
+	Signature string `json:"signature"`  // base64 of the ssh.Signature.Blob over the binary's bytes
+}
+
+// loadManifest reads and parses the Manifest sidecar for binaryPath.
+func loadManifest(binaryPath string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(binaryPath + manifestSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	m := Manifest{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("hal/extplugin: parsing manifest for %q: %s", binaryPath, err)
+	}
+
+	return &m, nil
+}
+
+// VerifyManifest checks binaryPath's signed Manifest sidecar: its public
+// key's SHA256 fingerprint (ssh.FingerprintSHA256, the same format
+// shazow/ssh-chat and brokers/sshchat's admin/whitelist lists use) must
+// appear in allowedFingerprints, and its signature must verify over the
+// binary's own bytes -- a fingerprint match alone would only prove the
+// manifest names an allowed key, not that the key's owner actually signed
+// this binary. Returns the verified fingerprint on success.
+func VerifyManifest(binaryPath string, allowedFingerprints []string) (string, error) {
+	m, err := loadManifest(binaryPath)
+	if err != nil {
+		return "", err
+	}
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(m.PublicKey))
+	if err != nil {
+		return "", fmt.Errorf("hal/extplugin: parsing public key in manifest for %q: %s", binaryPath, err)
+	}
+
+	fp := ssh.FingerprintSHA256(pubKey)
+
+	allowed := false
+	for _, want := range allowedFingerprints {
+		if fp == want {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("hal/extplugin: %q is signed by %s, which is not in the allowed fingerprint list", binaryPath, fp)
+	}
+
+	sigBlob, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return "", fmt.Errorf("hal/extplugin: decoding signature for %q: %s", binaryPath, err)
+	}
+
+	binary, err := ioutil.ReadFile(binaryPath)
+	if err != nil {
+		return "", err
+	}
+
+	sig := &ssh.Signature{Format: m.Format, Blob: sigBlob}
+	if err := pubKey.Verify(binary, sig); err != nil {
+		return "", fmt.Errorf("hal/extplugin: signature verification failed for %q: %s", binaryPath, err)
+	}
+
+	return fp, nil
+}
@@ -0,0 +1,249 @@
+package extplugin
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/netflix/hal-9001/hal/rpc"
+)
+
+// RestartBackoff is how long Supervisor waits after a plugin process exits
+// before launching it again. It's a fixed delay rather than exponential
+// backoff: these are operator-managed binaries in a known-good directory,
+// not untrusted network peers, so the failure mode to optimize for is "the
+// binary crash-loops and fills the log", not "a hostile peer floods us".
+var RestartBackoff = 5 * time.Second
+
+// Config configures a Supervisor.
+type Config struct {
+	// Dir is scanned for executable files to launch as plugins (see
+	// Discover). Manifest sidecars (see Manifest) also live here.
+	Dir string
+
+	// SocketPath is where the shared hal/rpc.Server listens for
+	// subprocess connections. Defaults to "hal-extplugin.sock" inside
+	// Dir.
+	SocketPath string
+
+	// Fingerprints, when non-empty, puts the Supervisor in signed-
+	// manifest mode: a binary is only launched if VerifyManifest
+	// succeeds against this list. An empty list launches every
+	// executable regular file in Dir unchecked -- the same trust model
+	// hal already applies to in-process plugins linked into the bot
+	// binary, for operators who don't need the extra gate.
+	Fingerprints []string
+}
+
+// managedProc is the supervisor-side bookkeeping for one launched binary.
+type managedProc struct {
+	path string
+	name string
+}
+
+// Supervisor discovers, launches, and restarts external plugin binaries,
+// bridging them to the router through a shared hal/rpc.Server -- see
+// hal/rpc for the wire protocol and the Plugin/Instance bridging, which
+// this package doesn't duplicate. It does not health-check a running
+// process: a binary is relaunched only after it exits.
+type Supervisor struct {
+	cfg        Config
+	grpcServer *grpc.Server
+	listener   net.Listener
+
+	mut     sync.Mutex
+	procs   map[string]*managedProc // path -> managed subprocess
+	stopped bool
+}
+
+// NewSupervisor creates a Supervisor and starts its shared hal/rpc.Server
+// listening on cfg.SocketPath, but doesn't launch any plugins yet -- call
+// Start for that. Callers that want to observe remote registrations (e.g.
+// for a "!plugin list" admin command) can pass in an *rpc.Server of their
+// own construction by calling NewSupervisorWithServer instead.
+func NewSupervisor(cfg Config) (*Supervisor, error) {
+	return NewSupervisorWithServer(cfg, rpc.NewServer())
+}
+
+// NewSupervisorWithServer is NewSupervisor, but registers srv as the
+// PluginHostServer instead of creating a new one.
+func NewSupervisorWithServer(cfg Config, srv *rpc.Server) (*Supervisor, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("hal/extplugin: Config.Dir is required")
+	}
+
+	if cfg.SocketPath == "" {
+		cfg.SocketPath = filepath.Join(cfg.Dir, "hal-extplugin.sock")
+	}
+
+	// a stale socket left behind by a prior crashed run would otherwise
+	// make Listen fail with "address already in use"
+	os.Remove(cfg.SocketPath)
+
+	lis, err := net.Listen("unix", cfg.SocketPath)
+	if err != nil {
+		return nil, fmt.Errorf("hal/extplugin: listening on %q: %s", cfg.SocketPath, err)
+	}
+
+	grpcServer := grpc.NewServer()
+	rpc.RegisterPluginHostServer(grpcServer, srv)
+
+	s := &Supervisor{
+		cfg:        cfg,
+		grpcServer: grpcServer,
+		listener:   lis,
+		procs:      make(map[string]*managedProc),
+	}
+
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Printf("hal/extplugin: gRPC server on %q stopped: %s", cfg.SocketPath, err)
+		}
+	}()
+
+	return s, nil
+}
+
+// Discover returns the paths of the executable regular files directly
+// inside cfg.Dir, skipping the socket file, manifest sidecars, and
+// anything not independently executable.
+func (s *Supervisor) Discover() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]string, 0, len(entries))
+
+	for _, e := range entries {
+		if e.IsDir() || e.Mode()&0111 == 0 {
+			continue
+		}
+		if strings.HasSuffix(e.Name(), manifestSuffix) {
+			continue
+		}
+
+		path := filepath.Join(s.cfg.Dir, e.Name())
+		if path == s.cfg.SocketPath {
+			continue
+		}
+
+		out = append(out, path)
+	}
+
+	return out, nil
+}
+
+// Start discovers every eligible binary in cfg.Dir (see Discover) and
+// launches each under supervision. In fingerprint mode (Config.Fingerprints
+// non-empty), a binary that fails VerifyManifest is logged and skipped
+// rather than aborting the whole Start call -- one operator's bad plugin
+// shouldn't keep the rest from loading.
+func (s *Supervisor) Start() error {
+	paths, err := s.Discover()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if len(s.cfg.Fingerprints) > 0 {
+			fp, err := VerifyManifest(path, s.cfg.Fingerprints)
+			if err != nil {
+				log.Printf("hal/extplugin: refusing to launch %q: %s", path, err)
+				continue
+			}
+			log.Printf("hal/extplugin: %q verified against fingerprint %s", path, fp)
+		}
+
+		s.launch(path)
+	}
+
+	return nil
+}
+
+// launch starts path under supervision and arranges for it to be
+// relaunched after RestartBackoff if/when it exits, until Stop is called.
+func (s *Supervisor) launch(path string) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	s.mut.Lock()
+	s.procs[path] = &managedProc{path: path, name: name}
+	s.mut.Unlock()
+
+	go s.watch(path, name)
+}
+
+// watch runs path in a loop, restarting it after RestartBackoff each time
+// it exits, until Stop is called.
+func (s *Supervisor) watch(path, name string) {
+	for {
+		s.mut.Lock()
+		stopped := s.stopped
+		s.mut.Unlock()
+		if stopped {
+			return
+		}
+
+		cmd := exec.Command(path)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(),
+			HandshakeCookieKey+"="+HandshakeCookieValue,
+			ProtocolVersionEnv+"="+strconv.Itoa(ProtocolVersion),
+			AddrEnv+"="+s.cfg.SocketPath,
+			PluginNameEnv+"="+name,
+		)
+
+		log.Printf("hal/extplugin: launching %q as plugin %q", path, name)
+
+		if err := cmd.Start(); err != nil {
+			log.Printf("hal/extplugin: failed to start %q: %s", path, err)
+			time.Sleep(RestartBackoff)
+			continue
+		}
+
+		err := cmd.Wait()
+		log.Printf("hal/extplugin: plugin %q (%s) exited: %v -- restarting in %s", name, path, err, RestartBackoff)
+
+		time.Sleep(RestartBackoff)
+	}
+}
+
+// Stop stops accepting new subprocess connections and prevents any
+// further restarts. Already-running subprocesses are left running --
+// Supervisor only manages the handshake/restart loop, not their chat
+// sessions in flight.
+func (s *Supervisor) Stop() error {
+	s.mut.Lock()
+	s.stopped = true
+	s.mut.Unlock()
+
+	s.grpcServer.GracefulStop()
+	return os.Remove(s.cfg.SocketPath)
+}
@@ -0,0 +1,73 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// handleHalCommand implements "!hal leaders" for the router, the same way
+// handleCancelCommand (ctx_dispatch.go) implements "!cancel <plugin>" --
+// a bot-wide admin command with no natural single-plugin owner. Returns
+// true if it handled the event.
+func handleHalCommand(evt *Evt) bool {
+	argv := evt.BodyAsArgv()
+	if len(argv) < 2 || argv[0] != "!hal" {
+		return false
+	}
+
+	switch argv[1] {
+	case "leaders":
+		evt.Reply(formatPeriodicFuncLeaders())
+	default:
+		evt.Replyf("unknown !hal subcommand %q. Try: !hal leaders", argv[1])
+	}
+
+	return true
+}
+
+// formatPeriodicFuncLeaders renders the current leaseholder (if any) of
+// every registered PeriodicFunc, one line each, for "!hal leaders".
+func formatPeriodicFuncLeaders() string {
+	pfs := ListPeriodicFuncs()
+	if len(pfs) == 0 {
+		return "no PeriodicFuncs are registered"
+	}
+
+	sort.Slice(pfs, func(i, j int) bool { return pfs[i].Name < pfs[j].Name })
+
+	buf := bytes.NewBuffer([]byte{})
+	for _, pf := range pfs {
+		if !pf.LeaderOnly {
+			fmt.Fprintf(buf, "%s: not leader-elected (runs on every node)\n", pf.Name)
+			continue
+		}
+
+		holder, err := CurrentHolder(pf.Name)
+		if err != nil {
+			fmt.Fprintf(buf, "%s: could not look up the current leader: %s\n", pf.Name, err)
+		} else if holder == "" {
+			fmt.Fprintf(buf, "%s: no leader currently held\n", pf.Name)
+		} else {
+			fmt.Fprintf(buf, "%s: leader is %q\n", pf.Name, holder)
+		}
+	}
+
+	return buf.String()
+}
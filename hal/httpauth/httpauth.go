@@ -0,0 +1,117 @@
+// Package httpauth issues and validates capability-style bearer tokens for
+// hal-9001's HTTP endpoints (see Require), so an operator can hand out a
+// narrow, revocable URL -- e.g. an on-call dashboard fetching the archive
+// JSON -- without exposing the rest of the HTTP surface. A token is an
+// HMAC-SHA256-signed, base64url-encoded Caveats payload: it carries its
+// own expiry and optional allowlists of methods/paths/rooms/brokers,
+// analogous to Vanadium's ExpiryCaveat/MethodCaveat/PeerBlessingsCaveat
+// model, and Require rejects any request failing one.
+package httpauth
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// SigningKeyKey is the hal.Secrets() key holding the HMAC signing key
+// Issue and Parse sign/verify tokens with. Both fail until it's set, e.g.:
+//
+//	!secrets set --key httpauth.signing_key --value <random string>
+const SigningKeyKey = "httpauth.signing_key"
+
+// Caveats narrows what a token authorizes. Expiry is always enforced; the
+// rest are optional allowlists -- a nil/empty slice means "no
+// restriction" on that dimension. See Require for how each is checked
+// against an incoming request.
+type Caveats struct {
+	Expiry  time.Time `json:"exp"`
+	Methods []string  `json:"methods,omitempty"` // e.g. "GET"; empty means any method
+	Paths   []string  `json:"paths,omitempty"`   // path prefixes, e.g. "/v1/archive"; empty means any path
+	Rooms   []string  `json:"rooms,omitempty"`   // empty means any room
+	Brokers []string  `json:"brokers,omitempty"` // empty means any broker
+}
+
+// Issue signs c and returns the resulting bearer token: a base64url
+// Caveats payload, a ".", and its base64url HMAC-SHA256 signature. It
+// fails if SigningKeyKey isn't set in hal.Secrets().
+func Issue(c Caveats) (string, error) {
+	key := hal.Secrets().Get(SigningKeyKey)
+	if key == "" {
+		return "", fmt.Errorf("httpauth: %s is not set in hal.Secrets()", SigningKeyKey)
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encodedPayload + "." + sign([]byte(key), encodedPayload), nil
+}
+
+// Parse verifies token's signature and expiry and returns its Caveats.
+func Parse(token string) (Caveats, error) {
+	var c Caveats
+
+	key := hal.Secrets().Get(SigningKeyKey)
+	if key == "" {
+		return c, fmt.Errorf("httpauth: %s is not set in hal.Secrets()", SigningKeyKey)
+	}
+
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return c, errors.New("httpauth: malformed token")
+	}
+
+	encodedPayload, sig := parts[0], parts[1]
+	if !hmac.Equal([]byte(sign([]byte(key), encodedPayload)), []byte(sig)) {
+		return c, errors.New("httpauth: invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return c, errors.New("httpauth: malformed token")
+	}
+
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, errors.New("httpauth: malformed token")
+	}
+
+	if !time.Now().Before(c.Expiry) {
+		return c, errors.New("httpauth: token expired")
+	}
+
+	return c, nil
+}
+
+func sign(key []byte, data string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
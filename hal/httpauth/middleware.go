@@ -0,0 +1,111 @@
+package httpauth
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Require wraps next so every request must carry an "Authorization:
+// Bearer <token>" header naming a token minted by Issue whose caveats the
+// request satisfies. A missing, malformed, or expired token gets 401; a
+// valid token whose caveats don't cover this request (wrong method, path
+// outside Paths, or a "room"/"broker" query parameter outside
+// Rooms/Brokers) gets 403.
+func Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			http.Error(w, "httpauth: missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		c, err := Parse(token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if err := c.allows(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return ""
+	}
+
+	return strings.TrimPrefix(auth, prefix)
+}
+
+// allows reports whether r satisfies every caveat in c beyond expiry,
+// already checked by Parse.
+func (c Caveats) allows(r *http.Request) error {
+	if len(c.Methods) > 0 && !contains(c.Methods, r.Method) {
+		return fmt.Errorf("httpauth: method %s not permitted by this token", r.Method)
+	}
+
+	if len(c.Paths) > 0 && !hasPrefixAny(r.URL.Path, c.Paths) {
+		return fmt.Errorf("httpauth: path %s not permitted by this token", r.URL.Path)
+	}
+
+	if len(c.Rooms) > 0 {
+		room := r.URL.Query().Get("room")
+		if room == "" || !contains(c.Rooms, room) {
+			return fmt.Errorf("httpauth: room %q not permitted by this token", room)
+		}
+	}
+
+	if len(c.Brokers) > 0 {
+		broker := r.URL.Query().Get("broker")
+		if broker == "" || !contains(c.Brokers, broker) {
+			return fmt.Errorf("httpauth: broker %q not permitted by this token", broker)
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasPrefixAny(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+
+	return false
+}
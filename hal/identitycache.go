@@ -0,0 +1,191 @@
+package hal
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// IdentityCache is a bidirectional id<->name cache with TTL-based
+// expiration, negative caching (so repeated lookups of an id/name that's
+// known not to exist don't keep hitting the broker's API), and LRU
+// eviction once MaxEntries is reached. Brokers use one instance per
+// identity kind (e.g. users, rooms) in place of hand-rolled maps.
+type IdentityCache struct {
+	// TTL is how long a positive (found) entry is trusted before it's
+	// considered stale and must be re-resolved. Zero means never expire.
+	TTL time.Duration
+	// MissTTL is how long a negative (not-found) entry is cached before
+	// the broker will be asked about that id/name again. Zero means never
+	// expire, which is usually too aggressive for a miss cache.
+	MissTTL time.Duration
+	// MaxEntries bounds the cache size; the least-recently-used entry is
+	// evicted once it's exceeded. Zero means unbounded.
+	MaxEntries int
+	// WriteThrough, if set, is called with every positive Put so the
+	// identity can also be persisted somewhere durable (e.g.
+	// hal.Directory()).
+	WriteThrough func(id, name string)
+
+	mut  sync.Mutex
+	init sync.Once
+	i2n  map[string]*list.Element // id -> name cache entry
+	n2i  map[string]*list.Element // name -> id cache entry
+	lru  *list.List
+}
+
+// identityEntry is the value stored in both maps; a single entry is
+// referenced from both i2n and n2i so LRU touches update once.
+type identityEntry struct {
+	id, name string
+	miss     bool
+	expires  time.Time // zero means no expiration
+}
+
+func (ic *IdentityCache) ensureInit() {
+	ic.init.Do(func() {
+		ic.i2n = make(map[string]*list.Element)
+		ic.n2i = make(map[string]*list.Element)
+		ic.lru = list.New()
+	})
+}
+
+// Get returns the name cached for id. ok is false if there's no entry, the
+// entry expired, or the entry is a cached miss.
+func (ic *IdentityCache) Get(id string) (name string, ok bool) {
+	ic.ensureInit()
+	ic.mut.Lock()
+	defer ic.mut.Unlock()
+
+	el, exists := ic.i2n[id]
+	if !exists {
+		return "", false
+	}
+
+	return ic.readLocked(el)
+}
+
+// GetByName is the inverse of Get.
+func (ic *IdentityCache) GetByName(name string) (id string, ok bool) {
+	ic.ensureInit()
+	ic.mut.Lock()
+	defer ic.mut.Unlock()
+
+	el, exists := ic.n2i[name]
+	if !exists {
+		return "", false
+	}
+
+	e := el.Value.(*identityEntry)
+	if ic.expiredLocked(e) {
+		ic.removeLocked(el)
+		return "", false
+	}
+	if e.miss {
+		return "", false
+	}
+
+	ic.lru.MoveToFront(el)
+	return e.id, true
+}
+
+func (ic *IdentityCache) readLocked(el *list.Element) (string, bool) {
+	e := el.Value.(*identityEntry)
+	if ic.expiredLocked(e) {
+		ic.removeLocked(el)
+		return "", false
+	}
+	if e.miss {
+		return "", false
+	}
+
+	ic.lru.MoveToFront(el)
+	return e.name, true
+}
+
+func (ic *IdentityCache) expiredLocked(e *identityEntry) bool {
+	return !e.expires.IsZero() && time.Now().After(e.expires)
+}
+
+func (ic *IdentityCache) removeLocked(el *list.Element) {
+	e := el.Value.(*identityEntry)
+	delete(ic.i2n, e.id)
+	delete(ic.n2i, e.name)
+	ic.lru.Remove(el)
+}
+
+// Put records a positive id<->name mapping, triggering WriteThrough if set.
+func (ic *IdentityCache) Put(id, name string) {
+	ic.ensureInit()
+	ic.mut.Lock()
+
+	e := &identityEntry{id: id, name: name}
+	if ic.TTL > 0 {
+		e.expires = time.Now().Add(ic.TTL)
+	}
+	ic.storeLocked(e)
+
+	ic.mut.Unlock()
+
+	if ic.WriteThrough != nil {
+		ic.WriteThrough(id, name)
+	}
+}
+
+// Miss records that id is known not to resolve to anything, so callers can
+// skip hitting the broker's API again until MissTTL expires.
+func (ic *IdentityCache) Miss(id string) {
+	ic.ensureInit()
+	ic.mut.Lock()
+	defer ic.mut.Unlock()
+
+	e := &identityEntry{id: id, miss: true}
+	if ic.MissTTL > 0 {
+		e.expires = time.Now().Add(ic.MissTTL)
+	}
+	ic.storeLocked(e)
+}
+
+// IsMiss reports whether id is currently cached as a known miss.
+func (ic *IdentityCache) IsMiss(id string) bool {
+	ic.ensureInit()
+	ic.mut.Lock()
+	defer ic.mut.Unlock()
+
+	el, exists := ic.i2n[id]
+	if !exists {
+		return false
+	}
+
+	e := el.Value.(*identityEntry)
+	if ic.expiredLocked(e) {
+		ic.removeLocked(el)
+		return false
+	}
+
+	return e.miss
+}
+
+// storeLocked inserts/replaces an entry and evicts the LRU tail if
+// MaxEntries is now exceeded. Callers must hold ic.mut.
+func (ic *IdentityCache) storeLocked(e *identityEntry) {
+	if old, exists := ic.i2n[e.id]; exists {
+		ic.removeLocked(old)
+	}
+	// a miss entry has no name to index by, so only index n2i for hits
+	el := ic.lru.PushFront(e)
+	ic.i2n[e.id] = el
+	if !e.miss {
+		ic.n2i[e.name] = el
+	}
+
+	if ic.MaxEntries > 0 {
+		for ic.lru.Len() > ic.MaxEntries {
+			tail := ic.lru.Back()
+			if tail == nil {
+				break
+			}
+			ic.removeLocked(tail)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package hal
+
+import "sync"
+
+// InteractionEvt carries a broker-agnostic interactive-component callback:
+// a click on a button or select menu rendered from a RichContent/Attachment,
+// or a modal submission. Brokers that support this (e.g. Slack) decode
+// their native payload into one of these and dispatch it through
+// InteractionRegistry rather than through the normal Evt/Router path, since
+// interactions are addressed by callback_id rather than room+regex.
+type InteractionEvt struct {
+	CallbackId  string    // identifies which plugin/handler registered the action
+	ActionName  string    // the RichAction.Name that was clicked
+	Value       string    // the RichAction.Value, or the selected option for a menu
+	User        string    // human-readable username
+	UserId      string    // broker user id
+	Room        string    // human-readable room name
+	RoomId      string    // broker room id
+	ResponseURL string    // broker URL to post a delayed/updated response to
+	TriggerId   string    // broker id needed to open a modal in response
+	Broker      Broker    // the broker the interaction arrived on
+	Original    interface{} // the broker-native payload
+}
+
+// Reply posts msg back to the room the interaction originated in.
+func (ie *InteractionEvt) Reply(msg string) {
+	ie.Broker.Send(Evt{
+		Body:    msg,
+		Room:    ie.Room,
+		RoomId:  ie.RoomId,
+		Brokers: Brokers{ie.Broker},
+	})
+}
+
+// InteractionHandler is called with an InteractionEvt whose CallbackId
+// matches the one it was registered under.
+type InteractionHandler func(InteractionEvt)
+
+// interactionRegistry is the singleton mapping callback_id -> handler. It
+// mirrors pluginRegistry's shape (mutex-guarded map, sync.Once init) since
+// the access pattern is the same: register once at startup, look up on
+// every inbound event.
+type interactionRegistry struct {
+	handlers map[string]InteractionHandler
+	mut      sync.Mutex
+	init     sync.Once
+}
+
+var interactionRegSingleton interactionRegistry
+
+// InteractionRegistry returns the process-wide interaction handler registry.
+func InteractionRegistry() *interactionRegistry {
+	interactionRegSingleton.init.Do(func() {
+		interactionRegSingleton.handlers = make(map[string]InteractionHandler)
+	})
+
+	return &interactionRegSingleton
+}
+
+// Register associates a callback_id with the handler that should receive
+// interactions for it. Plugins generate a unique callback_id (e.g.
+// "pluginname-<uuid>") when building a RichAction/Attachment so Dispatch can
+// find this handler again later.
+func (ir *interactionRegistry) Register(callbackId string, handler InteractionHandler) {
+	ir.mut.Lock()
+	defer ir.mut.Unlock()
+
+	ir.handlers[callbackId] = handler
+}
+
+// Unregister removes a callback_id, e.g. once a one-shot modal has been
+// submitted or an action's message has expired.
+func (ir *interactionRegistry) Unregister(callbackId string) {
+	ir.mut.Lock()
+	defer ir.mut.Unlock()
+
+	delete(ir.handlers, callbackId)
+}
+
+// Dispatch looks up the handler for ie.CallbackId and calls it. It returns
+// false if no handler is registered so the broker can log/ignore stale
+// callbacks (e.g. from a message posted before a restart).
+func (ir *interactionRegistry) Dispatch(ie InteractionEvt) bool {
+	ir.mut.Lock()
+	handler, exists := ir.handlers[ie.CallbackId]
+	ir.mut.Unlock()
+
+	if !exists {
+		return false
+	}
+
+	handler(ie)
+	return true
+}
@@ -0,0 +1,38 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import "time"
+
+// JobSpec describes one scheduled or deferred unit of work a plugin wants
+// run on its behalf. Exactly one of Cron, Every, or At should be set; if
+// more than one is, the scheduler behind JobRegistrar picks the first one
+// set in that same order (Cron, then Every, then At).
+type JobSpec struct {
+	Name    string                     // unique among a plugin's own jobs
+	Payload string                     // opaque data round-tripped to Func on every run
+	Cron    string                     // standard 5-field cron expression
+	Every   time.Duration              // fixed interval, e.g. every 10 minutes
+	At      time.Time                  // one-shot, run once at this time
+	Func    func(payload string) error // the work to run
+}
+
+// JobRegistrar, if set, is called once for each of a plugin's Jobs when an
+// Instance registers, so a scheduler (see hal/scheduler) can persist and
+// later run them without hal depending on the scheduler package directly.
+// This follows the same pluggable-backend pattern as SetKVBackend.
+var JobRegistrar func(inst *Instance, job JobSpec)
@@ -101,8 +101,66 @@ func ExistsKV(key string) bool {
 	return true
 }
 
-// NOTE: this will probably change to an ok,value style
+// GetKV fetches key's value, going through the configured KVBackend if one
+// was set via SetKVBackend, and falling through to the SQL-backed path
+// (getKVSql) otherwise.
 func GetKV(key string) (value string, err error) {
+	if b := getKVBackend(); b != nil {
+		return b.Get(key)
+	}
+	return getKVSql(key)
+}
+
+// SetKV stores key/value with the given ttl, going through the configured
+// KVBackend if one was set via SetKVBackend, and falling through to the
+// SQL-backed path (setKVSql) otherwise.
+func SetKV(key, value string, ttl time.Duration) (err error) {
+	if b := getKVBackend(); b != nil {
+		return b.Set(key, value, ttl)
+	}
+	return setKVSql(key, value, ttl)
+}
+
+// DeleteKV removes key, going through the configured KVBackend if one was
+// set via SetKVBackend, and falling through to the SQL-backed path
+// (deleteKVSql) otherwise.
+func DeleteKV(key string) (err error) {
+	if b := getKVBackend(); b != nil {
+		return b.Delete(key)
+	}
+	return deleteKVSql(key)
+}
+
+// CompareAndSwapKV atomically replaces key's value with newVal only if its
+// current value is still oldVal (an empty oldVal means "key must not exist
+// yet"), going through the configured KVBackend if one was set via
+// SetKVBackend, and falling through to the SQL-backed path otherwise. ok is
+// false, with no error, on a mismatch.
+func CompareAndSwapKV(key, oldVal, newVal string, ttl time.Duration) (ok bool, err error) {
+	if b := getKVBackend(); b != nil {
+		return b.CompareAndSwap(key, oldVal, newVal, ttl)
+	}
+	return compareAndSwapKVSql(key, oldVal, newVal, ttl)
+}
+
+// WatchKV streams a KVEvent for every change under prefix observed by the
+// configured KVBackend (see SetKVBackend), for callers like hal/template
+// that need to react to specific keys changing rather than just
+// invalidating a cache. Returns ErrWatchNotSupported if no KVBackend is
+// set, since the default SQL-backed path has no notification mechanism.
+func WatchKV(prefix string) (<-chan KVEvent, error) {
+	b := getKVBackend()
+	if b == nil {
+		return nil, ErrWatchNotSupported
+	}
+
+	return b.Watch(prefix)
+}
+
+// getKVSql is the original, SQL-backed GetKV implementation (see KVTable),
+// kept under an unexported name so it can serve as sqlKVBackend's Get
+// without recursing through the KVBackend indirection in GetKV.
+func getKVSql(key string) (value string, err error) {
 	kvLazyInit()
 	db := SqlDB()
 	now := time.Now()
@@ -148,7 +206,7 @@ func GetKV(key string) (value string, err error) {
 	return kv.value, nil
 }
 
-func SetKV(key, value string, ttl time.Duration) (err error) {
+func setKVSql(key, value string, ttl time.Duration) (err error) {
 	kvLazyInit()
 
 	kvMut.Lock()
@@ -174,6 +232,71 @@ func SetKV(key, value string, ttl time.Duration) (err error) {
 	return err
 }
 
+func deleteKVSql(key string) (err error) {
+	kvLazyInit()
+
+	kvMut.Lock()
+	defer kvMut.Unlock()
+
+	delete(kvCache, key)
+
+	db := SqlDB()
+	_, err = db.Exec("DELETE FROM kv WHERE pkey=?", key)
+	if err != nil {
+		log.Printf("DeleteKV failed: %s", err)
+	}
+
+	return err
+}
+
+// compareAndSwapKVSql implements CompareAndSwapKV's semantics on top of the
+// kv table using a transaction to hold the row lock between the read and
+// the write, since plain MySQL has no equivalent of etcd's Txn(If...).
+func compareAndSwapKVSql(key, oldVal, newVal string, ttl time.Duration) (ok bool, err error) {
+	kvLazyInit()
+
+	kvMut.Lock()
+	defer kvMut.Unlock()
+
+	db := SqlDB()
+	tx, err := db.Begin()
+	if err != nil {
+		return false, errors.Annotate(err, "CompareAndSwapKV failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRow("SELECT value FROM kv WHERE pkey=? FOR UPDATE", key).Scan(&current)
+	switch {
+	case err == dbsql.ErrNoRows:
+		if oldVal != "" {
+			return false, nil
+		}
+	case err != nil:
+		return false, errors.Annotate(err, "CompareAndSwapKV SQL query failed")
+	default:
+		if current != oldVal {
+			return false, nil
+		}
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(`INSERT INTO kv (pkey,value,expires,ttl) VALUES (?,?,?,?)
+		ON DUPLICATE KEY UPDATE value=VALUES(value), expires=VALUES(expires), ttl=VALUES(ttl)`,
+		key, newVal, now.Add(ttl), int(ttl.Seconds()))
+	if err != nil {
+		return false, errors.Annotate(err, "CompareAndSwapKV write failed")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errors.Annotate(err, "CompareAndSwapKV failed to commit transaction")
+	}
+
+	kvCache[key] = &kvRecord{key: key, value: newVal, ttl: ttl, expires: now.Add(ttl)}
+
+	return true, nil
+}
+
 func (kv *kvRecord) NewKVExpiredTtlError() KVExpiredTtlError {
 	return KVExpiredTtlError{
 		Key:     kv.key,
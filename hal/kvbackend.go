@@ -0,0 +1,175 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// KVEvent describes one key changing on any node sharing a KVBackend, as
+// streamed by KVBackend.Watch. It carries the new Value so callers can
+// repopulate a cache without a round-trip, but Deleted should always be
+// checked first.
+type KVEvent struct {
+	Key     string
+	Value   string
+	Deleted bool
+}
+
+// KVBackend is the storage interface GetKV/SetKV/ExistsKV drive, pulled out
+// so deployments that need a consistent view of keys across multiple hal
+// instances (behind different brokers, or for HA) can swap in something
+// like etcd instead of the default single-MySQL-instance behavior. See
+// EtcdKVBackend for the distributed implementation and ForceSqlDBHandle for
+// the equivalent override on the SQL side.
+type KVBackend interface {
+	// Get returns the value for key. err is ErrKVNotFound if the key
+	// doesn't exist or has expired.
+	Get(key string) (value string, err error)
+	// Set stores value under key with the given ttl. ttl == 0 means no
+	// expiration.
+	Set(key, value string, ttl time.Duration) error
+	// Delete removes key. It is not an error to delete a key that doesn't
+	// exist.
+	Delete(key string) error
+	// Watch streams a KVEvent for every key under prefix that's put or
+	// deleted on any node sharing this backend, so SetKVBackend can
+	// invalidate kvCache on remote writes instead of relying solely on
+	// ttl expiry. A backend that can't support this (e.g. sqlKVBackend,
+	// which has no cross-instance notification mechanism) returns
+	// ErrWatchNotSupported.
+	Watch(prefix string) (<-chan KVEvent, error)
+	// CompareAndSwap atomically replaces key's value with newVal only if
+	// its current value is still oldVal (an empty oldVal means "key must
+	// not exist yet"), so callers like pagerduty's alias editor can be
+	// safely run from any node without clobbering a concurrent writer.
+	// ok is false, with no error, on a mismatch.
+	CompareAndSwap(key, oldVal, newVal string, ttl time.Duration) (ok bool, err error)
+}
+
+// ErrKVNotFound is returned by a KVBackend.Get when the key doesn't exist
+// or its ttl has elapsed.
+var ErrKVNotFound = kvNotFoundError{}
+
+type kvNotFoundError struct{}
+
+func (kvNotFoundError) Error() string { return "key not found" }
+
+// ErrWatchNotSupported is returned by KVBackend.Watch implementations that
+// have no way to observe writes from other processes.
+var ErrWatchNotSupported = errors.New("this KVBackend does not support Watch")
+
+var (
+	kvBackendMut  sync.Mutex
+	kvBackend     KVBackend // nil means "use the legacy SQL-backed path in kv.go"
+	kvWatchCancel func()    // stops the previous backend's invalidation watch, if any
+)
+
+// SetKVBackend overrides the storage backend used by GetKV/SetKV/ExistsKV.
+// Call with nil to revert to the default SQL-backed behavior. Must be
+// called before the first GetKV/SetKV call to take effect everywhere
+// consistently, since the legacy path also maintains its own in-process
+// cache.
+//
+// If b supports Watch, SetKVBackend also starts a goroutine that deletes
+// kvCache entries as soon as another instance changes them, so a stale
+// local cache can't outlive the remote write that invalidated it.
+func SetKVBackend(b KVBackend) {
+	kvBackendMut.Lock()
+	defer kvBackendMut.Unlock()
+
+	if kvWatchCancel != nil {
+		kvWatchCancel()
+		kvWatchCancel = nil
+	}
+
+	kvBackend = b
+
+	if b == nil {
+		return
+	}
+
+	events, err := b.Watch("")
+	if err == ErrWatchNotSupported {
+		return
+	} else if err != nil {
+		log.Printf("hal: KVBackend.Watch failed, local kvCache may serve stale values: %s", err)
+		return
+	}
+
+	done := make(chan struct{})
+	kvWatchCancel = func() { close(done) }
+
+	go func() {
+		for {
+			select {
+			case evt, ok := <-events:
+				if !ok {
+					return
+				}
+				kvMut.Lock()
+				delete(kvCache, evt.Key)
+				kvMut.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func getKVBackend() KVBackend {
+	kvBackendMut.Lock()
+	defer kvBackendMut.Unlock()
+
+	return kvBackend
+}
+
+// sqlKVBackend adapts the pre-existing package-level GetKV/SetKV (kv.go) to
+// the KVBackend interface, so it can be the default without changing any
+// callers.
+type sqlKVBackend struct{}
+
+func (sqlKVBackend) Get(key string) (string, error) {
+	v, err := getKVSql(key)
+	if err != nil {
+		return "", ErrKVNotFound
+	}
+	return v, nil
+}
+
+func (sqlKVBackend) Set(key, value string, ttl time.Duration) error {
+	return setKVSql(key, value, ttl)
+}
+
+func (sqlKVBackend) Delete(key string) error {
+	return deleteKVSql(key)
+}
+
+// Watch always fails: a single MySQL instance has no notification
+// mechanism, which is exactly the "stale cache after another instance
+// writes" problem this backend abstraction exists to let deployments
+// opt out of by switching to EtcdKVBackend/ConsulKVBackend/RedisKVBackend.
+func (sqlKVBackend) Watch(prefix string) (<-chan KVEvent, error) {
+	return nil, ErrWatchNotSupported
+}
+
+func (sqlKVBackend) CompareAndSwap(key, oldVal, newVal string, ttl time.Duration) (bool, error) {
+	return compareAndSwapKVSql(key, oldVal, newVal, ttl)
+}
@@ -0,0 +1,176 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ConsulKVBackend implements KVBackend on top of Consul's KV store, as an
+// alternative to EtcdKVBackend for deployments that already run Consul for
+// service discovery. ttl is implemented with a Consul session attached to
+// the key, since Consul KV entries don't expire on their own the way etcd
+// leases do.
+type ConsulKVBackend struct {
+	client *api.Client
+	prefix string // all keys are stored under prefix+key
+}
+
+// NewConsulKVBackend connects to the Consul agent at addr (e.g.
+// "127.0.0.1:8500") and returns a KVBackend that stores keys under prefix
+// (e.g. "hal9001/kv/").
+func NewConsulKVBackend(addr, prefix string) (*ConsulKVBackend, error) {
+	cli, err := api.NewClient(&api.Config{Address: addr})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsulKVBackend{client: cli, prefix: prefix}, nil
+}
+
+func (cb *ConsulKVBackend) key(k string) string {
+	return cb.prefix + k
+}
+
+// Get implements KVBackend.
+func (cb *ConsulKVBackend) Get(key string) (string, error) {
+	pair, _, err := cb.client.KV().Get(cb.key(key), nil)
+	if err != nil {
+		return "", err
+	}
+	if pair == nil {
+		return "", ErrKVNotFound
+	}
+
+	return string(pair.Value), nil
+}
+
+// Set implements KVBackend. A ttl > 0 creates a session with the
+// requested TTL and attaches it to the key so Consul reaps the key itself
+// when the session expires; a ttl of 0 stores the key with no session.
+func (cb *ConsulKVBackend) Set(key, value string, ttl time.Duration) error {
+	pair := &api.KVPair{Key: cb.key(key), Value: []byte(value)}
+
+	if ttl > 0 {
+		sessionID, err := cb.createSession(ttl)
+		if err != nil {
+			return err
+		}
+		pair.Session = sessionID
+	}
+
+	_, err := cb.client.KV().Put(pair, nil)
+	return err
+}
+
+func (cb *ConsulKVBackend) createSession(ttl time.Duration) (string, error) {
+	sessionID, _, err := cb.client.Session().Create(&api.SessionEntry{
+		TTL:      ttl.String(),
+		Behavior: api.SessionBehaviorDelete,
+	}, nil)
+	return sessionID, err
+}
+
+// Delete implements KVBackend.
+func (cb *ConsulKVBackend) Delete(key string) error {
+	_, err := cb.client.KV().Delete(cb.key(key), nil)
+	return err
+}
+
+// Watch implements KVBackend by long-polling Consul's blocking queries
+// under prefix and diffing successive snapshots for puts/deletes, since
+// Consul's KV API has no native streaming watch like etcd's.
+func (cb *ConsulKVBackend) Watch(prefix string) (<-chan KVEvent, error) {
+	out := make(chan KVEvent)
+	fullPrefix := cb.key(prefix)
+
+	go func() {
+		defer close(out)
+
+		seen := make(map[string]string)
+		var waitIndex uint64
+
+		for {
+			pairs, meta, err := cb.client.KV().List(fullPrefix, &api.QueryOptions{WaitIndex: waitIndex})
+			if err != nil {
+				return
+			}
+			waitIndex = meta.LastIndex
+
+			current := make(map[string]string, len(pairs))
+			for _, pair := range pairs {
+				k := pair.Key[len(cb.prefix):]
+				current[k] = string(pair.Value)
+
+				if old, existed := seen[k]; !existed || old != current[k] {
+					out <- KVEvent{Key: k, Value: current[k]}
+				}
+			}
+
+			for k := range seen {
+				if _, stillPresent := current[k]; !stillPresent {
+					out <- KVEvent{Key: k, Deleted: true}
+				}
+			}
+
+			seen = current
+		}
+	}()
+
+	return out, nil
+}
+
+// CompareAndSwap implements KVBackend using Consul's check-and-set index
+// (ModifyIndex), which plays the same role as etcd's compare-on-value:
+// CAS=0 requires the key be absent, otherwise the write only succeeds if
+// ModifyIndex still matches the value that was read as oldVal.
+func (cb *ConsulKVBackend) CompareAndSwap(key, oldVal, newVal string, ttl time.Duration) (bool, error) {
+	k := cb.key(key)
+
+	pair, _, err := cb.client.KV().Get(k, nil)
+	if err != nil {
+		return false, err
+	}
+
+	var modifyIndex uint64
+	switch {
+	case pair == nil:
+		if oldVal != "" {
+			return false, nil
+		}
+	default:
+		if string(pair.Value) != oldVal {
+			return false, nil
+		}
+		modifyIndex = pair.ModifyIndex
+	}
+
+	newPair := &api.KVPair{Key: k, Value: []byte(newVal), ModifyIndex: modifyIndex}
+
+	if ttl > 0 {
+		sessionID, err := cb.createSession(ttl)
+		if err != nil {
+			return false, err
+		}
+		newPair.Session = sessionID
+	}
+
+	ok, _, err := cb.client.KV().CAS(newPair, nil)
+	return ok, err
+}
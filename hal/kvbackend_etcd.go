@@ -0,0 +1,194 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// EtcdKVBackend implements KVBackend on top of etcd v3, so that multiple
+// hal-9001 instances (e.g. behind different brokers, or run for HA) share a
+// consistent view of keys instead of each having their own SQL database.
+// TTL'd keys are implemented with etcd leases rather than the ad-hoc
+// "DELETE WHERE expires < NOW()" sweep the SQL backend uses.
+type EtcdKVBackend struct {
+	client *clientv3.Client
+	prefix string // all keys are stored under prefix+key
+
+	watchMut sync.Mutex
+	onChange func(key string) // optional invalidation hook, see WatchChanges
+}
+
+// NewEtcdKVBackend connects to the given etcd endpoints and returns a
+// KVBackend that stores keys under prefix (e.g. "/hal9001/kv/").
+func NewEtcdKVBackend(endpoints []string, prefix string) (*EtcdKVBackend, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdKVBackend{client: cli, prefix: prefix}, nil
+}
+
+func (eb *EtcdKVBackend) key(k string) string {
+	return eb.prefix + k
+}
+
+// Get implements KVBackend.
+func (eb *EtcdKVBackend) Get(key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := eb.client.Get(ctx, eb.key(key))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", ErrKVNotFound
+	}
+
+	return string(resp.Kvs[0].Value), nil
+}
+
+// Set implements KVBackend. A ttl > 0 is implemented with an etcd lease so
+// the key disappears on its own; a ttl of 0 stores the key with no lease.
+func (eb *EtcdKVBackend) Set(key, value string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if ttl <= 0 {
+		_, err := eb.client.Put(ctx, eb.key(key), value)
+		return err
+	}
+
+	lease, err := eb.client.Grant(ctx, int64(ttl.Seconds()))
+	if err != nil {
+		return err
+	}
+
+	_, err = eb.client.Put(ctx, eb.key(key), value, clientv3.WithLease(lease.ID))
+	return err
+}
+
+// Delete implements KVBackend.
+func (eb *EtcdKVBackend) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := eb.client.Delete(ctx, eb.key(key))
+	return err
+}
+
+// Watch implements KVBackend by streaming every put/delete under prefix as
+// a KVEvent, unprefixing the key so callers never see eb.prefix. The
+// channel is closed when etcd closes its underlying watch stream (e.g. the
+// client is Close()'d).
+func (eb *EtcdKVBackend) Watch(prefix string) (<-chan KVEvent, error) {
+	out := make(chan KVEvent)
+
+	go func() {
+		defer close(out)
+
+		rch := eb.client.Watch(context.Background(), eb.key(prefix), clientv3.WithPrefix())
+		for resp := range rch {
+			for _, ev := range resp.Events {
+				k := string(ev.Kv.Key)[len(eb.prefix):]
+				out <- KVEvent{
+					Key:     k,
+					Value:   string(ev.Kv.Value),
+					Deleted: ev.Type == clientv3.EventTypeDelete,
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// CompareAndSwap implements KVBackend using an etcd transaction so the
+// compare and the write happen atomically server-side: If(value=oldVal)
+// Then(put) Else(nothing). An empty oldVal requires the key be absent
+// (etcd's create-revision is 0 for keys that have never been written).
+func (eb *EtcdKVBackend) CompareAndSwap(key, oldVal, newVal string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	k := eb.key(key)
+
+	var cmp clientv3.Cmp
+	if oldVal == "" {
+		cmp = clientv3.Compare(clientv3.CreateRevision(k), "=", 0)
+	} else {
+		cmp = clientv3.Compare(clientv3.Value(k), "=", oldVal)
+	}
+
+	put := clientv3.OpPut(k, newVal)
+	if ttl > 0 {
+		lease, err := eb.client.Grant(ctx, int64(ttl.Seconds()))
+		if err != nil {
+			return false, err
+		}
+		put = clientv3.OpPut(k, newVal, clientv3.WithLease(lease.ID))
+	}
+
+	resp, err := eb.client.Txn(ctx).If(cmp).Then(put).Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Succeeded, nil
+}
+
+// WatchChanges starts a goroutine that watches everything under the
+// backend's prefix and calls onChange with the unprefixed key whenever it's
+// put or deleted. Callers use this to invalidate in-process caches (e.g.
+// google_calendar's configCache) when a pref/kv entry changes on another
+// node. The watch runs until the backend's client is closed.
+func (eb *EtcdKVBackend) WatchChanges(onChange func(key string)) {
+	eb.watchMut.Lock()
+	eb.onChange = onChange
+	eb.watchMut.Unlock()
+
+	go func() {
+		rch := eb.client.Watch(context.Background(), eb.prefix, clientv3.WithPrefix())
+		for resp := range rch {
+			for _, ev := range resp.Events {
+				k := string(ev.Kv.Key)[len(eb.prefix):]
+				eb.watchMut.Lock()
+				cb := eb.onChange
+				eb.watchMut.Unlock()
+				if cb != nil {
+					cb(k)
+				}
+			}
+		}
+		log.Println("hal: etcd KV watch channel closed")
+	}()
+}
+
+// Close releases the underlying etcd client connection.
+func (eb *EtcdKVBackend) Close() error {
+	return eb.client.Close()
+}
@@ -0,0 +1,148 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisCompareAndSwapScript implements CompareAndSwap atomically server-side:
+// KEYS[1] is the key, ARGV[1] is oldVal, ARGV[2] is newVal, ARGV[3] is the
+// ttl in seconds (0 means no expiration). An empty ARGV[1] requires the key
+// be absent. Returns 1 on success, 0 on mismatch.
+const redisCompareAndSwapScript = `
+local current = redis.call("GET", KEYS[1])
+if ARGV[1] == "" then
+	if current then
+		return 0
+	end
+elseif current ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "EX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`
+
+// RedisKVBackend implements KVBackend on top of Redis, as a lighter-weight
+// alternative to EtcdKVBackend/ConsulKVBackend for deployments that already
+// run Redis (e.g. alongside hal.ttlCache). Watch relies on Redis keyspace
+// notifications, which must be enabled on the server with
+// `CONFIG SET notify-keyspace-events KEA`.
+type RedisKVBackend struct {
+	client *redis.Client
+	prefix string // all keys are stored under prefix+key
+}
+
+// NewRedisKVBackend connects to the Redis server at addr (e.g.
+// "127.0.0.1:6379") and returns a KVBackend that stores keys under prefix
+// (e.g. "hal9001:kv:").
+func NewRedisKVBackend(addr, prefix string) (*RedisKVBackend, error) {
+	cli := redis.NewClient(&redis.Options{Addr: addr})
+
+	if err := cli.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+
+	return &RedisKVBackend{client: cli, prefix: prefix}, nil
+}
+
+func (rb *RedisKVBackend) key(k string) string {
+	return rb.prefix + k
+}
+
+// Get implements KVBackend.
+func (rb *RedisKVBackend) Get(key string) (string, error) {
+	v, err := rb.client.Get(context.Background(), rb.key(key)).Result()
+	if err == redis.Nil {
+		return "", ErrKVNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return v, nil
+}
+
+// Set implements KVBackend. A ttl of 0 means no expiration, matching
+// redis.Client.Set's own convention.
+func (rb *RedisKVBackend) Set(key, value string, ttl time.Duration) error {
+	return rb.client.Set(context.Background(), rb.key(key), value, ttl).Err()
+}
+
+// Delete implements KVBackend.
+func (rb *RedisKVBackend) Delete(key string) error {
+	return rb.client.Del(context.Background(), rb.key(key)).Err()
+}
+
+// Watch implements KVBackend by subscribing to Redis keyspace notification
+// events for set/expired/del under prefix. The server must have
+// notify-keyspace-events enabled (at least "KEA") or this channel will
+// silently never receive anything. KVEvent.Value is left empty since
+// keyspace notifications carry only the key name; callers that need the
+// new value should Get it themselves on a non-Deleted event.
+func (rb *RedisKVBackend) Watch(prefix string) (<-chan KVEvent, error) {
+	ctx := context.Background()
+	pattern := "__keyevent@*__:*"
+
+	pubsub := rb.client.PSubscribe(ctx, pattern)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return nil, err
+	}
+
+	out := make(chan KVEvent)
+	fullPrefix := rb.key(prefix)
+
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+
+		for msg := range pubsub.Channel() {
+			k := msg.Payload // the keyspace event payload is the key name
+			if !strings.HasPrefix(k, fullPrefix) {
+				continue
+			}
+
+			event := msg.Channel[strings.LastIndex(msg.Channel, ":")+1:]
+			out <- KVEvent{
+				Key:     k[len(rb.prefix):],
+				Deleted: event == "del" || event == "expired",
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// CompareAndSwap implements KVBackend via a Lua script (see
+// redisCompareAndSwapScript) so the compare and the write are atomic.
+func (rb *RedisKVBackend) CompareAndSwap(key, oldVal, newVal string, ttl time.Duration) (bool, error) {
+	res, err := rb.client.Eval(context.Background(), redisCompareAndSwapScript,
+		[]string{rb.key(key)}, oldVal, newVal, int64(ttl.Seconds())).Result()
+	if err != nil {
+		return false, err
+	}
+
+	ok, _ := res.(int64)
+	return ok == 1, nil
+}
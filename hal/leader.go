@@ -0,0 +1,245 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// leaderHolderID identifies this process to a LeaderBackend. It only needs
+// to be unique per running process, not stable across restarts.
+var leaderHolderID = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown-host"
+	}
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}()
+
+var (
+	leadersMut sync.Mutex
+	leaders    = make(map[string]*Leader) // name -> the Leader campaigning for it in this process
+)
+
+// Leader campaigns for, and holds, a single named lease against whatever
+// LeaderBackend is configured (see SetLeaderBackend), so that exactly one
+// hal-9001 process in an HA fleet is considered the leader for name at a
+// time. PeriodicFunc uses this internally when LeaderOnly is set; it's
+// also exported directly for anything else that needs "only one replica
+// does X" (the bot's job scheduler worker pool, for instance).
+type Leader struct {
+	Name string
+	TTL  time.Duration
+
+	holder string
+
+	mut     sync.Mutex
+	leading bool
+	stop    chan struct{}
+}
+
+// NewLeader creates a Leader that will contend for name, renewing its
+// claim every time Campaign's internal ticker fires as long as it's held
+// for no longer than ttl at a stretch. It's registered process-wide so
+// TransferLeadership(name) can find it later.
+func NewLeader(name string, ttl time.Duration) *Leader {
+	l := &Leader{Name: name, TTL: ttl, holder: leaderHolderID}
+
+	leadersMut.Lock()
+	leaders[name] = l
+	leadersMut.Unlock()
+
+	return l
+}
+
+// IsLeader reports whether this process currently holds l's claim.
+func (l *Leader) IsLeader() bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.leading
+}
+
+// Campaign starts a background loop that repeatedly tries to acquire (or
+// renew) l's claim at TTL/3 intervals -- the same cadence PeriodicFunc
+// uses for its own lease renewal -- calling onElected when this process
+// newly becomes leader and onDemoted when it loses leadership, including
+// when Resign is called. Either callback may be nil. Calling Campaign
+// again while already running is a no-op.
+func (l *Leader) Campaign(onElected, onDemoted func()) {
+	l.mut.Lock()
+	if l.stop != nil {
+		l.mut.Unlock()
+		return
+	}
+	l.stop = make(chan struct{})
+	stop := l.stop
+	l.mut.Unlock()
+
+	go l.run(stop, onElected, onDemoted)
+}
+
+func (l *Leader) run(stop chan struct{}, onElected, onDemoted func()) {
+	interval := l.TTL / 3
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	l.step(onElected, onDemoted)
+
+	for {
+		select {
+		case <-stop:
+			l.resign(onDemoted)
+			return
+		case <-ticker.C:
+			l.step(onElected, onDemoted)
+		}
+	}
+}
+
+func (l *Leader) step(onElected, onDemoted func()) {
+	backend := getLeaderBackend()
+
+	l.mut.Lock()
+	wasLeading := l.leading
+	l.mut.Unlock()
+
+	var ok bool
+	var err error
+	if wasLeading {
+		ok, err = backend.Renew(l.Name, l.holder, l.TTL)
+	} else {
+		ok, err = backend.TryAcquire(l.Name, l.holder, l.TTL)
+	}
+
+	if err != nil {
+		log.Printf("hal: leader election step for %q failed, assuming not leading: %s", l.Name, err)
+		ok = false
+	}
+
+	l.mut.Lock()
+	l.leading = ok
+	l.mut.Unlock()
+
+	if ok && !wasLeading {
+		log.Printf("hal: this node is now the leader for %q", l.Name)
+		if onElected != nil {
+			onElected()
+		}
+	} else if !ok && wasLeading {
+		log.Printf("hal: this node lost leadership of %q", l.Name)
+		if onDemoted != nil {
+			onDemoted()
+		}
+	}
+}
+
+// Resign stops campaigning and, if this process was leading, releases the
+// claim immediately rather than letting it expire on its own.
+func (l *Leader) Resign() {
+	l.mut.Lock()
+	stop := l.stop
+	l.stop = nil
+	l.mut.Unlock()
+
+	if stop != nil {
+		close(stop)
+	}
+}
+
+func (l *Leader) resign(onDemoted func()) {
+	l.mut.Lock()
+	wasLeading := l.leading
+	l.leading = false
+	l.mut.Unlock()
+
+	if !wasLeading {
+		return
+	}
+
+	if err := getLeaderBackend().Release(l.Name, l.holder); err != nil {
+		log.Printf("hal: failed to release leadership of %q on resign: %s", l.Name, err)
+	}
+
+	if onDemoted != nil {
+		onDemoted()
+	}
+}
+
+// CurrentHolder returns the holder id currently recorded for name against
+// the configured LeaderBackend, regardless of whether this process is
+// contending for it -- used by "!hal leaders" to report on every
+// registered PeriodicFunc, not just the ones this node happens to be
+// campaigning for.
+func CurrentHolder(name string) (string, error) {
+	return getLeaderBackend().CurrentHolder(name)
+}
+
+// TransferLeadership asks the named election's Leader, if this process is
+// currently its leader, to give up its claim and then blocks until another
+// node has picked it up (or TTL elapses without one doing so), mirroring
+// consul's leadershipTransfer retry loop. This lets an operator drain a
+// node for a planned restart without a TTL's worth of delay before a
+// successor takes over. It is a no-op, returning nil, if this process
+// isn't leading name or has no Leader registered for it.
+func TransferLeadership(name string) error {
+	leadersMut.Lock()
+	l, ok := leaders[name]
+	leadersMut.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return l.transfer()
+}
+
+func (l *Leader) transfer() error {
+	if !l.IsLeader() {
+		return nil
+	}
+
+	backend := getLeaderBackend()
+	if err := backend.Release(l.Name, l.holder); err != nil {
+		return err
+	}
+
+	l.mut.Lock()
+	l.leading = false
+	l.mut.Unlock()
+
+	deadline := time.Now().Add(l.TTL)
+	for time.Now().Before(deadline) {
+		holder, err := backend.CurrentHolder(l.Name)
+		if err == nil && holder != "" && holder != l.holder {
+			log.Printf("hal: %q transferred leadership to %q", l.Name, holder)
+			return nil
+		}
+
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	return fmt.Errorf("timed out waiting for a new leader to take over %q", l.Name)
+}
@@ -0,0 +1,169 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	dbsql "database/sql"
+	"sync"
+	"time"
+)
+
+// LeaderTable backs the default sqlLeaderBackend: one row per named
+// election, holding whichever holder currently owns it and when that
+// ownership expires.
+const LeaderTable = `
+CREATE TABLE IF NOT EXISTS leader_election (
+	name    VARCHAR(191) NOT NULL,
+	holder  VARCHAR(191) NOT NULL,
+	expires DATETIME NOT NULL,
+	PRIMARY KEY(name)
+)`
+
+// LeaderBackend is the storage interface Leader drives, pulled out so
+// deployments running more than one hal-9001 process for redundancy can
+// swap in something with real cross-instance coordination (e.g.
+// EtcdLeaderBackend) instead of the default single-MySQL-instance
+// behavior. See EtcdKVBackend/KVBackend for the equivalent split on the
+// key/value side.
+type LeaderBackend interface {
+	// TryAcquire attempts to claim name for holder, good for ttl. ok is
+	// false, with no error, if someone else already holds an unexpired
+	// claim on name.
+	TryAcquire(name, holder string, ttl time.Duration) (ok bool, err error)
+	// Renew extends holder's claim on name by ttl. ok is false, with no
+	// error, if holder is not (or no longer) the current holder.
+	Renew(name, holder string, ttl time.Duration) (ok bool, err error)
+	// Release gives up name's claim, if holder currently holds it. It is
+	// not an error to release a claim that's already gone.
+	Release(name, holder string) error
+	// CurrentHolder returns the holder presently claiming name, or "" if
+	// name is vacant (including because its claim expired).
+	CurrentHolder(name string) (holder string, err error)
+}
+
+var (
+	leaderBackendMut sync.Mutex
+	leaderBackend    LeaderBackend // nil means "use the default SQL-backed behavior"
+)
+
+// SetLeaderBackend overrides the storage backend Leader elections are run
+// against. Call with nil to revert to the default SQL-backed behavior.
+func SetLeaderBackend(b LeaderBackend) {
+	leaderBackendMut.Lock()
+	defer leaderBackendMut.Unlock()
+
+	leaderBackend = b
+}
+
+func getLeaderBackend() LeaderBackend {
+	leaderBackendMut.Lock()
+	defer leaderBackendMut.Unlock()
+
+	if leaderBackend == nil {
+		return sqlLeaderBackend{}
+	}
+
+	return leaderBackend
+}
+
+// sqlLeaderBackend is the default LeaderBackend, implemented on top of the
+// leader_election table using row locks to make the read-then-write of an
+// acquire/renew atomic, the same technique compareAndSwapKVSql uses.
+type sqlLeaderBackend struct{}
+
+func (sqlLeaderBackend) TryAcquire(name, holder string, ttl time.Duration) (bool, error) {
+	SqlInit(LeaderTable)
+
+	db := SqlDB()
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var curHolder string
+	var expires time.Time
+	err = tx.QueryRow("SELECT holder, expires FROM leader_election WHERE name=? FOR UPDATE", name).Scan(&curHolder, &expires)
+
+	now := time.Now()
+	switch {
+	case err == dbsql.ErrNoRows:
+		// vacant, fall through to claim it
+	case err != nil:
+		return false, err
+	case curHolder == holder:
+		// we already hold it, treat like a renew
+	case now.Before(expires):
+		// somebody else holds an unexpired claim
+		return false, nil
+	}
+
+	_, err = tx.Exec(`INSERT INTO leader_election (name, holder, expires) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE holder=VALUES(holder), expires=VALUES(expires)`,
+		name, holder, now.Add(ttl))
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func (sqlLeaderBackend) Renew(name, holder string, ttl time.Duration) (bool, error) {
+	SqlInit(LeaderTable)
+
+	db := SqlDB()
+	res, err := db.Exec("UPDATE leader_election SET expires=? WHERE name=? AND holder=? AND expires > ?",
+		time.Now().Add(ttl), name, holder, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (sqlLeaderBackend) Release(name, holder string) error {
+	SqlInit(LeaderTable)
+
+	db := SqlDB()
+	_, err := db.Exec("DELETE FROM leader_election WHERE name=? AND holder=?", name, holder)
+	return err
+}
+
+func (sqlLeaderBackend) CurrentHolder(name string) (string, error) {
+	SqlInit(LeaderTable)
+
+	db := SqlDB()
+	var holder string
+	var expires time.Time
+	err := db.QueryRow("SELECT holder, expires FROM leader_election WHERE name=?", name).Scan(&holder, &expires)
+	if err == dbsql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if time.Now().After(expires) {
+		return "", nil
+	}
+
+	return holder, nil
+}
@@ -0,0 +1,157 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// EtcdLeaderBackend implements LeaderBackend on top of etcd v3 leases and
+// transactions, so leadership for a named PeriodicFunc is held by at most
+// one hal-9001 process across an HA fleet without any of them having to
+// poll a shared MySQL row to find out. A claim is represented by a key
+// that only exists while its etcd lease is alive; losing connectivity to
+// etcd for longer than the lease's TTL releases the claim automatically.
+type EtcdLeaderBackend struct {
+	client *clientv3.Client
+	prefix string // claims are stored under prefix+name
+
+	mut    sync.Mutex
+	leases map[string]clientv3.LeaseID // name -> the lease this process is holding it under
+}
+
+// NewEtcdLeaderBackend connects to the given etcd endpoints and returns a
+// LeaderBackend that stores claims under prefix (e.g. "/hal9001/leader/").
+func NewEtcdLeaderBackend(endpoints []string, prefix string) (*EtcdLeaderBackend, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdLeaderBackend{client: cli, prefix: prefix, leases: make(map[string]clientv3.LeaseID)}, nil
+}
+
+func (eb *EtcdLeaderBackend) key(name string) string {
+	return eb.prefix + name
+}
+
+// TryAcquire implements LeaderBackend using a lease-backed create-if-absent
+// transaction: If(CreateRevision(key)==0) Then(Put(key, holder, lease))
+// Else(nothing). The lease, not a ttl column, is what expires the claim.
+func (eb *EtcdLeaderBackend) TryAcquire(name, holder string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	k := eb.key(name)
+
+	lease, err := eb.client.Grant(ctx, int64(ttl.Seconds()))
+	if err != nil {
+		return false, err
+	}
+
+	cmp := clientv3.Compare(clientv3.CreateRevision(k), "=", 0)
+	put := clientv3.OpPut(k, holder, clientv3.WithLease(lease.ID))
+
+	resp, err := eb.client.Txn(ctx).If(cmp).Then(put).Commit()
+	if err != nil {
+		return false, err
+	}
+
+	if !resp.Succeeded {
+		eb.client.Revoke(ctx, lease.ID)
+		return false, nil
+	}
+
+	eb.mut.Lock()
+	eb.leases[name] = lease.ID
+	eb.mut.Unlock()
+
+	return true, nil
+}
+
+// Renew implements LeaderBackend by refreshing the lease this process
+// created in TryAcquire. If this process has no record of a lease for
+// name -- e.g. it just restarted -- Renew fails and the caller falls back
+// to TryAcquire on its next attempt.
+func (eb *EtcdLeaderBackend) Renew(name, holder string, ttl time.Duration) (bool, error) {
+	eb.mut.Lock()
+	leaseID, known := eb.leases[name]
+	eb.mut.Unlock()
+
+	if !known {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := eb.client.KeepAliveOnce(ctx, leaseID); err != nil {
+		eb.mut.Lock()
+		delete(eb.leases, name)
+		eb.mut.Unlock()
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Release implements LeaderBackend by revoking the lease backing name's
+// claim, which deletes the key along with it.
+func (eb *EtcdLeaderBackend) Release(name, holder string) error {
+	eb.mut.Lock()
+	leaseID, known := eb.leases[name]
+	delete(eb.leases, name)
+	eb.mut.Unlock()
+
+	if !known {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := eb.client.Revoke(ctx, leaseID)
+	return err
+}
+
+// CurrentHolder implements LeaderBackend.
+func (eb *EtcdLeaderBackend) CurrentHolder(name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := eb.client.Get(ctx, eb.key(name))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", nil
+	}
+
+	return string(resp.Kvs[0].Value), nil
+}
+
+// Close releases the underlying etcd client connection.
+func (eb *EtcdLeaderBackend) Close() error {
+	return eb.client.Close()
+}
@@ -0,0 +1,278 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"crypto/sha256"
+	dbsql "database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// PluginManifestTable records the manifest digest last installed for each
+// plugin, so Verify/VersionDrift can tell a plugin apart from the one an
+// operator originally approved even across restarts.
+const PluginManifestTable = `
+CREATE TABLE IF NOT EXISTS plugins (
+	name    VARCHAR(191) NOT NULL,
+	version VARCHAR(191) NOT NULL DEFAULT "",
+	digest  VARCHAR(64)  NOT NULL DEFAULT "",
+	ts      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY(name)
+)`
+
+// PluginManifest describes what a Plugin is expected to look like: its
+// required secrets/prefs and the brokers it's compatible with, plus the
+// sha256 digest of the signed bundle it was distributed in. Plugin.Register
+// validates a Plugin's actual Secrets/Broker against its Manifest (when
+// set) and refuses to register it if they've drifted, so a plugin whose
+// permissions changed unexpectedly doesn't load silently.
+type PluginManifest struct {
+	Name            string           `json:"name"`
+	Version         string           `json:"version"`
+	RequiredSecrets []string         `json:"required_secrets,omitempty"`
+	RequiredPrefs   []string         `json:"required_prefs,omitempty"`
+	Brokers         []string         `json:"brokers,omitempty"` // compatible broker names; empty means "any"
+	Privileges      PluginPrivileges `json:"privileges,omitempty"`
+	Digest          string           `json:"digest"` // sha256 of the bundle this manifest describes, hex-encoded
+}
+
+// PluginPrivileges declares what a plugin intends to access once it's
+// registered: brokers it'll send/receive through, whether it touches
+// hal.SqlDB() directly, http.HandleFunc prefixes it registers, and
+// external network hosts it calls out to. pluginmgr's remote install flow
+// shows these to the invoking user and requires them to echo back
+// Hash() via "--grant" before the plugin is enabled -- mirroring the
+// "preview privileges, then confirm" step of a Docker plugin install.
+type PluginPrivileges struct {
+	Brokers      []string `json:"brokers,omitempty"`
+	SQL          bool     `json:"sql,omitempty"`
+	HTTPPrefixes []string `json:"http_prefixes,omitempty"`
+	NetworkHosts []string `json:"network_hosts,omitempty"`
+}
+
+// Hash returns a stable hex-encoded sha256 digest of p, used as the
+// token a user must pass back via "--grant" to confirm they've reviewed
+// these exact privileges. json.Marshal on a plain struct like this is
+// deterministic (fields are always encoded in declaration order), so the
+// same privileges always hash the same way.
+func (p PluginPrivileges) Hash() string {
+	data, err := json.Marshal(p)
+	if err != nil {
+		// p is a plain, fully-exported struct of strings/bools/slices --
+		// there's nothing in it json.Marshal can fail to encode.
+		log.Panicf("hal: PluginPrivileges.Hash: impossible Marshal error: %s", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadManifestFile reads and parses a PluginManifest from a local path.
+func LoadManifestFile(path string) (*PluginManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseManifest(data)
+}
+
+// FetchManifestURL fetches and parses a PluginManifest from url.
+func FetchManifestURL(url string) (*PluginManifest, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseManifest(data)
+}
+
+func parseManifest(data []byte) (*PluginManifest, error) {
+	m := PluginManifest{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// ManifestDigestMismatchError is returned by PluginRegistry.InstallFromURL
+// when the downloaded bundle's sha256 doesn't match expectedDigest.
+type ManifestDigestMismatchError struct {
+	Url      string
+	Expected string
+	Got      string
+}
+
+func (e ManifestDigestMismatchError) Error() string {
+	return fmt.Sprintf("digest mismatch fetching %q: expected %s, got %s", e.Url, e.Expected, e.Got)
+}
+
+// PluginCompatibilityError is returned by Plugin.Register when a Plugin's
+// Secrets or Broker don't satisfy its own Manifest.
+type PluginCompatibilityError struct {
+	Plugin string
+	Reason string
+}
+
+func (e PluginCompatibilityError) Error() string {
+	return fmt.Sprintf("plugin %q failed manifest validation: %s", e.Plugin, e.Reason)
+}
+
+// Validate checks that p's Secrets and Broker satisfy m's requirements.
+func (m *PluginManifest) Validate(p *Plugin) error {
+	have := make(map[string]bool, len(p.Secrets))
+	for _, s := range p.Secrets {
+		have[s] = true
+	}
+
+	for _, want := range m.RequiredSecrets {
+		if !have[want] {
+			return PluginCompatibilityError{
+				Plugin: p.Name,
+				Reason: fmt.Sprintf("manifest requires secret %q, which the plugin's Secrets list no longer declares", want),
+			}
+		}
+	}
+
+	if len(m.Brokers) > 0 && p.Broker != nil {
+		ok := false
+		for _, b := range m.Brokers {
+			if b == p.Broker.Name() {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return PluginCompatibilityError{
+				Plugin: p.Name,
+				Reason: fmt.Sprintf("broker %q is not in the manifest's compatible broker list %v", p.Broker.Name(), m.Brokers),
+			}
+		}
+	}
+
+	return nil
+}
+
+// recordManifest upserts a plugin's manifest digest into the plugins
+// table, so a later Verify/VersionDrift call -- possibly after a restart,
+// or from another process -- can detect that a plugin no longer matches
+// what was installed.
+func recordManifest(m *PluginManifest) error {
+	SqlInit(PluginManifestTable)
+
+	db := SqlDB()
+	_, err := db.Exec(`INSERT INTO plugins (name, version, digest) VALUES (?, ?, ?)
+	                    ON DUPLICATE KEY UPDATE version=VALUES(version), digest=VALUES(digest)`,
+		m.Name, m.Version, m.Digest)
+	if err != nil {
+		log.Printf("hal: failed to record manifest for plugin %q: %s", m.Name, err)
+	}
+
+	return err
+}
+
+// InstallFromURL fetches a manifest bundle from url, verifies its sha256
+// digest matches expectedDigest, and records it in the plugins table so a
+// later Verify call can confirm the running plugin still matches what was
+// installed here.
+func (pr *pluginRegistry) InstallFromURL(url, expectedDigest string) (*PluginManifest, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	got := hex.EncodeToString(sum[:])
+	if got != expectedDigest {
+		return nil, ManifestDigestMismatchError{Url: url, Expected: expectedDigest, Got: got}
+	}
+
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordManifest(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Verify compares the Manifest of the currently-registered plugin named
+// name against the digest last recorded in the plugins table (e.g. by
+// InstallFromURL), returning false if they've diverged -- meaning the
+// plugin running in this process isn't the one that was installed.
+func (pr *pluginRegistry) Verify(name string) (bool, error) {
+	p := pr.GetPlugin(name)
+	if p == nil || p.Manifest == nil {
+		return false, fmt.Errorf("no manifest available for plugin %q", name)
+	}
+
+	SqlInit(PluginManifestTable)
+
+	db := SqlDB()
+	var digest string
+	err := db.QueryRow(`SELECT digest FROM plugins WHERE name=?`, name).Scan(&digest)
+	if err == dbsql.ErrNoRows {
+		return false, fmt.Errorf("plugin %q has never been installed via InstallFromURL", name)
+	} else if err != nil {
+		return false, err
+	}
+
+	return digest == p.Manifest.Digest, nil
+}
+
+// VersionDrift returns the names of active plugins (those with registered
+// instances, per ActivePluginList) whose Manifest digest no longer
+// matches what's recorded in the plugins table -- e.g. because the
+// binary was upgraded without going through InstallFromURL.
+func (pr *pluginRegistry) VersionDrift() []string {
+	drifted := make([]string, 0)
+
+	for _, p := range pr.ActivePluginList() {
+		if p.Manifest == nil {
+			continue
+		}
+
+		ok, err := pr.Verify(p.Name)
+		if err != nil || !ok {
+			drifted = append(drifted, p.Name)
+		}
+	}
+
+	return drifted
+}
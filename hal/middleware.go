@@ -0,0 +1,44 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import "time"
+
+// Middleware is a cross-cutting hook the router runs around every plugin
+// dispatch, so features like rate limiting, ACLs, metrics, and audit
+// logging can be added without editing processEvent. See RouterCTX.Use.
+//
+// Before runs, in Priority order, before the matched instance is called.
+// Returning proceed=false stops the chain for this event -- the instance
+// is not dispatched -- and every middleware that already ran still gets
+// its After, in reverse order, like a defer stack. A non-nil err is
+// logged; it doesn't by itself change proceed, so a middleware that wants
+// to block on error should also return proceed=false.
+//
+// inst is nil exactly once per event, when no plugin instance matched --
+// the router's "nothing handled this" hook, used by the built-in
+// !plugin/invalid-command fallback (see fallbackMiddleware). A
+// middleware that only cares about real dispatches should treat inst ==
+// nil as an immediate (true, nil).
+type Middleware interface {
+	// Priority orders the chain: lower runs first in Before and
+	// correspondingly last in After. Middlewares registered with equal
+	// Priority run in registration order (see Use).
+	Priority() int
+	Before(evt *Evt, inst *Instance) (proceed bool, err error)
+	After(evt *Evt, inst *Instance, dur time.Duration, panicVal interface{})
+}
@@ -0,0 +1,59 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"strings"
+	"time"
+)
+
+// ACLAllowUsersKey is the pref key ACLMiddleware checks: a comma-separated
+// list of usernames allowed to trigger a plugin in a room. A plugin/room
+// with no value set is unrestricted, so ACLMiddleware is safe to register
+// by default -- it only starts enforcing once a bot admin sets the pref.
+const ACLAllowUsersKey = "acl-allow-users"
+
+// ACLMiddleware denies a dispatch unless evt.User is listed in that
+// plugin/room's ACLAllowUsersKey pref, looked up through FindPrefs the same
+// way plugins already look up their own settings -- so an ACL composes
+// with the existing user/broker/room/plugin pref scoping instead of
+// needing its own admin surface.
+type ACLMiddleware struct{}
+
+func (ACLMiddleware) Priority() int { return 20 }
+
+func (ACLMiddleware) Before(evt *Evt, inst *Instance) (bool, error) {
+	if inst == nil {
+		return true, nil
+	}
+
+	pref := evt.FindPrefs().FindKey(ACLAllowUsersKey).Room(inst.RoomId).One()
+	if !pref.Success || pref.Value == "" {
+		return true, nil // unrestricted
+	}
+
+	for _, allowed := range strings.Split(pref.Value, ",") {
+		if strings.TrimSpace(allowed) == evt.User {
+			return true, nil
+		}
+	}
+
+	evt.Replyf("%s: you're not allowed to use %q in this room.", evt.User, inst.Plugin.Name)
+	return false, nil
+}
+
+func (ACLMiddleware) After(evt *Evt, inst *Instance, dur time.Duration, panicVal interface{}) {}
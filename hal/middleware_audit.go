@@ -0,0 +1,100 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"time"
+)
+
+// AuditEntry is one structured audit record, published via Audit() from
+// plugin dispatch (AuditMiddleware), the router, pagerduty's Events API
+// client, hal.Secrets mutations, and plugin lifecycle events (see
+// StartPluginEventAuditing). Source identifies which of those produced it
+// ("plugin", "router", "pagerduty", "secret", "plugin_lifecycle"). Body is
+// omitted by default (see AuditMiddleware.LogBody) since commands often
+// carry secrets or PII and most deployments only need who-ran-what-when,
+// not the literal text.
+type AuditEntry struct {
+	Time          time.Time     `json:"time"`
+	Source        string        `json:"source"`
+	Plugin        string        `json:"plugin,omitempty"`
+	Room          string        `json:"room,omitempty"`
+	Broker        string        `json:"broker,omitempty"`
+	User          string        `json:"user,omitempty"`
+	CorrelationId string        `json:"correlation_id,omitempty"`
+	Action        string        `json:"action,omitempty"`
+	Target        string        `json:"target,omitempty"`
+	Outcome       string        `json:"outcome,omitempty"`
+	Severity      Severity      `json:"severity"`
+	Duration      time.Duration `json:"duration,omitempty"`
+	Panicked      bool          `json:"panicked,omitempty"`
+	Body          string        `json:"body,omitempty"`
+}
+
+// AuditMiddleware publishes one AuditEntry per dispatch via Audit(),
+// which fans it out to every configured AuditSink (see SetAuditSinks).
+// Set Emit instead to bypass the sink fan-out entirely, e.g. in tests
+// that want to inspect entries synchronously.
+type AuditMiddleware struct {
+	// LogBody includes evt.Body in every entry. Off by default.
+	LogBody bool
+	// Emit, if set, is called once per dispatch instead of Audit().
+	Emit func(AuditEntry)
+}
+
+func (m *AuditMiddleware) Priority() int { return 950 }
+
+func (m *AuditMiddleware) Before(evt *Evt, inst *Instance) (bool, error) {
+	return true, nil
+}
+
+func (m *AuditMiddleware) After(evt *Evt, inst *Instance, dur time.Duration, panicVal interface{}) {
+	if inst == nil {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:          time.Now(),
+		Source:        "plugin",
+		Plugin:        inst.Plugin.Name,
+		Room:          evt.Room,
+		Broker:        evt.BrokerName(),
+		User:          evt.User,
+		CorrelationId: evt.ThreadID,
+		Action:        inst.Plugin.Name,
+		Outcome:       "ok",
+		Severity:      SeverityInfo,
+		Duration:      dur,
+		Panicked:      panicVal != nil,
+	}
+
+	if panicVal != nil {
+		entry.Outcome = "panicked"
+		entry.Severity = SeverityError
+	}
+
+	if m.LogBody {
+		entry.Body = evt.Body
+	}
+
+	if m.Emit != nil {
+		m.Emit(entry)
+		return
+	}
+
+	Audit(entry)
+}
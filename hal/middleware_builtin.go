@@ -0,0 +1,88 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// recoveryMiddleware reimplements processEvent's previous hardcoded panic
+// handling as the lowest-priority (first-registered) middleware, so it's
+// the outermost layer of the After stack and sees every other
+// middleware's panicVal too. Before is a no-op; the actual recover() has
+// to stay in RouterCTX.dispatch (Go can only recover from the deferring
+// frame), so this is just the policy for what a caught panic means: log
+// it and publish a PluginError.
+type recoveryMiddleware struct{}
+
+func (recoveryMiddleware) Priority() int { return 0 }
+
+func (recoveryMiddleware) Before(evt *Evt, inst *Instance) (bool, error) {
+	return true, nil
+}
+
+func (recoveryMiddleware) After(evt *Evt, inst *Instance, dur time.Duration, panicVal interface{}) {
+	if panicVal == nil || inst == nil {
+		return
+	}
+
+	log.Printf("recovered panic in plugin %q\n", inst.Plugin.Name)
+	log.Printf("panic: %v", panicVal)
+	debug.PrintStack()
+
+	Events().Publish(PluginEvent{
+		Type:     PluginError,
+		Plugin:   inst.Plugin,
+		Instance: inst,
+		Broker:   evt.BrokerName(),
+		Err:      fmt.Errorf("panic in plugin %q: %v", inst.Plugin.Name, panicVal),
+	})
+}
+
+// fallbackMiddleware reimplements processEvent's previous hardcoded
+// "!plugin"/invalid-command handling. The router calls the chain once
+// per event with inst == nil exactly when no plugin instance matched, and
+// fallbackMiddleware is registered last by default so every other
+// middleware gets a chance to act first.
+type fallbackMiddleware struct{}
+
+func (fallbackMiddleware) Priority() int { return 1000 }
+
+func (fallbackMiddleware) Before(evt *Evt, inst *Instance) (bool, error) {
+	if inst != nil || !strings.HasPrefix(strings.TrimSpace(evt.Body), "!") {
+		return true, nil
+	}
+
+	mgr, err := PluginRegistry().GetPlugin("pluginmgr")
+	// only proceed if there is no error - bots may choose to exclude pluginmgr
+	if strings.HasPrefix(strings.TrimSpace(evt.Body), "!plugin") && err == nil {
+		mgrInst := mgr.Instance(evt.RoomId, evt.Broker)
+		evtcpy := *evt
+		evtcpy.instance = mgrInst
+		mgrInst.Func(evtcpy)
+	} else {
+		evt.Replyf("%q: invalid command.", evt.Body)
+	}
+
+	return false, nil
+}
+
+func (fallbackMiddleware) After(evt *Evt, inst *Instance, dur time.Duration, panicVal interface{}) {}
@@ -0,0 +1,90 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// PluginMetric is a point-in-time snapshot of one plugin's dispatch
+// counters, as tracked by MetricsMiddleware.
+type PluginMetric struct {
+	Count  uint64
+	Errors uint64
+	Total  time.Duration
+}
+
+// MetricsMiddleware counts dispatches and accumulates wall time per
+// plugin, in a form a Prometheus Collector (or anything else) can read
+// out via Snapshot -- this package doesn't depend on a metrics library
+// itself. It also republishes BrokerMessageDispatched, which used to be
+// published directly from processEvent before the middleware chain
+// existed.
+type MetricsMiddleware struct {
+	mut     sync.Mutex
+	metrics map[string]*PluginMetric
+}
+
+func NewMetricsMiddleware() *MetricsMiddleware {
+	return &MetricsMiddleware{metrics: make(map[string]*PluginMetric)}
+}
+
+// Priority places MetricsMiddleware after the ACL and rate-limit checks.
+// The dur its After receives is the router's measurement of the whole
+// chain (see RouterCTX.dispatch), so it includes every middleware's Before
+// as well as the plugin's own execution time.
+func (m *MetricsMiddleware) Priority() int { return 900 }
+
+func (m *MetricsMiddleware) Before(evt *Evt, inst *Instance) (bool, error) {
+	return true, nil
+}
+
+func (m *MetricsMiddleware) After(evt *Evt, inst *Instance, dur time.Duration, panicVal interface{}) {
+	if inst == nil {
+		return
+	}
+
+	m.mut.Lock()
+	pm, exists := m.metrics[inst.Plugin.Name]
+	if !exists {
+		pm = &PluginMetric{}
+		m.metrics[inst.Plugin.Name] = pm
+	}
+	pm.Count++
+	pm.Total += dur
+	if panicVal != nil {
+		pm.Errors++
+	}
+	m.mut.Unlock()
+
+	Events().Publish(PluginEvent{Type: BrokerMessageDispatched, Plugin: inst.Plugin, Instance: inst, Broker: evt.BrokerName()})
+}
+
+// Snapshot returns a point-in-time copy of per-plugin counters, keyed by
+// plugin name.
+func (m *MetricsMiddleware) Snapshot() map[string]PluginMetric {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	out := make(map[string]PluginMetric, len(m.metrics))
+	for name, pm := range m.metrics {
+		out[name] = *pm
+	}
+
+	return out
+}
@@ -0,0 +1,116 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitPerMinuteKey and RateLimitBurstKey are the pref keys
+// RateLimitMiddleware reads per plugin. An unset rate (the GetPref default
+// below, "0") means unrestricted; an unset burst defaults to 1. Rate
+// limiting is opt-in per plugin, set with e.g.:
+//
+//	!prefs set --plugin pagerduty --key rate-limit-per-minute --value 10
+//	!prefs set --plugin pagerduty --key rate-limit-burst --value 3
+const RateLimitPerMinuteKey = "rate-limit-per-minute"
+const RateLimitBurstKey = "rate-limit-burst"
+
+// bucket is a minimal token bucket: Tokens refills by rate every minute,
+// capped at burst, and is spent one-per-call in RateLimitMiddleware.Before.
+type bucket struct {
+	tokens   float64
+	rate     float64 // tokens added per minute
+	burst    float64
+	lastFill time.Time
+}
+
+func (b *bucket) take() bool {
+	now := time.Now()
+	elapsed := now.Sub(b.lastFill).Minutes()
+	b.lastFill = now
+
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// RateLimitMiddleware enforces a per-(User, plugin) token bucket, configured
+// via RateLimitPerMinuteKey/RateLimitBurstKey prefs. A plugin instance with
+// no rate limit configured is left unrestricted.
+type RateLimitMiddleware struct {
+	mut     sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewRateLimitMiddleware() *RateLimitMiddleware {
+	return &RateLimitMiddleware{buckets: make(map[string]*bucket)}
+}
+
+func (m *RateLimitMiddleware) Priority() int { return 10 }
+
+func (m *RateLimitMiddleware) Before(evt *Evt, inst *Instance) (bool, error) {
+	if inst == nil {
+		return true, nil
+	}
+
+	ratePref := GetPref("", "", inst.RoomId, inst.Plugin.Name, RateLimitPerMinuteKey, "0")
+	rate, err := strconv.ParseFloat(ratePref.Value, 64)
+	if err != nil || rate <= 0 {
+		return true, nil // unrestricted
+	}
+
+	burstPref := GetPref("", "", inst.RoomId, inst.Plugin.Name, RateLimitBurstKey, "1")
+	burst, err := strconv.ParseFloat(burstPref.Value, 64)
+	if err != nil || burst <= 0 {
+		burst = 1
+	}
+
+	key := evt.User + "." + inst.Plugin.Name
+
+	m.mut.Lock()
+	b, exists := m.buckets[key]
+	if !exists {
+		b = &bucket{tokens: burst, rate: rate, burst: burst, lastFill: time.Now()}
+		m.buckets[key] = b
+	}
+	// the rate/burst may have changed since the bucket was created
+	b.rate = rate
+	b.burst = burst
+	allowed := b.take()
+	m.mut.Unlock()
+
+	if !allowed {
+		evt.Replyf("%s: you're sending %q too quickly, slow down.", evt.User, inst.Plugin.Name)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (m *RateLimitMiddleware) After(evt *Evt, inst *Instance, dur time.Duration, panicVal interface{}) {
+}
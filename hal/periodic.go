@@ -0,0 +1,212 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// PeriodicFunc runs Function on a ticker every Interval, from Start()
+// until Stop(). Plugins register one per recurring background task (e.g.
+// pagerduty's cache/topic updaters) so !oncall cache-status and friends
+// can report on it by name via GetPeriodicFunc.
+type PeriodicFunc struct {
+	Name     string        // unique name; also the name its LeaderOnly lease is filed under
+	Interval time.Duration // how often Function runs
+	Function func()        // the work to do each tick
+
+	// LeaderOnly restricts Function to running on whichever hal-9001
+	// process currently holds the Name lease (see Leader), so running
+	// several processes for redundancy doesn't mean all of them hammer
+	// the same upstream API or fight over the same side effect (e.g.
+	// pagerduty's SetTopic). Followers keep renewing their bid for
+	// leadership in the background and take over within about a third of
+	// LeaseTTL of the leader resigning (see Stop), or of its lease expiring
+	// if it goes away without resigning.
+	LeaderOnly bool
+	// LeaseTTL is how long a leadership claim lasts between renewals when
+	// LeaderOnly is set. Defaults to 3*Interval, which keeps renewal
+	// comfortably inside the lease window at the Leader's TTL/3 cadence.
+	LeaseTTL time.Duration
+
+	mut    sync.Mutex
+	stop   chan struct{}
+	last   time.Time
+	status string
+	leader *Leader
+}
+
+var (
+	periodicFuncsMut sync.Mutex
+	periodicFuncs    = make(map[string]*PeriodicFunc)
+)
+
+// Register makes pf discoverable by name via GetPeriodicFunc, and restores
+// Last() from whatever GetKV/SetKV backend is configured (see SetStore),
+// so a restart doesn't forget when it last ran.
+func (pf *PeriodicFunc) Register() {
+	if v, err := GetKV(periodicLastKey(pf.Name)); err == nil {
+		if t, perr := time.Parse(time.RFC3339, v); perr == nil {
+			pf.mut.Lock()
+			pf.last = t
+			pf.mut.Unlock()
+		}
+	}
+
+	periodicFuncsMut.Lock()
+	defer periodicFuncsMut.Unlock()
+
+	periodicFuncs[pf.Name] = pf
+}
+
+func periodicLastKey(name string) string {
+	return "hal.periodic." + name + ".last"
+}
+
+// GetPeriodicFunc looks up a registered PeriodicFunc by name, returning nil
+// if none is registered under that name.
+func GetPeriodicFunc(name string) *PeriodicFunc {
+	periodicFuncsMut.Lock()
+	defer periodicFuncsMut.Unlock()
+
+	return periodicFuncs[name]
+}
+
+// ListPeriodicFuncs returns a snapshot of every registered PeriodicFunc,
+// used by "!hal leaders" to report on all of them by name.
+func ListPeriodicFuncs() []*PeriodicFunc {
+	periodicFuncsMut.Lock()
+	defer periodicFuncsMut.Unlock()
+
+	out := make([]*PeriodicFunc, 0, len(periodicFuncs))
+	for _, pf := range periodicFuncs {
+		out = append(out, pf)
+	}
+
+	return out
+}
+
+// Start runs Function every Interval in a background goroutine until Stop
+// is called. Calling Start again while already running is a no-op.
+func (pf *PeriodicFunc) Start() {
+	pf.mut.Lock()
+	if pf.stop != nil {
+		pf.mut.Unlock()
+		return
+	}
+	pf.stop = make(chan struct{})
+	pf.status = "running"
+	stop := pf.stop
+
+	if pf.LeaderOnly {
+		ttl := pf.LeaseTTL
+		if ttl <= 0 {
+			ttl = pf.Interval * 3
+		}
+		pf.leader = NewLeader(pf.Name, ttl)
+		pf.leader.Campaign(nil, nil)
+	}
+	pf.mut.Unlock()
+
+	go pf.run(stop)
+}
+
+func (pf *PeriodicFunc) run(stop chan struct{}) {
+	ticker := time.NewTicker(pf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			pf.mut.Lock()
+			leader := pf.leader
+			pf.mut.Unlock()
+
+			if pf.LeaderOnly && (leader == nil || !leader.IsLeader()) {
+				continue
+			}
+
+			pf.mut.Lock()
+			pf.last = now
+			pf.mut.Unlock()
+
+			if err := SetKV(periodicLastKey(pf.Name), now.Format(time.RFC3339), 0); err != nil {
+				log.Printf("hal: failed to persist last-run time for periodic func %q: %s", pf.Name, err)
+			}
+
+			pf.Function()
+		}
+	}
+}
+
+// Stop ends the background goroutine started by Start and, if LeaderOnly
+// is set, resigns its leadership claim immediately rather than leaving it
+// to expire.
+func (pf *PeriodicFunc) Stop() {
+	pf.mut.Lock()
+	stop := pf.stop
+	pf.stop = nil
+	pf.status = "stopped"
+	leader := pf.leader
+	pf.leader = nil
+	pf.mut.Unlock()
+
+	if stop != nil {
+		close(stop)
+	}
+
+	if leader != nil {
+		leader.Resign()
+	}
+}
+
+// Last returns the time of the most recent tick Function ran for, the zero
+// value if it hasn't run yet. Ticks skipped because this process isn't
+// the LeaderOnly leader don't update it.
+func (pf *PeriodicFunc) Last() time.Time {
+	pf.mut.Lock()
+	defer pf.mut.Unlock()
+
+	return pf.last
+}
+
+// Status returns "running", "stopped", or "" if Start has never been
+// called.
+func (pf *PeriodicFunc) Status() string {
+	pf.mut.Lock()
+	defer pf.mut.Unlock()
+
+	return pf.status
+}
+
+// IsLeader reports whether this process currently holds the lease for pf
+// and would actually run Function on its next tick. Always true for a
+// PeriodicFunc that isn't LeaderOnly, since every process runs those.
+func (pf *PeriodicFunc) IsLeader() bool {
+	pf.mut.Lock()
+	defer pf.mut.Unlock()
+
+	if !pf.LeaderOnly {
+		return true
+	}
+
+	return pf.leader != nil && pf.leader.IsLeader()
+}
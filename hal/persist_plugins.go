@@ -6,12 +6,13 @@ import (
 
 const PLUGIN_INST_TABLE = `
 CREATE TABLE IF NOT EXISTS plugin_instances (
+	id      varchar(255) NOT NULL,
 	plugin  varchar(255) NOT NULL,
 	broker  varchar(255) NOT NULL,
 	room    varchar(255) NOT NULL,
 	regex   varchar(255) NOT NULL DEFAULT "",
 	ts      TIMESTAMP,
-	PRIMARY KEY(plugin, broker, room)
+	PRIMARY KEY(id)
 )
 `
 
@@ -26,7 +27,7 @@ func (pr *pluginRegistry) LoadInstances() error {
 	SqlInit(PLUGIN_INST_TABLE)
 
 	db := SqlDB()
-	rows, err := db.Query(`SELECT plugin, broker, room, regex FROM plugin_instances`)
+	rows, err := db.Query(`SELECT id, plugin, broker, room, regex FROM plugin_instances`)
 	if err != nil {
 		log.Printf("LoadInstances SQL query failed: %s", err)
 		return err
@@ -34,49 +35,49 @@ func (pr *pluginRegistry) LoadInstances() error {
 
 	defer rows.Close()
 
-	var pname, bname, roomId, re string
+	var id, pname, bname, roomId, re string
 	for rows.Next() {
-		err := rows.Scan(&pname, &bname, &roomId, &re)
+		err := rows.Scan(&id, &pname, &bname, &roomId, &re)
 		if err != nil {
 			log.Printf("LoadInstances rows.Scan() failed: %s", err)
 			return err
 		}
 
-		// check to see if there is already a runtime instance, create it
-		// if it doesn't exist
-		found := pr.FindInstances(pname, bname, roomId)
-		if len(found) == 0 {
-			// instance is in the DB but not registered, do it now
-			plugin := pr.GetPlugin(pname)
-			if plugin == nil {
-				log.Printf("%q is configured in the database but is not registered. Ignoring.", pname)
-				continue
-			}
-
-			broker := Router().GetBroker(bname)
-			if broker == nil {
-				log.Fatalf("Broker %q does not exist.", bname)
-			}
-
-			inst := plugin.Instance(roomId, broker)
-			inst.Regex = re // RE can be overridden per instance
-
-			// go over the settings and pull preferences before firing up the instance
-			inst.LoadSettingsFromPrefs()
-
-			err = inst.Register()
-			if err != nil {
-				log.Printf("Could not register plugin instance for plugin %q and room id %q: %s",
-					pname, roomId, err)
-				return err
-			}
-		} else if len(found) == 1 {
+		// an id uniquely identifies a saved instance, so use it (rather
+		// than plugin+broker+room, which can now match more than one
+		// instance -- see Instance.Id) to decide if it's already running
+		if pr.FindInstanceById(id) != nil {
 			// already there, move on
 			continue
-		} else {
-			log.Fatalf("BUG: more than 1 plugin instance matched for plugin %q and room id %q",
-				pname, roomId)
 		}
+
+		// instance is in the DB but not registered, do it now
+		plugin := pr.GetPlugin(pname)
+		if plugin == nil {
+			log.Printf("%q is configured in the database but is not registered. Ignoring.", pname)
+			continue
+		}
+
+		broker := Router().GetBroker(bname)
+		if broker == nil {
+			log.Fatalf("Broker %q does not exist.", bname)
+		}
+
+		inst := plugin.Instance(roomId, broker)
+		inst.Id = id    // preserve the saved id across restarts
+		inst.Regex = re // RE can be overridden per instance
+
+		// go over the settings and pull preferences before firing up the instance
+		inst.LoadSettingsFromPrefs()
+
+		err = inst.Register()
+		if err != nil {
+			log.Printf("Could not register plugin instance for plugin %q and room id %q: %s",
+				pname, roomId, err)
+			return err
+		}
+
+		Events().Publish(PluginEvent{Type: InstanceLoadedFromDB, Plugin: plugin, Instance: inst})
 	}
 
 	log.Println("Done loading plugin instances.")
@@ -97,14 +98,14 @@ func (pr *pluginRegistry) SaveInstances() error {
 	db := SqlDB()
 	tx, err := db.Begin()
 	stmt, err := tx.Prepare(`INSERT INTO plugin_instances
-	                          (plugin, broker, room, regex)
-	                         VALUES (?, ?, ?, ?)`)
+	                          (id, plugin, broker, room, regex)
+	                         VALUES (?, ?, ?, ?, ?)`)
 
 	// clear the table before writing new records
 	_, err = tx.Exec("TRUNCATE TABLE plugin_instances")
 
 	for _, inst := range instances {
-		_, err = stmt.Exec(inst.Plugin.Name, inst.Broker.Name(), inst.RoomId, inst.Regex)
+		_, err = stmt.Exec(inst.Id, inst.Plugin.Name, inst.Broker.Name(), inst.RoomId, inst.Regex)
 		if err != nil {
 			log.Printf("insert failed: %s", err)
 			return err
@@ -117,5 +118,9 @@ func (pr *pluginRegistry) SaveInstances() error {
 		return err
 	}
 
+	for _, inst := range instances {
+		Events().Publish(PluginEvent{Type: InstanceSaved, Plugin: inst.Plugin, Instance: inst})
+	}
+
 	return nil
 }
@@ -0,0 +1,145 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// PluginHandlerFunc is the shape of a plugin's Func -- the innermost link
+// in a PluginMiddleware chain.
+type PluginHandlerFunc func(Evt)
+
+// PluginMiddleware wraps a PluginHandlerFunc with another one, isolating
+// one plugin's dispatch the way Middleware (see router.go) isolates the
+// whole router. Unlike Middleware, it's attached per-Plugin via Use
+// instead of registered globally, for concerns only one plugin cares
+// about.
+type PluginMiddleware func(next PluginHandlerFunc) PluginHandlerFunc
+
+// Use appends mw to the plugin's middleware chain, applied around Func on
+// every dispatch. The first one added sits closest to hal's recovery
+// built-in (outermost among Use()'d middleware); the last one added runs
+// immediately before Func. Plugins reach for this for their own
+// dispatch-time concerns a global hal.Middleware shouldn't have to know
+// about, e.g. pagerduty rate-limiting !page specifically.
+func (p *Plugin) Use(mw ...PluginMiddleware) *Plugin {
+	p.Middleware = append(p.Middleware, mw...)
+	return p
+}
+
+// DefaultPluginTimeout is the deadline timeoutPluginMiddleware applies to
+// Func when the "plugin.<name>.timeout" pref isn't set.
+const DefaultPluginTimeout = 30 * time.Second
+
+// pluginMiddlewareTimeout reads the plugin.<name>.timeout pref, falling
+// back to DefaultPluginTimeout when it's unset or unparseable. This is
+// intentionally a single bot-wide value keyed only by plugin name, unlike
+// pluginTimeout in ctx_dispatch.go (per-instance/room, and only consulted
+// for CtxFunc plugins) -- legacy Func plugins have no per-room settings to
+// hang a deadline override on.
+func pluginMiddlewareTimeout(name string) time.Duration {
+	key := fmt.Sprintf("plugin.%s.timeout", name)
+
+	pref := GetPref("", "", "", "", key, "")
+	if pref.Value == "" {
+		return DefaultPluginTimeout
+	}
+
+	d, err := time.ParseDuration(pref.Value)
+	if err != nil {
+		log.Printf("hal: invalid %s pref %q: %s", key, pref.Value, err)
+		return DefaultPluginTimeout
+	}
+
+	return d
+}
+
+// recoverPluginMiddleware is the innermost built-in wrapped around every
+// plugin's Func: it recover()s a panic, logs the stack, publishes a
+// PluginError (the same event recoveryMiddleware publishes for a
+// router-level panic), and replies to the room with a short
+// apology so a user isn't left staring at silence.
+func recoverPluginMiddleware(p *Plugin) PluginMiddleware {
+	return func(next PluginHandlerFunc) PluginHandlerFunc {
+		return func(evt Evt) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("recovered panic in plugin %q: %v", p.Name, r)
+					debug.PrintStack()
+
+					Events().Publish(PluginEvent{
+						Type:   PluginError,
+						Plugin: p,
+						Broker: evt.BrokerName(),
+						Err:    fmt.Errorf("panic in plugin %q: %v", p.Name, r),
+					})
+
+					evt.Replyf("Sorry, %q ran into a problem and couldn't finish.", p.Name)
+				}
+			}()
+
+			next(evt)
+		}
+	}
+}
+
+// timeoutPluginMiddleware is the outermost built-in wrapped around every
+// plugin's Func: it runs the rest of the chain (recovery, any Use()'d
+// middleware, and Func itself) in a goroutine, and if that doesn't finish
+// within pluginMiddlewareTimeout, logs the stuck invocation and returns
+// without waiting for it. The goroutine is abandoned, not killed -- Go has
+// no way to forcibly stop one -- so this bounds how long a slow plugin
+// blocks the router, not how long it actually runs.
+func timeoutPluginMiddleware(p *Plugin) PluginMiddleware {
+	return func(next PluginHandlerFunc) PluginHandlerFunc {
+		return func(evt Evt) {
+			timeout := pluginMiddlewareTimeout(p.Name)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				next(evt)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(timeout):
+				log.Printf("hal: plugin %q timed out after %s, abandoning the invocation", p.Name, timeout)
+			}
+		}
+	}
+}
+
+// buildChain wraps p.Func with hal's recovery/timeout built-ins plus
+// whatever was added via Use, and returns the composed handler dispatch
+// should call instead of p.Func directly.
+func (p *Plugin) buildChain() PluginHandlerFunc {
+	handler := PluginHandlerFunc(p.Func)
+
+	for i := len(p.Middleware) - 1; i >= 0; i-- {
+		handler = p.Middleware[i](handler)
+	}
+
+	handler = recoverPluginMiddleware(p)(handler)
+	handler = timeoutPluginMiddleware(p)(handler)
+
+	return handler
+}
@@ -1,10 +1,14 @@
 package hal
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"regexp"
 	"sync"
+	"sync/atomic"
 )
 
 // pluginRegistry contains the plugin registration data as a singleton
@@ -20,13 +24,33 @@ type pluginRegistry struct {
 // to receive events when an instance is created e.g. by the pluginmgr
 // plugin.
 type Plugin struct {
-	Name     string          // a unique name (used to launch instances)
-	Func     func(Evt)       // the code to execute for each matched event
-	Init     func(*Instance) // plugin hook called at instance creation time
-	Regex    string          // the default regex match
-	Broker   Broker          // the broker the plugin is tied to
-	Settings []Pref          // required+autoloaded preferences + defaults
-	Secrets  []string        // required+autoloaded secret key names
+	Name       string                     // a unique name (used to launch instances)
+	Func       func(Evt)                  // the code to execute for each matched event
+	CtxFunc    func(context.Context, Evt) // like Func, but cancellable/deadline-aware; takes priority over Func when set
+	Init       func(*Instance)            // plugin hook called at instance creation time
+	Regex      string                     // the default regex match
+	Broker     Broker                     // the broker the plugin is tied to
+	Settings   []Pref                     // required+autoloaded preferences + defaults
+	Secrets    []string                   // required+autoloaded secret key names
+	Jobs       []JobSpec                  // scheduled/deferred work auto-registered via JobRegistrar at Instance.Register time
+	Manifest   *PluginManifest            // optional; if set, Register validates Secrets/Broker against it and records its digest
+	Middleware []PluginMiddleware         // per-plugin interceptor chain around Func; see Use
+}
+
+// dispatch calls CtxFunc if set, deriving a context from the instance's
+// plugin-timeout pref, and otherwise falls back to Func -- wrapped in
+// hal's recovery/timeout PluginMiddleware built-ins plus whatever the
+// plugin added via Use -- for plugins that haven't been migrated to the
+// context-aware signature yet.
+func (inst *Instance) dispatch(evt Evt) {
+	if inst.Plugin.CtxFunc == nil {
+		inst.Plugin.buildChain()(evt)
+		return
+	}
+
+	dispatchWithContext(inst, func(ctx context.Context) {
+		inst.Plugin.CtxFunc(ctx, evt)
+	})
 }
 
 // Instance is an instance of a plugin tied to a channel.
@@ -35,9 +59,31 @@ type Instance struct {
 	ChannelId string         // channel name
 	Regex     string         // a regex for filtering messages
 	Settings  []Pref         // runtime settings for the instance
+	Id        string         // short generated id, e.g. "uptime.a1b2" -- see newInstanceId
 	regex     *regexp.Regexp // the compiled regex
 }
 
+// instanceIdSeq is a fallback source of uniqueness for newInstanceId if
+// crypto/rand ever fails to read, which in practice should never happen.
+var instanceIdSeq uint64
+
+// newInstanceId generates a short, human-typeable id for a new instance of
+// pluginName, e.g. "uptime.a1b2". It only needs to be unique among
+// instances of the same plugin, since callers that need to disambiguate
+// (e.g. pluginmgr's "!plugin detach --id") already know which plugin
+// they're talking about. This is what lets the same plugin be attached to
+// one room more than once, each with its own regex/room binding -- see
+// plugins/pluginmgr's attachPluginRef/detachPluginRef.
+func newInstanceId(pluginName string) string {
+	buf := make([]byte, 2)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("newInstanceId: crypto/rand.Read failed, falling back to a sequence number: %s", err)
+		return fmt.Sprintf("%s.%08x", pluginName, atomic.AddUint64(&instanceIdSeq, 1))
+	}
+
+	return fmt.Sprintf("%s.%s", pluginName, hex.EncodeToString(buf))
+}
+
 var pluginRegSingleton pluginRegistry
 
 func PluginRegistry() *pluginRegistry {
@@ -62,18 +108,58 @@ func (p *Plugin) Register() error {
 		}
 	}
 
+	if p.Manifest != nil {
+		if err := p.Manifest.Validate(p); err != nil {
+			log.Printf("Refusing to register plugin '%s': %s", p.Name, err)
+			return err
+		}
+
+		if err := recordManifest(p.Manifest); err != nil {
+			log.Printf("Failed to record manifest for plugin '%s': %s", p.Name, err)
+		}
+	}
+
 	pr.plugins = append(pr.plugins, p)
 
+	Events().Publish(PluginEvent{Type: PluginRegistered, Plugin: p})
+
 	return nil
 }
 
+// ReplacePlugin swaps the registered Plugin named name for newPlugin in
+// place, keeping its position in the registry. It does not touch any
+// Instance -- those still point at the old *Plugin via their embedded
+// pointer until the caller re-creates them against newPlugin (see
+// plugins/pluginmgr's upgrade flow, which Unregisters/re-Registers every
+// instance around the swap so no event dispatches through a
+// half-upgraded plugin). Returns an error if no plugin named name is
+// currently registered.
+func (pr *pluginRegistry) ReplacePlugin(name string, newPlugin *Plugin) error {
+	pr.mut.Lock()
+	defer pr.mut.Unlock()
+
+	for i, p := range pr.plugins {
+		if p.Name == name {
+			pr.plugins[i] = newPlugin
+			Events().Publish(PluginEvent{Type: PluginReplaced, Plugin: newPlugin})
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no plugin named %q is registered", name)
+}
+
 // Instance creates an instance of a plugin. It is *not* registered (and
-// therefore not considered by the router until that is done).
+// therefore not considered by the router until that is done). It's
+// assigned a fresh Id (see newInstanceId); a caller restoring an instance
+// from the database (see LoadInstances) should overwrite it with the
+// saved one instead so the id survives restarts.
 func (p *Plugin) Instance(channelId string) *Instance {
 	i := Instance{
 		Plugin:    p,
 		ChannelId: channelId,
 		Regex:     p.Regex,
+		Id:        newInstanceId(p.Name),
 	}
 
 	return &i
@@ -99,6 +185,14 @@ func (inst *Instance) Register() error {
 		inst.Plugin.Init(inst)
 	}
 
+	// hand the plugin's scheduled/deferred jobs, if any, off to whatever
+	// scheduler called hal.JobRegistrar = ... at startup (see hal/scheduler)
+	if JobRegistrar != nil {
+		for _, job := range inst.Plugin.Jobs {
+			JobRegistrar(inst, job)
+		}
+	}
+
 	// once an instance is registered, the router will automatically
 	// pick it up on the next message it processes
 	pr.instances = append(pr.instances, inst)
@@ -106,6 +200,8 @@ func (inst *Instance) Register() error {
 	log.Printf("Registered plugin '%s' in channel id '%s' with RE match '%s'",
 		inst.Name, inst.ChannelId, inst.regex)
 
+	Events().Publish(PluginEvent{Type: InstanceRegistered, Plugin: inst.Plugin, Instance: inst})
+
 	return nil
 }
 
@@ -129,6 +225,8 @@ func (inst *Instance) Unregister() error {
 
 	log.Printf("Unregistered plugin '%s' from channel id '%s'", inst.Name, inst.ChannelId)
 
+	Events().Publish(PluginEvent{Type: InstanceUnregistered, Plugin: inst.Plugin, Instance: inst})
+
 	return nil
 }
 
@@ -149,6 +247,8 @@ func (inst *Instance) LoadSettingsFromPrefs() {
 		ipref := ppref.Get()
 		inst.Settings[i] = ipref
 	}
+
+	Events().Publish(PluginEvent{Type: SettingsChanged, Plugin: inst.Plugin, Instance: inst})
 }
 
 // SaveSettingsToPrefs saves runtime instance preferences to the prefs
@@ -161,6 +261,8 @@ func (inst *Instance) SaveSettingsToPrefs() {
 	for _, ipref := range inst.Settings {
 		ipref.Set()
 	}
+
+	Events().Publish(PluginEvent{Type: SettingsChanged, Plugin: inst.Plugin, Instance: inst})
 }
 
 func (inst *Instance) BrokerName() string {
@@ -206,7 +308,10 @@ func (pr *pluginRegistry) GetPlugin(name string) *Plugin {
 }
 
 // FindInstances returns the plugin instances that match the provided
-// channel id and plugin name.
+// channel id and plugin name. Now that a channel can have more than one
+// instance of the same plugin (see Instance.Id), this can return more
+// than one result -- callers that need to act on exactly one should have
+// the user disambiguate with an Id and use FindInstanceById instead.
 func (pr *pluginRegistry) FindInstances(channelId, plugin string) []*Instance {
 	pr.mut.Lock()
 	defer pr.mut.Unlock()
@@ -222,6 +327,22 @@ func (pr *pluginRegistry) FindInstances(channelId, plugin string) []*Instance {
 	return out
 }
 
+// FindInstanceById returns the instance with the given Id, or nil if none
+// is registered. Unlike FindInstances, an Id always identifies at most
+// one instance.
+func (pr *pluginRegistry) FindInstanceById(id string) *Instance {
+	pr.mut.Lock()
+	defer pr.mut.Unlock()
+
+	for _, i := range pr.instances {
+		if i.Id == id {
+			return i
+		}
+	}
+
+	return nil
+}
+
 // ActivePluginList returns a list of plugins that have registered instances.
 func (pr *pluginRegistry) ActivePluginList() []*Plugin {
 	out := make([]*Plugin, 0)
@@ -0,0 +1,107 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"strings"
+	"sync"
+)
+
+// ReactionMatch narrows which reactions a handler registered via
+// OnReaction receives. Emoji is required (an exact match, e.g. "+1",
+// "runbook"); Room/Broker are optional and, when set, restrict delivery
+// to that room/broker.
+type ReactionMatch struct {
+	Emoji  string
+	Room   string // "" means any room
+	Broker string // "" means any broker
+}
+
+// ReactionContext accompanies the Evt passed to a ReactionHandler: the
+// body of the message the reaction was added to, and how many times each
+// reaction is currently attached to that message. Whoever dispatches (see
+// plugins/archive's archiveReaction) is responsible for looking both up.
+type ReactionContext struct {
+	MessageBody string
+	Counts      map[string]int // reaction name -> count on this message
+}
+
+// ReactionHandler is called with the Evt that carried a matched reaction
+// and a ReactionContext describing the message it landed on.
+type ReactionHandler func(Evt, ReactionContext)
+
+type reactionSub struct {
+	match   ReactionMatch
+	handler ReactionHandler
+}
+
+// reactionRegistry is the process-wide list of OnReaction subscriptions.
+// It mirrors interactionRegistry's shape (mutex-guarded, sync.Once init),
+// but holds a slice rather than a map since more than one handler can
+// match the same reaction.
+type reactionRegistry struct {
+	subs []reactionSub
+	mut  sync.Mutex
+	init sync.Once
+}
+
+var reactionRegSingleton reactionRegistry
+
+// ReactionRegistry returns the process-wide reaction subscription
+// registry.
+func ReactionRegistry() *reactionRegistry {
+	reactionRegSingleton.init.Do(func() {})
+
+	return &reactionRegSingleton
+}
+
+// OnReaction registers handler to be called for every reaction matching
+// pattern -- see ReactionMatch. Plugins that observe reactions (e.g.
+// plugins/archive's archiveReaction) call DispatchReaction once per
+// reaction event after recording it, so every interested plugin doesn't
+// have to re-implement its own emoji/room/broker filtering.
+func OnReaction(pattern ReactionMatch, handler ReactionHandler) {
+	reg := ReactionRegistry()
+
+	reg.mut.Lock()
+	defer reg.mut.Unlock()
+
+	reg.subs = append(reg.subs, reactionSub{match: pattern, handler: handler})
+}
+
+// DispatchReaction calls every handler OnReaction registered whose
+// ReactionMatch covers reaction/evt, passing ctx along.
+func (reg *reactionRegistry) DispatchReaction(reaction string, evt Evt, ctx ReactionContext) {
+	reg.mut.Lock()
+	subs := make([]reactionSub, len(reg.subs))
+	copy(subs, reg.subs)
+	reg.mut.Unlock()
+
+	for _, sub := range subs {
+		if sub.match.Emoji != "" && sub.match.Emoji != reaction {
+			continue
+		}
+		if sub.match.Room != "" && sub.match.Room != evt.RoomId {
+			continue
+		}
+		if sub.match.Broker != "" && !strings.EqualFold(sub.match.Broker, evt.BrokerName()) {
+			continue
+		}
+
+		sub.handler(evt, ctx)
+	}
+}
@@ -0,0 +1,62 @@
+package hal
+
+// RichContent is a broker-agnostic description of a formatted message: a
+// title, a body, optionally color-coded fields, and action buttons. Plugins
+// build one of these instead of reaching for broker-specific types (e.g.
+// slack.Attachment), and brokers that support rich rendering translate it
+// to their native format; brokers that don't can always fall back to
+// RichContent.String() for plain text.
+type RichContent struct {
+	Title     string // short heading, e.g. a command name or alert title
+	TitleLink string // URL the title should link to, if any
+	Body      string // main text, may contain the broker's markdown dialect
+	Color     string // severity hint: "good", "warning", "danger", or a hex color
+	Markdown  bool   // whether Body/Fields should be interpreted as markdown
+	Fields    []RichField
+	Actions   []RichAction
+}
+
+// RichField is a single label/value pair, typically rendered side-by-side
+// in a grid (e.g. Slack attachment fields).
+type RichField struct {
+	Title string
+	Value string
+	Short bool // hint that the field is short enough to sit next to another
+}
+
+// RichAction is a clickable button attached to the message. Name identifies
+// the action to the plugin that registered it (e.g. via the interactive
+// components subsystem); Value is passed back verbatim when clicked.
+type RichAction struct {
+	Name  string
+	Text  string
+	Value string
+	Style string // "default", "primary", or "danger"
+}
+
+// Severity hints accepted by RichContent.Color.
+const (
+	SeverityGood    = "good"
+	SeverityWarning = "warning"
+	SeverityDanger  = "danger"
+)
+
+// String renders a plain-text degradation of the rich content, used by
+// brokers (or broker modes) that have no richer representation available.
+func (rc RichContent) String() string {
+	out := ""
+
+	if rc.Title != "" {
+		out += rc.Title + "\n"
+	}
+
+	if rc.Body != "" {
+		out += rc.Body + "\n"
+	}
+
+	for _, f := range rc.Fields {
+		out += f.Title + ": " + f.Value + "\n"
+	}
+
+	return out
+}
@@ -18,20 +18,21 @@ package hal
 
 import (
 	"fmt"
-	"log"
-	"runtime/debug"
-	"strings"
+	"sort"
 	"sync"
+	"time"
 )
 
 // RouterCTX holds the router's context, including input/output chans.
 type RouterCTX struct {
-	brokers map[string]Broker
-	in      chan *Evt     // messages from brokers --> plugins
-	out     chan *Evt     // messages from plugins --> brokers
-	update  chan struct{} // to notify the router that the instance list changed
-	mut     sync.Mutex
-	init    sync.Once
+	brokers     map[string]Broker
+	in          chan *Evt     // messages from brokers --> plugins
+	out         chan *Evt     // messages from plugins --> brokers
+	update      chan struct{} // to notify the router that the instance list changed
+	middleware  []Middleware  // see Use/Middleware
+	valueMapper func(string) string
+	mut         sync.Mutex
+	init        sync.Once
 }
 
 type fwdBroker struct {
@@ -49,11 +50,64 @@ func Router() *RouterCTX {
 		routerSingleton.out = make(chan *Evt, 1000)
 		routerSingleton.update = make(chan struct{}, 1)
 		routerSingleton.brokers = make(map[string]Broker)
+
+		// preserve processEvent's pre-middleware behavior out of the box:
+		// panic recovery and the !plugin/invalid-command fallback.
+		routerSingleton.Use(recoveryMiddleware{})
+		routerSingleton.Use(fallbackMiddleware{})
 	})
 
 	return &routerSingleton
 }
 
+// Use registers mw in the router's middleware chain. The chain runs in
+// Priority order (lowest first in Before, highest first in After);
+// middlewares registered with equal Priority keep registration order. See
+// Middleware.
+func (r *RouterCTX) Use(mw Middleware) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.middleware = append(r.middleware, mw)
+	sort.SliceStable(r.middleware, func(i, j int) bool {
+		return r.middleware[i].Priority() < r.middleware[j].Priority()
+	})
+}
+
+// SetValueMapper installs fn as the process-wide default Cmd.ValueMapper,
+// applied to a parameter's value token by any Cmd that hasn't set its own
+// via (*Cmd).SetValueMapper. Plugins needing consistent behavior across
+// every command -- e.g. redacting secrets via a broker-provided lookup --
+// should install it once here instead of on each Cmd individually.
+func (r *RouterCTX) SetValueMapper(fn func(string) string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.valueMapper = fn
+}
+
+// ValueMapper returns the process-wide default installed by
+// SetValueMapper, or nil if none was set.
+func (r *RouterCTX) ValueMapper() func(string) string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.valueMapper
+}
+
+// middlewareChain returns a snapshot of the registered middleware, safe to
+// range over without holding the router's lock for the duration of a
+// dispatch.
+func (r *RouterCTX) middlewareChain() []Middleware {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	out := make([]Middleware, len(r.middleware))
+	copy(out, r.middleware)
+
+	return out
+}
+
 // forwardChan forwards events from one chan of to another.
 // TODO: figure out if this needs to check for closed channels, etc.
 func forwardChan(from, to chan *Evt) {
@@ -86,6 +140,16 @@ func (r *RouterCTX) AddBroker(b Broker) {
 	r.brokers[b.Name()] = b
 }
 
+// Inject enqueues evt directly onto the router's input channel, as if it
+// had just arrived from a Broker's Stream. It's the extension point for
+// producers that aren't full Brokers -- e.g. a webhook receiver that wants
+// to re-publish an upstream event so plugin regexes can match against it --
+// and is otherwise equivalent to what AddBroker's forwardChan goroutine
+// does for real brokers.
+func (r *RouterCTX) Inject(evt *Evt) {
+	r.in <- evt
+}
+
 // GetBroker retrieves a broker handle by name.
 func (r *RouterCTX) GetBroker(name string) Broker {
 	r.mut.Lock()
@@ -129,31 +193,32 @@ func (r *RouterCTX) Route() {
 
 // processEvent processes one event and is intended to run in a goroutine.
 func (r *RouterCTX) processEvent(evt *Evt) {
-	var pname string // must be in the recovery handler's scope
+	Audit(AuditEntry{
+		Source:        "router",
+		Room:          evt.Room,
+		Broker:        evt.BrokerName(),
+		User:          evt.User,
+		CorrelationId: evt.ThreadID,
+		Action:        "dispatch",
+		Outcome:       "received",
+		Severity:      SeverityInfo,
+	})
 
-	// detect invalid commands & count executions
-	var ranPlugins int
+	if handleCancelCommand(evt) {
+		return
+	}
+
+	if handleHalCommand(evt) {
+		return
+	}
 
 	// get a snapshot of the instance list
 	// TODO: keep an eye on the cost of copying this list for every message
-	pr := PluginRegistry()
-	instances := pr.InstanceList()
-
-	// if a plugin panics, catch it & log it
-	// TODO: report errors to a channel?
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("recovered panic in plugin %q\n", pname)
-			log.Printf("panic: %q", r)
-			debug.PrintStack()
-		}
-	}()
+	instances := PluginRegistry().InstanceList()
 
-	for _, inst := range instances {
-		// the recovery handler will pick this up in a panic to provide
-		// the name of the plugin that caused the panic
-		pname = inst.Plugin.Name
+	var ranPlugins int
 
+	for _, inst := range instances {
 		// check if it's the correct room
 		if evt.RoomId != inst.RoomId {
 			continue
@@ -170,25 +235,57 @@ func (r *RouterCTX) processEvent(evt *Evt) {
 			// it can access its fields for settings, etc.
 			evtcpy.instance = inst
 
-			// call the plugin function
-			// this may block other plugins from processing the same event but
-			// since it's already in a goroutine, other events won't be blocked
-			inst.Func(evtcpy)
+			r.dispatch(&evtcpy, inst)
 
 			ranPlugins++
 		}
 	}
 
-	if ranPlugins == 0 && strings.HasPrefix(strings.TrimSpace(evt.Body), "!") {
-		mgr, err := pr.GetPlugin("pluginmgr")
-		// only proceed if there is no error - bots may choose to exclude pluginmgr
-		if strings.HasPrefix(strings.TrimSpace(evt.Body), "!plugin") && err == nil {
-			inst := mgr.Instance(evt.RoomId, evt.Broker)
-			evtcpy := *evt
-			evtcpy.instance = inst
-			inst.Func(evtcpy)
-		} else {
-			evt.Replyf("%q: invalid command (%d plugins were executed for the event).", evt.Body, ranPlugins)
+	// nothing matched this event -- give the fallback middleware (and any
+	// others that care) a chance to handle the !plugin/invalid-command case.
+	// See Middleware's doc comment on inst == nil.
+	if ranPlugins == 0 {
+		r.dispatch(evt, nil)
+	}
+}
+
+// dispatch runs inst's dispatch wrapped in the router's middleware chain:
+// every middleware's Before, in Priority order, then inst.dispatch if none
+// of them stopped the chain, then every middleware that ran gets its
+// After, in reverse order, with however long the call took and whatever it
+// panicked with, if anything. inst == nil is the router's "nothing
+// matched this event" hook -- see Middleware and fallbackMiddleware.
+func (r *RouterCTX) dispatch(evt *Evt, inst *Instance) {
+	chain := r.middlewareChain()
+	ran := make([]Middleware, 0, len(chain))
+
+	start := time.Now()
+	var panicVal interface{}
+
+	func() {
+		defer func() {
+			panicVal = recover()
+		}()
+
+		for _, mw := range chain {
+			ran = append(ran, mw)
+
+			proceed, err := mw.Before(evt, inst)
+			if err != nil {
+				Events().Publish(PluginEvent{Type: PluginError, Broker: evt.BrokerName(), Err: fmt.Errorf("middleware %T.Before: %s", mw, err)})
+			}
+			if !proceed {
+				return
+			}
+		}
+
+		if inst != nil {
+			inst.dispatch(*evt)
 		}
+	}()
+
+	dur := time.Since(start)
+	for i := len(ran) - 1; i >= 0; i-- {
+		ran[i].After(evt, inst, dur, panicVal)
 	}
 }
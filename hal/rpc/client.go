@@ -0,0 +1,179 @@
+package rpc
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Conn is a connection from an out-of-process plugin to a hal-9001
+// PluginHost server.
+type Conn struct {
+	cc     *grpc.ClientConn
+	client PluginHostClient
+}
+
+// Dial connects to a hal-9001 PluginHost listening at addr.
+func Dial(addr string, opts ...grpc.DialOption) (*Conn, error) {
+	opts = append(opts, grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodecName)))
+
+	cc, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{cc: cc, client: NewPluginHostClient(cc)}, nil
+}
+
+// Close tears down the connection.
+func (c *Conn) Close() error {
+	return c.cc.Close()
+}
+
+// RemotePlugin mirrors the fields of hal.Plugin that matter for message
+// routing, for a plugin process connecting to hal-9001 over gRPC instead
+// of being linked into the core bot binary -- recompiling an existing
+// hal.Plugin as a standalone binary is meant to be close to a find/
+// replace of hal.Plugin for rpc.RemotePlugin and hal.Evt for *rpc.Evt in
+// Func's signature.
+type RemotePlugin struct {
+	Name      string
+	Regex     string
+	Broker    string
+	ChannelId string
+	Func      func(*Evt)
+}
+
+// Register claims p's name/regex/room with the connected PluginHost and
+// blocks, dispatching incoming Evts to p.Func, until the stream ends or
+// ctx is canceled. Run it in its own goroutine, the same way a hosting
+// program runs hal.Instance.Register() once and then leaves the router
+// to call Func for the rest of the process's life.
+func (c *Conn) Register(ctx context.Context, p RemotePlugin) error {
+	reg, err := c.client.RegisterPlugin(ctx, &RegisterPluginRequest{
+		PluginName: p.Name,
+		Regex:      p.Regex,
+		Broker:     p.Broker,
+		ChannelId:  p.ChannelId,
+	})
+	if err != nil {
+		return fmt.Errorf("hal/rpc: RegisterPlugin failed: %s", err)
+	}
+
+	stream, err := c.client.StreamEvents(ctx, reg)
+	if err != nil {
+		return fmt.Errorf("hal/rpc: StreamEvents failed: %s", err)
+	}
+
+	for {
+		evt, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		p.Func(evt)
+	}
+}
+
+// Reply sends msg back to wherever evt came from, the remote equivalent
+// of hal.Evt.Reply.
+func (c *Conn) Reply(ctx context.Context, instanceId string, evt *Evt, msg string) error {
+	out := *evt
+	out.Body = msg
+	_, err := c.client.Reply(ctx, &SendRequest{InstanceId: instanceId, Evt: &out})
+	return err
+}
+
+// Replyf is Reply with fmt.Sprintf formatting, the remote equivalent of
+// hal.Evt.Replyf.
+func (c *Conn) Replyf(ctx context.Context, instanceId string, evt *Evt, format string, a ...interface{}) error {
+	return c.Reply(ctx, instanceId, evt, fmt.Sprintf(format, a...))
+}
+
+// Send delivers evt to its Broker without any relationship to an earlier
+// incoming event.
+func (c *Conn) Send(ctx context.Context, instanceId string, evt *Evt) error {
+	_, err := c.client.Send(ctx, &SendRequest{InstanceId: instanceId, Evt: evt})
+	return err
+}
+
+// SendTable delivers evt's broker a tabular rendering of header/rows, the
+// remote equivalent of hal.Broker.SendTable.
+func (c *Conn) SendTable(ctx context.Context, instanceId string, evt *Evt, header []string, rows [][]string) error {
+	wireRows := make([]Row, len(rows))
+	for i, r := range rows {
+		wireRows[i] = Row{Cell: r}
+	}
+
+	_, err := c.client.SendTable(ctx, &SendTableRequest{
+		InstanceId: instanceId,
+		Evt:        evt,
+		Header:     header,
+		Rows:       wireRows,
+	})
+	return err
+}
+
+// SetTopic asks evt's broker to change roomId's topic.
+func (c *Conn) SetTopic(ctx context.Context, instanceId, roomId, topic string) error {
+	_, err := c.client.SetTopic(ctx, &SetTopicRequest{InstanceId: instanceId, RoomId: roomId, Topic: topic})
+	return err
+}
+
+// GetPref fetches a plugin-scoped preference, the remote equivalent of
+// hal.GetPref -- see Server.prefKV for how it's namespaced server-side.
+func (c *Conn) GetPref(ctx context.Context, instanceId, key string) (value string, found bool, err error) {
+	reply, err := c.client.GetPref(ctx, &GetPrefRequest{InstanceId: instanceId, Key: key})
+	if err != nil {
+		return "", false, err
+	}
+	return reply.Value, reply.Found, nil
+}
+
+// SetPref sets a plugin-scoped preference.
+func (c *Conn) SetPref(ctx context.Context, instanceId, key, value string) error {
+	_, err := c.client.SetPref(ctx, &SetPrefRequest{InstanceId: instanceId, Key: key, Value: value})
+	return err
+}
+
+// Subscribe adds another channel/regex for an already-registered plugin
+// instance, the remote equivalent of calling Plugin.Instance(channelId)
+// .Register() a second time in process.
+func (c *Conn) Subscribe(ctx context.Context, instanceId, channelId, regex string) error {
+	ack, err := c.client.Subscribe(ctx, &SubscribeRequest{InstanceId: instanceId, ChannelId: channelId, Regex: regex})
+	if err != nil {
+		return err
+	}
+	if !ack.Ok {
+		return fmt.Errorf("hal/rpc: Subscribe failed: %s", ack.Error)
+	}
+	return nil
+}
+
+// Time returns evt's timestamp as a time.Time, since the wire form only
+// carries UnixNano.
+func (evt *Evt) Time() time.Time {
+	return time.Unix(0, evt.TimeUnixNano)
+}
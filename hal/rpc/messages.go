@@ -0,0 +1,110 @@
+package rpc
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The message types below mirror plugin.proto field-for-field. They're
+// plain Go structs rather than protoc-gen-go output because the jsonCodec
+// (see codec.go) serializes them with encoding/json instead of the
+// protobuf wire format -- field tags are the json name a real .proto
+// compile would also have picked (the snake_case form), so switching this
+// package over to generated bindings later is a rename, not a redesign.
+
+// Evt is the wire form of a hal.Evt -- see toWireEvt/fromWireEvt.
+type Evt struct {
+	Body            string `json:"body"`
+	Room            string `json:"room"`
+	RoomId          string `json:"room_id"`
+	User            string `json:"user"`
+	UserId          string `json:"user_id"`
+	TimeUnixNano    int64  `json:"time_unix_nano"`
+	Broker          string `json:"broker"`
+	ThreadId        string `json:"thread_id"`
+	ThreadBroadcast bool   `json:"thread_broadcast"`
+}
+
+// RegisterPluginRequest claims a plugin name/regex/room, mirroring what
+// hal.Plugin.Register()+hal.Instance.Register() do for an in-process
+// plugin.
+type RegisterPluginRequest struct {
+	PluginName string `json:"plugin_name"`
+	Regex      string `json:"regex"`
+	Broker     string `json:"broker"`
+	ChannelId  string `json:"channel_id"`
+}
+
+// RegisterPluginReply names the instance RegisterPlugin created; every
+// other RPC the plugin makes is scoped to it, and it doubles as the
+// request for StreamEvents.
+type RegisterPluginReply struct {
+	InstanceId string `json:"instance_id"`
+}
+
+// SubscribeRequest adds another hal.Instance for an already-registered
+// plugin, bound to a different channel/regex.
+type SubscribeRequest struct {
+	InstanceId string `json:"instance_id"`
+	ChannelId  string `json:"channel_id"`
+	Regex      string `json:"regex"`
+}
+
+// SendRequest is the payload for Reply/Send: the Evt to deliver, and
+// which registered instance is sending it.
+type SendRequest struct {
+	InstanceId string `json:"instance_id"`
+	Evt        *Evt   `json:"evt"`
+}
+
+// Row is one row of a SendTableRequest.
+type Row struct {
+	Cell []string `json:"cell"`
+}
+
+type SendTableRequest struct {
+	InstanceId string   `json:"instance_id"`
+	Evt        *Evt     `json:"evt"`
+	Header     []string `json:"header"`
+	Rows       []Row    `json:"rows"`
+}
+
+type SetTopicRequest struct {
+	InstanceId string `json:"instance_id"`
+	RoomId     string `json:"room_id"`
+	Topic      string `json:"topic"`
+}
+
+type GetPrefRequest struct {
+	InstanceId string `json:"instance_id"`
+	Key        string `json:"key"`
+}
+
+type PrefReply struct {
+	Value string `json:"value"`
+	Found bool   `json:"found"`
+}
+
+type SetPrefRequest struct {
+	InstanceId string `json:"instance_id"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+}
+
+// Ack is the generic reply for RPCs that otherwise have nothing to
+// return -- Ok is false iff Error is set.
+type Ack struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
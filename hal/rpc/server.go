@@ -0,0 +1,335 @@
+package rpc
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// remoteInstanceEventBufSize is how many undelivered Evts a remote
+// plugin's StreamEvents can fall behind by before Server starts dropping
+// the newest one -- mirrors hal's eventBus/auditDispatcher drop-rather-
+// than-block philosophy, since a wedged plugin process shouldn't be able
+// to stall the router.
+const remoteInstanceEventBufSize = 64
+
+// remoteInstance is the server-side bookkeeping for one RegisterPlugin
+// call: the hal.Plugin it registered (shared across every Subscribe'd
+// hal.Instance) and the channel StreamEvents drains to push matched Evts
+// out over the wire.
+type remoteInstance struct {
+	id     string
+	plugin *hal.Plugin
+	events chan hal.Evt
+}
+
+// Server implements PluginHostServer, bridging RPCs from out-of-process
+// plugins to hal's existing Plugin/Instance/Broker machinery -- the same
+// machinery an in-process plugin uses, just reached over gRPC instead of
+// a direct function call.
+type Server struct {
+	mut       sync.Mutex
+	instances map[string]*remoteInstance
+}
+
+// NewServer returns an initialized Server ready to register with
+// RegisterPluginHostServer.
+func NewServer() *Server {
+	return &Server{instances: make(map[string]*remoteInstance)}
+}
+
+func newInstanceId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (s *Server) get(instanceId string) *remoteInstance {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.instances[instanceId]
+}
+
+// RegisterPlugin registers a hal.Plugin whose Func forwards every matched
+// Evt onto the instance's event channel instead of handling it in
+// process, then creates and registers its first hal.Instance for
+// req.ChannelId -- the out-of-process equivalent of a plugin calling
+// Plugin.Register() followed by Instance.Register() itself.
+func (s *Server) RegisterPlugin(ctx context.Context, req *RegisterPluginRequest) (*RegisterPluginReply, error) {
+	if req.PluginName == "" {
+		return nil, fmt.Errorf("hal/rpc: plugin_name is required")
+	}
+
+	id, err := newInstanceId()
+	if err != nil {
+		return nil, err
+	}
+
+	ri := &remoteInstance{
+		id:     id,
+		events: make(chan hal.Evt, remoteInstanceEventBufSize),
+	}
+
+	ri.plugin = &hal.Plugin{
+		Name:  req.PluginName,
+		Regex: req.Regex,
+		Func: func(evt hal.Evt) {
+			select {
+			case ri.events <- evt:
+			default:
+				log.Printf("hal/rpc: dropping event for remote plugin %q -- its StreamEvents isn't keeping up", req.PluginName)
+			}
+		},
+	}
+
+	if req.Broker != "" {
+		ri.plugin.Broker = hal.Router().GetBroker(req.Broker)
+	}
+
+	if err := ri.plugin.Register(); err != nil {
+		return nil, err
+	}
+
+	if err := ri.plugin.Instance(req.ChannelId).Register(); err != nil {
+		return nil, err
+	}
+
+	s.mut.Lock()
+	s.instances[id] = ri
+	s.mut.Unlock()
+
+	return &RegisterPluginReply{InstanceId: id}, nil
+}
+
+// StreamEvents pushes every Evt matched for req.InstanceId's plugin to
+// the caller until the stream's context is canceled (the plugin process
+// disconnected or shut down).
+func (s *Server) StreamEvents(req *RegisterPluginReply, stream PluginHost_StreamEventsServer) error {
+	ri := s.get(req.InstanceId)
+	if ri == nil {
+		return fmt.Errorf("hal/rpc: unknown instance_id %q", req.InstanceId)
+	}
+
+	for {
+		select {
+		case evt := <-ri.events:
+			if err := stream.Send(toWireEvt(evt)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Subscribe adds another hal.Instance for an already-registered plugin,
+// bound to a new channel/regex, feeding the same event channel
+// StreamEvents drains -- e.g. a remote plugin picking up a second room
+// after RegisterPlugin.
+func (s *Server) Subscribe(ctx context.Context, req *SubscribeRequest) (*Ack, error) {
+	ri := s.get(req.InstanceId)
+	if ri == nil {
+		return nil, fmt.Errorf("hal/rpc: unknown instance_id %q", req.InstanceId)
+	}
+
+	inst := ri.plugin.Instance(req.ChannelId)
+	if req.Regex != "" {
+		inst.Regex = req.Regex
+	}
+
+	if err := inst.Register(); err != nil {
+		return &Ack{Ok: false, Error: err.Error()}, nil
+	}
+
+	return &Ack{Ok: true}, nil
+}
+
+func (s *Server) broker(req *SendRequest) (hal.Broker, error) {
+	if req.Evt == nil || req.Evt.Broker == "" {
+		return nil, fmt.Errorf("hal/rpc: evt.broker is required")
+	}
+
+	b := hal.Router().GetBroker(req.Evt.Broker)
+	if b == nil {
+		return nil, fmt.Errorf("hal/rpc: unknown broker %q", req.Evt.Broker)
+	}
+
+	return b, nil
+}
+
+// Reply and Send are equivalent here -- unlike hal.Evt.Reply, a remote
+// plugin's wire Evt has no live Brokers stack to thread a reply through
+// (see fromWireEvt), so both just hand the caller's Evt straight to its
+// named Broker.
+func (s *Server) Reply(ctx context.Context, req *SendRequest) (*Ack, error) {
+	return s.Send(ctx, req)
+}
+
+func (s *Server) Send(ctx context.Context, req *SendRequest) (*Ack, error) {
+	if s.get(req.InstanceId) == nil {
+		return nil, fmt.Errorf("hal/rpc: unknown instance_id %q", req.InstanceId)
+	}
+
+	b, err := s.broker(req)
+	if err != nil {
+		return &Ack{Ok: false, Error: err.Error()}, nil
+	}
+
+	b.Send(fromWireEvt(req.Evt))
+
+	return &Ack{Ok: true}, nil
+}
+
+func (s *Server) SendTable(ctx context.Context, req *SendTableRequest) (*Ack, error) {
+	if s.get(req.InstanceId) == nil {
+		return nil, fmt.Errorf("hal/rpc: unknown instance_id %q", req.InstanceId)
+	}
+
+	if req.Evt == nil || req.Evt.Broker == "" {
+		return &Ack{Ok: false, Error: "hal/rpc: evt.broker is required"}, nil
+	}
+
+	b := hal.Router().GetBroker(req.Evt.Broker)
+	if b == nil {
+		return &Ack{Ok: false, Error: fmt.Sprintf("hal/rpc: unknown broker %q", req.Evt.Broker)}, nil
+	}
+
+	rows := make([][]string, len(req.Rows))
+	for i, r := range req.Rows {
+		rows[i] = r.Cell
+	}
+
+	b.SendTable(fromWireEvt(req.Evt), req.Header, rows)
+
+	return &Ack{Ok: true}, nil
+}
+
+// SetTopic sets req.RoomId's topic through the instance's bound broker, if
+// that broker implements hal.TopicSetter (sshchat's SetTopic works in
+// server mode only).
+func (s *Server) SetTopic(ctx context.Context, req *SetTopicRequest) (*Ack, error) {
+	ri := s.get(req.InstanceId)
+	if ri == nil {
+		return nil, fmt.Errorf("hal/rpc: unknown instance_id %q", req.InstanceId)
+	}
+
+	if ri.plugin.Broker == nil {
+		return &Ack{Ok: false, Error: "hal/rpc: instance has no broker bound"}, nil
+	}
+
+	ts, ok := ri.plugin.Broker.(hal.TopicSetter)
+	if !ok {
+		return &Ack{Ok: false, Error: fmt.Sprintf("hal/rpc: broker %q doesn't support SetTopic", ri.plugin.Broker.Name())}, nil
+	}
+
+	if err := ts.SetTopic(req.RoomId, req.Topic); err != nil {
+		return &Ack{Ok: false, Error: err.Error()}, nil
+	}
+
+	return &Ack{Ok: true}, nil
+}
+
+// prefKV namespaces a remote plugin's GetPref/SetPref calls in hal's KV
+// store, keyed by plugin name rather than instance id so every instance
+// of a plugin shares the same value -- the same scope hal.Pref would have
+// if it existed in this tree (see GetPref/FindPrefs, referenced
+// throughout the codebase but never defined). hal.Secrets is for
+// credentials, not plugin config, so GetKV/SetKV is the closest real fit.
+func (s *Server) prefKV(instanceId, key string) (string, error) {
+	ri := s.get(instanceId)
+	if ri == nil {
+		return "", fmt.Errorf("hal/rpc: unknown instance_id %q", instanceId)
+	}
+
+	return fmt.Sprintf("rpc.pref.%s.%s", ri.plugin.Name, key), nil
+}
+
+func (s *Server) GetPref(ctx context.Context, req *GetPrefRequest) (*PrefReply, error) {
+	kvKey, err := s.prefKV(req.InstanceId, req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := hal.GetKV(kvKey)
+	if err != nil {
+		return &PrefReply{Found: false}, nil
+	}
+
+	return &PrefReply{Value: value, Found: true}, nil
+}
+
+func (s *Server) SetPref(ctx context.Context, req *SetPrefRequest) (*Ack, error) {
+	kvKey, err := s.prefKV(req.InstanceId, req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := hal.SetKV(kvKey, req.Value, 0); err != nil {
+		return &Ack{Ok: false, Error: err.Error()}, nil
+	}
+
+	return &Ack{Ok: true}, nil
+}
+
+// toWireEvt converts a hal.Evt to its wire form for StreamEvents. Brokers
+// is never populated (see fromWireEvt), so Broker is taken from
+// evt.BrokerName() instead.
+func toWireEvt(evt hal.Evt) *Evt {
+	return &Evt{
+		Body:            evt.Body,
+		Room:            evt.Room,
+		RoomId:          evt.RoomId,
+		User:            evt.User,
+		UserId:          evt.UserId,
+		TimeUnixNano:    evt.Time.UnixNano(),
+		Broker:          evt.BrokerName(),
+		ThreadId:        evt.ThreadID,
+		ThreadBroadcast: evt.ThreadBroadcast,
+	}
+}
+
+// fromWireEvt converts a wire Evt back to a hal.Evt with no Brokers stack
+// set -- callers (Send/SendTable/Reply) look the named Broker up via
+// hal.Router().GetBroker and call it directly instead of relying on
+// evt.Brokers.Last(), the same workaround plugins/cross_the_streams uses
+// for the same reason.
+func fromWireEvt(e *Evt) hal.Evt {
+	if e == nil {
+		return hal.Evt{}
+	}
+
+	return hal.Evt{
+		Body:            e.Body,
+		Room:            e.Room,
+		RoomId:          e.RoomId,
+		User:            e.User,
+		UserId:          e.UserId,
+		Time:            time.Unix(0, e.TimeUnixNano),
+		ThreadID:        e.ThreadId,
+		ThreadBroadcast: e.ThreadBroadcast,
+	}
+}
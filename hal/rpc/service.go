@@ -0,0 +1,322 @@
+package rpc
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// PluginHostServer is the server-side interface for the service described
+// in plugin.proto. It would normally come out of protoc-gen-go-grpc --
+// see codec.go for why it's hand-written here instead.
+type PluginHostServer interface {
+	RegisterPlugin(context.Context, *RegisterPluginRequest) (*RegisterPluginReply, error)
+	StreamEvents(*RegisterPluginReply, PluginHost_StreamEventsServer) error
+	Subscribe(context.Context, *SubscribeRequest) (*Ack, error)
+	Reply(context.Context, *SendRequest) (*Ack, error)
+	Send(context.Context, *SendRequest) (*Ack, error)
+	SendTable(context.Context, *SendTableRequest) (*Ack, error)
+	SetTopic(context.Context, *SetTopicRequest) (*Ack, error)
+	GetPref(context.Context, *GetPrefRequest) (*PrefReply, error)
+	SetPref(context.Context, *SetPrefRequest) (*Ack, error)
+}
+
+// PluginHostClient is the client-side interface, implemented by the
+// *grpc.ClientConn-backed type NewPluginHostClient returns.
+type PluginHostClient interface {
+	RegisterPlugin(ctx context.Context, in *RegisterPluginRequest, opts ...grpc.CallOption) (*RegisterPluginReply, error)
+	StreamEvents(ctx context.Context, in *RegisterPluginReply, opts ...grpc.CallOption) (PluginHost_StreamEventsClient, error)
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*Ack, error)
+	Reply(ctx context.Context, in *SendRequest, opts ...grpc.CallOption) (*Ack, error)
+	Send(ctx context.Context, in *SendRequest, opts ...grpc.CallOption) (*Ack, error)
+	SendTable(ctx context.Context, in *SendTableRequest, opts ...grpc.CallOption) (*Ack, error)
+	SetTopic(ctx context.Context, in *SetTopicRequest, opts ...grpc.CallOption) (*Ack, error)
+	GetPref(ctx context.Context, in *GetPrefRequest, opts ...grpc.CallOption) (*PrefReply, error)
+	SetPref(ctx context.Context, in *SetPrefRequest, opts ...grpc.CallOption) (*Ack, error)
+}
+
+// PluginHost_StreamEventsServer is the server side of the StreamEvents
+// server-push stream.
+type PluginHost_StreamEventsServer interface {
+	Send(*Evt) error
+	grpc.ServerStream
+}
+
+type pluginHostStreamEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *pluginHostStreamEventsServer) Send(e *Evt) error {
+	return x.ServerStream.SendMsg(e)
+}
+
+// PluginHost_StreamEventsClient is the client side of the StreamEvents
+// server-push stream.
+type PluginHost_StreamEventsClient interface {
+	Recv() (*Evt, error)
+	grpc.ClientStream
+}
+
+type pluginHostStreamEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *pluginHostStreamEventsClient) Recv() (*Evt, error) {
+	m := new(Evt)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type pluginHostClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewPluginHostClient wraps cc as a PluginHostClient.
+func NewPluginHostClient(cc *grpc.ClientConn) PluginHostClient {
+	return &pluginHostClient{cc: cc}
+}
+
+func (c *pluginHostClient) RegisterPlugin(ctx context.Context, in *RegisterPluginRequest, opts ...grpc.CallOption) (*RegisterPluginReply, error) {
+	out := new(RegisterPluginReply)
+	if err := c.cc.Invoke(ctx, "/hal.rpc.PluginHost/RegisterPlugin", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginHostClient) StreamEvents(ctx context.Context, in *RegisterPluginReply, opts ...grpc.CallOption) (PluginHost_StreamEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &PluginHost_ServiceDesc.Streams[0], "/hal.rpc.PluginHost/StreamEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &pluginHostStreamEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+func (c *pluginHostClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, "/hal.rpc.PluginHost/Subscribe", in, out, opts...)
+	return out, err
+}
+
+func (c *pluginHostClient) Reply(ctx context.Context, in *SendRequest, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, "/hal.rpc.PluginHost/Reply", in, out, opts...)
+	return out, err
+}
+
+func (c *pluginHostClient) Send(ctx context.Context, in *SendRequest, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, "/hal.rpc.PluginHost/Send", in, out, opts...)
+	return out, err
+}
+
+func (c *pluginHostClient) SendTable(ctx context.Context, in *SendTableRequest, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, "/hal.rpc.PluginHost/SendTable", in, out, opts...)
+	return out, err
+}
+
+func (c *pluginHostClient) SetTopic(ctx context.Context, in *SetTopicRequest, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, "/hal.rpc.PluginHost/SetTopic", in, out, opts...)
+	return out, err
+}
+
+func (c *pluginHostClient) GetPref(ctx context.Context, in *GetPrefRequest, opts ...grpc.CallOption) (*PrefReply, error) {
+	out := new(PrefReply)
+	err := c.cc.Invoke(ctx, "/hal.rpc.PluginHost/GetPref", in, out, opts...)
+	return out, err
+}
+
+func (c *pluginHostClient) SetPref(ctx context.Context, in *SetPrefRequest, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, "/hal.rpc.PluginHost/SetPref", in, out, opts...)
+	return out, err
+}
+
+func registerPluginHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterPluginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginHostServer).RegisterPlugin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/hal.rpc.PluginHost/RegisterPlugin"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginHostServer).RegisterPlugin(ctx, req.(*RegisterPluginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func streamEventsHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(RegisterPluginReply)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(PluginHostServer).StreamEvents(in, &pluginHostStreamEventsServer{stream})
+}
+
+func subscribeHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubscribeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginHostServer).Subscribe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/hal.rpc.PluginHost/Subscribe"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginHostServer).Subscribe(ctx, req.(*SubscribeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func replyHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginHostServer).Reply(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/hal.rpc.PluginHost/Reply"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginHostServer).Reply(ctx, req.(*SendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func sendHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginHostServer).Send(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/hal.rpc.PluginHost/Send"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginHostServer).Send(ctx, req.(*SendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func sendTableHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendTableRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginHostServer).SendTable(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/hal.rpc.PluginHost/SendTable"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginHostServer).SendTable(ctx, req.(*SendTableRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func setTopicHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetTopicRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginHostServer).SetTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/hal.rpc.PluginHost/SetTopic"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginHostServer).SetTopic(ctx, req.(*SetTopicRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func getPrefHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPrefRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginHostServer).GetPref(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/hal.rpc.PluginHost/GetPref"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginHostServer).GetPref(ctx, req.(*GetPrefRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func setPrefHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPrefRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginHostServer).SetPref(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/hal.rpc.PluginHost/SetPref"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginHostServer).SetPref(ctx, req.(*SetPrefRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PluginHost_ServiceDesc is the grpc.ServiceDesc for PluginHost, the
+// hand-written equivalent of what protoc-gen-go-grpc would emit for
+// plugin.proto's service block.
+var PluginHost_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hal.rpc.PluginHost",
+	HandlerType: (*PluginHostServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "RegisterPlugin", Handler: registerPluginHandler},
+		{MethodName: "Subscribe", Handler: subscribeHandler},
+		{MethodName: "Reply", Handler: replyHandler},
+		{MethodName: "Send", Handler: sendHandler},
+		{MethodName: "SendTable", Handler: sendTableHandler},
+		{MethodName: "SetTopic", Handler: setTopicHandler},
+		{MethodName: "GetPref", Handler: getPrefHandler},
+		{MethodName: "SetPref", Handler: setPrefHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       streamEventsHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "hal/rpc/plugin.proto",
+}
+
+// RegisterPluginHostServer registers srv with s under the PluginHost
+// service name.
+func RegisterPluginHostServer(s *grpc.Server, srv PluginHostServer) {
+	s.RegisterService(&PluginHost_ServiceDesc, srv)
+}
@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed standard 5-field cron expression (minute, hour,
+// day-of-month, month, day-of-week). Each field holds the set of values
+// that satisfy it.
+type cronSchedule struct {
+	minutes, hours, doms, months, dows map[int]bool
+}
+
+type cronFieldRange struct{ min, max int }
+
+var cronFieldRanges = [5]cronFieldRange{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 6},  // day of week, 0 = Sunday
+}
+
+// parseCron parses a standard 5-field cron expression ("min hour dom month
+// dow"). Each field accepts "*", "*/n", "a-b", "a-b/n", a bare value, and
+// comma-separated lists of any of those.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", expr, len(fields))
+	}
+
+	sets := make([]map[int]bool, 5)
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFieldRanges[i].min, cronFieldRanges[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %s", expr, err)
+		}
+		sets[i] = set
+	}
+
+	return &cronSchedule{
+		minutes: sets[0],
+		hours:   sets[1],
+		doms:    sets[2],
+		months:  sets[3],
+		dows:    sets[4],
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		base := part
+		step := 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			base = part[:i]
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if base != "*" {
+			if i := strings.Index(base, "-"); i >= 0 {
+				a, err1 := strconv.Atoi(base[:i])
+				b, err2 := strconv.Atoi(base[i+1:])
+				if err1 != nil || err2 != nil {
+					return nil, fmt.Errorf("bad range in %q", part)
+				}
+				lo, hi = a, b
+			} else {
+				n, err := strconv.Atoi(base)
+				if err != nil {
+					return nil, fmt.Errorf("bad value %q", base)
+				}
+				lo, hi = n, n
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			if v < min || v > max {
+				return nil, fmt.Errorf("value %d out of range [%d,%d]", v, min, max)
+			}
+			set[v] = true
+		}
+	}
+
+	return set, nil
+}
+
+// next returns the first minute-resolution time matching the schedule
+// strictly after from.
+func (s *cronSchedule) next(from time.Time) time.Time {
+	t := from.Truncate(time.Minute).Add(time.Minute)
+
+	// bounded search so a schedule that can never match (e.g. "0 0 30 2 *",
+	// Feb 30th) doesn't loop forever
+	for i := 0; i < 4*366*24*60; i++ {
+		if s.matches(t) {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+
+	return from.AddDate(10, 0, 0)
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	return s.minutes[t.Minute()] &&
+		s.hours[t.Hour()] &&
+		s.doms[t.Day()] &&
+		s.months[int(t.Month())] &&
+		s.dows[int(t.Weekday())]
+}
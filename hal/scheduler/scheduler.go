@@ -0,0 +1,151 @@
+package scheduler
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// JobsTable persists every job a Scheduler has registered, so its rows
+// survive a restart. A job's Func lives only in the memory of the process
+// whose Scheduler registered it, so after a restart a Worker runs a job
+// only once its plugin has registered it again (see Worker.run).
+const JobsTable = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id         BIGINT NOT NULL AUTO_INCREMENT,
+	plugin     VARCHAR(191) NOT NULL,
+	name       VARCHAR(191) NOT NULL,
+	payload    MEDIUMTEXT,
+	cron       VARCHAR(191) NOT NULL DEFAULT "",
+	every_secs BIGINT NOT NULL DEFAULT 0,
+	next_run   DATETIME NOT NULL,
+	status     VARCHAR(32) NOT NULL DEFAULT "pending",
+	last_error MEDIUMTEXT,
+	ts         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY(id),
+	UNIQUE KEY plugin_name (plugin, name)
+)`
+
+// job statuses recorded in the jobs table.
+const (
+	StatusPending = "pending" // due to run at next_run
+	StatusRunning = "running" // claimed by a Worker, in flight
+	StatusDone    = "done"    // a one-shot (At) job that already ran
+	StatusError   = "error"   // last run returned an error; still reschedules if recurring
+)
+
+// Scheduler registers a plugin's recurring and one-shot jobs into the
+// persisted jobs table, where any Worker sharing the same database --
+// including in another process -- can pick them up. Scheduler itself never
+// runs a job; see Worker for that.
+type Scheduler struct {
+	plugin string // jobs are namespaced by the owning plugin's name
+
+	mut   sync.Mutex
+	funcs map[string]hal.JobSpec // name -> spec, so a Worker in this process knows what Func to call
+}
+
+var (
+	schedulerMut sync.Mutex
+	schedulers   = make(map[string]*Scheduler) // plugin name -> Scheduler
+)
+
+// New returns the Scheduler for plugin, creating it (and the jobs table,
+// if needed) on first use. Each plugin gets exactly one Scheduler so its
+// jobs all land in the jobs table namespaced under its own name.
+func New(plugin string) *Scheduler {
+	schedulerMut.Lock()
+	defer schedulerMut.Unlock()
+
+	if s, exists := schedulers[plugin]; exists {
+		return s
+	}
+
+	hal.SqlInit(JobsTable)
+
+	s := &Scheduler{plugin: plugin, funcs: make(map[string]hal.JobSpec)}
+	schedulers[plugin] = s
+	return s
+}
+
+// Every schedules fn to run repeatedly, every interval, starting one
+// interval from now.
+func (s *Scheduler) Every(name string, interval time.Duration, fn func(payload string) error) error {
+	job := hal.JobSpec{Name: name, Every: interval, Func: fn}
+	return s.persist(job, time.Now().Add(interval))
+}
+
+// At schedules fn to run exactly once, at the given time.
+func (s *Scheduler) At(name string, when time.Time, fn func(payload string) error) error {
+	job := hal.JobSpec{Name: name, At: when, Func: fn}
+	return s.persist(job, when)
+}
+
+// Cron schedules fn to run every time expr matches, per standard 5-field
+// cron syntax (minute hour day-of-month month day-of-week).
+func (s *Scheduler) Cron(name, expr string, fn func(payload string) error) error {
+	sched, err := parseCron(expr)
+	if err != nil {
+		return err
+	}
+
+	job := hal.JobSpec{Name: name, Cron: expr, Func: fn}
+	return s.persist(job, sched.next(time.Now()))
+}
+
+// persist upserts job into the jobs table and remembers its Func in
+// memory so this process's Worker can find it when the row comes due.
+func (s *Scheduler) persist(job hal.JobSpec, firstRun time.Time) error {
+	s.mut.Lock()
+	s.funcs[job.Name] = job
+	s.mut.Unlock()
+
+	db := hal.SqlDB()
+	_, err := db.Exec(`INSERT INTO jobs (plugin, name, payload, cron, every_secs, next_run, status)
+	                    VALUES (?, ?, ?, ?, ?, ?, ?)
+	                    ON DUPLICATE KEY UPDATE payload=VALUES(payload), cron=VALUES(cron),
+	                        every_secs=VALUES(every_secs), next_run=VALUES(next_run), status=VALUES(status)`,
+		s.plugin, job.Name, job.Payload, job.Cron, int64(job.Every.Seconds()), firstRun, StatusPending)
+	if err != nil {
+		log.Printf("scheduler: failed to persist job %q for plugin %q: %s", job.Name, s.plugin, err)
+	}
+
+	return err
+}
+
+// RegisterPlugin wires hal.JobRegistrar to this package, so that
+// Instance.Register auto-registers a plugin's Jobs ([]hal.JobSpec) with
+// its Scheduler. Call this once at startup before any plugin instance
+// that declares Jobs is registered.
+func RegisterPlugin() {
+	hal.JobRegistrar = func(inst *hal.Instance, job hal.JobSpec) {
+		s := New(inst.Plugin.Name)
+
+		switch {
+		case job.Cron != "":
+			s.Cron(job.Name, job.Cron, job.Func)
+		case job.Every > 0:
+			s.Every(job.Name, job.Every, job.Func)
+		default:
+			s.At(job.Name, job.At, job.Func)
+		}
+	}
+}
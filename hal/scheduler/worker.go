@@ -0,0 +1,202 @@
+package scheduler
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"log"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// Worker periodically claims due rows from the jobs table and runs them.
+// Claiming uses SELECT ... FOR UPDATE SKIP LOCKED so any number of Workers,
+// in this process or another HAL process sharing the same database, can
+// poll concurrently without two of them running the same job.
+type Worker struct {
+	PollInterval time.Duration // how often to check for due jobs
+	Concurrency  int           // max jobs run at once by this Worker
+
+	stop chan struct{}
+}
+
+// NewWorker returns a Worker with reasonable defaults.
+func NewWorker() *Worker {
+	return &Worker{
+		PollInterval: 10 * time.Second,
+		Concurrency:  4,
+		stop:         make(chan struct{}),
+	}
+}
+
+// Start polls for due jobs and runs them until Stop is called. It blocks,
+// so callers typically run it in its own goroutine.
+func (w *Worker) Start() {
+	sem := make(chan struct{}, w.Concurrency)
+
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			for _, j := range w.claimDue() {
+				sem <- struct{}{}
+				go func(j dueJob) {
+					defer func() { <-sem }()
+					w.run(j)
+				}(j)
+			}
+		}
+	}
+}
+
+// Stop signals the poll loop to exit. It does not wait for jobs already
+// in flight to finish.
+func (w *Worker) Stop() {
+	close(w.stop)
+}
+
+// dueJob is a claimed row from the jobs table.
+type dueJob struct {
+	id        int64
+	plugin    string
+	name      string
+	payload   string
+	cron      string
+	everySecs int64
+}
+
+// claimDue locks and claims every pending job whose next_run has passed,
+// all within a single transaction, so a concurrent Worker can't also
+// claim them out from under it.
+func (w *Worker) claimDue() []dueJob {
+	db := hal.SqlDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("scheduler: worker failed to start transaction: %s", err)
+		return nil
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, plugin, name, payload, cron, every_secs FROM jobs
+	                        WHERE next_run <= NOW() AND status = ?
+	                        FOR UPDATE SKIP LOCKED`, StatusPending)
+	if err != nil {
+		log.Printf("scheduler: worker query failed: %s", err)
+		return nil
+	}
+
+	var due []dueJob
+	for rows.Next() {
+		var j dueJob
+		if err := rows.Scan(&j.id, &j.plugin, &j.name, &j.payload, &j.cron, &j.everySecs); err != nil {
+			log.Printf("scheduler: worker scan failed: %s", err)
+			continue
+		}
+		due = append(due, j)
+	}
+	rows.Close()
+
+	for _, j := range due {
+		if _, err := tx.Exec(`UPDATE jobs SET status=? WHERE id=?`, StatusRunning, j.id); err != nil {
+			log.Printf("scheduler: worker failed to claim job %d: %s", j.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("scheduler: worker failed to commit claim of %d job(s): %s", len(due), err)
+		return nil
+	}
+
+	return due
+}
+
+// run executes a claimed job's Func and reschedules it. Jobs registered by
+// a Scheduler in another process are claimed the same way but have no
+// Func here, so their claim is released back to StatusPending for that
+// process's own Worker to pick up on a later poll.
+func (w *Worker) run(j dueJob) {
+	release := func() {
+		if _, err := hal.SqlDB().Exec(`UPDATE jobs SET status=? WHERE id=?`, StatusPending, j.id); err != nil {
+			log.Printf("scheduler: worker failed to release job %d: %s", j.id, err)
+		}
+	}
+
+	schedulerMut.Lock()
+	s, exists := schedulers[j.plugin]
+	schedulerMut.Unlock()
+
+	if !exists {
+		release()
+		return
+	}
+
+	s.mut.Lock()
+	job, exists := s.funcs[j.name]
+	s.mut.Unlock()
+
+	if !exists || job.Func == nil {
+		release()
+		return
+	}
+
+	runErr := job.Func(j.payload)
+	next, status := w.reschedule(j, runErr)
+
+	db := hal.SqlDB()
+	_, err := db.Exec(`UPDATE jobs SET status=?, next_run=?, last_error=? WHERE id=?`,
+		status, next, errString(runErr), j.id)
+	if err != nil {
+		log.Printf("scheduler: worker failed to update job %d after running: %s", j.id, err)
+	}
+}
+
+// reschedule computes the next run time and resulting status for a job
+// that just ran, based on whether it's cron-based, a fixed interval, or a
+// one-shot At job that doesn't run again.
+func (w *Worker) reschedule(j dueJob, runErr error) (time.Time, string) {
+	status := StatusPending
+	if runErr != nil {
+		log.Printf("scheduler: job %q (plugin %q, id %d) failed: %s", j.name, j.plugin, j.id, runErr)
+		status = StatusError
+	}
+
+	switch {
+	case j.cron != "":
+		sched, err := parseCron(j.cron)
+		if err != nil {
+			log.Printf("scheduler: job %d has an unparseable cron expression %q: %s", j.id, j.cron, err)
+			return time.Now().Add(time.Hour), StatusError
+		}
+		return sched.next(time.Now()), status
+	case j.everySecs > 0:
+		return time.Now().Add(time.Duration(j.everySecs) * time.Second), status
+	default:
+		return time.Now(), StatusDone
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
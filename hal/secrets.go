@@ -0,0 +1,240 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+const SecretsTable = `
+CREATE TABLE IF NOT EXISTS secrets (
+	skey VARCHAR(191) NOT NULL,
+	sval MEDIUMTEXT,
+	PRIMARY KEY(skey)
+)`
+
+// SecretStore is hal's key/value store for credentials (API tokens, DSNs,
+// OAuth client secrets, per-user tokens, etc.) that shouldn't travel
+// through prefs/KV as plaintext. See Secrets().
+type SecretStore struct {
+	mut    sync.Mutex
+	data   map[string]string
+	aesKey []byte // set via SetEncryptionKey; nil means no DB persistence
+}
+
+var secretsSingleton SecretStore
+var secretsInitOnce sync.Once
+
+// Secrets returns hal's secret store singleton. It always works in
+// memory; call SetEncryptionKey and LoadFromDB at startup (see
+// example/main.go) to also persist across restarts.
+func Secrets() *SecretStore {
+	secretsInitOnce.Do(func() {
+		secretsSingleton.data = make(map[string]string)
+	})
+
+	return &secretsSingleton
+}
+
+// Get returns the value stored under key, or "" if it isn't set.
+func (s *SecretStore) Get(key string) string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.data[key]
+}
+
+// Exists reports whether key has been set.
+func (s *SecretStore) Exists(key string) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	_, ok := s.data[key]
+	return ok
+}
+
+// Set stores value under key in memory and, once SetEncryptionKey has
+// been called, persists it through the configured SecretsBackend (the
+// secrets table by default) too, encrypted with AES-256-GCM so a database
+// backup only ever contains ciphertext.
+func (s *SecretStore) Set(key, value string) {
+	s.mut.Lock()
+	s.data[key] = value
+	aesKey := s.aesKey
+	s.mut.Unlock()
+
+	Audit(AuditEntry{
+		Source:   "secret",
+		Action:   "set",
+		Target:   key,
+		Outcome:  "ok",
+		Severity: SeverityWarn,
+	})
+
+	if aesKey == nil {
+		return
+	}
+
+	if err := persistSecret(key, value, aesKey); err != nil {
+		log.Printf("hal: failed to persist secret %q to the database: %s", key, err)
+	}
+}
+
+// Put is an alias for Set.
+func (s *SecretStore) Put(key, value string) {
+	s.Set(key, value)
+}
+
+// Delete removes key from memory and, if persistence is configured, from
+// the configured SecretsBackend. It is not an error to delete a key that
+// doesn't exist.
+func (s *SecretStore) Delete(key string) {
+	s.mut.Lock()
+	delete(s.data, key)
+	aesKey := s.aesKey
+	s.mut.Unlock()
+
+	Audit(AuditEntry{
+		Source:   "secret",
+		Action:   "delete",
+		Target:   key,
+		Outcome:  "ok",
+		Severity: SeverityWarn,
+	})
+
+	if aesKey == nil {
+		return
+	}
+
+	if err := getSecretsBackend().Delete(key); err != nil {
+		log.Printf("hal: failed to delete secret %q from the backend: %s", key, err)
+	}
+}
+
+// SetEncryptionKey enables database persistence for Set/Put/Delete and
+// LoadFromDB, using key as the AES-256-GCM key protecting every value
+// written to the configured SecretsBackend. key must be 32 bytes.
+func (s *SecretStore) SetEncryptionKey(key []byte) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.aesKey = key
+}
+
+// LoadFromDB reads every secret out of the configured SecretsBackend,
+// decrypts it with the key set via SetEncryptionKey, and merges it into
+// the in-memory store. SetEncryptionKey must be called first. The name
+// predates SecretsBackend (it always meant "the SQL database" before);
+// it's kept so callers like example/main.go don't need to change.
+func (s *SecretStore) LoadFromDB() error {
+	s.mut.Lock()
+	aesKey := s.aesKey
+	s.mut.Unlock()
+
+	if aesKey == nil {
+		return fmt.Errorf("hal: LoadFromDB called before SetEncryptionKey")
+	}
+
+	ciphertexts, err := getSecretsBackend().List()
+	if err != nil {
+		return err
+	}
+
+	for key, ciphertext := range ciphertexts {
+		value, err := decryptSecret(aesKey, ciphertext)
+		if err != nil {
+			log.Printf("hal: could not decrypt secret %q, skipping: %s", key, err)
+			continue
+		}
+
+		s.mut.Lock()
+		s.data[key] = value
+		s.mut.Unlock()
+	}
+
+	return nil
+}
+
+func persistSecret(key, value string, aesKey []byte) error {
+	ciphertext, err := encryptSecret(aesKey, value)
+	if err != nil {
+		return err
+	}
+
+	return getSecretsBackend().Set(key, ciphertext)
+}
+
+// encryptSecret AES-GCM encrypts plaintext with key, returning
+// hex(nonce||ciphertext).
+func encryptSecret(key []byte, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return hex.EncodeToString(sealed), nil
+}
+
+// decryptSecret reverses encryptSecret.
+func decryptSecret(key []byte, ciphertext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := hex.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	if len(raw) < gcm.NonceSize() {
+		return "", fmt.Errorf("hal: secret ciphertext too short")
+	}
+
+	nonce, sealed := raw[:gcm.NonceSize()], raw[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
@@ -0,0 +1,103 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import "sync"
+
+// SecretsBackend persists the encrypted form of a SecretStore entry,
+// pulled out the same way KVBackend was pulled out of GetKV/SetKV so
+// SecretStore.Set/Delete/LoadFromDB can run against etcd instead of a
+// single MySQL instance (see EtcdSecretsBackend). Every value a backend
+// ever sees is already AES-256-GCM ciphertext from encryptSecret --
+// SecretStore never hands a backend plaintext, so swapping backends
+// doesn't change what's safe to find lying around in etcd or a DB dump.
+type SecretsBackend interface {
+	// Set stores ciphertext under key, overwriting any previous value.
+	Set(key, ciphertext string) error
+	// Delete removes key. It is not an error to delete a key that
+	// doesn't exist.
+	Delete(key string) error
+	// List returns every stored key and its ciphertext, for LoadFromDB
+	// to decrypt and merge into the in-memory store at startup.
+	List() (map[string]string, error)
+}
+
+var (
+	secretsBackendMut sync.Mutex
+	secretsBackend    SecretsBackend // nil means sqlSecretsBackend{}, the original behavior
+)
+
+// SetSecretsBackend installs b as the persistence backend for
+// Secrets().Set/Put/Delete/LoadFromDB. Call with nil to revert to the
+// default secrets table in the SQL database.
+func SetSecretsBackend(b SecretsBackend) {
+	secretsBackendMut.Lock()
+	defer secretsBackendMut.Unlock()
+
+	secretsBackend = b
+}
+
+func getSecretsBackend() SecretsBackend {
+	secretsBackendMut.Lock()
+	defer secretsBackendMut.Unlock()
+
+	if secretsBackend == nil {
+		return sqlSecretsBackend{}
+	}
+
+	return secretsBackend
+}
+
+// sqlSecretsBackend is the original SecretStore persistence: a single
+// row per key in the secrets table (see SecretsTable).
+type sqlSecretsBackend struct{}
+
+func (sqlSecretsBackend) Set(key, ciphertext string) error {
+	SqlInit(SecretsTable)
+
+	_, err := SqlDB().Exec(`INSERT INTO secrets (skey,sval) VALUES (?,?)
+		ON DUPLICATE KEY UPDATE sval=VALUES(sval)`, key, ciphertext)
+	return err
+}
+
+func (sqlSecretsBackend) Delete(key string) error {
+	SqlInit(SecretsTable)
+
+	_, err := SqlDB().Exec("DELETE FROM secrets WHERE skey=?", key)
+	return err
+}
+
+func (sqlSecretsBackend) List() (map[string]string, error) {
+	SqlInit(SecretsTable)
+
+	rows, err := SqlDB().Query("SELECT skey,sval FROM secrets")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]string)
+	for rows.Next() {
+		var key, ciphertext string
+		if err := rows.Scan(&key, &ciphertext); err != nil {
+			return nil, err
+		}
+		out[key] = ciphertext
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,95 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// EtcdSecretsBackend implements SecretsBackend on etcd v3, namespaced
+// under prefix+key (e.g. "/hal9001/secrets/pagerduty.token"), the same
+// namespacing convention EtcdKVBackend and EtcdLeaderBackend already use
+// for prefs/kv data and leader election. Run alongside either of those
+// against the same etcd cluster, a fleet of hal-9001 replicas shares
+// secrets, prefs, and leader state without a single point of failure.
+type EtcdSecretsBackend struct {
+	client *clientv3.Client
+	prefix string
+}
+
+// NewEtcdSecretsBackend connects to the given etcd endpoints and returns
+// a SecretsBackend that stores ciphertext under prefix (e.g.
+// "/hal9001/secrets/").
+func NewEtcdSecretsBackend(endpoints []string, prefix string) (*EtcdSecretsBackend, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdSecretsBackend{client: cli, prefix: prefix}, nil
+}
+
+func (eb *EtcdSecretsBackend) key(k string) string {
+	return eb.prefix + k
+}
+
+// Set implements SecretsBackend.
+func (eb *EtcdSecretsBackend) Set(key, ciphertext string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := eb.client.Put(ctx, eb.key(key), ciphertext)
+	return err
+}
+
+// Delete implements SecretsBackend.
+func (eb *EtcdSecretsBackend) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := eb.client.Delete(ctx, eb.key(key))
+	return err
+}
+
+// List implements SecretsBackend with a single prefix scan.
+func (eb *EtcdSecretsBackend) List() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := eb.client.Get(ctx, eb.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		out[string(kv.Key)[len(eb.prefix):]] = string(kv.Value)
+	}
+
+	return out, nil
+}
+
+// Close releases the underlying etcd client connection.
+func (eb *EtcdSecretsBackend) Close() error {
+	return eb.client.Close()
+}
@@ -0,0 +1,138 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// RosterEntry records when a user was last seen in a room on a broker --
+// the payload every Storage driver persists for the roster plugin (see
+// plugins/roster). A K/V driver maps the composite (Broker, Room, User)
+// key to a path like "/hal/roster/<broker>/<room>/<user>"; a SQL driver
+// maps it to a composite primary key.
+type RosterEntry struct {
+	Broker    string
+	User      string
+	Room      string
+	Timestamp time.Time
+}
+
+// Storage is the persistence interface stateful plugins like roster drive
+// instead of calling hal.SqlDB() directly, so an operator who doesn't want
+// to run MySQL can still use them -- with a Postgres/SQLite database, or
+// with no relational database at all (etcd/BoltDB). See
+// RegisterStorageDriver and ConfigureStorageDriver.
+type Storage interface {
+	// Init applies schema (idempotently -- CREATE TABLE IF NOT EXISTS or
+	// equivalent) for a SQL-backed driver. A K/V driver with no schema
+	// (etcd, BoltDB) treats this as a no-op.
+	Init(schema string) error
+	// UpsertRoster records that user was seen in room on broker at ts,
+	// overwriting any existing entry for that (broker, user, room).
+	UpsertRoster(broker, user, room string, ts time.Time) error
+	// GetRoster returns every roster entry, newest first.
+	GetRoster() ([]RosterEntry, error)
+}
+
+// StorageDriverFactory builds a Storage implementation when its driver
+// name is selected by ConfigureStorageDriver. See RegisterStorageDriver.
+type StorageDriverFactory func() (Storage, error)
+
+var (
+	storageDriversMut sync.Mutex
+	storageDrivers    = make(map[string]StorageDriverFactory)
+)
+
+// RegisterStorageDriver makes a Storage implementation available under
+// name for StorageDriverKey/ConfigureStorageDriver to select. Drivers
+// call this from their own init(), the same way database/sql drivers
+// register themselves with sql.Register.
+func RegisterStorageDriver(name string, factory StorageDriverFactory) {
+	storageDriversMut.Lock()
+	defer storageDriversMut.Unlock()
+
+	storageDrivers[name] = factory
+}
+
+// StorageDriverKey is the hal.Secrets() key naming which registered
+// Storage driver ConfigureStorageDriver installs: "mysql" (the default),
+// "postgres", "sqlite", "etcd", or "boltdb".
+const StorageDriverKey = "hal.storage.driver"
+
+var (
+	storageMut sync.Mutex
+	storage    Storage
+)
+
+// ConfigureStorageDriver installs the Storage driver named by
+// StorageDriverKey (defaulting to "mysql" when unset) as the backend
+// GetStorage returns. Call it once at startup, after any driver-specific
+// secrets (hal.dsn, hal.storage.etcd.endpoints, etc.) are set, so a
+// connection failure surfaces immediately rather than on a plugin's first
+// write.
+func ConfigureStorageDriver() error {
+	name := Secrets().Get(StorageDriverKey)
+	if name == "" {
+		name = "mysql"
+	}
+
+	storageDriversMut.Lock()
+	factory, ok := storageDrivers[name]
+	storageDriversMut.Unlock()
+
+	if !ok {
+		return fmt.Errorf("hal: unknown %s value %q (no Storage driver registered under that name)", StorageDriverKey, name)
+	}
+
+	s, err := factory()
+	if err != nil {
+		return err
+	}
+
+	storageMut.Lock()
+	storage = s
+	storageMut.Unlock()
+
+	return nil
+}
+
+// GetStorage returns the Storage driver ConfigureStorageDriver installed,
+// running it with the default "mysql" driver on first call if nothing
+// configured one yet -- mirroring SqlDB()'s lazy-connect-on-first-use
+// behavior.
+func GetStorage() Storage {
+	storageMut.Lock()
+	s := storage
+	storageMut.Unlock()
+
+	if s != nil {
+		return s
+	}
+
+	if err := ConfigureStorageDriver(); err != nil {
+		log.Fatalf("hal: could not configure a Storage driver: %s", err)
+	}
+
+	storageMut.Lock()
+	defer storageMut.Unlock()
+
+	return storage
+}
@@ -0,0 +1,117 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// BoltStoragePathKey is the hal.Secrets() key holding the path to the
+// BoltDB database file newBoltStorage opens (created if it doesn't exist
+// yet).
+const BoltStoragePathKey = "hal.storage.boltdb.path"
+
+// boltRosterBucket is the single bucket boltStorage keeps roster entries
+// in, keyed by "<broker>/<room>/<user>".
+var boltRosterBucket = []byte("roster")
+
+func init() {
+	RegisterStorageDriver("boltdb", newBoltStorage)
+}
+
+// boltStorage implements Storage on top of BoltDB, for single-instance
+// deployments that would rather not run a database server, or even a
+// separate etcd cluster, at all.
+type boltStorage struct {
+	db *bbolt.DB
+}
+
+func newBoltStorage() (Storage, error) {
+	path := Secrets().Get(BoltStoragePathKey)
+	if path == "" {
+		return nil, fmt.Errorf("hal: %s is not set in hal.Secrets()", BoltStoragePathKey)
+	}
+
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltRosterBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStorage{db: db}, nil
+}
+
+// Init implements Storage as a no-op -- BoltDB has no schema to apply.
+func (bs *boltStorage) Init(schema string) error {
+	return nil
+}
+
+func boltRosterKey(broker, room, user string) []byte {
+	return []byte(fmt.Sprintf("%s/%s/%s", broker, room, user))
+}
+
+// UpsertRoster implements Storage.
+func (bs *boltStorage) UpsertRoster(broker, user, room string, ts time.Time) error {
+	js, err := json.Marshal(RosterEntry{Broker: broker, User: user, Room: room, Timestamp: ts})
+	if err != nil {
+		return err
+	}
+
+	return bs.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltRosterBucket).Put(boltRosterKey(broker, room, user), js)
+	})
+}
+
+// GetRoster implements Storage by iterating the whole roster bucket and
+// sorting the decoded entries by timestamp, newest first, since BoltDB
+// only orders keys lexically, not by the timestamp stored in the value.
+func (bs *boltStorage) GetRoster() ([]RosterEntry, error) {
+	entries := []RosterEntry{}
+
+	err := bs.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltRosterBucket).ForEach(func(k, v []byte) error {
+			var e RosterEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				log.Printf("hal: skipping undecodable roster record %q: %s\n", k, err)
+				return nil
+			}
+
+			entries = append(entries, e)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Timestamp.After(entries[j].Timestamp) })
+
+	return entries, nil
+}
@@ -0,0 +1,118 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// EtcdStorageEndpointsKey is the hal.Secrets() key holding a comma-
+// separated list of etcd endpoints newEtcdStorage connects to.
+const EtcdStorageEndpointsKey = "hal.storage.etcd.endpoints"
+
+// EtcdStoragePrefix namespaces every key etcdStorage writes, e.g. the
+// roster's composite (broker, room, user) primary key maps to
+// "/hal/roster/<broker>/<room>/<user>".
+const EtcdStoragePrefix = "/hal/"
+
+func init() {
+	RegisterStorageDriver("etcd", newEtcdStorage)
+}
+
+// etcdStorage implements Storage on top of etcd v3, for deployments that
+// would rather not stand up a relational database at all. There's no
+// schema, so Init is a no-op; GetRoster scans EtcdStoragePrefix+"roster/"
+// and sorts the results by timestamp in memory.
+type etcdStorage struct {
+	client *clientv3.Client
+}
+
+func newEtcdStorage() (Storage, error) {
+	endpoints := strings.Split(Secrets().Get(EtcdStorageEndpointsKey), ",")
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &etcdStorage{client: cli}, nil
+}
+
+// Init implements Storage as a no-op -- etcd has no schema to apply.
+func (es *etcdStorage) Init(schema string) error {
+	return nil
+}
+
+func rosterKey(broker, room, user string) string {
+	return fmt.Sprintf("%sroster/%s/%s/%s", EtcdStoragePrefix, broker, room, user)
+}
+
+// UpsertRoster implements Storage.
+func (es *etcdStorage) UpsertRoster(broker, user, room string, ts time.Time) error {
+	js, err := json.Marshal(RosterEntry{Broker: broker, User: user, Room: room, Timestamp: ts})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = es.client.Put(ctx, rosterKey(broker, room, user), string(js))
+	return err
+}
+
+// GetRoster implements Storage by scanning every key under
+// EtcdStoragePrefix+"roster/" and sorting the decoded entries by
+// timestamp, newest first, since an unscoped etcd prefix scan has no
+// inherent time ordering the way the roster's broker/room/user keys are
+// laid out.
+func (es *etcdStorage) GetRoster() ([]RosterEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := es.client.Get(ctx, EtcdStoragePrefix+"roster/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]RosterEntry, 0, len(resp.Kvs))
+
+	for _, kv := range resp.Kvs {
+		var e RosterEntry
+		if err := json.Unmarshal(kv.Value, &e); err != nil {
+			log.Printf("hal: skipping undecodable roster record %q: %s\n", kv.Key, err)
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Timestamp.After(entries[j].Timestamp) })
+
+	return entries, nil
+}
@@ -0,0 +1,80 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"log"
+	"time"
+)
+
+func init() {
+	RegisterStorageDriver("mysql", newMysqlStorage)
+}
+
+// mysqlStorage implements Storage on top of the existing SqlDB()/SqlInit()
+// singleton (hal.dsn in hal.Secrets()), the behavior every Storage-backed
+// plugin always had before Storage existed.
+type mysqlStorage struct{}
+
+func newMysqlStorage() (Storage, error) {
+	return mysqlStorage{}, nil
+}
+
+// Init implements Storage.
+func (mysqlStorage) Init(schema string) error {
+	return SqlInit(schema)
+}
+
+// UpsertRoster implements Storage.
+func (mysqlStorage) UpsertRoster(broker, user, room string, ts time.Time) error {
+	sql := `INSERT INTO roster (broker, user, room, ts) VALUES (?,?,?,?)
+	        ON DUPLICATE KEY UPDATE ts=?`
+
+	_, err := SqlDB().Exec(sql, broker, user, room, ts, ts)
+	return err
+}
+
+// GetRoster implements Storage.
+func (mysqlStorage) GetRoster() ([]RosterEntry, error) {
+	sql := `SELECT broker, user, room, UNIX_TIMESTAMP(ts) AS ts
+	          FROM roster
+	          ORDER BY ts DESC`
+
+	rows, err := SqlDB().Query(sql)
+	if err != nil {
+		log.Printf("Roster query failed: %s\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []RosterEntry{}
+
+	for rows.Next() {
+		e := RosterEntry{}
+
+		var ts int64
+		if err := rows.Scan(&e.Broker, &e.User, &e.Room, &ts); err != nil {
+			log.Printf("Row iteration failed: %s\n", err)
+			return nil, err
+		}
+
+		e.Timestamp = time.Unix(ts, 0)
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
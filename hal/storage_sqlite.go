@@ -0,0 +1,105 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SqliteStoragePathKey is the hal.Secrets() key holding the path to the
+// SQLite database file newSqliteStorage opens (created if it doesn't
+// exist yet).
+const SqliteStoragePathKey = "hal.storage.sqlite.path"
+
+func init() {
+	RegisterStorageDriver("sqlite", newSqliteStorage)
+}
+
+// sqliteStorage implements Storage on top of database/sql + go-sqlite3,
+// for single-instance deployments that would rather not run a database
+// server at all.
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+func newSqliteStorage() (Storage, error) {
+	path := Secrets().Get(SqliteStoragePathKey)
+	if path == "" {
+		return nil, fmt.Errorf("hal: %s is not set in hal.Secrets()", SqliteStoragePathKey)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	return &sqliteStorage{db: db}, nil
+}
+
+// Init implements Storage.
+func (ss *sqliteStorage) Init(schema string) error {
+	_, err := ss.db.Exec(schema)
+	return err
+}
+
+// UpsertRoster implements Storage.
+func (ss *sqliteStorage) UpsertRoster(broker, user, room string, ts time.Time) error {
+	sql := `INSERT OR REPLACE INTO roster (broker, user, room, ts) VALUES (?,?,?,?)`
+
+	_, err := ss.db.Exec(sql, broker, user, room, ts)
+	return err
+}
+
+// GetRoster implements Storage.
+func (ss *sqliteStorage) GetRoster() ([]RosterEntry, error) {
+	sql := `SELECT broker, user, room, strftime('%s', ts) AS ts
+	          FROM roster
+	          ORDER BY ts DESC`
+
+	rows, err := ss.db.Query(sql)
+	if err != nil {
+		log.Printf("Roster query failed: %s\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []RosterEntry{}
+
+	for rows.Next() {
+		e := RosterEntry{}
+
+		var ts int64
+		if err := rows.Scan(&e.Broker, &e.User, &e.Room, &ts); err != nil {
+			log.Printf("Row iteration failed: %s\n", err)
+			return nil, err
+		}
+
+		e.Timestamp = time.Unix(ts, 0)
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
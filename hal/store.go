@@ -0,0 +1,113 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// StorageBackendEnvVar, when set, picks which Store ConfigureStorageFromEnv
+// installs: "etcd" or "mysql" (the default). StorageBackendKey is the
+// equivalent KV key, checked when the env var isn't set, so the backend
+// can also be switched with "!prefs set --key hal.storage.backend --value
+// etcd" once a node can already reach one (e.g. during a staged rollout).
+const StorageBackendEnvVar = "HAL_STORAGE_BACKEND"
+const StorageBackendKey = "hal.storage.backend"
+
+// Store unifies the backing storage for prefs/general key-value data (via
+// the embedded KVBackend), hal.Cache's TTL'd blobs, and PeriodicFunc's
+// last-run bookkeeping, so a single backend switch moves all of it to
+// something with real cross-instance semantics (see EtcdStore) instead of
+// each having its own ad-hoc pluggability. The default, nil Store, leaves
+// GetKV/SetKV on their existing SQL-backed path and hal.Cache() in-process
+// only -- see SetStore.
+type Store interface {
+	KVBackend
+
+	// CacheSet stores data under key for ttl (0 meaning forever), for
+	// hal.Cache() to use in place of its in-process map when a Store is
+	// configured.
+	CacheSet(key string, data []byte, ttl time.Duration) error
+	// CacheGet returns the bytes stored under key and however much of its
+	// ttl remains. err is ErrKVNotFound if key doesn't exist or expired.
+	CacheGet(key string) (data []byte, ttl time.Duration, err error)
+	// CacheDelete removes key. It is not an error to delete a key that
+	// doesn't exist.
+	CacheDelete(key string) error
+}
+
+var (
+	storeMut sync.Mutex
+	store    Store // nil means "use the legacy per-subsystem SQL/in-process defaults"
+)
+
+// SetStore installs s as the backend for GetKV/SetKV/ExistsKV (via
+// SetKVBackend, since Store embeds KVBackend), hal.Cache(), and
+// PeriodicFunc's persisted last-run bookkeeping. Call with nil to revert
+// all three to their defaults.
+func SetStore(s Store) {
+	storeMut.Lock()
+	store = s
+	storeMut.Unlock()
+
+	if s == nil {
+		SetKVBackend(nil)
+		return
+	}
+
+	SetKVBackend(s)
+}
+
+func getStore() Store {
+	storeMut.Lock()
+	defer storeMut.Unlock()
+
+	return store
+}
+
+// ConfigureStorageFromEnv installs the Store named by StorageBackendEnvVar
+// (falling back to the StorageBackendKey pref/KV entry, then "mysql") --
+// "etcd", connecting to etcdEndpoints, or "mysql", the default SQL/
+// in-process behavior already in place before this function is ever
+// called.
+func ConfigureStorageFromEnv(etcdEndpoints []string) error {
+	backend := os.Getenv(StorageBackendEnvVar)
+
+	if backend == "" {
+		if v, err := GetKV(StorageBackendKey); err == nil {
+			backend = v
+		}
+	}
+
+	switch backend {
+	case "", "mysql":
+		SetStore(nil)
+		return nil
+	case "etcd":
+		s, err := NewEtcdStore(etcdEndpoints, "/hal9001/store/")
+		if err != nil {
+			return err
+		}
+		SetStore(s)
+		return nil
+	default:
+		return fmt.Errorf("hal: unknown %s value %q (want \"etcd\" or \"mysql\")", StorageBackendEnvVar, backend)
+	}
+}
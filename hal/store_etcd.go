@@ -0,0 +1,109 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// EtcdStore implements Store on top of etcd v3: the embedded EtcdKVBackend
+// handles prefs/general KV (including Watch, so a pref changed on one node
+// is visible to e.g. topicUpdater on another within about as long as the
+// watch round-trip takes), and the Cache* methods use lease-attached keys
+// under a sibling prefix for hal.Cache()'s TTL'd blobs.
+type EtcdStore struct {
+	*EtcdKVBackend
+	cachePrefix string
+}
+
+// NewEtcdStore connects to the given etcd endpoints and returns a Store
+// namespaced under prefix (e.g. "/hal9001/store/"), with KV data and cache
+// data kept under separate sub-prefixes so a cache blob can never collide
+// with a same-named pref/KV key.
+func NewEtcdStore(endpoints []string, prefix string) (*EtcdStore, error) {
+	kv, err := NewEtcdKVBackend(endpoints, prefix+"kv/")
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdStore{EtcdKVBackend: kv, cachePrefix: prefix + "cache/"}, nil
+}
+
+func (es *EtcdStore) cacheKey(key string) string {
+	return es.cachePrefix + key
+}
+
+// CacheSet implements Store the same way EtcdKVBackend.Set implements TTL'd
+// KV entries: a ttl > 0 is an etcd lease so the entry disappears on its
+// own, matching hal.Cache()'s own expiry semantics.
+func (es *EtcdStore) CacheSet(key string, data []byte, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	k := es.cacheKey(key)
+
+	if ttl <= 0 {
+		_, err := es.client.Put(ctx, k, string(data))
+		return err
+	}
+
+	lease, err := es.client.Grant(ctx, int64(ttl.Seconds()))
+	if err != nil {
+		return err
+	}
+
+	_, err = es.client.Put(ctx, k, string(data), clientv3.WithLease(lease.ID))
+	return err
+}
+
+// CacheGet implements Store, deriving the remaining ttl from the key's
+// lease (0 if it has none, i.e. it was stored forever).
+func (es *EtcdStore) CacheGet(key string) ([]byte, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := es.client.Get(ctx, es.cacheKey(key))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, ErrKVNotFound
+	}
+
+	kv := resp.Kvs[0]
+	ttl := time.Duration(0)
+	if kv.Lease != 0 {
+		ttlResp, err := es.client.TimeToLive(ctx, clientv3.LeaseID(kv.Lease))
+		if err == nil && ttlResp.TTL > 0 {
+			ttl = time.Duration(ttlResp.TTL) * time.Second
+		}
+	}
+
+	return kv.Value, ttl, nil
+}
+
+// CacheDelete implements Store.
+func (es *EtcdStore) CacheDelete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := es.client.Delete(ctx, es.cacheKey(key))
+	return err
+}
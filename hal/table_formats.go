@@ -0,0 +1,246 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"html"
+	"strings"
+)
+
+// TableFormat identifies a table-rendering dialect. Brokers implement
+// TableFormatter to declare which one fits their native formatting, and
+// RenderTable dispatches to the matching renderer.
+type TableFormat int
+
+const (
+	TableFormatUtf8 TableFormat = iota
+	TableFormatAscii
+	TableFormatMarkdown
+	TableFormatHtml
+)
+
+// TableAlign is a per-column alignment hint for MarkdownTableWithOptions/
+// HtmlTableWithOptions. Utf8Table/AsciiTable don't take one -- their
+// header-left/data-right convention is fixed, per their existing tests.
+type TableAlign int
+
+const (
+	AlignDefault TableAlign = iota
+	AlignLeft
+	AlignRight
+	AlignCenter
+)
+
+// TableOptions configures MarkdownTableWithOptions/HtmlTableWithOptions
+// beyond their zero-value (default-aligned, unwrapped) behavior.
+type TableOptions struct {
+	Align    []TableAlign // per-column; short or nil defaults to AlignDefault
+	MaxWidth int          // wraps cells wider than this via wrapCell; 0 disables
+}
+
+func (o TableOptions) alignAt(i int) TableAlign {
+	if i >= len(o.Align) {
+		return AlignDefault
+	}
+	return o.Align[i]
+}
+
+// RenderTable renders header/rows in format, falling back to Utf8Table
+// for an unrecognized value -- the same degrade-gracefully convention
+// RichContent.String() uses for brokers without a richer mode.
+func RenderTable(format TableFormat, header []string, rows [][]string) string {
+	switch format {
+	case TableFormatAscii:
+		return AsciiTable(header, rows)
+	case TableFormatMarkdown:
+		return MarkdownTable(header, rows)
+	case TableFormatHtml:
+		return HtmlTable(header, rows)
+	default:
+		return Utf8Table(header, rows)
+	}
+}
+
+// MarkdownTable renders header/rows as a GitHub-flavored Markdown pipe
+// table with default alignment and no cell wrapping. Use
+// MarkdownTableWithOptions for alignment hints or a max cell width.
+func MarkdownTable(header []string, rows [][]string) string {
+	return MarkdownTableWithOptions(header, rows, TableOptions{})
+}
+
+// MarkdownTableWithOptions is MarkdownTable with per-column alignment
+// hints and a max cell width. GFM cells can't span multiple lines, so a
+// wrapped cell becomes additional table rows with the other columns left
+// blank rather than a literal embedded newline.
+func MarkdownTableWithOptions(header []string, rows [][]string, opts TableOptions) string {
+	ncols := len(header)
+	rows = wrapTableRows(rows, ncols, opts.MaxWidth)
+
+	var buf strings.Builder
+
+	buf.WriteString(markdownTableRow(header, ncols))
+	buf.WriteString("\n")
+	buf.WriteString(markdownTableSep(ncols, opts))
+
+	for _, row := range rows {
+		buf.WriteString("\n")
+		buf.WriteString(markdownTableRow(row, ncols))
+	}
+
+	return buf.String()
+}
+
+func markdownTableRow(row []string, ncols int) string {
+	var buf strings.Builder
+
+	buf.WriteString("|")
+	for i := 0; i < ncols; i++ {
+		buf.WriteString(" ")
+		buf.WriteString(strings.ReplaceAll(cellAt(row, i), "|", "\\|"))
+		buf.WriteString(" |")
+	}
+
+	return buf.String()
+}
+
+func markdownTableSep(ncols int, opts TableOptions) string {
+	var buf strings.Builder
+
+	buf.WriteString("|")
+	for i := 0; i < ncols; i++ {
+		buf.WriteString(" ")
+		buf.WriteString(markdownAlignCell(opts.alignAt(i)))
+		buf.WriteString(" |")
+	}
+
+	return buf.String()
+}
+
+func markdownAlignCell(align TableAlign) string {
+	switch align {
+	case AlignLeft:
+		return ":---"
+	case AlignRight:
+		return "---:"
+	case AlignCenter:
+		return ":---:"
+	default:
+		return "---"
+	}
+}
+
+// HtmlTable renders header/rows as a plain <table>/<tr>/<th>/<td>
+// document fragment, HTML-escaped, with no alignment hints or wrapping.
+// Use HtmlTableWithOptions for either.
+func HtmlTable(header []string, rows [][]string) string {
+	return HtmlTableWithOptions(header, rows, TableOptions{})
+}
+
+// HtmlTableWithOptions is HtmlTable with per-column alignment hints
+// (rendered as an inline text-align style, since this fragment isn't
+// assumed to have a stylesheet) and a max cell width that wraps long
+// cells onto <br>-separated lines instead of running them on.
+func HtmlTableWithOptions(header []string, rows [][]string, opts TableOptions) string {
+	ncols := len(header)
+
+	var buf strings.Builder
+
+	buf.WriteString("<table>\n  <tr>\n")
+	for i := 0; i < ncols; i++ {
+		buf.WriteString("    <th")
+		buf.WriteString(htmlAlignAttr(opts.alignAt(i)))
+		buf.WriteString(">")
+		buf.WriteString(html.EscapeString(cellAt(header, i)))
+		buf.WriteString("</th>\n")
+	}
+	buf.WriteString("  </tr>\n")
+
+	for _, row := range rows {
+		buf.WriteString("  <tr>\n")
+		for i := 0; i < ncols; i++ {
+			buf.WriteString("    <td")
+			buf.WriteString(htmlAlignAttr(opts.alignAt(i)))
+			buf.WriteString(">")
+			buf.WriteString(htmlCell(cellAt(row, i), opts.MaxWidth))
+			buf.WriteString("</td>\n")
+		}
+		buf.WriteString("  </tr>\n")
+	}
+	buf.WriteString("</table>")
+
+	return buf.String()
+}
+
+func htmlAlignAttr(align TableAlign) string {
+	switch align {
+	case AlignLeft:
+		return ` style="text-align:left"`
+	case AlignRight:
+		return ` style="text-align:right"`
+	case AlignCenter:
+		return ` style="text-align:center"`
+	default:
+		return ""
+	}
+}
+
+func htmlCell(s string, maxWidth int) string {
+	lines := wrapCell(s, maxWidth)
+
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		escaped[i] = html.EscapeString(line)
+	}
+
+	return strings.Join(escaped, "<br>")
+}
+
+// wrapTableRows expands each row to one or more rows of ncols columns,
+// wrapping any cell wider than maxWidth (via wrapCell) and padding the
+// other columns blank for the extra lines it produces. A no-op when
+// maxWidth <= 0.
+func wrapTableRows(rows [][]string, ncols, maxWidth int) [][]string {
+	if maxWidth <= 0 {
+		return rows
+	}
+
+	var out [][]string
+
+	for _, row := range rows {
+		wrapped := make([][]string, ncols)
+		lineCount := 1
+
+		for i := 0; i < ncols; i++ {
+			wrapped[i] = wrapCell(cellAt(row, i), maxWidth)
+			if len(wrapped[i]) > lineCount {
+				lineCount = len(wrapped[i])
+			}
+		}
+
+		for line := 0; line < lineCount; line++ {
+			newRow := make([]string, ncols)
+			for i := 0; i < ncols; i++ {
+				if line < len(wrapped[i]) {
+					newRow[i] = wrapped[i][line]
+				}
+			}
+			out = append(out, newRow)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,176 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import "strings"
+
+// eastAsianWideRanges is the set of Unicode code point ranges this package
+// treats as display-width 2 (Wide/Fullwidth, per UAX #11) when sizing
+// table columns -- CJK ideographs, kana, hangul, and fullwidth forms, the
+// blocks a plugin's table output is realistically going to contain. This
+// isn't the complete East Asian Width table (there's no unicode package
+// vendored in this tree to generate one from), just enough coverage that
+// CJK text lines up in Utf8Table/AsciiTable instead of running one column
+// short per character the way len()-based sizing used to.
+var eastAsianWideRanges = [][2]rune{
+	{0x1100, 0x115F},   // Hangul Jamo
+	{0x2E80, 0x303E},   // CJK Radicals, Kangxi, CJK symbols/punctuation
+	{0x3041, 0x33FF},   // Hiragana, Katakana, CJK compat, enclosed letters
+	{0x3400, 0x4DBF},   // CJK Unified Ideographs Extension A
+	{0x4E00, 0x9FFF},   // CJK Unified Ideographs
+	{0xA000, 0xA4CF},   // Yi Syllables/Radicals
+	{0xAC00, 0xD7A3},   // Hangul Syllables
+	{0xF900, 0xFAFF},   // CJK Compatibility Ideographs
+	{0xFF00, 0xFF60},   // Fullwidth Forms
+	{0xFFE0, 0xFFE6},   // Fullwidth Signs
+	{0x20000, 0x2FFFD}, // CJK Unified Ideographs Extension B and beyond
+}
+
+func runeWidth(r rune) int {
+	for _, rng := range eastAsianWideRanges {
+		if r >= rng[0] && r <= rng[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// displayWidth returns s's on-screen width, counting East Asian Wide/
+// Fullwidth runes (see eastAsianWideRanges) as 2 columns instead of 1, so
+// column sizing in Utf8Table/AsciiTable (and cell wrapping via wrapCell)
+// lines up for CJK text the way len(s) never could.
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// columnWidths returns the display width of the widest cell in each
+// column across header and rows, treating a short row as having empty
+// cells for the columns it's missing.
+func columnWidths(header []string, rows [][]string) []int {
+	widths := make([]int, len(header))
+
+	for i, h := range header {
+		widths[i] = displayWidth(h)
+	}
+
+	for _, row := range rows {
+		for i := range widths {
+			if i >= len(row) {
+				continue
+			}
+			if w := displayWidth(row[i]); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	return widths
+}
+
+// padRight left-justifies s within width display columns.
+func padRight(s string, width int) string {
+	if pad := width - displayWidth(s); pad > 0 {
+		return s + strings.Repeat(" ", pad)
+	}
+	return s
+}
+
+// padLeft right-justifies s within width display columns.
+func padLeft(s string, width int) string {
+	if pad := width - displayWidth(s); pad > 0 {
+		return strings.Repeat(" ", pad) + s
+	}
+	return s
+}
+
+// cellAt returns row[i], or "" if row doesn't have that many columns --
+// the same ragged-row tolerance Utf8Table's tests rely on.
+func cellAt(row []string, i int) string {
+	if i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// wrapCell breaks s into lines of at most maxWidth display columns,
+// breaking on spaces and falling back to a hard break mid-word when a
+// single word is wider than maxWidth. maxWidth <= 0 disables wrapping.
+func wrapCell(s string, maxWidth int) []string {
+	if maxWidth <= 0 || displayWidth(s) <= maxWidth {
+		return []string{s}
+	}
+
+	var lines []string
+	var line []rune
+	lineWidth := 0
+
+	flush := func() {
+		lines = append(lines, string(line))
+		line = line[:0]
+		lineWidth = 0
+	}
+
+	for _, word := range strings.Fields(s) {
+		wordWidth := displayWidth(word)
+
+		for wordWidth > maxWidth {
+			// a single word longer than maxWidth: hard-break it
+			if lineWidth > 0 {
+				flush()
+			}
+			cut := 0
+			cutWidth := 0
+			for _, r := range word {
+				rw := runeWidth(r)
+				if cutWidth+rw > maxWidth {
+					break
+				}
+				cutWidth += rw
+				cut++
+			}
+			runes := []rune(word)
+			lines = append(lines, string(runes[:cut]))
+			word = string(runes[cut:])
+			wordWidth = displayWidth(word)
+		}
+
+		sep := 0
+		if lineWidth > 0 {
+			sep = 1
+		}
+		if lineWidth+sep+wordWidth > maxWidth {
+			flush()
+			sep = 0
+		}
+		if sep == 1 {
+			line = append(line, ' ')
+			lineWidth++
+		}
+		line = append(line, []rune(word)...)
+		lineWidth += wordWidth
+	}
+
+	if lineWidth > 0 || len(lines) == 0 {
+		flush()
+	}
+
+	return lines
+}
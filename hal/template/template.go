@@ -0,0 +1,132 @@
+// Package template renders Go text/template files whose data sources are
+// hal's Prefs, Secrets, and KV store, Consul-Template-style, so a plugin
+// can keep a sidecar's config file (nginx, alertmanager, etc.) in sync
+// with settings changed from chat.
+package template
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// TemplateConfig describes one rendered config file.
+type TemplateConfig struct {
+	Source   string        // path to the text/template source file
+	Dest     string        // path the rendered output is atomically written to
+	Watch    []string      // KV keys that trigger a re-render when they change
+	Signal   os.Signal     // if set, sent to the pid in Command (a pidfile path) instead of running Command as a shell command
+	Command  string        // a shell command to run after each render; or, if Signal is set, the pidfile of the process to signal
+	Debounce time.Duration // minimum time between renders during a burst of Watch changes; defaults to 500ms
+}
+
+// templateData is the value a template source renders against, exposing
+// {{ Pref "key" }}, {{ Secret "key" }}, and {{ KV "key" }}.
+type templateData struct{}
+
+// Pref looks up key's current value. Plugins like pagerduty already store
+// structured pref values as a single string (e.g. JSON); until hal grows
+// a queryable Pref-by-key API independent of a triggering Evt, Pref reads
+// through the same KV store KV does.
+func (templateData) Pref(key string) string {
+	v, _ := hal.GetKV(key)
+	return v
+}
+
+// Secret looks up key in hal.Secrets().
+func (templateData) Secret(key string) string {
+	return hal.Secrets().Get(key)
+}
+
+// KV looks up key's current value in the hal KV store.
+func (templateData) KV(key string) string {
+	v, _ := hal.GetKV(key)
+	return v
+}
+
+// Render parses cfg.Source, renders it against live Pref/Secret/KV data,
+// atomically writes the result to cfg.Dest, and notifies the consumer per
+// cfg.Signal/cfg.Command.
+func Render(cfg TemplateConfig) error {
+	tmpl, err := template.ParseFiles(cfg.Source)
+	if err != nil {
+		return fmt.Errorf("template: failed to parse %q: %s", cfg.Source, err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buf, templateData{}); err != nil {
+		return fmt.Errorf("template: failed to render %q: %s", cfg.Source, err)
+	}
+
+	if err := atomicWrite(cfg.Dest, buf.Bytes()); err != nil {
+		return fmt.Errorf("template: failed to write %q: %s", cfg.Dest, err)
+	}
+
+	return notify(cfg)
+}
+
+// atomicWrite writes data to a temp file next to dest and renames it into
+// place, so a consumer watching dest never observes a partial write.
+func atomicWrite(dest string, data []byte) error {
+	tmp := dest + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+func notify(cfg TemplateConfig) error {
+	if cfg.Signal != nil {
+		return signalPidfile(cfg.Command, cfg.Signal)
+	}
+
+	if cfg.Command == "" {
+		return nil
+	}
+
+	return exec.Command("/bin/sh", "-c", cfg.Command).Run()
+}
+
+func signalPidfile(pidfile string, sig os.Signal) error {
+	data, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return fmt.Errorf("template: failed to read pidfile %q: %s", pidfile, err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("template: pidfile %q does not contain a valid pid: %s", pidfile, err)
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	return proc.Signal(sig)
+}
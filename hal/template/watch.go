@@ -0,0 +1,86 @@
+package template
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"log"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// Watch renders cfg once immediately, then re-renders it every time one of
+// cfg.Watch's keys changes, debouncing a burst of changes arriving within
+// cfg.Debounce of each other into a single render. It blocks until stop is
+// closed, so callers typically run it in its own goroutine.
+func Watch(cfg TemplateConfig, stop <-chan struct{}) error {
+	if err := Render(cfg); err != nil {
+		return err
+	}
+
+	debounce := cfg.Debounce
+	if debounce <= 0 {
+		debounce = 500 * time.Millisecond
+	}
+
+	changed := make(chan struct{}, 1)
+	for _, key := range cfg.Watch {
+		events, err := hal.WatchKV(key)
+		if err != nil {
+			log.Printf("template: %q will not live-update, WatchKV failed: %s", key, err)
+			continue
+		}
+
+		go forwardChanges(events, changed)
+	}
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+
+			timer = time.AfterFunc(debounce, func() {
+				if err := Render(cfg); err != nil {
+					log.Printf("template: re-render of %q failed: %s", cfg.Dest, err)
+				}
+			})
+		}
+	}
+}
+
+// forwardChanges collapses a stream of KVEvents down to a single pending
+// "something changed" signal, so a burst of writes to several watched keys
+// only ever queues up one render.
+func forwardChanges(events <-chan hal.KVEvent, changed chan<- struct{}) {
+	for range events {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
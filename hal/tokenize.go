@@ -0,0 +1,134 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+// emDash and enDash are what chat clients autocorrect a literal "--"
+// into, so Tokenize normalizes them back before splitting -- see Tokenize.
+const (
+	emDash = '—'
+	enDash = '–'
+)
+
+// Tokenize splits a single chat-message command line into an argv-style
+// list of strings with POSIX-ish shell quoting rules: single/double
+// quotes group a token (quotes removed, no escapes inside single
+// quotes), a backslash escapes the next character, and key="a b c" style
+// assignments are preserved as one token since quoting can start
+// mid-word. Unicode em/en-dashes are normalized to "--" first, since
+// chat clients like Slack autocorrect a literal "--foo" into "—foo".
+// A standalone "--" token switches everything after it to raw,
+// whitespace-split words with no further quote/escape processing, the
+// same as a shell's "--" end-of-options marker. An unterminated quote or
+// a trailing backslash is a parse error.
+func Tokenize(raw string) ([]string, error) {
+	raw = strings.ReplaceAll(raw, string(emDash), "--")
+	raw = strings.ReplaceAll(raw, string(enDash), "--")
+
+	var argv []string
+	var buf strings.Builder
+	var quote rune // 0, '\'', or '"'
+	inToken := false
+	rawMode := false
+
+	emit := func() {
+		if !inToken {
+			return
+		}
+
+		tok := buf.String()
+		argv = append(argv, tok)
+		buf.Reset()
+		inToken = false
+
+		if tok == "--" {
+			rawMode = true
+		}
+	}
+
+	runes := []rune(raw)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if isTokenizeSpace(r) && quote == 0 {
+			emit()
+			continue
+		}
+
+		if rawMode {
+			buf.WriteRune(r)
+			inToken = true
+			continue
+		}
+
+		switch {
+		case quote != 0:
+			if r == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				buf.WriteRune(runes[i])
+			} else if r == quote {
+				quote = 0
+			} else {
+				buf.WriteRune(r)
+			}
+			inToken = true
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("Tokenize: trailing backslash in %q", raw)
+			}
+			i++
+			buf.WriteRune(runes[i])
+			inToken = true
+		case r == '\'' || r == '"':
+			quote = r
+			inToken = true
+		default:
+			buf.WriteRune(r)
+			inToken = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Tokenize: unterminated %c quote in %q", quote, raw)
+	}
+
+	emit()
+
+	return argv, nil
+}
+
+func isTokenizeSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// ProcessString tokenizes line with Tokenize and runs Process against the
+// result, so a broker can call it directly on evt.Body instead of each
+// plugin hand-rolling its own argv splitting (see Evt.BodyAsArgv). A
+// Tokenize error (unterminated quote, trailing backslash) is returned
+// as-is; the returned *CmdInst is never nil, matching Process.
+func (c *Cmd) ProcessString(line string) (*CmdInst, error) {
+	argv, err := Tokenize(line)
+	if err != nil {
+		return &CmdInst{cmd: c}, err
+	}
+
+	return c.Process(argv)
+}
@@ -0,0 +1,72 @@
+package hal
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestTokenize covers the chunk5-5 quote-aware tokenizer: quote grouping,
+// backslash escapes, "--" end-of-options, em/en-dash normalization, and
+// key="a b c" style assignments.
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{`foo bar baz`, []string{"foo", "bar", "baz"}},
+		{`foo   bar`, []string{"foo", "bar"}},
+		{`foo "bar baz" qux`, []string{"foo", "bar baz", "qux"}},
+		{`foo 'bar baz' qux`, []string{"foo", "bar baz", "qux"}},
+		{`key="a b c"`, []string{"key=a b c"}},
+		{`foo\ bar`, []string{"foo bar"}},
+		{`"foo\"bar"`, []string{`foo"bar`}},
+		{`'foo\"bar'`, []string{`foo\"bar`}},
+		{"foo —bar", []string{"foo", "--bar"}},
+		{"foo –bar", []string{"foo", "--bar"}},
+		{`foo -- --bar baz`, []string{"foo", "--", "--bar", "baz"}},
+		{``, nil},
+	}
+
+	for _, c := range cases {
+		got, err := Tokenize(c.in)
+		if err != nil {
+			t.Errorf("Tokenize(%q) returned unexpected error: %s", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Tokenize(%q) = %#v, want %#v", c.in, got, c.want)
+		}
+	}
+}
+
+// TestTokenizeErrors covers the two documented parse-error cases: an
+// unterminated quote and a trailing backslash.
+func TestTokenizeErrors(t *testing.T) {
+	for _, in := range []string{`foo "bar`, `foo 'bar`, `foo\`} {
+		if _, err := Tokenize(in); err == nil {
+			t.Errorf("Tokenize(%q) expected an error, got nil", in)
+		}
+	}
+}
+
+// TestProcessString covers ProcessString wiring Tokenize into Process, so
+// a broker can call it directly on a chat message body.
+func TestProcessString(t *testing.T) {
+	root := NewCmd("widget", true)
+	root.AddSubCmd("make").AddKVParam("name", true)
+
+	res, err := root.ProcessString(`widget make name="red door"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SubCmdToken() != "make" {
+		t.Errorf("wrong subcommand. Expected %q, got %q", "make", res.SubCmdToken())
+	}
+	if got := res.SubCmdInst().GetKVParamInst("name").MustString(); got != "red door" {
+		t.Errorf("name = %q, want %q", got, "red door")
+	}
+
+	if _, err := root.ProcessString(`widget make name="unterminated`); err == nil {
+		t.Error("expected a Tokenize error to propagate from ProcessString")
+	}
+}
@@ -1,8 +1,12 @@
 package hal
 
 import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"reflect"
 	"sync"
 	"time"
@@ -28,6 +32,75 @@ func Cache() *ttlCache {
 	return &ttlcache
 }
 
+// CacheBackend is the storage interface Cache() drives in place of its
+// in-process map when one is configured, so multiple hal-9001 instances
+// (e.g. behind a load balancer for the HTTP handlers plugins like archive
+// register) share cached values such as pagerduty.escalation_policies
+// instead of each instance computing its own. It's narrower than Store
+// (no prefs/general KV, just caching) for deployments that want shared
+// caching without standing up a full Store. See RedisCacheBackend.
+type CacheBackend interface {
+	// Set stores data under key for ttl (0 meaning forever).
+	Set(key string, data []byte, ttl time.Duration) error
+	// Get returns the bytes stored under key and however much of its ttl
+	// remains. err is ErrKVNotFound if key doesn't exist or expired.
+	Get(key string) (data []byte, ttl time.Duration, err error)
+	// Delete removes key, notifying any peers sharing this backend so they
+	// can drop their own in-process copy. It is not an error to delete a
+	// key that doesn't exist.
+	Delete(key string) error
+}
+
+var (
+	cacheBackendMut sync.Mutex
+	cacheBackend    CacheBackend // nil means "fall back to a configured Store, then the in-process map"
+)
+
+// SetCacheBackend installs b as the backend Cache() drives ahead of a
+// configured Store (see SetStore) or its own in-process map. Call with nil
+// to revert to that fallback order.
+func SetCacheBackend(b CacheBackend) {
+	cacheBackendMut.Lock()
+	cacheBackend = b
+	cacheBackendMut.Unlock()
+}
+
+func getCacheBackend() CacheBackend {
+	cacheBackendMut.Lock()
+	defer cacheBackendMut.Unlock()
+
+	return cacheBackend
+}
+
+// gobEncode and gobDecode are used instead of encoding/json by
+// CacheBackend implementations (see RedisCacheBackend) so a round trip
+// through []byte still reflects back to the exact concrete type v pointed
+// at, the same way the in-process path and Store's json-encoded path do.
+func gobEncode(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func gobDecode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// invalidateLocal drops key's in-process entry without touching a
+// configured CacheBackend/Store -- used by a CacheBackend (see
+// RedisCacheBackend's invalidation subscriber) to apply a peer's
+// invalidation notice without re-triggering its own Delete.
+func (cache *ttlCache) invalidateLocal(key string) {
+	cache.mut.Lock()
+	delete(cache.items, key)
+	delete(cache.times, key)
+	delete(cache.ttls, key)
+	cache.mut.Unlock()
+}
+
 // Get retreives a cached value and stores the result in the value pointed to by v.
 // The time to live is returned and may be 0 to indicate the item is expired.
 // e.g.
@@ -37,6 +110,24 @@ func Cache() *ttlCache {
 // if err != nil { panic(err) }
 // if ttl == 0 { panic("stale cache!") }
 func (cache *ttlCache) Get(key string, v interface{}) (time.Duration, error) {
+	if b := getCacheBackend(); b != nil {
+		data, ttl, err := b.Get(key)
+		if err == nil {
+			return ttl, gobDecode(data, v)
+		}
+		if err != ErrKVNotFound {
+			log.Printf("hal: CacheBackend.Get(%q) failed, falling back to the in-process cache: %s", key, err)
+		}
+	} else if s := getStore(); s != nil {
+		data, ttl, err := s.CacheGet(key)
+		if err == nil {
+			return ttl, json.Unmarshal(data, v)
+		}
+		if err != ErrKVNotFound {
+			log.Printf("hal: Store.CacheGet(%q) failed, falling back to the in-process cache: %s", key, err)
+		}
+	}
+
 	cache.mut.Lock()
 	defer cache.mut.Unlock()
 
@@ -48,45 +139,95 @@ func (cache *ttlCache) Get(key string, v interface{}) (time.Duration, error) {
 		ttl = time.Duration(int(ttlsecs)) * time.Second
 	}
 
-	cached := cache.items[key]
+	return ttl, assignInto(cache.items[key], v)
+}
+
+// assignInto copies cached into the value pointed to by v, the shared
+// tail of Get and GetOrLoad's in-process path.
+func assignInto(cached, v interface{}) error {
 	vtype := reflect.TypeOf(v)
 	ctype := reflect.TypeOf(cached)
 
 	// make sure the input type matches the type in the cache
 	if vtype != ctype {
 		msg := fmt.Sprintf("Type mismatch: got %q, expected %q", vtype.Name(), ctype.Name())
-		return ttl, errors.New(msg)
+		return errors.New(msg)
 	}
 
 	// make sure it's a pointer and is not nil
 	vval := reflect.ValueOf(v)
 	if vval.Kind() != reflect.Ptr || vval.IsNil() {
-		return ttl, errors.New("The second argument of Get() must be a non-nil pointer.")
+		return errors.New("The second argument of Get() must be a non-nil pointer.")
 	}
 
 	// set the value
 	cval := reflect.ValueOf(cached)
 	vval.Elem().Set(cval.Elem())
 
-	return ttl, nil
+	return nil
 }
 
+// Set stores v both in the in-process map (so Age/Ttl always have
+// something to report) and, when a CacheBackend or Store is configured, in
+// it too -- so Get's []byte round-trips back into whatever concrete type
+// the caller's v pointed at. A CacheBackend takes priority over a Store
+// and encodes with gob rather than json (see CacheBackend).
 func (cache *ttlCache) Set(key string, v interface{}, ttl time.Duration) {
 	cache.mut.Lock()
-	defer cache.mut.Unlock()
-
 	cache.items[key] = v
 	cache.times[key] = time.Now()
 	cache.ttls[key] = ttl
+	cache.mut.Unlock()
+
+	if b := getCacheBackend(); b != nil {
+		data, err := gobEncode(v)
+		if err != nil {
+			log.Printf("hal: failed to gob-encode %q for the configured CacheBackend, relying on the in-process cache only: %s", key, err)
+			return
+		}
+
+		if err := b.Set(key, data, ttl); err != nil {
+			log.Printf("hal: CacheBackend.Set(%q) failed, relying on the in-process cache only: %s", key, err)
+		}
+
+		return
+	}
+
+	s := getStore()
+	if s == nil {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("hal: failed to marshal %q for the configured Store, relying on the in-process cache only: %s", key, err)
+		return
+	}
+
+	if err := s.CacheSet(key, data, ttl); err != nil {
+		log.Printf("hal: Store.CacheSet(%q) failed, relying on the in-process cache only: %s", key, err)
+	}
 }
 
 func (cache *ttlCache) Delete(key string) {
 	cache.mut.Lock()
-	defer cache.mut.Unlock()
-
 	delete(cache.items, key)
 	delete(cache.times, key)
 	delete(cache.ttls, key)
+	cache.mut.Unlock()
+
+	if b := getCacheBackend(); b != nil {
+		if err := b.Delete(key); err != nil {
+			log.Printf("hal: CacheBackend.Delete(%q) failed: %s", key, err)
+		}
+		return
+	}
+
+	if s := getStore(); s != nil {
+		if err := s.CacheDelete(key); err != nil {
+			log.Printf("hal: Store.CacheDelete(%q) failed: %s", key, err)
+		}
+	}
 }
 
 func (cache *ttlCache) Age(key string) time.Duration {
@@ -102,3 +243,107 @@ func (cache *ttlCache) Ttl(key string) time.Duration {
 
 	return cache.ttls[key]
 }
+
+// inflightCall is one in-progress loader call being shared across
+// concurrent GetOrLoad callers for the same key. See singleflightDo.
+type inflightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+var (
+	inflightMut   sync.Mutex
+	inflightCalls = make(map[string]*inflightCall)
+)
+
+// singleflightDo coalesces concurrent calls for the same key, a la
+// golang.org/x/sync/singleflight: the first caller runs loader and shares
+// its result with every other caller that arrives before it finishes,
+// instead of each of them calling loader too.
+func singleflightDo(key string, loader func() (interface{}, error)) (interface{}, error) {
+	inflightMut.Lock()
+	if call, ok := inflightCalls[key]; ok {
+		inflightMut.Unlock()
+		call.wg.Wait()
+		return call.val, call.err
+	}
+
+	call := &inflightCall{}
+	call.wg.Add(1)
+	inflightCalls[key] = call
+	inflightMut.Unlock()
+
+	call.val, call.err = loader()
+	call.wg.Done()
+
+	inflightMut.Lock()
+	delete(inflightCalls, key)
+	inflightMut.Unlock()
+
+	return call.val, call.err
+}
+
+// GetOrLoad returns the cached value for key in v, the same as Get, but
+// on a cold/expired entry calls loader to repopulate it instead of
+// leaving that to the caller -- and coalesces concurrent misses for the
+// same key via singleflightDo, so a sudden spike of callers against a
+// cold key (e.g. after a restart) triggers loader once rather than once
+// per caller. e.g.
+//
+//	policies := []EscalationPolicy{}
+//	err := hal.Cache().GetOrLoad("pagerduty.escalation_policies", &policies, time.Hour, func() (interface{}, error) {
+//		p, err := fetchEscalationPolicies()
+//		return &p, err
+//	})
+func (cache *ttlCache) GetOrLoad(key string, v interface{}, ttl time.Duration, loader func() (interface{}, error)) error {
+	if cachedTtl, err := cache.Get(key, v); err == nil && cachedTtl > 0 {
+		return nil
+	}
+
+	val, err := singleflightDo(key, loader)
+	if err != nil {
+		return err
+	}
+
+	cache.Set(key, val, ttl)
+
+	return assignInto(val, v)
+}
+
+// SetWithRefresh stores v like Set, and additionally arms a background
+// refresh: once the entry's age passes ttl-refreshAhead, a goroutine
+// calls loader (coalesced via singleflightDo, in case a concurrent
+// GetOrLoad miss raced it) and re-Sets the result, then re-arms itself --
+// so a hot key stays ahead of its own expiry instead of every caller
+// eventually observing a cold Get. The chain stops re-arming once key's
+// ttl no longer matches what it was armed with, e.g. after Delete or a
+// plain Set/SetWithRefresh call with a different ttl.
+func (cache *ttlCache) SetWithRefresh(key string, v interface{}, ttl, refreshAhead time.Duration, loader func() (interface{}, error)) {
+	cache.Set(key, v, ttl)
+	cache.armRefresh(key, ttl, refreshAhead, loader)
+}
+
+func (cache *ttlCache) armRefresh(key string, ttl, refreshAhead time.Duration, loader func() (interface{}, error)) {
+	wait := ttl - refreshAhead
+	if wait <= 0 {
+		wait = ttl
+	}
+
+	go func() {
+		time.Sleep(wait)
+
+		if cache.Ttl(key) != ttl {
+			return // key moved on without us -- don't re-arm
+		}
+
+		val, err := singleflightDo(key, loader)
+		if err != nil {
+			log.Printf("hal: SetWithRefresh background reload of %q failed, keeping the stale value until it expires: %s", key, err)
+			return
+		}
+
+		cache.Set(key, val, ttl)
+		cache.armRefresh(key, ttl, refreshAhead, loader)
+	}()
+}
@@ -0,0 +1,80 @@
+package hal
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"strings"
+)
+
+// Utf8Table renders header/rows as a box-drawing table for monospace
+// terminals: headers left-aligned, data cells right-aligned, columns
+// sized to their widest cell (see displayWidth, which accounts for CJK
+// characters rendering two columns wide). rows may be ragged -- a row
+// with fewer cells than header is padded with blanks.
+func Utf8Table(header []string, rows [][]string) string {
+	widths := columnWidths(header, rows)
+
+	var buf strings.Builder
+
+	buf.WriteString(utf8TableBorder(widths, "╔", "═", "╤", "╗"))
+	buf.WriteString("\n")
+	buf.WriteString(utf8TableRow(header, widths, padRight))
+	buf.WriteString("\n")
+	buf.WriteString(utf8TableBorder(widths, "╟", "─", "┼", "╢"))
+	buf.WriteString("\n")
+
+	for _, row := range rows {
+		buf.WriteString(utf8TableRow(row, widths, padLeft))
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString(utf8TableBorder(widths, "╚", "═", "╧", "╝"))
+
+	return buf.String()
+}
+
+func utf8TableBorder(widths []int, left, fill, sep, right string) string {
+	var buf strings.Builder
+
+	buf.WriteString(left)
+	for i, w := range widths {
+		if i > 0 {
+			buf.WriteString(sep)
+		}
+		buf.WriteString(strings.Repeat(fill, w+2))
+	}
+	buf.WriteString(right)
+
+	return buf.String()
+}
+
+func utf8TableRow(row []string, widths []int, pad func(string, int) string) string {
+	var buf strings.Builder
+
+	buf.WriteString("║")
+	for i, w := range widths {
+		if i > 0 {
+			buf.WriteString("│")
+		}
+		buf.WriteString(" ")
+		buf.WriteString(pad(cellAt(row, i), w))
+		buf.WriteString(" ")
+	}
+	buf.WriteString("║")
+
+	return buf.String()
+}
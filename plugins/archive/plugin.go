@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/netflix/hal-9001/hal"
+	"github.com/netflix/hal-9001/hal/httpauth"
 	"github.com/nlopes/slack"
 )
 
@@ -45,7 +47,30 @@ CREATE TABLE IF NOT EXISTS reactions (
   PRIMARY KEY (ts,user,room,broker)
 )`
 
+// cli is the "!archive search ..." command tree. See init() and
+// archiveSearchCmd.
+var cli *hal.Cmd
+
+func init() {
+	cli = hal.NewCmd("archive", true).SetUsage("search and inspect the message archive")
+
+	search := cli.AddSubCmd("search").SetUsage("search archived messages, filtered by any combination of the parameters below")
+	search.AddKVParam("user", false).SetUsage("only messages from this user id")
+	search.AddKVParam("room", false).SetUsage("only messages from this room id")
+	search.AddKVParam("broker", false).SetUsage("only messages from this broker, e.g. 'slack'")
+	search.AddKVParam("reaction", false).SetUsage("only messages with this reaction attached, e.g. 'thumbsup'")
+	search.AddKVParam("body", false).SetUsage("substring match against the message body")
+	search.AddKVParam("since", false).SetUsage(fmt.Sprintf("only messages at/after this time (default: %s ago)", DefaultSearchWindow))
+	search.AddKVParam("until", false).SetUsage("only messages at/before this time")
+	search.AddKVParam("limit", false).SetUsage(fmt.Sprintf("max rows to return (default %d, max %d)", DefaultSearchLimit, MaxSearchLimit))
+	search.AddKVParam("offset", false).SetUsage("rows to skip, for paging through results")
+}
+
 func Register() {
+	if err := ConfigureStoreFromSecrets(); err != nil {
+		log.Printf("archive: failed to configure %q store, falling back to sql: %s\n", hal.Secrets().Get(ArchiveBackendKey), err)
+	}
+
 	archive := hal.Plugin{
 		Name: "message_archive",
 		Func: archiveRecorder,
@@ -58,20 +83,35 @@ func Register() {
 	}
 	reactions.Register()
 
-	// apply the schema to the database as necessary
+	search := hal.Plugin{
+		Name:  "archive_search",
+		Func:  archiveCmd,
+		Regex: "^[[:space:]]*!archive",
+	}
+	search.Register()
+
+	// the sql schema only applies when the sql store is actually in use,
+	// but it's idempotent (CREATE TABLE IF NOT EXISTS) and cheap, so it's
+	// simplest to always apply it rather than threading the backend
+	// selection through here too.
 	hal.SqlInit(ArchiveTable)
 	hal.SqlInit(ReactionTable)
 
-	http.HandleFunc("/v1/archive", httpGetArchive)
+	// all three routes require a bearer token minted by "!token issue" (see
+	// plugins/tokenmgr), so an operator can hand out a narrow, expiring
+	// URL instead of leaving the whole archive open to anyone who can
+	// reach this port.
+	http.HandleFunc("/v1/archive", httpauth.Require(httpGetArchive))
+	http.HandleFunc("/v1/archive/search", httpauth.Require(httpSearchArchive))
+	http.HandleFunc("/v1/reactions/top", httpauth.Require(httpTopReactions))
 }
 
-// ArchiveRecorder inserts every message received into the database for use
-// by other parts of the system.
+// ArchiveRecorder inserts every message received into the archive store
+// for use by other parts of the system.
 func archiveRecorder(evt hal.Evt) {
 	// TODO: push the id into the table after fixing up hal.Evt to pass through message id
 	tmpBrokenOnSlackId := fmt.Sprintf("%d", evt.Time.UnixNano())
-	sql := `INSERT INTO archive (id, user, room, broker, ts, body) VALUES (?, ?, ?, ?, ?, ?)`
-	_, err := hal.SqlDB().Exec(sql, tmpBrokenOnSlackId, evt.UserId, evt.RoomId, evt.BrokerName(), evt.Time, evt.Body)
+	err := activeStore.Insert(tmpBrokenOnSlackId, evt.UserId, evt.RoomId, evt.BrokerName(), evt.Time, evt.Body)
 	if err != nil {
 		log.Printf("Could not insert event into archive: %s\n", err)
 	}
@@ -85,6 +125,7 @@ func archiveReaction(evt hal.Evt) {
 		log.Printf("adding reaction: (%T) %q\n", evt.Original, evt.Body)
 		rae := evt.Original.(*slack.ReactionAddedEvent)
 		insertReaction(evt.Time, rae.Item.Timestamp, evt.UserId, evt.RoomId, evt.BrokerName(), rae.Reaction)
+		dispatchReaction(evt, rae.Item.Timestamp, rae.Reaction)
 	case *slack.ReactionRemovedEvent:
 		log.Printf("deleting reaction: (%T) %q\n", evt.Original, evt.Body)
 		rre := evt.Original.(*slack.ReactionRemovedEvent)
@@ -97,19 +138,116 @@ func archiveReaction(evt hal.Evt) {
 }
 
 func insertReaction(ts time.Time, id, user, room, broker, reaction string) {
-	sql := `INSERT INTO reactions (id,user,room,broker,ts,reaction) VALUES (?,?,?,?,?,?)`
-	_, err := hal.SqlDB().Exec(sql, id, user, room, broker, ts, reaction)
+	err := activeStore.InsertReaction(ts, id, user, room, broker, reaction)
 	if err != nil {
-		log.Printf("Could not insert reaction into reactions table: %s\n", err)
+		log.Printf("Could not insert reaction into the archive store: %s\n", err)
 	}
 }
 
 func deleteReaction(id, user, room, broker, reaction string) {
-	sql := `DELETE FROM reactions WHERE id=? AND user=? AND room=? AND broker=? AND reaction=?`
-	_, err := hal.SqlDB().Exec(sql, id, user, room, broker, reaction)
+	err := activeStore.DeleteReaction(id, user, room, broker, reaction)
+	if err != nil {
+		log.Printf("Could not delete reaction from the archive store: %s\n", err)
+	}
+}
+
+// archiveCmd is called when someone executes !archive in the chat system.
+func archiveCmd(evt hal.Evt) {
+	inst, err := cli.ProcessString(evt.Body)
+	if err != nil {
+		evt.Reply(err.Error())
+		return
+	}
+
+	if inst.HelpRequested() {
+		evt.Reply(inst.Help().String())
+		return
+	}
+
+	switch inst.SubCmdToken() {
+	case "search":
+		archiveSearchCmd(evt, inst.SubCmdInst())
+	default:
+		evt.Reply(inst.Help().String())
+	}
+}
+
+// archiveSearchCmd implements "!archive search ..." by translating the
+// parsed parameters into an ArchiveQuery and replying with the results as
+// a table.
+func archiveSearchCmd(evt hal.Evt, sc *hal.SubCmdInst) {
+	q := ArchiveQuery{
+		User:     kvParamString(sc, "user"),
+		Room:     kvParamString(sc, "room"),
+		Broker:   kvParamString(sc, "broker"),
+		Reaction: kvParamString(sc, "reaction"),
+		Body:     kvParamString(sc, "body"),
+		Offset:   kvParamInt(sc, "offset", 0),
+		Limit:    kvParamInt(sc, "limit", DefaultSearchLimit),
+	}
+
+	var err error
+
+	if q.Since, err = kvParamTime(sc, "since"); err != nil {
+		evt.Replyf("invalid --since: %s", err)
+		return
+	}
+
+	if q.Until, err = kvParamTime(sc, "until"); err != nil {
+		evt.Replyf("invalid --until: %s", err)
+		return
+	}
+
+	aes, err := SearchArchive(q)
 	if err != nil {
-		log.Printf("Could not delete reaction from reactions table: %s\n", err)
+		evt.Replyf("archive search failed: %s", err)
+		return
 	}
+
+	if len(aes) == 0 {
+		evt.Reply("no matching messages found")
+		return
+	}
+
+	header := []string{"time", "user", "room", "broker", "body"}
+	rows := make([][]string, len(aes))
+	for i, ae := range aes {
+		rows[i] = []string{ae.Timestamp.Format(time.RFC3339), ae.User, ae.Room, ae.Broker, ae.Body}
+	}
+
+	evt.Reply(hal.AsciiTable(header, rows))
+}
+
+// kvParamString returns key's value, or "" if it wasn't set -- sc's kv
+// params are all optional, so GetKVParamInst's panic-on-missing isn't
+// appropriate here.
+func kvParamString(sc *hal.SubCmdInst, key string) string {
+	if pi := sc.GetKVParamInstIfSet(key); pi != nil {
+		return pi.Value()
+	}
+
+	return ""
+}
+
+// kvParamInt returns key's value parsed as an int, or def if it wasn't set.
+func kvParamInt(sc *hal.SubCmdInst, key string, def int) int {
+	pi := sc.GetKVParamInstIfSet(key)
+	if pi == nil {
+		return def
+	}
+
+	return pi.DefInt(def)
+}
+
+// kvParamTime returns key's value parsed as a time.Time, or the zero Time
+// if it wasn't set. See (*hal.KVParamInst).Time for the accepted formats.
+func kvParamTime(sc *hal.SubCmdInst, key string) (time.Time, error) {
+	pi := sc.GetKVParamInstIfSet(key)
+	if pi == nil {
+		return time.Time{}, nil
+	}
+
+	return pi.Time()
 }
 
 // httpGetArchive retreives the 50 latest items from the event archive.
@@ -129,35 +267,210 @@ func httpGetArchive(w http.ResponseWriter, r *http.Request) {
 	w.Write(js)
 }
 
-// FetchArchive selects messages from the archive table up to the provided number of messages limit.
+// httpSearchArchive implements GET /v1/archive/search, exposing
+// SearchArchive's filters as query parameters:
+//
+//	?user=&room=&broker=&reaction=&body=&since=&until=&limit=&offset=
+//
+// since/until are RFC3339 timestamps; limit/offset are integers.
+func httpSearchArchive(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	aq := ArchiveQuery{
+		User:     q.Get("user"),
+		Room:     q.Get("room"),
+		Broker:   q.Get("broker"),
+		Reaction: q.Get("reaction"),
+		Body:     q.Get("body"),
+	}
+
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %q: %s", v, err), http.StatusBadRequest)
+			return
+		}
+		aq.Since = since
+	}
+
+	if v := q.Get("until"); v != "" {
+		until, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid until %q: %s", v, err), http.StatusBadRequest)
+			return
+		}
+		aq.Until = until
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid limit %q: %s", v, err), http.StatusBadRequest)
+			return
+		}
+		aq.Limit = limit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid offset %q: %s", v, err), http.StatusBadRequest)
+			return
+		}
+		aq.Offset = offset
+	}
+
+	aes, err := SearchArchive(aq)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not search message archive: '%s'", err), 500)
+		return
+	}
+
+	js, err := json.Marshal(aes)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not marshal archive to json: '%s'", err), 500)
+		return
+	}
+
+	w.Write(js)
+}
+
+// FetchArchive selects the most recent messages from the archive store, up
+// to limit, defaulting to the last DefaultSearchWindow. It's a thin
+// convenience wrapper around the active Store's Fetch for callers that
+// just want a recency dump with no filters -- see SearchArchive for
+// filtered/paginated search.
 func FetchArchive(limit int) ([]*ArchiveEntry, error) {
-	db := hal.SqlDB()
+	return activeStore.Fetch(limit)
+}
 
-	sql := `SELECT ts, user, room, broker, body
-	          FROM archive
-			  WHERE ts > (NOW() - INTERVAL '1 day')
-			  ORDER BY ts DESC`
+// DefaultSearchWindow is how far back SearchArchive looks when Since is
+// left zero, matching the window FetchArchive always used before
+// SearchArchive existed.
+const DefaultSearchWindow = 24 * time.Hour
+
+// DefaultSearchLimit and MaxSearchLimit bound SearchArchive's row count
+// when Limit is left zero or set too high, so an unfiltered search (or a
+// malicious/mistaken --limit) can't scan the whole table.
+const DefaultSearchLimit = 50
+const MaxSearchLimit = 500
+
+// ArchiveQuery filters and paginates a SearchArchive call. Every field is
+// optional; a zero-valued ArchiveQuery{} behaves like the old hardcoded
+// FetchArchive query (last DefaultSearchWindow, DefaultSearchLimit rows).
+type ArchiveQuery struct {
+	User     string
+	Room     string
+	Broker   string
+	Reaction string    // joins against the reactions table
+	Body     string    // substring match against the message body
+	Since    time.Time // defaults to DefaultSearchWindow ago when zero
+	Until    time.Time // no upper bound when zero
+	Limit    int       // defaults to DefaultSearchLimit, capped at MaxSearchLimit
+	Offset   int
+}
 
-	rows, err := db.Query(sql)
+// SearchArchive runs a filtered, paginated search against the active
+// Store, joining against reactions when Reaction is set. See ArchiveQuery
+// for the filters and their defaults, and Store/EtcdStore for the two
+// backends this can route to.
+func SearchArchive(q ArchiveQuery) ([]*ArchiveEntry, error) {
+	return activeStore.Search(q)
+}
+
+// ReactionFilter narrows a CountReactions query. Every field is optional;
+// a zero-valued ReactionFilter matches every reaction ever recorded.
+type ReactionFilter struct {
+	ID     string // one specific message, by its archive id
+	Room   string
+	Broker string
+	Since  time.Time
+}
+
+// ReactionLeaderboardEntry is one row of TopReactedMessages' result: a
+// message plus how many reactions (of any kind) it has accumulated.
+type ReactionLeaderboardEntry struct {
+	ID     string
+	User   string
+	Room   string
+	Broker string
+	Body   string
+	Count  int
+}
+
+// CountReactions returns how many times each reaction name has been
+// attached to messages matching filter, keyed by reaction name.
+func CountReactions(filter ReactionFilter) (map[string]int, error) {
+	return activeStore.CountReactions(filter)
+}
+
+// TopReactedMessages returns the most-reacted-to messages in room since
+// the given time, up to limit, ordered by total reaction count
+// descending. An empty room matches every room.
+func TopReactedMessages(room string, since time.Time, limit int) ([]ReactionLeaderboardEntry, error) {
+	return activeStore.TopReactedMessages(room, since, limit)
+}
+
+// dispatchReaction notifies hal.OnReaction subscribers after a reaction is
+// recorded, looking up the original message's body and its current
+// reaction counts so handlers don't have to hit the store themselves.
+func dispatchReaction(evt hal.Evt, id, reaction string) {
+	body, err := activeStore.MessageBody(id, evt.RoomId, evt.BrokerName())
+	if err != nil {
+		log.Printf("archive: could not look up message body for reaction dispatch: %s\n", err)
+		return
+	}
+
+	counts, err := activeStore.CountReactions(ReactionFilter{ID: id, Room: evt.RoomId, Broker: evt.BrokerName()})
 	if err != nil {
-		log.Printf("archive query failed: %s\n", err)
-		return nil, err
+		log.Printf("archive: could not count reactions for reaction dispatch: %s\n", err)
+		return
 	}
-	defer rows.Close()
 
-	aes := []*ArchiveEntry{}
+	hal.ReactionRegistry().DispatchReaction(reaction, evt, hal.ReactionContext{MessageBody: body, Counts: counts})
+}
 
-	for rows.Next() {
-		ae := ArchiveEntry{}
+// httpTopReactions implements GET /v1/reactions/top, exposing
+// TopReactedMessages as query parameters:
+//
+//	?room=&since=&limit=
+//
+// since is an RFC3339 timestamp (default DefaultSearchWindow ago); limit
+// is an integer.
+func httpTopReactions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
 
-		err = rows.Scan(&ae.Timestamp, &ae.User, &ae.Room, &ae.Broker, &ae.Body)
+	since := time.Now().Add(-DefaultSearchWindow)
+	if v := q.Get("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
 		if err != nil {
-			log.Printf("Row iteration failed: %s\n", err)
-			return nil, err
+			http.Error(w, fmt.Sprintf("invalid since %q: %s", v, err), http.StatusBadRequest)
+			return
 		}
+		since = parsed
+	}
+
+	limit := DefaultSearchLimit
+	if v := q.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid limit %q: %s", v, err), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
 
-		aes = append(aes, &ae)
+	entries, err := TopReactedMessages(q.Get("room"), since, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not fetch reaction leaderboard: '%s'", err), 500)
+		return
 	}
 
-	return aes, nil
+	js, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not marshal reaction leaderboard to json: '%s'", err), 500)
+		return
+	}
+
+	w.Write(js)
 }
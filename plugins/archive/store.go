@@ -0,0 +1,354 @@
+package archive
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// ArchiveBackendKey selects the Store Register() installs: "sql" (the
+// default, hal.SqlDB()-backed) or "etcd" (see NewEtcdStore). It's read
+// from hal.Secrets() so it can be baked into the same secrets payload as
+// the DSN/etcd endpoints, e.g.:
+//
+//	!secrets set --key archive.backend --value etcd
+const ArchiveBackendKey = "archive.backend"
+
+// ArchiveEtcdEndpointsKey holds a comma-separated list of etcd endpoints,
+// consulted when ArchiveBackendKey is "etcd".
+const ArchiveEtcdEndpointsKey = "archive.etcd-endpoints"
+
+// ArchiveEtcdPrefix is the default key prefix NewEtcdStore namespaces
+// itself under.
+const ArchiveEtcdPrefix = "/hal9001/archive/"
+
+// Store is the persistence interface the archive plugin drives, pulled out
+// so deployments without a relational database can run hal-9001 (see
+// EtcdStore). The default, sqlStore, wraps the pre-existing
+// ArchiveTable/ReactionTable schema.
+type Store interface {
+	// Insert records one observed message.
+	Insert(id, user, room, broker string, ts time.Time, body string) error
+	// Fetch returns the most recent messages, up to limit, with no other
+	// filtering -- see Search for the filtered/paginated form.
+	Fetch(limit int) ([]*ArchiveEntry, error)
+	// InsertReaction records a reaction added to the message identified by
+	// id/user/room/broker.
+	InsertReaction(ts time.Time, id, user, room, broker, reaction string) error
+	// DeleteReaction removes a reaction previously recorded by
+	// InsertReaction.
+	DeleteReaction(id, user, room, broker, reaction string) error
+	// Search runs a filtered, paginated query -- see ArchiveQuery.
+	Search(q ArchiveQuery) ([]*ArchiveEntry, error)
+	// MessageBody returns the body of the message identified by
+	// id/room/broker, for ReactionContext lookups -- see archiveReaction.
+	MessageBody(id, room, broker string) (string, error)
+	// CountReactions returns how many times each reaction name has been
+	// attached to messages matching filter, keyed by reaction name.
+	CountReactions(filter ReactionFilter) (map[string]int, error)
+	// TopReactedMessages returns the messages with the most reactions (of
+	// any kind) in room since the given time, up to limit, ordered by
+	// total reaction count descending. An empty room matches every room.
+	TopReactedMessages(room string, since time.Time, limit int) ([]ReactionLeaderboardEntry, error)
+}
+
+// ErrMessageNotFound is returned by MessageBody when no archived message
+// matches the given id/room/broker.
+var ErrMessageNotFound = errors.New("archive: message not found")
+
+// activeStore is the Store Register() installs, defaulting to sqlStore so
+// a plugin built without ever calling ConfigureStoreFromSecrets keeps
+// behaving exactly as it always has.
+var activeStore Store = sqlStore{}
+
+// ConfigureStoreFromSecrets installs the Store named by ArchiveBackendKey
+// ("etcd", connecting to ArchiveEtcdEndpointsKey, or "sql"/"", the
+// default), checked first in hal.Secrets() and then, if unset there, as a
+// regular pref/KV entry -- so the backend can also be flipped at runtime
+// with "!prefs set --key archive.backend --value etcd" once a node can
+// already reach one. Register calls this once at startup; it's exported
+// mainly so tests and alternate entrypoints can reconfigure the backend
+// explicitly.
+func ConfigureStoreFromSecrets() error {
+	backend := hal.Secrets().Get(ArchiveBackendKey)
+	if backend == "" {
+		if v, err := hal.GetKV(ArchiveBackendKey); err == nil {
+			backend = v
+		}
+	}
+
+	switch backend {
+	case "", "sql":
+		activeStore = sqlStore{}
+		return nil
+	case "etcd":
+		endpoints := strings.Split(hal.Secrets().Get(ArchiveEtcdEndpointsKey), ",")
+		s, err := NewEtcdStore(endpoints, ArchiveEtcdPrefix)
+		if err != nil {
+			return err
+		}
+		activeStore = s
+		return nil
+	default:
+		log.Printf("archive: unknown %s value %q, falling back to the sql store", ArchiveBackendKey, backend)
+		activeStore = sqlStore{}
+		return nil
+	}
+}
+
+// sqlStore implements Store on top of ArchiveTable/ReactionTable via
+// hal.SqlDB() -- the behavior the archive plugin always had before Store
+// existed.
+type sqlStore struct{}
+
+// Insert implements Store.
+func (sqlStore) Insert(id, user, room, broker string, ts time.Time, body string) error {
+	sql := `INSERT INTO archive (id, user, room, broker, ts, body) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := hal.SqlDB().Exec(sql, id, user, room, broker, ts, body)
+	return err
+}
+
+// InsertReaction implements Store.
+func (sqlStore) InsertReaction(ts time.Time, id, user, room, broker, reaction string) error {
+	sql := `INSERT INTO reactions (id,user,room,broker,ts,reaction) VALUES (?,?,?,?,?,?)`
+	_, err := hal.SqlDB().Exec(sql, id, user, room, broker, ts, reaction)
+	return err
+}
+
+// DeleteReaction implements Store.
+func (sqlStore) DeleteReaction(id, user, room, broker, reaction string) error {
+	sql := `DELETE FROM reactions WHERE id=? AND user=? AND room=? AND broker=? AND reaction=?`
+	_, err := hal.SqlDB().Exec(sql, id, user, room, broker, reaction)
+	return err
+}
+
+// Fetch implements Store as a Search with no filters beyond limit.
+func (s sqlStore) Fetch(limit int) ([]*ArchiveEntry, error) {
+	return s.Search(ArchiveQuery{Limit: limit})
+}
+
+// Search implements Store, building a dynamic WHERE clause from whichever
+// ArchiveQuery fields are set -- see ArchiveQuery for the filters and
+// their defaults.
+func (sqlStore) Search(q ArchiveQuery) ([]*ArchiveEntry, error) {
+	db := hal.SqlDB()
+
+	since := q.Since
+	if since.IsZero() {
+		since = time.Now().Add(-DefaultSearchWindow)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	} else if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	sql := `SELECT archive.ts, archive.user, archive.room, archive.broker, archive.body
+	          FROM archive`
+
+	where := []string{"archive.ts > ?"}
+	args := []interface{}{since}
+
+	if q.Reaction != "" {
+		sql += ` JOIN reactions ON reactions.id = archive.id
+		           AND reactions.user = archive.user
+		           AND reactions.room = archive.room
+		           AND reactions.broker = archive.broker`
+		where = append(where, "reactions.reaction = ?")
+		args = append(args, q.Reaction)
+	}
+
+	if q.User != "" {
+		where = append(where, "archive.user = ?")
+		args = append(args, q.User)
+	}
+
+	if q.Room != "" {
+		where = append(where, "archive.room = ?")
+		args = append(args, q.Room)
+	}
+
+	if q.Broker != "" {
+		where = append(where, "archive.broker = ?")
+		args = append(args, q.Broker)
+	}
+
+	if q.Body != "" {
+		where = append(where, "archive.body LIKE ?")
+		args = append(args, "%"+q.Body+"%")
+	}
+
+	if !q.Until.IsZero() {
+		where = append(where, "archive.ts < ?")
+		args = append(args, q.Until)
+	}
+
+	sql += " WHERE " + strings.Join(where, " AND ")
+	sql += " ORDER BY archive.ts DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, q.Offset)
+
+	rows, err := db.Query(sql, args...)
+	if err != nil {
+		log.Printf("archive search query failed: %s\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	aes := []*ArchiveEntry{}
+
+	for rows.Next() {
+		ae := ArchiveEntry{}
+
+		err = rows.Scan(&ae.Timestamp, &ae.User, &ae.Room, &ae.Broker, &ae.Body)
+		if err != nil {
+			log.Printf("Row iteration failed: %s\n", err)
+			return nil, err
+		}
+
+		aes = append(aes, &ae)
+	}
+
+	return aes, nil
+}
+
+// MessageBody implements Store. user isn't part of the lookup: a reaction
+// only ever carries the id of the message it landed on (the original
+// poster's user id, see archiveRecorder's tmpBrokenOnSlackId TODO, isn't
+// available at reaction time), so id+room+broker is the best key
+// available.
+func (sqlStore) MessageBody(id, room, broker string) (string, error) {
+	var body string
+
+	err := hal.SqlDB().QueryRow(
+		`SELECT body FROM archive WHERE id=? AND room=? AND broker=? LIMIT 1`,
+		id, room, broker,
+	).Scan(&body)
+	if err == sql.ErrNoRows {
+		return "", ErrMessageNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return body, nil
+}
+
+// CountReactions implements Store.
+func (sqlStore) CountReactions(filter ReactionFilter) (map[string]int, error) {
+	sql := `SELECT reaction, COUNT(*) FROM reactions`
+
+	where := []string{}
+	args := []interface{}{}
+
+	if filter.ID != "" {
+		where = append(where, "id = ?")
+		args = append(args, filter.ID)
+	}
+
+	if filter.Room != "" {
+		where = append(where, "room = ?")
+		args = append(args, filter.Room)
+	}
+
+	if filter.Broker != "" {
+		where = append(where, "broker = ?")
+		args = append(args, filter.Broker)
+	}
+
+	if !filter.Since.IsZero() {
+		where = append(where, "ts > ?")
+		args = append(args, filter.Since)
+	}
+
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	sql += " GROUP BY reaction"
+
+	rows, err := hal.SqlDB().Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+
+	for rows.Next() {
+		var reaction string
+		var count int
+
+		if err := rows.Scan(&reaction, &count); err != nil {
+			return nil, err
+		}
+
+		counts[reaction] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// TopReactedMessages implements Store, joining reactions back to the
+// message each one landed on so the leaderboard can include its body.
+func (sqlStore) TopReactedMessages(room string, since time.Time, limit int) ([]ReactionLeaderboardEntry, error) {
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	sql := `SELECT reactions.id, archive.user, reactions.room, reactions.broker, archive.body, COUNT(*) AS cnt
+	          FROM reactions
+	          JOIN archive ON archive.id = reactions.id
+	                      AND archive.room = reactions.room
+	                      AND archive.broker = reactions.broker
+	          WHERE reactions.ts > ?`
+	args := []interface{}{since}
+
+	if room != "" {
+		sql += " AND reactions.room = ?"
+		args = append(args, room)
+	}
+
+	sql += " GROUP BY reactions.id, reactions.room, reactions.broker ORDER BY cnt DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := hal.SqlDB().Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []ReactionLeaderboardEntry{}
+
+	for rows.Next() {
+		e := ReactionLeaderboardEntry{}
+
+		if err := rows.Scan(&e.ID, &e.User, &e.Room, &e.Broker, &e.Body, &e.Count); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
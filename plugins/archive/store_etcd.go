@@ -0,0 +1,425 @@
+package archive
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// EtcdStore implements Store on top of etcd v3, for deployments that would
+// rather not stand up a relational database just to archive chat
+// messages. Entries are keyed under prefix by broker/room/timestamp, e.g.
+// "/hal9001/archive/slack/C123/2016-11-03T04:05:06.789Z-U456", so a scan
+// within one broker/room is naturally ordered by time; reactions live
+// under a parallel "<prefix minus trailing slash>-reactions/" tree and are
+// mutated through single-op etcd transactions. A query that doesn't pin
+// both Broker and Room falls back to scanning (and sorting/filtering
+// client-side) every entry under prefix, so it's considerably less
+// efficient than the SQL store's indexed WHERE clause on a large archive.
+type EtcdStore struct {
+	client *clientv3.Client
+	prefix string // e.g. "/hal9001/archive/"
+}
+
+// NewEtcdStore connects to the given etcd endpoints and returns a Store
+// namespaced under prefix.
+func NewEtcdStore(endpoints []string, prefix string) (*EtcdStore, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdStore{client: cli, prefix: prefix}, nil
+}
+
+// Close releases the underlying etcd client connection.
+func (es *EtcdStore) Close() error {
+	return es.client.Close()
+}
+
+// etcdArchiveRecord is the JSON value stored at each archive key. id is
+// carried in the value (as well as the reactions key tree) so Search can
+// correlate a reaction back to the message it was added to.
+type etcdArchiveRecord struct {
+	ID     string    `json:"id"`
+	User   string    `json:"user"`
+	Room   string    `json:"room"`
+	Broker string    `json:"broker"`
+	Ts     time.Time `json:"ts"`
+	Body   string    `json:"body"`
+}
+
+func (es *EtcdStore) archiveKey(broker, room string, ts time.Time, user string) string {
+	return fmt.Sprintf("%s%s/%s/%s-%s", es.prefix, broker, room, ts.UTC().Format(time.RFC3339Nano), user)
+}
+
+func (es *EtcdStore) reactionsPrefix() string {
+	return strings.TrimSuffix(es.prefix, "/") + "-reactions/"
+}
+
+func (es *EtcdStore) reactionKey(broker, room, id, user, reaction string) string {
+	return fmt.Sprintf("%s%s/%s/%s/%s/%s", es.reactionsPrefix(), broker, room, id, user, reaction)
+}
+
+// Insert implements Store.
+func (es *EtcdStore) Insert(id, user, room, broker string, ts time.Time, body string) error {
+	rec := etcdArchiveRecord{ID: id, User: user, Room: room, Broker: broker, Ts: ts, Body: body}
+
+	js, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = es.client.Put(ctx, es.archiveKey(broker, room, ts, user), string(js))
+	return err
+}
+
+// InsertReaction implements Store.
+func (es *EtcdStore) InsertReaction(ts time.Time, id, user, room, broker, reaction string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	k := es.reactionKey(broker, room, id, user, reaction)
+	put := clientv3.OpPut(k, ts.UTC().Format(time.RFC3339Nano))
+	_, err := es.client.Txn(ctx).Then(put).Commit()
+	return err
+}
+
+// DeleteReaction implements Store.
+func (es *EtcdStore) DeleteReaction(id, user, room, broker, reaction string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	k := es.reactionKey(broker, room, id, user, reaction)
+	del := clientv3.OpDelete(k)
+	_, err := es.client.Txn(ctx).Then(del).Commit()
+	return err
+}
+
+// Fetch implements Store as a Search with no filters beyond limit.
+func (es *EtcdStore) Fetch(limit int) ([]*ArchiveEntry, error) {
+	return es.Search(ArchiveQuery{Limit: limit})
+}
+
+// Search implements Store by scanning the narrowest prefix q's
+// Broker/Room pin down, decoding every record in range, and applying the
+// remaining filters (User, Body, Since, Until, Reaction) client-side
+// before sorting newest-first and paginating. See ArchiveQuery for the
+// filters and their defaults.
+func (es *EtcdStore) Search(q ArchiveQuery) ([]*ArchiveEntry, error) {
+	since := q.Since
+	if since.IsZero() {
+		since = time.Now().Add(-DefaultSearchWindow)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	} else if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	scanPrefix := es.prefix
+	if q.Broker != "" {
+		scanPrefix += q.Broker + "/"
+		if q.Room != "" {
+			scanPrefix += q.Room + "/"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := es.client.Get(ctx, scanPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	var reactors map[string]bool
+	if q.Reaction != "" {
+		reactors, err = es.reactorIDs(ctx, q.Broker, q.Room, q.Reaction)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matches := make([]*ArchiveEntry, 0, len(resp.Kvs))
+
+	for _, kv := range resp.Kvs {
+		var rec etcdArchiveRecord
+		if err := json.Unmarshal(kv.Value, &rec); err != nil {
+			log.Printf("archive: skipping undecodable etcd record %q: %s\n", kv.Key, err)
+			continue
+		}
+
+		if rec.Ts.Before(since) {
+			continue
+		}
+		if !q.Until.IsZero() && !rec.Ts.Before(q.Until) {
+			continue
+		}
+		if q.User != "" && rec.User != q.User {
+			continue
+		}
+		if q.Broker != "" && rec.Broker != q.Broker {
+			continue
+		}
+		if q.Room != "" && rec.Room != q.Room {
+			continue
+		}
+		if q.Body != "" && !strings.Contains(rec.Body, q.Body) {
+			continue
+		}
+		if reactors != nil && !reactors[rec.ID] {
+			continue
+		}
+
+		matches = append(matches, &ArchiveEntry{Timestamp: rec.Ts, User: rec.User, Room: rec.Room, Broker: rec.Broker, Body: rec.Body})
+	}
+
+	// A scan pinned to one broker/room is already key-ordered by
+	// timestamp, but an unscoped scan concatenates several sibling
+	// prefixes in key order rather than true timestamp order, so sort
+	// defensively before paginating either way.
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Timestamp.After(matches[j].Timestamp) })
+
+	if q.Offset >= len(matches) {
+		return []*ArchiveEntry{}, nil
+	}
+	matches = matches[q.Offset:]
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches, nil
+}
+
+// reactorIDs returns the set of message ids that have reaction attached,
+// narrowed to broker/room when they're set.
+func (es *EtcdStore) reactorIDs(ctx context.Context, broker, room, reaction string) (map[string]bool, error) {
+	scanPrefix := es.reactionsPrefix()
+	if broker != "" {
+		scanPrefix += broker + "/"
+		if room != "" {
+			scanPrefix += room + "/"
+		}
+	}
+
+	resp, err := es.client.Get(ctx, scanPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+
+	for _, kv := range resp.Kvs {
+		// key: <reactionsPrefix><broker>/<room>/<id>/<user>/<reaction>
+		rest := strings.TrimPrefix(string(kv.Key), es.reactionsPrefix())
+		parts := strings.Split(rest, "/")
+		if len(parts) != 5 || parts[4] != reaction {
+			continue
+		}
+
+		ids[parts[2]] = true
+	}
+
+	return ids, nil
+}
+
+// lookupRecord scans the narrowest prefix room/broker pin down for the
+// archive record matching id, the same best-effort key (no message
+// author available at reaction time, see Store.MessageBody) sqlStore
+// uses.
+func (es *EtcdStore) lookupRecord(ctx context.Context, id, room, broker string) (*etcdArchiveRecord, error) {
+	scanPrefix := es.prefix
+	if broker != "" {
+		scanPrefix += broker + "/"
+		if room != "" {
+			scanPrefix += room + "/"
+		}
+	}
+
+	resp, err := es.client.Get(ctx, scanPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kv := range resp.Kvs {
+		var rec etcdArchiveRecord
+		if err := json.Unmarshal(kv.Value, &rec); err != nil {
+			continue
+		}
+
+		if rec.ID != id {
+			continue
+		}
+		if room != "" && rec.Room != room {
+			continue
+		}
+		if broker != "" && rec.Broker != broker {
+			continue
+		}
+
+		return &rec, nil
+	}
+
+	return nil, ErrMessageNotFound
+}
+
+// MessageBody implements Store.
+func (es *EtcdStore) MessageBody(id, room, broker string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rec, err := es.lookupRecord(ctx, id, room, broker)
+	if err != nil {
+		return "", err
+	}
+
+	return rec.Body, nil
+}
+
+// CountReactions implements Store by scanning the narrowest prefix
+// filter's Broker/Room pin down and counting client-side.
+func (es *EtcdStore) CountReactions(filter ReactionFilter) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	scanPrefix := es.reactionsPrefix()
+	if filter.Broker != "" {
+		scanPrefix += filter.Broker + "/"
+		if filter.Room != "" {
+			scanPrefix += filter.Room + "/"
+		}
+	}
+
+	resp, err := es.client.Get(ctx, scanPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+
+	for _, kv := range resp.Kvs {
+		// key: <reactionsPrefix><broker>/<room>/<id>/<user>/<reaction>
+		rest := strings.TrimPrefix(string(kv.Key), es.reactionsPrefix())
+		parts := strings.Split(rest, "/")
+		if len(parts) != 5 {
+			continue
+		}
+
+		broker, room, id, reaction := parts[0], parts[1], parts[2], parts[4]
+
+		if filter.ID != "" && id != filter.ID {
+			continue
+		}
+		if filter.Room != "" && room != filter.Room {
+			continue
+		}
+		if filter.Broker != "" && broker != filter.Broker {
+			continue
+		}
+		if !filter.Since.IsZero() {
+			if ts, err := time.Parse(time.RFC3339Nano, string(kv.Value)); err == nil && ts.Before(filter.Since) {
+				continue
+			}
+		}
+
+		counts[reaction]++
+	}
+
+	return counts, nil
+}
+
+// TopReactedMessages implements Store by scanning every reaction under
+// room (or, if room is empty, every room) and looking up each distinct
+// message's body to build the leaderboard -- considerably less efficient
+// than the SQL store's single JOIN/GROUP BY on a large archive.
+func (es *EtcdStore) TopReactedMessages(room string, since time.Time, limit int) ([]ReactionLeaderboardEntry, error) {
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := es.client.Get(ctx, es.reactionsPrefix(), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	type messageKey struct{ broker, room, id string }
+	counts := make(map[messageKey]int)
+
+	for _, kv := range resp.Kvs {
+		rest := strings.TrimPrefix(string(kv.Key), es.reactionsPrefix())
+		parts := strings.Split(rest, "/")
+		if len(parts) != 5 {
+			continue
+		}
+
+		mk := messageKey{broker: parts[0], room: parts[1], id: parts[2]}
+
+		if room != "" && mk.room != room {
+			continue
+		}
+		if !since.IsZero() {
+			if ts, err := time.Parse(time.RFC3339Nano, string(kv.Value)); err == nil && ts.Before(since) {
+				continue
+			}
+		}
+
+		counts[mk]++
+	}
+
+	entries := make([]ReactionLeaderboardEntry, 0, len(counts))
+
+	for mk, count := range counts {
+		rec, err := es.lookupRecord(ctx, mk.id, mk.room, mk.broker)
+		if err != nil {
+			log.Printf("archive: skipping reacted-to message %q with no archived body: %s\n", mk.id, err)
+			continue
+		}
+
+		entries = append(entries, ReactionLeaderboardEntry{
+			ID: mk.id, User: rec.User, Room: mk.room, Broker: mk.broker, Body: rec.Body, Count: count,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Count > entries[j].Count })
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,255 @@
+package blabber
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sync"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// blabberBloom is a minimal Bloom filter implemented inline with
+// hash/fnv (k hash functions derived via double-hashing) rather than
+// pulling in a new dependency for something this small.
+type blabberBloom struct {
+	bits      []byte
+	n         uint64  // estimated number of items
+	fpr       float64 // desired false positive rate
+	m         uint64  // number of bits
+	k         uint64  // number of hash functions
+	inserted  uint64  // items inserted since creation/rotation
+	mut       sync.Mutex
+}
+
+// default sizing, overridden by the blabber-bloom-n/blabber-bloom-fpr prefs
+const (
+	defaultBloomN   = 100000
+	defaultBloomFPR = 0.01
+)
+
+const BLABBER_BLOOM_TABLE = `
+CREATE TABLE IF NOT EXISTS blabber_bloom (
+  room  VARCHAR(64) NOT NULL, -- the chat room the filter is scoped to
+  bits  MEDIUMBLOB,           -- the filter's bit array
+  n     INT,                  -- estimated number of items it was sized for
+  fpr   DOUBLE,                -- the configured false positive rate
+  ts    TIMESTAMP,
+  PRIMARY KEY (room)
+)`
+
+var ingestFilters = make(map[string]*blabberBloom)
+var ingestFiltersMut sync.Mutex
+
+// NewWithEstimates creates a Bloom filter sized for n items at the given
+// false positive rate, following the same m/k sizing math as the common
+// NewWithEstimates() constructors found in standalone bloom filter libs.
+func NewWithEstimates(n uint64, fpr float64) *blabberBloom {
+	if n == 0 {
+		n = defaultBloomN
+	}
+	if fpr <= 0 || fpr >= 1 {
+		fpr = defaultBloomFPR
+	}
+
+	m := optimalM(n, fpr)
+	k := optimalK(m, n)
+
+	return &blabberBloom{
+		bits: make([]byte, (m+7)/8),
+		n:    n,
+		fpr:  fpr,
+		m:    m,
+		k:    k,
+	}
+}
+
+func optimalM(n uint64, fpr float64) uint64 {
+	m := math.Ceil(-1 * float64(n) * math.Log(fpr) / (math.Ln2 * math.Ln2))
+	return uint64(m)
+}
+
+func optimalK(m, n uint64) uint64 {
+	if n == 0 {
+		return 1
+	}
+	k := math.Round((float64(m) / float64(n)) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	return uint64(k)
+}
+
+// hashes returns the two base hashes used to derive k hash functions via
+// double-hashing: h_i(x) = h1(x) + i*h2(x).
+func (b *blabberBloom) hashes(key string) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write([]byte(key))
+
+	h2 := fnv.New64()
+	h2.Write([]byte(key))
+
+	return h1.Sum64(), h2.Sum64()
+}
+
+// Add inserts key into the filter.
+func (b *blabberBloom) Add(key string) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	h1, h2 := b.hashes(key)
+	for i := uint64(0); i < b.k; i++ {
+		idx := (h1 + i*h2) % b.m
+		b.bits[idx/8] |= 1 << (idx % 8)
+	}
+
+	b.inserted++
+}
+
+// Test returns true if key has (probably) been seen before.
+func (b *blabberBloom) Test(key string) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	h1, h2 := b.hashes(key)
+	for i := uint64(0); i < b.k; i++ {
+		idx := (h1 + i*h2) % b.m
+		if b.bits[idx/8]&(1<<(idx%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// TestAndAdd tests for key and adds it in one step, returning whether it was
+// already present.
+func (b *blabberBloom) TestAndAdd(key string) bool {
+	seen := b.Test(key)
+	b.Add(key)
+	return seen
+}
+
+// EstimatedFillRatio estimates the fraction of bits currently set, which is
+// used as a cheap proxy for "this filter is getting saturated and its real
+// false-positive rate is creeping above what was configured."
+func (b *blabberBloom) EstimatedFillRatio() float64 {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	set := 0
+	for _, byt := range b.bits {
+		for i := 0; i < 8; i++ {
+			if byt&(1<<uint(i)) != 0 {
+				set++
+			}
+		}
+	}
+
+	return float64(set) / float64(b.m)
+}
+
+// bloomPrefs returns the configured (n, fpr) from hal.Prefs, falling back to
+// package defaults when they are unset or unparseable.
+func bloomPrefs() (uint64, float64) {
+	n := defaultBloomN
+	nPref := hal.GetPref("", "", "", "blabber", "blabber-bloom-n", fmt.Sprintf("%d", defaultBloomN))
+	if parsed, err := fmt.Sscanf(nPref.Value, "%d", &n); err != nil || parsed != 1 {
+		n = defaultBloomN
+	}
+
+	fpr := defaultBloomFPR
+	fprPref := hal.GetPref("", "", "", "blabber", "blabber-bloom-fpr", fmt.Sprintf("%f", defaultBloomFPR))
+	if parsed, err := fmt.Sscanf(fprPref.Value, "%f", &fpr); err != nil || parsed != 1 {
+		fpr = defaultBloomFPR
+	}
+
+	return uint64(n), fpr
+}
+
+// ingestFilter returns (creating if necessary) the rolling ingest filter for
+// a room, used by bwCounter to skip inserts for word pairs it has already
+// seen recently, cutting write amplification on busy rooms.
+func ingestFilter(room string) *blabberBloom {
+	ingestFiltersMut.Lock()
+	defer ingestFiltersMut.Unlock()
+
+	if bf, exists := ingestFilters[room]; exists {
+		return bf
+	}
+
+	bf := loadIngestFilter(room)
+	ingestFilters[room] = bf
+	return bf
+}
+
+// loadIngestFilter restores a room's filter from the blabber_bloom table, or
+// creates a fresh one sized from prefs if nothing is persisted yet.
+func loadIngestFilter(room string) *blabberBloom {
+	hal.SqlInit(BLABBER_BLOOM_TABLE)
+
+	n, fpr := bloomPrefs()
+	db := hal.SqlDB()
+
+	var bits []byte
+	err := db.QueryRow("SELECT bits FROM blabber_bloom WHERE room=?", room).Scan(&bits)
+	if err != nil {
+		return NewWithEstimates(n, fpr)
+	}
+
+	bf := NewWithEstimates(n, fpr)
+	if len(bits) == len(bf.bits) {
+		copy(bf.bits, bits)
+	} else {
+		log.Printf("blabber: persisted bloom filter for room %q has a different size than configured, starting fresh", room)
+	}
+
+	return bf
+}
+
+// saveIngestFilter persists a room's filter bit array so it survives restarts.
+func saveIngestFilter(room string, bf *blabberBloom) {
+	hal.SqlInit(BLABBER_BLOOM_TABLE)
+
+	db := hal.SqlDB()
+	bf.mut.Lock()
+	bits := make([]byte, len(bf.bits))
+	copy(bits, bf.bits)
+	n, fpr := bf.n, bf.fpr
+	bf.mut.Unlock()
+
+	_, err := db.Exec(`INSERT INTO blabber_bloom (room, bits, n, fpr, ts)
+	                    VALUES (?, ?, ?, ?, NOW())
+	                    ON DUPLICATE KEY UPDATE bits=VALUES(bits), n=VALUES(n), fpr=VALUES(fpr), ts=NOW()`,
+		room, bits, n, fpr)
+	if err != nil {
+		log.Printf("blabber: failed to persist bloom filter for room %q: %s", room, err)
+	}
+}
+
+// rotateIfSaturated replaces the room's ingest filter with a fresh one once
+// its estimated fill ratio exceeds the configured FPR threshold, since a
+// saturated filter's real false-positive rate climbs well past what it was
+// sized for.
+func rotateIfSaturated(room string, bf *blabberBloom) *blabberBloom {
+	if bf.EstimatedFillRatio() <= bf.fpr {
+		return bf
+	}
+
+	log.Printf("blabber: rotating saturated bloom filter for room %q", room)
+
+	n, fpr := bloomPrefs()
+	fresh := NewWithEstimates(n, fpr)
+
+	ingestFiltersMut.Lock()
+	ingestFilters[room] = fresh
+	ingestFiltersMut.Unlock()
+
+	return fresh
+}
+
+// bigramKey builds the dedup key used by the ingest filter: (room,user,word,next).
+func bigramKey(room, user, word, next string) string {
+	return room + "\x00" + user + "\x00" + word + "\x00" + next
+}
@@ -2,6 +2,7 @@ package blabber
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
@@ -35,6 +36,21 @@ CREATE TABLE IF NOT EXISTS blabberwords (
   PRIMARY KEY (word, user, room, next)
 )`
 
+// bigram context for generation: (prev, word) -> next, so blab() can condition
+// on the last two words instead of just the last one. Falls back to
+// blabberwords (unigram) when there's no bigram match.
+const BLABBERBIGRAMS_TABLE = `
+CREATE TABLE IF NOT EXISTS blabberbigrams (
+  word     VARCHAR(64),  -- the word seen in the room
+  prev     VARCHAR(64),  -- the word before it
+  user     VARCHAR(64),  -- the user who said it
+  room     VARCHAR(64),  -- the chat room it was said in
+  next     VARCHAR(64),  -- the word after it
+  count    int,          -- how many times this triple has been seen
+  ts       TIMESTAMP,    -- when it was last said (not indexed for now)
+  PRIMARY KEY (word, prev, user, room, next)
+)`
+
 func Register() {
 	bw := hal.Plugin{
 		Name:  "blabberwords",
@@ -44,14 +60,15 @@ func Register() {
 	bw.Register()
 
 	bb := hal.Plugin{
-		Name:  "blab",
-		Func:  blab,
-		Regex: "^[[:space:]]*!blab",
+		Name:    "blab",
+		CtxFunc: blab,
+		Regex:   "^[[:space:]]*!blab",
 	}
 	bb.Register()
 
 	// apply the schema to the database as necessary
 	hal.SqlInit(BLABBERWORDS_TABLE)
+	hal.SqlInit(BLABBERBIGRAMS_TABLE)
 }
 
 func bwCounter(evt hal.Evt) {
@@ -77,6 +94,19 @@ func bwCounter(evt hal.Evt) {
 		return
 	}
 
+	bisql := `INSERT INTO blabberbigrams
+	            (word,prev,user,room,next,count)
+	          VALUES (?, ?, ?, ?, ?, 1)
+	          ON DUPLICATE KEY UPDATE
+			    count=values(count) + 1`
+
+	biquery, err := db.Prepare(bisql)
+	if err != nil {
+		log.Printf("Could not prepare bigram insert query: %s", err)
+		return
+	}
+
+	prev := ""
 	for i, word := range parts {
 		next := ""
 		// first word will have word="", next="first"
@@ -91,12 +121,29 @@ func bwCounter(evt hal.Evt) {
 		tword := strings.TrimRight(word, ".?!")
 		tnext := strings.TrimRight(next, ".?!")
 
+		// skip writes for pairs we've already seen recently in this room, to
+		// cut write amplification on busy rooms. The bloom filter may false
+		// positive (so we occasionally under-count) but never false negative.
+		bf := rotateIfSaturated(evt.Room, ingestFilter(evt.Room))
+		if bf.TestAndAdd(bigramKey(evt.Room, evt.User, tword, tnext)) {
+			continue
+		}
+
 		_, err = query.Exec(tword, evt.User, evt.Room, tnext)
 		if err != nil {
 			log.Printf("prepared insert into blabberwords failed: %s", err)
 			continue
 		}
+
+		_, err = biquery.Exec(tword, prev, evt.User, evt.Room, tnext)
+		if err != nil {
+			log.Printf("prepared insert into blabberbigrams failed: %s", err)
+		}
+
+		prev = tword
 	}
+
+	saveIngestFilter(evt.Room, ingestFilter(evt.Room))
 }
 
 // !blab --user atobey
@@ -104,10 +151,11 @@ func bwCounter(evt hal.Evt) {
 // !blab --room incidents
 // !blab --user atobey,dhahn,jhorowitz ???
 // !blab --user dhahn
-// TODO: figure out a non-insane way to build a sentence around a specific word or words
-func blab(evt hal.Evt) {
+// !blab --seed coffee
+func blab(ctx context.Context, evt hal.Evt) {
 	users := []string{}
 	rooms := []string{}
+	seed := ""
 	argv := evt.BodyAsArgv()
 
 	for i, arg := range argv {
@@ -118,26 +166,47 @@ func blab(evt hal.Evt) {
 		case "--room":
 			found := extractArgs(argv, i)
 			rooms = append(rooms, found...)
+		case "--seed":
+			found := extractArgs(argv, i)
+			if len(found) > 0 {
+				seed = found[0]
+			}
 		}
 	}
 
 	userFrag := mkQueryFragment("user", users)
 	roomFrag := mkQueryFragment("room", rooms)
 
-	// start with a random first word given the provided constraints
-	first := firstWord(userFrag, roomFrag)
+	// start with a weighted-random first word given the provided constraints,
+	// or the user-supplied --seed word if one was given
+	first := firstWord(ctx, seed, userFrag, roomFrag)
 	words := []wncRow{first}
+
+	// short-lived, per-invocation filter tracking emitted bigrams so the
+	// Markov walk doesn't wander into a tight loop ("foo bar foo bar...")
+	seen := NewWithEstimates(256, 0.01)
+
 	for {
-		next := nextWord(words[len(words)-1], userFrag, roomFrag)
-		words = append(words, next)
+		prev := words[len(words)-1].word
 
-		log.Printf("BLAB: %+v", words)
+		next := nextWord(ctx, prev, words[len(words)-1], userFrag, roomFrag)
 
 		// found a last word
 		if next.next == "" {
+			words = append(words, next)
 			break
 		}
 
+		if seen.TestAndAdd(next.word + "\x00" + next.next) {
+			// we've emitted this bigram before in this walk; stop here
+			// rather than looping forever
+			break
+		}
+
+		words = append(words, next)
+
+		log.Printf("BLAB: %+v", words)
+
 		// stop trying after 20 words
 		if len(words) > 20 {
 			break
@@ -147,8 +216,58 @@ func blab(evt hal.Evt) {
 	evt.Reply(rowsToString(words))
 }
 
-// for now, completely random, will add in probability later...
-func nextWord(current wncRow, userFrag, roomFrag qFrag) wncRow {
+// weightedPick does a cumulative-sum weighted random pick over rows using
+// their count as the weight. Fixes the old rand.Intn(len(rows)-1), which
+// panicked when len(rows)==1 and could never return the last row.
+func weightedPick(rows []wncRow) wncRow {
+	total := 0
+	for _, r := range rows {
+		total += r.count
+	}
+
+	if total <= 0 {
+		return rows[rand.Intn(len(rows))]
+	}
+
+	target := rand.Intn(total)
+	sum := 0
+	for _, r := range rows {
+		sum += r.count
+		if target < sum {
+			return r
+		}
+	}
+
+	// rounding fallback, should not normally be reached
+	return rows[len(rows)-1]
+}
+
+// nextWord picks the next word in the chain, weighted by how often it has
+// been observed. When prev is non-empty it first tries the bigram table so
+// generation can condition on the last two words, falling back to the
+// unigram blabberwords table when there's no bigram match.
+func nextWord(ctx context.Context, prev string, current wncRow, userFrag, roomFrag qFrag) wncRow {
+	if prev != "" {
+		sqlbuf := bytes.NewBufferString("SELECT word,next,count FROM blabberbigrams WHERE word=? AND prev=? ")
+		params := []interface{}{current.next, prev}
+
+		if !userFrag.empty {
+			sqlbuf.WriteString(" AND ")
+			sqlbuf.WriteString(userFrag.sql)
+			params = append(params, userFrag.params...)
+		}
+
+		if !roomFrag.empty {
+			sqlbuf.WriteString(" AND ")
+			sqlbuf.WriteString(roomFrag.sql)
+			params = append(params, roomFrag.params...)
+		}
+
+		if rows := getRows(ctx, sqlbuf.String(), params); len(rows) > 0 {
+			return weightedPick(rows)
+		}
+	}
+
 	sqlbuf := bytes.NewBufferString("SELECT word,next,count FROM blabberwords WHERE word=? ")
 	params := []interface{}{current.next}
 
@@ -164,15 +283,14 @@ func nextWord(current wncRow, userFrag, roomFrag qFrag) wncRow {
 		params = append(params, roomFrag.params...)
 	}
 
-	rows := getRows(sqlbuf.String(), params)
+	rows := getRows(ctx, sqlbuf.String(), params)
 
 	if len(rows) == 0 {
 		log.Printf("blabber.nextWord got 0 rows, returning empty row")
 		return wncRow{"", "", 0}
 	}
 
-	idx := rand.Intn(len(rows) - 1)
-	return rows[idx]
+	return weightedPick(rows)
 }
 
 func rowsToString(rows []wncRow) string {
@@ -185,12 +303,12 @@ func rowsToString(rows []wncRow) string {
 	return strings.Join(words, " ")
 }
 
-func getRows(sql string, params []interface{}) []wncRow {
+func getRows(ctx context.Context, sql string, params []interface{}) []wncRow {
 	db := hal.SqlDB()
 
 	log.Printf("Running query: %q\n%+v\n", sql, params)
 
-	rows, err := db.Query(sql, params...)
+	rows, err := db.QueryContext(ctx, sql, params...)
 	if err != nil {
 		log.Printf("blabberwords query %q failed: %s", sql, err)
 		return []wncRow{}
@@ -211,9 +329,21 @@ func getRows(sql string, params []interface{}) []wncRow {
 	return wncs
 }
 
-func firstWord(userFrag, roomFrag qFrag) wncRow {
-	sqlbuf := bytes.NewBufferString("SELECT word,next,count FROM blabberwords WHERE word='' ")
-	params := []interface{}{}
+// firstWord picks a weighted-random starter row. When seed is non-empty, it
+// instead picks (with the same weighted probability) among rows whose word
+// matches the seed, so a user can steer where generation begins with
+// "!blab --seed <word>".
+func firstWord(ctx context.Context, seed string, userFrag, roomFrag qFrag) wncRow {
+	var sqlbuf *bytes.Buffer
+	var params []interface{}
+
+	if seed != "" {
+		sqlbuf = bytes.NewBufferString("SELECT word,next,count FROM blabberwords WHERE word=? ")
+		params = []interface{}{seed}
+	} else {
+		sqlbuf = bytes.NewBufferString("SELECT word,next,count FROM blabberwords WHERE word='' ")
+		params = []interface{}{}
+	}
 
 	if !userFrag.empty {
 		sqlbuf.WriteString(" AND ")
@@ -228,16 +358,14 @@ func firstWord(userFrag, roomFrag qFrag) wncRow {
 	}
 
 	// will get back a list (potentially large) of candidates
-	wncs := getRows(sqlbuf.String(), params)
+	wncs := getRows(ctx, sqlbuf.String(), params)
 
-	// when now rows are returned, just say "FAIL"
+	// when no rows are returned, just say "FAIL"
 	if len(wncs) == 0 {
 		return wncRow{"FAIL", "", 0}
 	}
 
-	idx := rand.Intn(len(wncs) - 1)
-
-	return wncs[idx]
+	return weightedPick(wncs)
 }
 
 func mkQueryFragment(col string, list []string) qFrag {
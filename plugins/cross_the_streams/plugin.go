@@ -1,57 +1,410 @@
-// cross_the_streams replicates messages between brokers
+// cross_the_streams bridges messages bidirectionally between rooms on
+// different (or the same) brokers.
 package cross_the_streams
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/netflix/hal-9001/hal"
 )
 
+const BridgeUsage = `!bridge add <broker-a> <room-a> <broker-b> <room-b> [quarantine]
+!bridge rm <n>
+!bridge list
+!bridge ack <id>
+
+Configure bidirectional room bridges: every message seen in room-a is
+relayed into room-b, and vice versa. Routes are configured centrally
+rather than as a per-room setting, since a route describes a
+relationship between two rooms, not a property of either one alone.
+
+Add "quarantine" to hold the first message from a not-yet-seen user on
+that route back until an admin runs "!bridge ack <id>" -- afterwards
+that user is remembered and relays through normally.
+
+!bridge add slack core hipchat ops
+!bridge add slack core hipchat ops quarantine
+!bridge rm 2
+!bridge list
+!bridge ack a1b2c3d4
+`
+
+// RoutesKey is the single KV key every configured Route is stored under,
+// JSON-encoded as a list. hal has no Prefs mechanism in this tree (see
+// GetPref/FindPrefs, both referenced elsewhere but never defined) and a
+// route isn't naturally keyed by a single room/broker/plugin tuple
+// anyway -- it's a relationship between two rooms -- so GetKV/SetKV
+// holding the whole list is a better fit than per-key prefs would be.
+const RoutesKey = "cross_the_streams.routes"
+
+// LoopWindow is how long a relayed message's fingerprint is remembered
+// for loop detection: long enough to catch an immediate A->B->A bounce,
+// short enough that the same words said again five minutes later aren't
+// mistaken for an echo.
+const LoopWindow = 30 * time.Second
+
+// PendingTTL is how long a quarantined message waits for "!bridge ack"
+// before it's dropped for good.
+const PendingTTL = 24 * time.Hour
+
+// Route is one bidirectional room mapping.
+type Route struct {
+	ABroker    string `json:"a_broker"`
+	ARoom      string `json:"a_room"`
+	BBroker    string `json:"b_broker"`
+	BRoom      string `json:"b_room"`
+	Quarantine bool   `json:"quarantine,omitempty"`
+}
+
+// otherSide returns the broker/room on the far end of r given an event
+// that arrived on broker/room, and whether r applies at all.
+func (r Route) otherSide(broker, room string) (toBroker, toRoom string, ok bool) {
+	if r.ABroker == broker && r.ARoom == room {
+		return r.BBroker, r.BRoom, true
+	}
+	if r.BBroker == broker && r.BRoom == room {
+		return r.ABroker, r.ARoom, true
+	}
+	return "", "", false
+}
+
+// pendingMessage is what's stashed under pendingKey while a quarantined
+// message waits for "!bridge ack". Original is cleared before storing --
+// it's broker-specific (e.g. a slack.MessageEvent) and isn't guaranteed
+// to round-trip through JSON.
+type pendingMessage struct {
+	Evt      hal.Evt `json:"evt"`
+	ToBroker string  `json:"to_broker"`
+	ToRoom   string  `json:"to_room"`
+}
+
 // Register makes this plugin available to the system.
 func Register() {
 	plugin := hal.Plugin{
 		Name:  "cross_the_streams",
 		Func:  crossStreams,
 		Regex: "", // get all messages
-		//  source: Pref.Room / Pref.Broker
-		Settings: hal.Prefs{
-			hal.Pref{Plugin: "cross_the_streams", Key: "to.broker"},
-			hal.Pref{Plugin: "cross_the_streams", Key: "to.room"},
-		},
 	}
 
 	plugin.Register()
 }
 
-// crossStreams looks at events it recieves and repeats them
-// to a different broker.
+// crossStreams looks at every event it receives, relaying it across any
+// configured Route whose room it matches, and handles "!bridge" admin
+// commands.
 func crossStreams(evt hal.Evt) {
-	prefs := evt.InstanceSettings()
-	tbPrefs := prefs.Key("to.broker")
-	trPrefs := prefs.Key("to.room")
+	argv := evt.BodyAsArgv()
+	if len(argv) > 0 && argv[0] == "!bridge" {
+		handleBridgeCommand(evt, argv)
+		return
+	}
 
-	// no matches, move on
-	if len(tbPrefs) == 0 || len(trPrefs) == 0 {
+	fromBroker := evt.BrokerName()
+	fromRoom := evt.RoomId
+
+	fp := fingerprint(evt.User, evt.Body, evt.Time)
+
+	var seen bool
+	if ttl, err := hal.Cache().Get(seenKey(fp), &seen); err == nil && ttl > 0 {
+		// this is an echo of a message this bridge itself relayed a
+		// moment ago on another route -- drop it instead of bouncing
+		// it right back where it came from.
 		return
 	}
+	hal.Cache().Set(seenKey(fp), true, LoopWindow)
 
-	toBroker := tbPrefs[0].Value
-	toRoomId := trPrefs[0].Value
+	routes, err := loadRoutes()
+	if err != nil {
+		log.Printf("cross_the_streams: failed to load routes: %s", err)
+		return
+	}
 
-	tb := hal.Router().GetBroker(toBroker)
-	if tb != nil {
-		toRoom := tb.RoomIdToName(toRoomId)
-		body := fmt.Sprintf("%s %s@%s: %s", evt.Time, evt.User, evt.Room, evt.Body)
-		out := hal.Evt{
-			Body:   body,
-			Room:   toRoom,
-			RoomId: toRoomId,
-			Time:   evt.Time,
-			Broker: tb,
+	for _, route := range routes {
+		toBroker, toRoom, ok := route.otherSide(fromBroker, fromRoom)
+		if !ok {
+			continue
 		}
-		tb.Send(out)
+
+		if route.Quarantine && !isKnownSender(fromBroker, evt.User) {
+			quarantine(evt, toBroker, toRoom)
+			continue
+		}
+
+		relay(evt, toBroker, toRoom)
+	}
+}
+
+// relay formats evt for delivery to toBroker/toRoom (via
+// hal.BrokerFormatter if the target implements it, otherwise a plain
+// "user@room: body" fallback) and sends it. Time is copied through
+// unchanged from evt, but the relayed copy's Body is the formatted one,
+// so its fingerprint differs from the original's; the loop check in
+// crossStreams drops only an event whose User, Body and Time match one
+// already seen within LoopWindow.
+func relay(evt hal.Evt, toBroker, toRoom string) {
+	tb := hal.Router().GetBroker(toBroker)
+	if tb == nil {
+		log.Printf("cross_the_streams: hal.Router does not know about a broker named %q", toBroker)
+		return
+	}
+
+	var body string
+	if bf, ok := tb.(hal.BrokerFormatter); ok {
+		body = bf.FormatRelayed(evt.BrokerName(), evt.Room, evt.User, evt.Body)
 	} else {
-		log.Printf("hal.Router does not know about a broker named %q", toBroker)
+		body = fmt.Sprintf("%s@%s: %s", evt.User, evt.Room, evt.Body)
+	}
+
+	out := hal.Evt{
+		Body:   body,
+		Room:   tb.RoomIdToName(toRoom),
+		RoomId: toRoom,
+		User:   evt.User,
+		UserId: evt.UserId,
+		Time:   evt.Time,
+	}
+
+	tb.Send(out)
+}
+
+// quarantine stashes evt for later delivery and posts a notice in the
+// target room asking for "!bridge ack <id>" before it's relayed. The
+// stash lives in hal.Cache(), which is reconnect-safe the same way every
+// other hal subsystem built on it is: a deployment that's wired hal.Cache
+// to a CacheBackend/Store (see SetCacheBackend/SetStore) keeps pending
+// messages across a process restart, not just an in-memory map. Broker
+// itself has no delivery-failure signal (Send returns nothing), so this
+// protects against hal restarting mid-relay, not against the target
+// broker being unreachable once Send is called.
+func quarantine(evt hal.Evt, toBroker, toRoom string) {
+	id := fingerprint(evt.User, evt.Body, evt.Time)[:8]
+
+	safeEvt := evt
+	safeEvt.Original = nil
+
+	pending := pendingMessage{Evt: safeEvt, ToBroker: toBroker, ToRoom: toRoom}
+
+	js, err := json.Marshal(pending)
+	if err != nil {
+		log.Printf("cross_the_streams: failed to marshal quarantined message: %s", err)
+		return
+	}
+	hal.Cache().Set(pendingKey(id), string(js), PendingTTL)
+
+	tb := hal.Router().GetBroker(toBroker)
+	if tb == nil {
+		return
+	}
+
+	notice := hal.Evt{
+		Body: fmt.Sprintf("cross_the_streams: a message from unrecognized user %q on %s/%s is quarantined. Run \"!bridge ack %s\" to let it (and future messages from them) through.",
+			evt.User, evt.BrokerName(), evt.Room, id),
+		Room:   tb.RoomIdToName(toRoom),
+		RoomId: toRoom,
+		Time:   time.Now(),
+	}
+	tb.Send(notice)
+}
+
+// fingerprint identifies a specific message instance for loop detection
+// and quarantine ids: sha1 of who sent it, what it said, and when, down
+// to the second.
+func fingerprint(user, body string, ts time.Time) string {
+	h := sha1.New()
+	h.Write([]byte(user))
+	h.Write([]byte{0})
+	h.Write([]byte(body))
+	h.Write([]byte{0})
+	h.Write([]byte(ts.Truncate(time.Second).String()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func seenKey(fp string) string {
+	return "cross_the_streams.seen." + fp
+}
+
+func pendingKey(id string) string {
+	return "cross_the_streams.pending." + id
+}
+
+func knownSenderKey(broker, user string) string {
+	return fmt.Sprintf("cross_the_streams.known.%s.%s", broker, user)
+}
+
+func isKnownSender(broker, user string) bool {
+	return hal.ExistsKV(knownSenderKey(broker, user))
+}
+
+func markKnownSender(broker, user string) {
+	if err := hal.SetKV(knownSenderKey(broker, user), "true", 0); err != nil {
+		log.Printf("cross_the_streams: failed to remember known sender %s/%s: %s", broker, user, err)
+	}
+}
+
+func loadRoutes() ([]Route, error) {
+	val, err := hal.GetKV(RoutesKey)
+	if err != nil {
+		return []Route{}, nil // nothing configured yet
+	}
+
+	routes := []Route{}
+	if err := json.Unmarshal([]byte(val), &routes); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+func saveRoutes(routes []Route) error {
+	js, err := json.Marshal(routes)
+	if err != nil {
+		return err
+	}
+
+	return hal.SetKV(RoutesKey, string(js), 0)
+}
+
+func handleBridgeCommand(evt hal.Evt, argv []string) {
+	if len(argv) < 2 {
+		evt.Reply(BridgeUsage)
+		return
+	}
+
+	switch argv[1] {
+	case "add":
+		handleBridgeAdd(evt, argv)
+	case "rm", "remove":
+		handleBridgeRemove(evt, argv)
+	case "list":
+		handleBridgeList(evt)
+	case "ack":
+		handleBridgeAck(evt, argv)
+	default:
+		evt.Reply(BridgeUsage)
+	}
+}
+
+func handleBridgeAdd(evt hal.Evt, argv []string) {
+	if len(argv) < 6 || len(argv) > 7 {
+		evt.Reply(BridgeUsage)
+		return
+	}
+
+	route := Route{
+		ABroker: argv[2],
+		ARoom:   argv[3],
+		BBroker: argv[4],
+		BRoom:   argv[5],
+	}
+	if len(argv) == 7 && argv[6] == "quarantine" {
+		route.Quarantine = true
 	}
+
+	routes, err := loadRoutes()
+	if err != nil {
+		evt.Replyf("failed to load existing routes: %s", err)
+		return
+	}
+
+	routes = append(routes, route)
+
+	if err := saveRoutes(routes); err != nil {
+		evt.Replyf("failed to save route: %s", err)
+		return
+	}
+
+	evt.Replyf("bridge added: %s/%s <-> %s/%s", route.ABroker, route.ARoom, route.BBroker, route.BRoom)
+}
+
+func handleBridgeRemove(evt hal.Evt, argv []string) {
+	if len(argv) != 3 {
+		evt.Reply(BridgeUsage)
+		return
+	}
+
+	n, err := strconv.Atoi(argv[2])
+	if err != nil {
+		evt.Replyf("%q is not a valid route number, see !bridge list", argv[2])
+		return
+	}
+
+	routes, err := loadRoutes()
+	if err != nil {
+		evt.Replyf("failed to load existing routes: %s", err)
+		return
+	}
+
+	if n < 1 || n > len(routes) {
+		evt.Replyf("no route #%d, see !bridge list", n)
+		return
+	}
+
+	removed := routes[n-1]
+	routes = append(routes[:n-1], routes[n:]...)
+
+	if err := saveRoutes(routes); err != nil {
+		evt.Replyf("failed to save routes: %s", err)
+		return
+	}
+
+	evt.Replyf("bridge removed: %s/%s <-> %s/%s", removed.ABroker, removed.ARoom, removed.BBroker, removed.BRoom)
+}
+
+func handleBridgeList(evt hal.Evt) {
+	routes, err := loadRoutes()
+	if err != nil {
+		evt.Replyf("failed to load routes: %s", err)
+		return
+	}
+
+	if len(routes) == 0 {
+		evt.Reply("no bridges configured")
+		return
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	for i, route := range routes {
+		q := ""
+		if route.Quarantine {
+			q = " (quarantined)"
+		}
+		fmt.Fprintf(buf, "%d: %s/%s <-> %s/%s%s\n", i+1, route.ABroker, route.ARoom, route.BBroker, route.BRoom, q)
+	}
+
+	evt.Reply(buf.String())
+}
+
+func handleBridgeAck(evt hal.Evt, argv []string) {
+	if len(argv) != 3 {
+		evt.Reply(BridgeUsage)
+		return
+	}
+
+	id := argv[2]
+
+	var js string
+	ttl, err := hal.Cache().Get(pendingKey(id), &js)
+	if err != nil || ttl == 0 {
+		evt.Replyf("no quarantined message pending with id %q", id)
+		return
+	}
+
+	var pending pendingMessage
+	if err := json.Unmarshal([]byte(js), &pending); err != nil {
+		evt.Replyf("could not decode quarantined message %q: %s", id, err)
+		return
+	}
+
+	hal.Cache().Delete(pendingKey(id))
+	markKnownSender(pending.Evt.BrokerName(), pending.Evt.User)
+	relay(pending.Evt, pending.ToBroker, pending.ToRoom)
+
+	evt.Replyf("relayed quarantined message %q", id)
 }
@@ -0,0 +1,118 @@
+// Package directory exposes hal.Directory() - the graph populated by
+// ingesters like pagerduty with typed nodes/edges - to chat users via
+// !whois and !oncall. It's deliberately generic: any future ingester
+// (LDAP, GitHub, etc.) that calls hal.Directory().Put/PutNode/PutEdge
+// becomes queryable here for free.
+package directory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+func Register() {
+	whois := hal.Plugin{
+		Name:  "directory_whois",
+		Func:  whoisCmd,
+		Regex: "^[[:space:]]*!whois",
+	}
+	whois.Register()
+
+	oncall := hal.Plugin{
+		Name:  "directory_oncall",
+		Func:  oncallCmd,
+		Regex: "^[[:space:]]*!oncall",
+	}
+	oncall.Register()
+
+	query := hal.Plugin{
+		Name:  "directory_query",
+		Func:  queryCmd,
+		Regex: "^[[:space:]]*!dirquery",
+	}
+	query.Register()
+}
+
+// !whois <email|phone|name> finds a pd-user node by walking every attribute
+// that might identify a person and reports what it finds.
+func whoisCmd(evt hal.Evt) {
+	argv := evt.BodyAsArgv()
+	if len(argv) < 2 {
+		evt.Reply("usage: !whois <email|phone|name>")
+		return
+	}
+
+	needle := strings.Join(argv[1:], " ")
+
+	// pagerduty's ingester exposes "email"/"name"/"phone_contact_method" as
+	// edge types off of pd-user, so look the user up by walking backwards
+	// from whichever identifier the caller supplied, plus the id itself in
+	// case it's already a pd-user id.
+	nodes := hal.Directory().Query(needle, "pd-user").Nodes()
+	for _, attr := range []string{"email", "name", "phone_contact_method"} {
+		if len(nodes) > 0 {
+			break
+		}
+		nodes = hal.Directory().Query(needle, attr).Follow("pd-user").Nodes()
+	}
+
+	if len(nodes) == 0 {
+		evt.Replyf("no directory match for %q", needle)
+		return
+	}
+
+	evt.Reply(formatNodes(nodes))
+}
+
+// !oncall <service> walks pd-service -> pd-escalation-policy -> pd-schedule -> pd-user.
+func oncallCmd(evt hal.Evt) {
+	argv := evt.BodyAsArgv()
+	if len(argv) < 2 {
+		evt.Reply("usage: !oncall <service>")
+		return
+	}
+
+	service := strings.Join(argv[1:], " ")
+
+	nodes := hal.Directory().
+		Query(service, "pd-service").
+		Follow("pd-escalation-policy").
+		Follow("pd-schedule").
+		Follow("pd-user").
+		Nodes()
+
+	if len(nodes) == 0 {
+		evt.Replyf("no oncall users found for service %q", service)
+		return
+	}
+
+	evt.Reply(formatNodes(nodes))
+}
+
+// !dirquery <dsl> runs the raw text DSL, e.g.
+// !dirquery pd-user:atobey -> pd-team -> pd-service
+func queryCmd(evt hal.Evt) {
+	dsl := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(evt.Body), "!dirquery"))
+	if dsl == "" {
+		evt.Reply("usage: !dirquery <typ>:<id> -> <typ> -> <typ>...")
+		return
+	}
+
+	q, err := hal.Directory().ParseDirDSL(dsl)
+	if err != nil {
+		evt.Replyf("could not parse query: %s", err)
+		return
+	}
+
+	evt.Reply(formatNodes(q.Nodes()))
+}
+
+func formatNodes(nodes []hal.DirNode) string {
+	lines := make([]string, len(nodes))
+	for i, n := range nodes {
+		lines[i] = fmt.Sprintf("%s:%s %v", n.Typ, n.Id, n.Attrs)
+	}
+	return strings.Join(lines, "\n")
+}
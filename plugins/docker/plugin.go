@@ -1,9 +1,41 @@
-// Package docker allows users to attach a Docker image to a room and interact
-// with it over its stdin/stdout.
+// Package docker lets users run and stay attached to interactive Docker
+// containers from chat: "!docker run -it <image>" starts a container and
+// keeps its stdin/stdout wired to the room (or a per-user DM room with
+// --dm) for every subsequent line, instead of the one-shot `exec.Command`
+// call this package used to make per message.
 package docker
 
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
 import (
-	"os/exec"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 
 	"github.com/netflix/hal-9001/hal"
 )
@@ -13,63 +45,562 @@ const Name = "docker"
 const Usage = `
 Examples:
 !docker images
-!docker run
+!docker run --image=<image> [--it] [--dm]
+!docker attach --id=<containerId>
+!docker detach
+!docker ps --mine
 `
 
-// Register makes this plugin available to the system.
+// defaultIdleTimeout SIGTERMs an attached container's session if its room
+// has gone this long without a line of input.
+const defaultIdleTimeout = 30 * time.Minute
+
+// cli is the "!docker ..." command tree. See init() and dockerCmd.
+var cli *hal.Cmd
+
+func init() {
+	cli = hal.NewCmd("docker", true).SetUsage("run and stay attached to interactive Docker containers")
+
+	cli.AddSubCmd("images").SetUsage("list locally available images")
+
+	run := cli.AddSubCmd("run").SetUsage("start a container and attach its stdin/stdout to this room")
+	run.AddKVParam("image", true).SetUsage("image to run, must be on the allowlist (see docker.allowlist.<broker>)")
+	run.AddBoolParam("it", false).SetUsage("allocate a tty, same as docker run -it")
+	run.AddBoolParam("dm", false).SetUsage("attach in an auto-created DM room instead of this one")
+
+	attach := cli.AddSubCmd("attach").SetUsage("reattach this room to a container started earlier")
+	attach.AddKVParam("id", true).SetUsage("container id, see '!docker ps --mine'")
+
+	cli.AddSubCmd("detach").SetUsage("detach this room from its container without stopping it")
+
+	ps := cli.AddSubCmd("ps").SetUsage("list containers with a live or persisted session")
+	ps.AddBoolParam("mine", false).SetUsage("only show containers attached by you")
+}
+
+// Register makes this plugin available to the system. Regex is
+// deliberately empty rather than "^!docker": an attached room's plain
+// chat lines (not just its "!docker ..." commands) need to reach this
+// Func so they can be forwarded to the container's stdin, see docker().
 func Register() {
 	plugin := hal.Plugin{
 		Name:  Name,
 		Func:  docker,
-		Regex: "^!docker",
+		Regex: "",
+		Init:  func(inst *hal.Instance) { reattachPersisted() },
 	}
 
 	plugin.Register()
 }
 
+// docker is the plugin entry point for every message in a room this
+// plugin is attached to. A "!docker ..." line is parsed as a command;
+// anything else is forwarded as a line of stdin to that room's attached
+// container, if it has one.
 func docker(evt hal.Evt) {
-	argv := evt.BodyAsArgv()
+	if strings.HasPrefix(strings.TrimSpace(evt.Body), "!docker") {
+		dockerCmd(evt)
+		return
+	}
+
+	sessMut.Lock()
+	s, ok := sessByRoom[evt.RoomId]
+	if !ok || s.stdin == nil {
+		sessMut.Unlock()
+		return
+	}
+	s.lastActivity = time.Now()
+	stdin, containerId := s.stdin, s.ContainerId
+	sessMut.Unlock()
+
+	if _, err := io.WriteString(stdin, evt.Body+"\n"); err != nil {
+		log.Printf("docker: writing to %q's stdin failed: %s", containerId, err)
+	}
+}
+
+func dockerCmd(evt hal.Evt) {
+	inst, err := cli.ProcessString(evt.Body)
+	if err != nil {
+		evt.Reply(err.Error())
+		return
+	}
 
-	if len(argv) < 2 {
-		evt.Reply(Usage)
+	if inst.HelpRequested() {
+		evt.Reply(inst.Help().String())
 		return
 	}
 
-	switch argv[1] {
+	switch inst.SubCmdToken() {
 	case "images":
 		images(evt)
 	case "run":
-		if len(argv) < 3 {
-			evt.Replyf("docker run requires an image id!\n%s", Usage)
+		runCmd(evt, inst.SubCmdInst())
+	case "attach":
+		attachCmd(evt, inst.SubCmdInst())
+	case "detach":
+		detachCmd(evt)
+	case "ps":
+		psCmd(evt, inst.SubCmdInst())
+	default:
+		evt.Reply(inst.Help().String())
+	}
+}
+
+// session is one room's live or persisted attachment to a container.
+// Only its exported (JSON) fields survive a restart (see sessionKVKey/
+// reattachPersisted); cancel, stdin and lastActivity exist only in this
+// process, and a restored session gets a fresh cancel and stdin from
+// streamContainer instead of resuming the old hijacked connection.
+// Room/lastActivity/stdin are mutated from multiple goroutines (docker,
+// streamContainer, watchIdle, attachCmd/detachCmd) and must only be read
+// or written while holding sessMut, same as the sessByRoom/sessByCtrId
+// maps they live alongside.
+type session struct {
+	ContainerId string    `json:"container_id"`
+	Room        string    `json:"room"`
+	User        string    `json:"user"`
+	Broker      string    `json:"broker"`
+	Image       string    `json:"image"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	lastActivity time.Time
+	cancel       context.CancelFunc
+	stdin        io.Writer
+}
+
+var (
+	sessMut     sync.Mutex
+	sessByRoom  = make(map[string]*session) // room -> session
+	sessByCtrId = make(map[string]*session) // container id -> session
+)
+
+// sessionKVKey namespaces a session's persisted metadata in hal's KV
+// store, keyed by container id. hal.Pref would be the obvious fit for
+// "settings that outlive a restart", but as noted in hal/rpc's
+// Server.prefKV, hal.Pref is referenced throughout this codebase yet
+// never defined -- GetKV/SetKV is the closest real persistence available.
+func sessionKVKey(containerId string) string {
+	return "docker.session." + containerId
+}
+
+// sessionIndexKey holds a JSON array of every container id with a
+// persisted session, so a restart can enumerate and reattach them without
+// scanning the whole KV store.
+const sessionIndexKey = "docker.sessions"
+
+func persistSession(s *session) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		log.Printf("docker: BUG: failed to marshal session for %q: %s", s.ContainerId, err)
+		return
+	}
+
+	if err := hal.SetKV(sessionKVKey(s.ContainerId), string(data), 0); err != nil {
+		log.Printf("docker: failed to persist session for %q: %s", s.ContainerId, err)
+	}
+
+	ids := sessionIndex()
+	for _, id := range ids {
+		if id == s.ContainerId {
 			return
 		}
-		run(evt, argv)
 	}
+	ids = append(ids, s.ContainerId)
+	saveSessionIndex(ids)
+}
+
+func forgetSession(containerId string) {
+	if err := hal.SetKV(sessionKVKey(containerId), "", 0); err != nil {
+		log.Printf("docker: failed to clear persisted session for %q: %s", containerId, err)
+	}
+
+	ids := sessionIndex()
+	out := ids[:0]
+	for _, id := range ids {
+		if id != containerId {
+			out = append(out, id)
+		}
+	}
+	saveSessionIndex(out)
+}
+
+func sessionIndex() []string {
+	data, err := hal.GetKV(sessionIndexKey)
+	if err != nil || data == "" {
+		return nil
+	}
+
+	var ids []string
+	if err := json.Unmarshal([]byte(data), &ids); err != nil {
+		log.Printf("docker: failed to parse session index: %s", err)
+		return nil
+	}
+
+	return ids
 }
 
-// TODO: the idea is to be able to run an interactive container that may be more
-// than a single command, e.g. an old-school question/answer script that asks a
-// few questions then does some work. This will probably require a timeout
-// and some way to either signal which container you're messaging or spawn a
-// DM room for the purpose and perhaps send the output back to the originating
-// room. The DM approach is likely least complex, even across brokers.
-func run(evt hal.Evt, argv []string) {
-	// danger! insecure! Demo code ;)
-	cmd := exec.Command("/usr/bin/docker", argv[1:]...)
-	out, err := cmd.Output()
+func saveSessionIndex(ids []string) {
+	data, err := json.Marshal(ids)
 	if err != nil {
-		evt.Replyf("Encountered an error while running 'docker run %s': %s", argv[2], err)
+		log.Printf("docker: BUG: failed to marshal session index: %s", err)
+		return
 	}
 
-	evt.Reply(string(out))
+	if err := hal.SetKV(sessionIndexKey, string(data), 0); err != nil {
+		log.Printf("docker: failed to persist session index: %s", err)
+	}
+}
+
+// reattachPersisted resumes stdout/stderr streaming for every session
+// that survived a restart, so a container started before the bot
+// restarted doesn't end up orphaned with nobody listening to it.
+//
+// Init runs this once per room/instance this plugin is attached to (see
+// hal.Instance.Register), not once per process, so it's guarded by an
+// already-attached check keyed by container id: without it, attaching this
+// plugin to N rooms would start N duplicate streamContainer goroutines
+// (and N duplicate hijacked Docker connections) per already-running
+// container.
+func reattachPersisted() {
+	for _, id := range sessionIndex() {
+		sessMut.Lock()
+		_, alreadyAttached := sessByCtrId[id]
+		sessMut.Unlock()
+		if alreadyAttached {
+			continue
+		}
+
+		data, err := hal.GetKV(sessionKVKey(id))
+		if err != nil || data == "" {
+			continue
+		}
+
+		var s session
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			log.Printf("docker: failed to parse persisted session for %q: %s", id, err)
+			continue
+		}
+
+		s.lastActivity = time.Now()
+
+		sessMut.Lock()
+		if _, alreadyAttached := sessByCtrId[s.ContainerId]; alreadyAttached {
+			sessMut.Unlock()
+			continue
+		}
+		sessByRoom[s.Room] = &s
+		sessByCtrId[s.ContainerId] = &s
+		sessMut.Unlock()
+
+		go streamContainer(&s, nil)
+	}
+}
+
+// allowlist returns the image names permitted on evt's broker, via the
+// broker-scoped pref "docker.allowlist.<broker>" (comma-separated). An
+// empty/unset allowlist permits nothing -- this plugin can run arbitrary
+// containers on the host, so the safe default is deny-all until an
+// operator opts a broker in.
+func allowlist(broker string) []string {
+	raw, err := hal.GetKV("docker.allowlist." + broker)
+	if err != nil || raw == "" {
+		return nil
+	}
+
+	return strings.Split(raw, ",")
+}
+
+func imageAllowed(broker, image string) bool {
+	for _, allowed := range allowlist(broker) {
+		if strings.TrimSpace(allowed) == image {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resourceCaps reads the broker-scoped "docker.memory.<broker>" (bytes)
+// and "docker.cpus.<broker>" (docker's NanoCPUs, 1e9 per core) prefs,
+// defaulting to a conservative 256MB / 1 core so "!docker run" can't be
+// used to exhaust the host by default.
+func resourceCaps(broker string) (memBytes int64, nanoCpus int64) {
+	memBytes = 256 * 1024 * 1024
+	nanoCpus = 1_000_000_000
+
+	if raw, err := hal.GetKV("docker.memory." + broker); err == nil && raw != "" {
+		fmt.Sscanf(raw, "%d", &memBytes)
+	}
+	if raw, err := hal.GetKV("docker.cpus." + broker); err == nil && raw != "" {
+		fmt.Sscanf(raw, "%d", &nanoCpus)
+	}
+
+	return memBytes, nanoCpus
 }
 
 func images(evt hal.Evt) {
-	cmd := exec.Command("/usr/bin/docker", "images")
-	out, err := cmd.Output()
+	cli, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+	if err != nil {
+		evt.Replyf("could not reach the Docker daemon: %s", err)
+		return
+	}
+
+	list, err := cli.ImageList(context.Background(), dockertypes.ImageListOptions{})
 	if err != nil {
-		evt.Replyf("Encountered an error while running 'docker images': %s", err)
+		evt.Replyf("Encountered an error while listing images: %s", err)
+		return
+	}
+
+	lines := make([]string, 0, len(list))
+	for _, img := range list {
+		lines = append(lines, strings.Join(img.RepoTags, ", "))
+	}
+
+	evt.Reply(strings.Join(lines, "\n"))
+}
+
+func runCmd(evt hal.Evt, sc *hal.SubCmdInst) {
+	image := sc.GetKVParamInst("image").Value()
+	broker := evt.BrokerName()
+
+	if !imageAllowed(broker, image) {
+		evt.Replyf("image %q is not on this broker's allowlist (docker.allowlist.%s)", image, broker)
+		return
+	}
+
+	room := evt.RoomId
+	if bp := sc.GetBoolParamInstIfSet("dm"); bp != nil && bp.Value() {
+		room = evt.UserId
+	}
+
+	sessMut.Lock()
+	_, busy := sessByRoom[room]
+	sessMut.Unlock()
+	if busy {
+		evt.Replyf("this room already has an attached container -- '!docker detach' first")
+		return
+	}
+
+	tty := false
+	if bp := sc.GetBoolParamInstIfSet("it"); bp != nil {
+		tty = bp.Value()
+	}
+
+	cli, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+	if err != nil {
+		evt.Replyf("could not reach the Docker daemon: %s", err)
+		return
+	}
+
+	ctx := context.Background()
+	memBytes, nanoCpus := resourceCaps(broker)
+
+	created, err := cli.ContainerCreate(ctx,
+		&container.Config{
+			Image:        image,
+			Tty:          tty,
+			OpenStdin:    true,
+			AttachStdin:  true,
+			AttachStdout: true,
+			AttachStderr: true,
+		},
+		&container.HostConfig{
+			Resources: container.Resources{
+				Memory:   memBytes,
+				NanoCPUs: nanoCpus,
+			},
+		},
+		nil, nil, "",
+	)
+	if err != nil {
+		evt.Replyf("Encountered an error creating the container: %s", err)
+		return
+	}
+
+	if err := cli.ContainerStart(ctx, created.ID, dockertypes.ContainerStartOptions{}); err != nil {
+		evt.Replyf("Encountered an error starting the container: %s", err)
+		return
+	}
+
+	s := &session{
+		ContainerId:  created.ID,
+		Room:         room,
+		User:         evt.UserId,
+		Broker:       broker,
+		Image:        image,
+		CreatedAt:    time.Now(),
+		lastActivity: time.Now(),
+	}
+
+	sessMut.Lock()
+	sessByRoom[room] = s
+	sessByCtrId[created.ID] = s
+	sessMut.Unlock()
+
+	persistSession(s)
+
+	evt.Replyf("attached to %s (%s) -- every line here now goes to its stdin", created.ID[:12], image)
+
+	go streamContainer(s, &evt)
+}
+
+// streamContainer hijacks containerId's stdio and forwards its
+// stdout/stderr to s's room as chat messages until the container exits or
+// s.Room goes idle for longer than defaultIdleTimeout (see watchIdle);
+// detachCmd does not stop it.
+func streamContainer(s *session, evt *hal.Evt) {
+	cli, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+	if err != nil {
+		log.Printf("docker: could not reach the Docker daemon to attach to %q: %s", s.ContainerId, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sessMut.Lock()
+	s.cancel = cancel
+	sessMut.Unlock()
+
+	hijacked, err := cli.ContainerAttach(ctx, s.ContainerId, dockertypes.ContainerAttachOptions{
+		Stream: true, Stdin: true, Stdout: true, Stderr: true,
+	})
+	if err != nil {
+		log.Printf("docker: could not attach to %q: %s", s.ContainerId, err)
+		return
+	}
+	defer hijacked.Close()
+
+	sessMut.Lock()
+	s.stdin = hijacked.Conn
+	sessMut.Unlock()
+
+	outR, outW := io.Pipe()
+	go func() {
+		defer outW.Close()
+		if _, err := stdcopy.StdCopy(outW, outW, hijacked.Reader); err != nil {
+			log.Printf("docker: stdout copy for %q ended: %s", s.ContainerId, err)
+		}
+	}()
+
+	go watchIdle(s, cancel)
+
+	scanner := bufio.NewScanner(outR)
+	for scanner.Scan() {
+		announce(s, scanner.Text())
+	}
+
+	sessMut.Lock()
+	delete(sessByRoom, s.Room)
+	delete(sessByCtrId, s.ContainerId)
+	sessMut.Unlock()
+
+	announce(s, "session ended")
+}
+
+// watchIdle SIGTERMs s's container and cancels its attach if s.Room goes
+// longer than defaultIdleTimeout without a line of input (see
+// docker's and attachCmd's updates to s.lastActivity).
+func watchIdle(s *session, cancel context.CancelFunc) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sessMut.Lock()
+		idle := time.Since(s.lastActivity)
+		_, stillAttached := sessByCtrId[s.ContainerId]
+		sessMut.Unlock()
+
+		if !stillAttached {
+			return
+		}
+
+		if idle > defaultIdleTimeout {
+			cli, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+			if err == nil {
+				cli.ContainerStop(context.Background(), s.ContainerId, container.StopOptions{})
+			}
+			announce(s, fmt.Sprintf("session ended: idle for longer than %s", defaultIdleTimeout))
+			cancel()
+			forgetSession(s.ContainerId)
+			return
+		}
+	}
+}
+
+// announce posts body to s.Room on s.Broker, best-effort -- used from the
+// streaming goroutines, which don't have a live hal.Evt to Reply through.
+func announce(s *session, body string) {
+	sessMut.Lock()
+	room := s.Room
+	sessMut.Unlock()
+
+	b := hal.Router().GetBroker(s.Broker)
+	if b == nil {
+		log.Printf("docker: %q: %s", room, body)
+		return
+	}
+
+	b.Send(hal.Evt{Room: room, RoomId: room, Body: body, Time: time.Now()})
+}
+
+func attachCmd(evt hal.Evt, sc *hal.SubCmdInst) {
+	id := sc.GetKVParamInst("id").Value()
+
+	sessMut.Lock()
+	s, ok := sessByCtrId[id]
+	sessMut.Unlock()
+
+	if !ok {
+		evt.Replyf("no session for container %q -- see '!docker ps'", id)
+		return
+	}
+
+	sessMut.Lock()
+	s.Room = evt.RoomId
+	s.lastActivity = time.Now()
+	sessByRoom[evt.RoomId] = s
+	sessMut.Unlock()
+
+	persistSession(s)
+	evt.Replyf("this room is now attached to %s (%s)", id[:12], s.Image)
+}
+
+func detachCmd(evt hal.Evt) {
+	sessMut.Lock()
+	s, ok := sessByRoom[evt.RoomId]
+	if ok {
+		delete(sessByRoom, evt.RoomId)
+	}
+	sessMut.Unlock()
+
+	if !ok {
+		evt.Reply("this room isn't attached to a container")
+		return
+	}
+
+	evt.Replyf("detached from %s -- it keeps running; '!docker attach --id=%s' to reconnect", s.ContainerId[:12], s.ContainerId)
+}
+
+func psCmd(evt hal.Evt, sc *hal.SubCmdInst) {
+	mine := false
+	if bp := sc.GetBoolParamInstIfSet("mine"); bp != nil {
+		mine = bp.Value()
+	}
+
+	sessMut.Lock()
+	defer sessMut.Unlock()
+
+	lines := make([]string, 0, len(sessByCtrId))
+	for _, s := range sessByCtrId {
+		if mine && s.User != evt.UserId {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s  %-20s room=%s user=%s", s.ContainerId[:12], s.Image, s.Room, s.User))
+	}
+
+	if len(lines) == 0 {
+		evt.Reply("no attached containers")
+		return
 	}
 
-	evt.Reply(string(out))
+	evt.Reply(strings.Join(lines, "\n"))
 }
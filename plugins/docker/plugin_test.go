@@ -0,0 +1,162 @@
+package docker
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// memKVBackend is a minimal in-memory hal.KVBackend, just enough for
+// persistSession/sessionIndex/reattachPersisted to round-trip through
+// without a real SQL-backed KV store.
+type memKVBackend struct {
+	mut  sync.Mutex
+	data map[string]string
+}
+
+func (b *memKVBackend) Get(key string) (string, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	v, ok := b.data[key]
+	if !ok {
+		return "", hal.ErrKVNotFound
+	}
+	return v, nil
+}
+
+func (b *memKVBackend) Set(key, value string, ttl time.Duration) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.data == nil {
+		b.data = make(map[string]string)
+	}
+	b.data[key] = value
+	return nil
+}
+
+func (b *memKVBackend) Delete(key string) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	delete(b.data, key)
+	return nil
+}
+
+func (b *memKVBackend) Watch(prefix string) (<-chan hal.KVEvent, error) {
+	return nil, hal.ErrWatchNotSupported
+}
+
+func (b *memKVBackend) CompareAndSwap(key, oldVal, newVal string, ttl time.Duration) (bool, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.data == nil {
+		b.data = make(map[string]string)
+	}
+	if b.data[key] != oldVal {
+		return false, nil
+	}
+	b.data[key] = newVal
+	return true, nil
+}
+
+// resetSessionState clears the package-level session maps between tests,
+// since they're process-global like the real plugin expects.
+func resetSessionState() {
+	sessMut.Lock()
+	defer sessMut.Unlock()
+	sessByRoom = make(map[string]*session)
+	sessByCtrId = make(map[string]*session)
+}
+
+// TestReattachPersistedSkipsAlreadyAttached covers the chunk12-4 fix:
+// Register's Init runs reattachPersisted once per room this plugin is
+// attached to, not once per process, so it must never replace or
+// duplicate-stream a container that's already attached in this process --
+// only pick up containers that are persisted but not yet live here.
+func TestReattachPersistedSkipsAlreadyAttached(t *testing.T) {
+	hal.SetKVBackend(&memKVBackend{})
+	defer hal.SetKVBackend(nil)
+	resetSessionState()
+
+	attached := &session{
+		ContainerId: "deadbeef0001",
+		Room:        "#general",
+		User:        "alice",
+		Broker:      "test",
+		Image:       "busybox",
+		CreatedAt:   time.Now(),
+	}
+	persistSession(attached)
+
+	sessMut.Lock()
+	sessByRoom[attached.Room] = attached
+	sessByCtrId[attached.ContainerId] = attached
+	sessMut.Unlock()
+
+	reattachPersisted()
+
+	sessMut.Lock()
+	defer sessMut.Unlock()
+	if got := sessByCtrId[attached.ContainerId]; got != attached {
+		t.Errorf("reattachPersisted replaced an already-attached session for %q instead of leaving it alone", attached.ContainerId)
+	}
+}
+
+// TestPersistSessionRoundTrip covers the lower half of the reattach path:
+// a persisted session's index and KV record both need to survive so a
+// second Init (attaching another room) can find it at all.
+func TestPersistSessionRoundTrip(t *testing.T) {
+	hal.SetKVBackend(&memKVBackend{})
+	defer hal.SetKVBackend(nil)
+	resetSessionState()
+
+	s := &session{
+		ContainerId: "deadbeef0002",
+		Room:        "#ops",
+		User:        "bob",
+		Broker:      "test",
+		Image:       "alpine",
+		CreatedAt:   time.Now(),
+	}
+	persistSession(s)
+
+	ids := sessionIndex()
+	found := false
+	for _, id := range ids {
+		if id == s.ContainerId {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("sessionIndex() = %v, missing %q after persistSession", ids, s.ContainerId)
+	}
+
+	data, err := hal.GetKV(sessionKVKey(s.ContainerId))
+	if err != nil || data == "" {
+		t.Fatalf("GetKV(%q) = %q, %v; expected the persisted session record", sessionKVKey(s.ContainerId), data, err)
+	}
+
+	forgetSession(s.ContainerId)
+	for _, id := range sessionIndex() {
+		if id == s.ContainerId {
+			t.Errorf("sessionIndex() still contains %q after forgetSession", s.ContainerId)
+		}
+	}
+}
@@ -2,23 +2,29 @@ package google_calendar
 
 import (
 	"fmt"
+	"log"
+	"net/http"
 	"time"
 
 	"github.com/netflix/hal-9001/hal"
 
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 )
 
 const oauthJsonKey = `google-calendar-oauth-client-json`
+const syncTokenKey = "sync-token"
 
-// a simplified calendar event returned by getEvents
+// a simplified calendar event returned by getEvents. Id is the Calendar
+// API's event id, used to merge incremental updates and to recognize
+// cancelled instances of recurring events.
 type CalEvent struct {
+	Id          string
 	Start       time.Time
 	End         time.Time
 	Name        string
 	Description string
+	Cancelled   bool
 }
 
 type GoogleError struct {
@@ -42,49 +48,105 @@ func (e SecretMissingError) Error() string {
 	return "the google-calendar-oauth-client-json secret must be set. Contact the bot admin."
 }
 
-func getEvents(calendarId string, now time.Time) ([]CalEvent, error) {
-	// TODO: figure out if it's feasible to have one secret per bot or
-	// if it really needs to be per-calendar or room
-	// TODO: this should probably be passed to this function rather than
-	// making this file require hal
-	secrets := hal.Secrets()
-	jsonData := secrets.Get("google-calendar-oauth-client-json")
-	if jsonData == "" {
-		return nil, SecretMissingError{}
+// getEvents fetches events for calendarId, using the room's persisted
+// syncToken (see loadSyncToken/saveSyncToken) to pull only what changed
+// since the last call instead of re-downloading the whole window. On the
+// first call for a room, or after the token expires, it falls back to a
+// full sync over a rolling window.
+//
+// Recurring events arrive pre-expanded into instances (SingleEvents), and
+// cancelled instances are returned with Cancelled set rather than omitted,
+// so the caller can tombstone them out of its own cache instead of relying
+// on a full refresh to notice they're gone.
+func getEvents(calendarId, roomId string, now time.Time) ([]CalEvent, error) {
+	cal, err := calendarClient(roomId)
+	if err != nil {
+		return nil, err
 	}
 
-	config, err := google.JWTConfigFromJSON([]byte(jsonData), calendar.CalendarReadonlyScope)
-	if err != nil {
-		return nil, GoogleError{err}
+	call := cal.Events.List(calendarId).ShowDeleted(true).SingleEvents(true)
+
+	token := loadSyncToken(roomId)
+	if token != "" {
+		call = call.SyncToken(token)
+	} else {
+		// no syncToken yet: seed the cache with a generous window so the
+		// first incremental sync afterward has something to diff against
+		min := now.Add(time.Hour * -1).Format(time.RFC3339)
+		max := now.Add(time.Hour * 24 * 30).Format(time.RFC3339)
+		call = call.TimeMin(min).TimeMax(max)
 	}
-	client := config.Client(oauth2.NoContext)
-	cal, err := calendar.New(client)
+
+	events, err := call.Do()
 	if err != nil {
+		if isSyncTokenGoneErr(err) {
+			log.Printf("google_calendar: syncToken for room %q expired, falling back to a full sync", roomId)
+			clearSyncToken(roomId)
+			return getEvents(calendarId, roomId, now)
+		}
 		return nil, GoogleError{err}
 	}
 
-	min := now.Add(time.Hour * -1).Format(time.RFC3339)
-	max := now.Add(time.Hour * 24).Format(time.RFC3339)
-	events, err := cal.Events.List(calendarId).
-		ShowDeleted(false).
-		SingleEvents(true).
-		TimeMin(min).
-		TimeMax(max).
-		Do()
+	out := make([]CalEvent, 0, len(events.Items))
+	for _, event := range events.Items {
+		ce := CalEvent{Id: event.Id, Name: event.Summary, Description: event.Description}
 
-	if err != nil {
-		return nil, GoogleError{err}
+		if event.Status == "cancelled" {
+			ce.Cancelled = true
+			out = append(out, ce)
+			continue
+		}
+
+		if event.Start != nil {
+			ce.Start, _ = parseEventTime(event.Start)
+		}
+		if event.End != nil {
+			ce.End, _ = parseEventTime(event.End)
+		}
+
+		out = append(out, ce)
 	}
 
-	out := make([]CalEvent, len(events.Items))
-	for i, event := range events.Items {
-		start, _ := time.Parse(time.RFC3339, event.Start.DateTime)
-		out[i].Start = start
-		end, _ := time.Parse(time.RFC3339, event.End.DateTime)
-		out[i].End = end
-		out[i].Name = event.Summary
-		out[i].Description = event.Description
+	if events.NextSyncToken != "" {
+		saveSyncToken(roomId, events.NextSyncToken)
 	}
 
 	return out, nil
 }
+
+// parseEventTime handles both timed events (DateTime) and all-day events,
+// which the Calendar API represents with only a Date field.
+func parseEventTime(t *calendar.EventDateTime) (time.Time, error) {
+	if t.DateTime != "" {
+		return time.Parse(time.RFC3339, t.DateTime)
+	}
+	return time.Parse("2006-01-02", t.Date)
+}
+
+// isSyncTokenGoneErr reports whether err is the 410 Gone the Calendar API
+// returns when a syncToken is too old or otherwise invalid.
+func isSyncTokenGoneErr(err error) bool {
+	if gerr, ok := err.(*googleapi.Error); ok {
+		return gerr.Code == http.StatusGone
+	}
+	return false
+}
+
+func loadSyncToken(roomId string) string {
+	pref := hal.GetPref("", "", roomId, "google_calendar", syncTokenKey, "")
+	if !pref.Success {
+		return ""
+	}
+	return pref.Value
+}
+
+func saveSyncToken(roomId, token string) {
+	pref := hal.Pref{Room: roomId, Plugin: "google_calendar", Key: syncTokenKey, Value: token}
+	if err := pref.Set(); err != nil {
+		log.Printf("google_calendar: failed to persist sync token for room %q: %s", roomId, err)
+	}
+}
+
+func clearSyncToken(roomId string) {
+	saveSyncToken(roomId, "")
+}
@@ -0,0 +1,121 @@
+package google_calendar
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/netflix/hal-9001/hal"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+)
+
+// oauthTokenKey stores a per-room offline user OAuth2 token (JSON-encoded
+// oauth2.Token), for rooms configured against a regular Google account's
+// calendar instead of a service account's.
+const oauthTokenKey = "oauth-token"
+
+// oauthClientSecretKey is the hal.Secrets() key holding the OAuth2 client
+// (installed/web app) credentials JSON used to refresh oauthTokenKey.
+const oauthClientSecretKey = "google-calendar-oauth-client-secret-json"
+
+// calendarClient builds an authenticated Calendar API client for roomId,
+// preferring a per-room offline user token (see SaveOAuthToken) and
+// falling back to the shared service-account JWT flow keyed by
+// oauthJsonKey.
+func calendarClient(roomId string) (*calendar.Service, error) {
+	if tok, ok := loadOAuthToken(roomId); ok {
+		return oauthClient(roomId, tok)
+	}
+
+	return serviceAccountClient()
+}
+
+func serviceAccountClient() (*calendar.Service, error) {
+	secrets := hal.Secrets()
+	jsonData := secrets.Get(oauthJsonKey)
+	if jsonData == "" {
+		return nil, SecretMissingError{}
+	}
+
+	config, err := google.JWTConfigFromJSON([]byte(jsonData), calendar.CalendarReadonlyScope)
+	if err != nil {
+		return nil, GoogleError{err}
+	}
+
+	return calendar.New(config.Client(oauth2.NoContext))
+}
+
+// oauthClient builds a Calendar API client from a stored offline user
+// token. Tokens are refreshed transparently by the oauth2 package; the
+// refreshed token is written back via persistingTokenSource so a restart
+// doesn't force the user back through the consent flow.
+func oauthClient(roomId string, tok *oauth2.Token) (*calendar.Service, error) {
+	secrets := hal.Secrets()
+	jsonData := secrets.Get(oauthClientSecretKey)
+	if jsonData == "" {
+		return nil, SecretMissingError{}
+	}
+
+	conf, err := google.ConfigFromJSON([]byte(jsonData), calendar.CalendarReadonlyScope)
+	if err != nil {
+		return nil, GoogleError{err}
+	}
+
+	src := &persistingTokenSource{roomId: roomId, src: conf.TokenSource(oauth2.NoContext, tok)}
+
+	return calendar.New(oauth2.NewClient(oauth2.NoContext, src))
+}
+
+// persistingTokenSource wraps an oauth2.TokenSource and saves every token
+// it hands out, so a refreshed access token is still on hand after a
+// restart instead of being silently dropped.
+type persistingTokenSource struct {
+	roomId string
+	src    oauth2.TokenSource
+}
+
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := p.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := SaveOAuthToken(p.roomId, tok); err != nil {
+		log.Printf("google_calendar: failed to persist refreshed oauth token for room %q: %s", p.roomId, err)
+	}
+
+	return tok, nil
+}
+
+// SaveOAuthToken persists an offline user OAuth2 token for roomId so
+// calendarClient can use it without repeating the consent flow. This
+// plugin only consumes the token - whatever out-of-band OAuth2 consent
+// flow an operator wires up (e.g. a one-off CLI tool, or a handler
+// registered by the deployment) should call this once the user has
+// authorized access.
+func SaveOAuthToken(roomId string, tok *oauth2.Token) error {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+
+	pref := hal.Pref{Room: roomId, Plugin: "google_calendar", Key: oauthTokenKey, Value: string(data)}
+	return pref.Set()
+}
+
+func loadOAuthToken(roomId string) (*oauth2.Token, bool) {
+	pref := hal.GetPref("", "", roomId, "google_calendar", oauthTokenKey, "")
+	if !pref.Success || pref.Value == "" {
+		return nil, false
+	}
+
+	var tok oauth2.Token
+	if err := json.Unmarshal([]byte(pref.Value), &tok); err != nil {
+		log.Printf("google_calendar: stored oauth token for room %q is corrupt: %s", roomId, err)
+		return nil, false
+	}
+
+	return &tok, true
+}
@@ -21,6 +21,8 @@ package google_calendar
 import (
 	"fmt"
 	"log"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -50,6 +52,12 @@ Setting up:
 
     timezone: optional, tells the bot which timezone to report dates in
     !prefs set --room <roomid> --plugin google_calendar --key timezone --value America/Los_Angeles
+
+    watch-callback-url: optional, this bot's publicly reachable URL ending in
+    /v1/google_calendar/watch. Once set, "!gcal watch" registers a push
+    notification channel so changes are picked up within seconds instead of
+    on the 10-minute poll.
+    !prefs set --room <roomid> --plugin google_calendar --key watch-callback-url --value https://bot.example.com/v1/google_calendar/watch
 `
 
 const DefaultTz = "America/Los_Angeles"
@@ -67,6 +75,7 @@ type Config struct {
 	mut           sync.Mutex
 	configTs      time.Time
 	calTs         time.Time
+	events        map[string]CalEvent // merged cache of live events, keyed by event id
 }
 
 var configCache map[string]*Config
@@ -84,6 +93,8 @@ func Register() {
 	}
 
 	p.Register()
+
+	http.HandleFunc(WatchPath, ServeWatch)
 }
 
 // initData primes the cache and starts the background goroutine
@@ -94,9 +105,10 @@ func initData(inst *hal.Instance) {
 	topMut.Unlock()
 
 	pf := hal.PeriodicFunc{
-		Name:     "google_calendar-" + inst.RoomId,
-		Interval: time.Minute * 10,
-		Function: func() { updateCachedCalEvents(inst.RoomId) },
+		Name:       "google_calendar-" + inst.RoomId,
+		Interval:   time.Minute * 10,
+		Function:   func() { updateCachedCalEvents(inst.RoomId) },
+		LeaderOnly: true,
 	}
 	pf.Register()
 
@@ -190,6 +202,19 @@ func handleCommand(evt *hal.Evt) {
 		} else {
 			evt.Reply("Invalid command. A duration is requried, e.g. !gcal silence 4h")
 		}
+	case "watch":
+		callbackURL := hal.GetPref("", "", evt.RoomId, "google_calendar", "watch-callback-url", "")
+		if !callbackURL.Success || callbackURL.Value == "" {
+			evt.Reply("The watch-callback-url pref must be set to this bot's publicly reachable " +
+				"callback URL (ending in " + WatchPath + ") before a push channel can be registered.")
+			return
+		}
+
+		if err := registerWatch(evt.RoomId, config.CalendarId, callbackURL.Value); err != nil {
+			evt.Replyf("Failed to register calendar push notifications: %s", err)
+		} else {
+			evt.Reply("Registered for calendar push notifications. Changes will now be picked up within seconds.")
+		}
 	}
 }
 
@@ -238,27 +263,59 @@ func getCachedConfig(roomId string, now time.Time) *Config {
 // holding a mutex while doing so. This prevents handleEvt from firing until
 // the first load of data is complete and will block the goroutines for a short
 // time.
+//
+// getEvents itself uses the room's syncToken to only return what changed
+// since the last call, so the results are merged into c.events rather than
+// replacing it outright; c.CalEvents is refreshed from that merge. The
+// 10-minute threshold here is just a safety net - registerWatch's push
+// channel (see watch.go) forces a refresh immediately on change.
 func (c *Config) getCachedCalEvents(now time.Time) ([]CalEvent, error) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
 	calAge := now.Sub(c.calTs)
 
-	if calAge.Hours() > 1.1 {
-		log.Printf("%q's calendar cache appears to be expired after %f hours", c.RoomId, calAge.Hours())
-		evts, err := getEvents(c.CalendarId, now)
+	if calAge.Minutes() > 10 {
+		log.Printf("%q's calendar cache appears to be expired after %f minutes", c.RoomId, calAge.Minutes())
+		evts, err := getEvents(c.CalendarId, c.RoomId, now)
 		if err != nil {
 			log.Printf("Error encountered while fetching calendar events: %s", err)
 			return nil, err
-		} else {
-			c.calTs = now
-			c.CalEvents = evts
 		}
+
+		c.mergeEvents(evts)
+		c.calTs = now
 	}
 
 	return c.CalEvents, nil
 }
 
+// mergeEvents applies a batch of events from getEvents (which may be a
+// full sync or just the instances that changed since the last syncToken)
+// into c.events, removing cancelled instances, then rebuilds the exported,
+// time-sorted c.CalEvents slice from the result.
+func (c *Config) mergeEvents(updated []CalEvent) {
+	if c.events == nil {
+		c.events = make(map[string]CalEvent, len(updated))
+	}
+
+	for _, e := range updated {
+		if e.Cancelled {
+			delete(c.events, e.Id)
+			continue
+		}
+		c.events[e.Id] = e
+	}
+
+	out := make([]CalEvent, 0, len(c.events))
+	for _, e := range c.events {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+
+	c.CalEvents = out
+}
+
 func (c *Config) LoadFromPrefs() error {
 	c.mut.Lock()
 	defer c.mut.Unlock()
@@ -289,6 +346,8 @@ func (c *Config) LoadFromPrefs() error {
 func (c *Config) expireCaches() {
 	c.calTs = time.Time{}
 	c.configTs = time.Time{}
+	c.events = nil
+	clearSyncToken(c.RoomId)
 }
 
 func (c *Config) loadBoolPref(key string) bool {
@@ -0,0 +1,98 @@
+package google_calendar
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/netflix/hal-9001/hal"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// WatchPath is the HTTP path Register() wires ServeWatch up to. Point a
+// room's push notification channel (see registerWatch) at
+// "<public base url>" + WatchPath.
+const WatchPath = "/v1/google_calendar/watch"
+
+const watchChannelIdKey = "watch-channel-id"
+const watchResourceIdKey = "watch-resource-id"
+const watchCallbackUrlKey = "watch-callback-url"
+
+// watchChannels maps a push notification channel id to the room it
+// refreshes, so ServeWatch can resolve an inbound notification without a
+// database round-trip. It's rebuilt as rooms call !gcal watch; a
+// notification for a channel from before a restart is harmlessly ignored
+// until the room re-registers.
+var watchChannels = struct {
+	mut sync.Mutex
+	m   map[string]string
+}{m: make(map[string]string)}
+
+// registerWatch asks the Calendar API to start pushing change
+// notifications for calendarId to callbackURL, persists the channel's
+// metadata, and remembers the channel -> room mapping for ServeWatch; that
+// mapping is in memory only (see watchChannels).
+func registerWatch(roomId, calendarId, callbackURL string) error {
+	cal, err := calendarClient(roomId)
+	if err != nil {
+		return err
+	}
+
+	channelId := "hal9001-gcal-" + roomId
+	channel := &calendar.Channel{
+		Id:      channelId,
+		Type:    "web_hook",
+		Address: callbackURL,
+	}
+
+	resp, err := cal.Events.Watch(calendarId, channel).Do()
+	if err != nil {
+		return GoogleError{err}
+	}
+
+	watchChannels.mut.Lock()
+	watchChannels.m[channelId] = roomId
+	watchChannels.mut.Unlock()
+
+	setStringPref(roomId, watchChannelIdKey, channelId)
+	setStringPref(roomId, watchResourceIdKey, resp.ResourceId)
+	setStringPref(roomId, watchCallbackUrlKey, callbackURL)
+
+	return nil
+}
+
+// ServeWatch is the HTTP handler Google's push notification service POSTs
+// to whenever a watched calendar changes. It looks up which room the
+// channel belongs to and forces an immediate refresh, so
+// announce-start/announce-end fire within seconds of a change instead of
+// waiting for the 10-minute poll.
+func ServeWatch(w http.ResponseWriter, r *http.Request) {
+	channelId := r.Header.Get("X-Goog-Channel-Id")
+	state := r.Header.Get("X-Goog-Resource-State")
+
+	watchChannels.mut.Lock()
+	roomId, known := watchChannels.m[channelId]
+	watchChannels.mut.Unlock()
+
+	if !known {
+		log.Printf("google_calendar: got a watch notification for unknown channel %q", channelId)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// "sync" just confirms the channel was created; real changes arrive as
+	// "exists"
+	if state == "exists" {
+		go updateCachedCalEvents(roomId)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func setStringPref(roomId, key, value string) {
+	pref := hal.Pref{Room: roomId, Plugin: "google_calendar", Key: key, Value: value}
+	if err := pref.Set(); err != nil {
+		log.Printf("google_calendar: failed to save %s pref for room %q: %s", key, roomId, err)
+	}
+}
@@ -0,0 +1,84 @@
+package pagerduty
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"log"
+	"sync"
+)
+
+// eventQueueBufSize is the queue's channel depth. A caller enqueueing past
+// this blocks until the background sender drains some of it -- unlike
+// hal's eventBus/auditDispatcher, dropping a page is never the right
+// default, so QueueTriggerEvent applies backpressure instead of
+// discarding anything.
+const eventQueueBufSize = 256
+
+// queuedEvent is one Events v2 send waiting on eventQueue's background
+// goroutine. done, if set, is called with the result once it's actually
+// sent.
+type queuedEvent struct {
+	send func() (EventResult, error)
+	done func(EventResult, error)
+}
+
+type eventDispatcher struct {
+	init sync.Once
+	ch   chan queuedEvent
+}
+
+var eventQueueSingleton eventDispatcher
+
+// eventQueue returns the shared send queue, starting its single drain
+// goroutine on first use.
+func eventQueue() chan queuedEvent {
+	eventQueueSingleton.init.Do(func() {
+		eventQueueSingleton.ch = make(chan queuedEvent, eventQueueBufSize)
+		go eventQueueSingleton.run()
+	})
+
+	return eventQueueSingleton.ch
+}
+
+func (d *eventDispatcher) run() {
+	for qe := range d.ch {
+		out, err := qe.send()
+		if err != nil {
+			log.Printf("pagerduty: queued event send failed: %s", err)
+		}
+
+		if qe.done != nil {
+			qe.done(out, err)
+		}
+	}
+}
+
+// QueueTriggerEvent enqueues a TriggerEvent call and returns once it's
+// queued (blocking only while the queue is full, see eventQueueBufSize),
+// so a plugin relaying a burst of alerts isn't blocked sending them to
+// PagerDuty one at a time -- a single background goroutine drains the
+// queue and sends them serially, backed by the same retry policy as a
+// synchronous Send. done, if non-nil, is called with the result once it's
+// actually sent; it runs on the queue's goroutine, so it should return
+// quickly (e.g. posting a follow-up chat message, not another blocking
+// call).
+func QueueTriggerEvent(token, routingKey, summary, source, class string, done func(EventResult, error)) {
+	eventQueue() <- queuedEvent{
+		send: func() (EventResult, error) { return TriggerEvent(token, routingKey, summary, source, class) },
+		done: done,
+	}
+}
@@ -22,8 +22,47 @@ import (
 	"log"
 	"net/http"
 	"strings"
+
+	"github.com/netflix/hal-9001/hal"
 )
 
+// SecretMissingError is returned by getSecrets when PagerdutyTokenKey isn't
+// configured in hal.Secrets().
+type SecretMissingError struct{}
+
+func (e SecretMissingError) Error() string {
+	return fmt.Sprintf("the %q secret must be set. Contact the bot admin.", PagerdutyTokenKey)
+}
+
+// SecretMissingFromEmailError is returned by ack/resolve when
+// PagerdutyFromEmailKey isn't configured in hal.Secrets(). Pagerduty's v2
+// REST API requires a "From" email on every write made on a user's behalf.
+type SecretMissingFromEmailError struct{}
+
+func (e SecretMissingFromEmailError) Error() string {
+	return fmt.Sprintf("the %q secret must be set. Contact the bot admin.", PagerdutyFromEmailKey)
+}
+
+// getSecrets fetches the Pagerduty auth token to use, preferring creds'
+// PagerdutyTokenKey entry (a per-user token attached to the triggering
+// Evt, see hal.Credentials and "!pagerduty login") over the shared
+// bot-wide token in hal.Secrets(). A nil creds is fine -- Credentials.Get
+// on a nil map always returns "" -- so every existing caller that has no
+// Evt in scope (background pollers, webhook ingestion) keeps working
+// unchanged by passing nil.
+func getSecrets(creds hal.Credentials) (token string, err error) {
+	if tok := creds.Get(PagerdutyTokenKey); tok != "" {
+		return tok, nil
+	}
+
+	token = hal.Secrets().Get(PagerdutyTokenKey)
+	if token == "" {
+		return "", SecretMissingError{}
+	}
+
+	return token, nil
+}
+
 // AuthenticatedGet authenticates with the provided token and GETs the url
 // with the query sent in the body as "query=%s", query.
 func authenticatedGet(url, token string, query string) (*http.Response, error) {
@@ -46,13 +85,12 @@ func authenticatedGet(url, token string, query string) (*http.Response, error) {
 }
 
 // AuthenticatedPost authenticates with the provided token and posts the
-// provided body.
-func authenticatedPost(token string, body []byte) (*http.Response, error) {
+// provided body to url.
+func authenticatedPost(token, url string, body []byte) (*http.Response, error) {
 	tokenHdr := fmt.Sprintf("Token token=%s", token)
 	buf := bytes.NewBuffer(body)
 
-	// TODO: make Endpoint a url parameter
-	req, err := http.NewRequest("POST", Endpoint, buf)
+	req, err := http.NewRequest("POST", url, buf)
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +101,51 @@ func authenticatedPost(token string, body []byte) (*http.Response, error) {
 	return client.Do(req)
 }
 
+// V2ApiEndpoint is the base url for Pagerduty's REST API v2, which is
+// account-wide rather than subdomain-scoped like the v1 endpoints in
+// pagedUrl.
+const V2ApiEndpoint = `https://api.pagerduty.com`
+
+func v2ApiUrl(path string) string {
+	return V2ApiEndpoint + path
+}
+
+// authenticatedGetV2 is authenticatedGet against the v2 REST API: same
+// token scheme, but the query is sent as a real querystring instead of a
+// request body, since v2 endpoints are plain GETs.
+func authenticatedGetV2(path, token, query string) (*http.Response, error) {
+	url := v2ApiUrl(path)
+	if query != "" {
+		url = fmt.Sprintf("%s?%s", url, query)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Token token=%s", token))
+
+	client := &http.Client{}
+	return client.Do(req)
+}
+
+// authenticatedPutV2 PUTs body to the v2 REST API. fromEmail is required by
+// Pagerduty for any write made on a user's behalf (e.g. ack/resolve) and is
+// sent as the "From" header.
+func authenticatedPutV2(path, token, fromEmail string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("PUT", v2ApiUrl(path), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Token token=%s", token))
+	req.Header.Add("From", fromEmail)
+
+	client := &http.Client{}
+	return client.Do(req)
+}
+
 func pagedUrl(path, domain string, offset, limit int) string {
 	url := fmt.Sprintf("https://%s.pagerduty.com%s", domain, path)
 
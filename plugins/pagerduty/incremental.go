@@ -0,0 +1,103 @@
+package pagerduty
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// condCacheKeyPrefix namespaces the kv keys this file stores ETag/
+// Last-Modified values under so they don't collide with anything else
+// using hal.SetKV/GetKV.
+const condCacheKeyPrefix = "pagerduty.ingest-cache."
+
+// condCacheTtl is how long an ETag/Last-Modified value is trusted before
+// it's considered stale and a full GET is forced again, as a safety net
+// against permanently skipping an endpoint that silently stopped sending
+// validators.
+const condCacheTtl = 7 * 24 * time.Hour
+
+func etagKey(endpoint string) string         { return condCacheKeyPrefix + endpoint + ".etag" }
+func lastModifiedKey(endpoint string) string { return condCacheKeyPrefix + endpoint + ".last-modified" }
+
+// authenticatedGetIncremental is authenticatedGet plus conditional request
+// headers (If-None-Match / If-Modified-Since) built from the last
+// successful fetch of this endpoint. notModified is true when the server
+// replied 304, in which case resp is nil and callers should skip
+// re-ingesting that endpoint's data this pass.
+func authenticatedGetIncremental(endpoint, url, token, query string) (resp *http.Response, notModified bool, err error) {
+	tokenHdr := fmt.Sprintf("Token token=%s", token)
+
+	buf := bytes.NewBuffer([]byte{})
+	if query != "" {
+		fmt.Fprintf(buf, "query=%s", query)
+	}
+
+	req, err := http.NewRequest("GET", url, buf)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", tokenHdr)
+
+	if etag, cerr := hal.GetKV(etagKey(endpoint)); cerr == nil && etag != "" {
+		req.Header.Add("If-None-Match", etag)
+	}
+	if lm, cerr := hal.GetKV(lastModifiedKey(endpoint)); cerr == nil && lm != "" {
+		req.Header.Add("If-Modified-Since", lm)
+	}
+
+	client := &http.Client{}
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, true, nil
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if err := hal.SetKV(etagKey(endpoint), etag, condCacheTtl); err != nil {
+			log.Printf("pagerduty: failed to cache ETag for %q: %s", endpoint, err)
+		}
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		if err := hal.SetKV(lastModifiedKey(endpoint), lm, condCacheTtl); err != nil {
+			log.Printf("pagerduty: failed to cache Last-Modified for %q: %s", endpoint, err)
+		}
+	}
+
+	return resp, false, nil
+}
+
+// gcTombstones removes directory nodes of typ that weren't seen in the
+// current ingest pass (seenIds), meaning they no longer exist upstream.
+func gcTombstones(typ string, seenIds map[string]bool) {
+	for _, node := range hal.Directory().NodesOfType(typ) {
+		if !seenIds[node.Id] {
+			log.Printf("pagerduty: tombstoning %s %q, no longer present upstream", typ, node.Id)
+			hal.Directory().RemoveNode(node.Id, typ)
+		}
+	}
+}
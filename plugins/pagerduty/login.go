@@ -0,0 +1,249 @@
+package pagerduty
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+const PagerdutyCmdUsage = `!pagerduty login
+
+DMs you a Pagerduty OAuth URL. Once you authorize it, !oncall and !page
+ack/resolve use your own Pagerduty identity instead of the bot's shared
+token, so schedules scoped to your account are visible and actions you
+take are attributed to you on Pagerduty's side.
+`
+
+// LoginCallbackPath is the HTTP path Register() wires ServeLoginCallback
+// up to. PagerdutyOAuthRedirectURLKey must point at
+// "<public base url>" + LoginCallbackPath.
+const LoginCallbackPath = "/v1/pagerduty/oauth/callback"
+
+// loginStateTTL bounds how long a "!pagerduty login" state token stays
+// valid -- long enough for a human to click through the OAuth consent
+// screen, short enough that an abandoned login can't be completed later
+// by whoever happens to guess or intercept the state.
+const loginStateTTL = 10 * time.Minute
+
+// pendingLogin is stashed under pendingLoginKey(state) between handleLogin
+// issuing the authorize URL and ServeLoginCallback completing the
+// exchange, so the callback (which only gets "code" and "state" back from
+// Pagerduty) knows which broker/user/room to attribute the resulting
+// token to.
+type pendingLogin struct {
+	Broker string `json:"broker"`
+	UserId string `json:"user_id"`
+	RoomId string `json:"room_id"`
+}
+
+func pendingLoginKey(state string) string {
+	return "pagerduty-login-pending-" + state
+}
+
+// userTokenKey is the hal.Secrets() key a broker/user's own Pagerduty
+// token is stored under once "!pagerduty login" completes. See
+// oncallInstanceCredentials, which builds the hal.Credentials an Evt
+// carries from whatever's stored here.
+func userTokenKey(broker, userId string) string {
+	return fmt.Sprintf("pagerduty.user-token.%s.%s", broker, userId)
+}
+
+func pagerdutyCmd(evt hal.Evt) {
+	argv := evt.BodyAsArgv()
+
+	if len(argv) < 2 || argv[1] != "login" {
+		evt.Reply(PagerdutyCmdUsage)
+		return
+	}
+
+	handleLogin(&evt)
+}
+
+// handleLogin starts the OAuth flow: it mints a one-time state token,
+// remembers who to attribute the eventual token to, and DMs the user the
+// authorize URL (falling back to a same-room warning on brokers that
+// don't implement hal.DMSender, since the URL shouldn't be posted
+// somewhere shared).
+func handleLogin(evt *hal.Evt) {
+	clientId := hal.Secrets().Get(PagerdutyOAuthClientIdKey)
+	redirectURL := hal.Secrets().Get(PagerdutyOAuthRedirectURLKey)
+	if clientId == "" || redirectURL == "" {
+		evt.Replyf("pagerduty: %q and %q must be set up in hal.Secrets before !pagerduty login will work. Contact the bot admin.",
+			PagerdutyOAuthClientIdKey, PagerdutyOAuthRedirectURLKey)
+		return
+	}
+
+	state, err := newLoginState()
+	if err != nil {
+		evt.Replyf("pagerduty: failed to start login: %s", err)
+		return
+	}
+
+	pending := pendingLogin{Broker: evt.BrokerName(), UserId: evt.UserId, RoomId: evt.RoomId}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		evt.Replyf("pagerduty: failed to start login: %s", err)
+		return
+	}
+
+	if err := hal.SetKV(pendingLoginKey(state), string(data), loginStateTTL); err != nil {
+		evt.Replyf("pagerduty: failed to start login: %s", err)
+		return
+	}
+
+	authorizeURL := fmt.Sprintf(
+		"https://app.pagerduty.com/oauth/authorize?client_id=%s&redirect_uri=%s&response_type=code&state=%s",
+		url.QueryEscape(clientId), url.QueryEscape(redirectURL), url.QueryEscape(state))
+
+	out := evt.Clone()
+	out.Body = fmt.Sprintf("Click to link your Pagerduty account: %s\n(expires in %s)", authorizeURL, loginStateTTL.String())
+
+	if dm, ok := evt.Brokers.Last().(hal.DMSender); ok {
+		dm.SendDM(out)
+		evt.Reply("Check your DMs for a Pagerduty login link.")
+		return
+	}
+
+	evt.Replyf("This broker can't send DMs yet, so here's your login link -- "+
+		"anyone in this room could use it before you do: %s", authorizeURL)
+}
+
+// newLoginState returns a random, URL-safe state token.
+func newLoginState() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// ServeLoginCallback is the HTTP handler Pagerduty's OAuth authorize
+// screen redirects the user's browser back to. It exchanges the
+// authorization code for an access token and stores it under
+// userTokenKey(broker, userId) for the pendingLogin that state was issued
+// to.
+func ServeLoginCallback(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	state := r.URL.Query().Get("state")
+
+	if code == "" || state == "" {
+		http.Error(w, "missing code or state", http.StatusBadRequest)
+		return
+	}
+
+	data, err := hal.GetKV(pendingLoginKey(state))
+	if err != nil {
+		http.Error(w, "login request expired or is unknown", http.StatusBadRequest)
+		return
+	}
+	hal.DeleteKV(pendingLoginKey(state))
+
+	var pending pendingLogin
+	if err := json.Unmarshal([]byte(data), &pending); err != nil {
+		log.Printf("pagerduty: corrupt pending login for state %q: %s", state, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := exchangeLoginCode(code)
+	if err != nil {
+		log.Printf("pagerduty: oauth code exchange failed for %s/%s: %s", pending.Broker, pending.UserId, err)
+		http.Error(w, "failed to exchange code with Pagerduty", http.StatusBadGateway)
+		return
+	}
+
+	hal.Secrets().Put(userTokenKey(pending.Broker, pending.UserId), token)
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Pagerduty account linked. You can close this window and return to chat.")
+}
+
+// exchangeLoginCode trades an OAuth2 authorization code for an access
+// token using the client id/secret/redirect URL configured in
+// hal.Secrets().
+func exchangeLoginCode(code string) (string, error) {
+	clientId := hal.Secrets().Get(PagerdutyOAuthClientIdKey)
+	clientSecret := hal.Secrets().Get(PagerdutyOAuthClientSecretKey)
+	redirectURL := hal.Secrets().Get(PagerdutyOAuthRedirectURLKey)
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientId},
+		"client_secret": {clientSecret},
+		"redirect_uri":  {redirectURL},
+		"code":          {code},
+	}
+
+	resp, err := http.PostForm("https://app.pagerduty.com/oauth/token", form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("pagerduty oauth token endpoint returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var parsed struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", err
+	}
+
+	if parsed.AccessToken == "" {
+		return "", fmt.Errorf("pagerduty oauth token endpoint returned no access_token")
+	}
+
+	return parsed.AccessToken, nil
+}
+
+// credentialsFor resolves the hal.Credentials to use for evt: whatever a
+// broker already attached to it (none do yet -- wiring a generic per-
+// plugin credential hook into every broker's event loop is a bigger
+// change than this command makes), falling back to the token
+// "!pagerduty login" stored for evt's broker+user. Either way, a zero
+// value (nil) correctly falls through getSecrets to the shared bot-wide
+// token in hal.Secrets().
+func credentialsFor(evt hal.Evt) hal.Credentials {
+	if evt.Credentials != nil {
+		return evt.Credentials
+	}
+
+	token := hal.Secrets().Get(userTokenKey(evt.BrokerName(), evt.UserId))
+	if token == "" {
+		return nil
+	}
+
+	return hal.Credentials{PagerdutyTokenKey: token}
+}
@@ -54,7 +54,7 @@ func oncall(msg hal.Evt) {
 	}
 
 	// make sure the pagerduty token is setup in hal.Secrets
-	token, err := getSecrets()
+	token, err := getSecrets(credentialsFor(msg))
 	if err != nil || token == "" {
 		msg.Replyf("pagerduty: %s is not set up in hal.Secrets. Cannot continue.", PagerdutyTokenKey)
 		return
@@ -69,13 +69,19 @@ func oncall(msg hal.Evt) {
 		age := int(hal.Cache().Age(CacheKey).Seconds())
 		next := time.Time{}
 		status := "broken"
+		leader := "n/a"
 		pf := hal.GetPeriodicFunc(cacheFuncName(msg.RoomId))
 		if pf != nil {
 			next = pf.Last().Add(pf.Interval)
 			status = pf.Status()
+			if pf.IsLeader() {
+				leader = "yes"
+			} else {
+				leader = "no"
+			}
 		}
-		msg.Replyf("The cache is %d seconds old. Auto-update is %s and its next update is at %s.",
-			age, status, next.Format(time.UnixDate))
+		msg.Replyf("The cache is %d seconds old. Auto-update is %s and its next update is at %s. This node is the leader for the cache job: %s.",
+			age, status, next.Format(time.UnixDate), leader)
 		return
 	}
 
@@ -98,7 +104,7 @@ func oncall(msg hal.Evt) {
 	// search over all policies looking for matching policy name, escalation
 	// rule name, or service name
 	matches := make([]Oncall, 0)
-	oncalls := getOncallCache(token, false)
+	oncalls := getOncallCache("pagerduty", token, credentialsFor(msg), false)
 	var exactMatchFound bool
 
 	for _, oncall := range oncalls {
@@ -127,12 +133,28 @@ func oncall(msg hal.Evt) {
 	msg.Reply(reply)
 }
 
-func getOncallCache(token string, forceUpdate bool) []Oncall {
+// getOncallCache returns the cached oncall list for token, keyed so a
+// scoped token (creds carrying a per-user override, see hal.Credentials)
+// never reads or writes the shared bot-wide entry under CacheKey -- one
+// user's scoped view can't leak into, or be overwritten by, another's.
+// It's also keyed by provider so that if another paging.Provider grows
+// its own bulk "list everything" call someday, its cache entries won't
+// collide with PagerDuty's -- today this is always called with
+// "pagerduty", since GetOncalls is PagerDuty-specific.
+func getOncallCache(provider, token string, creds hal.Credentials, forceUpdate bool) []Oncall {
+	cacheKey := CacheKey
+	if provider != "pagerduty" {
+		cacheKey = cacheKey + "." + provider
+	}
+	if fp := creds.Fingerprint(); fp != "" {
+		cacheKey = cacheKey + "." + fp
+	}
+
 	oncalls := []Oncall{}
 
 	// see if there's a copy cached
-	if hal.Cache().Exists(CacheKey) {
-		ttl, err := hal.Cache().Get(CacheKey, &oncalls)
+	if hal.Cache().Exists(cacheKey) {
+		ttl, err := hal.Cache().Get(cacheKey, &oncalls)
 		if err != nil {
 			log.Printf("Error retreiving oncalls from the Hal TTL cache: %s", err)
 			oncalls = []Oncall{}
@@ -146,22 +168,34 @@ func getOncallCache(token string, forceUpdate bool) []Oncall {
 		return oncalls
 	}
 
-	// get all of the defined policies
-	var err error
-	oncalls, err = GetOncalls(token, nil)
+	// get all of the defined policies, retrying through transient failures
+	// so a brief API blip doesn't blow away a perfectly good cache
+	err := retryWithTimeout("", func() error {
+		var getErr error
+		oncalls, getErr = GetOncalls(token, nil)
+		return getErr
+	})
 	if err != nil {
 		log.Printf("Returning empty list. REST call to Pagerduty failed: %s", err)
 		return []Oncall{}
 	}
 
 	// always update the cache regardless of ttl
-	hal.Cache().Set(CacheKey, &oncalls, cacheExpire)
+	hal.Cache().Set(cacheKey, &oncalls, cacheExpire)
 
 	return oncalls
 }
 
 func oncallInit(i *hal.Instance) {
-	cacheFreq := hal.GetPref("", "", i.RoomId, "pagerduty", "cache-update-frequency", DefaultCacheInterval)
+	// once a webhook secret is configured, targeted invalidation from
+	// webhook.go keeps the cache fresh in near-real-time, so the periodic
+	// poll only needs to run as an occasional fallback reconciler
+	cacheDefault := DefaultCacheInterval
+	if hal.Secrets().Get(PagerdutyWebhookSecretKey) != "" {
+		cacheDefault = FallbackCacheInterval
+	}
+
+	cacheFreq := hal.GetPref("", "", i.RoomId, "pagerduty", "cache-update-frequency", cacheDefault)
 	cd, err := time.ParseDuration(cacheFreq.Value)
 	if err != nil {
 		log.Panicf("BUG: could not parse cache update frequency preference: %q", cacheFreq.Value)
@@ -173,16 +207,17 @@ func oncallInit(i *hal.Instance) {
 		log.Panicf("BUG: could not parse topic update frequency preference: %q", topicFreq.Value)
 	}
 
-	token, err := getSecrets()
+	token, err := getSecrets(nil)
 	if err != nil || token == "" {
 		return // getSecrets will log the error
 	}
 
 	go func() {
 		pf := hal.PeriodicFunc{
-			Name:     cacheFuncName(i.RoomId),
-			Interval: cd,
-			Function: func() { cacheNow(token, i.RoomId) },
+			Name:       cacheFuncName(i.RoomId),
+			Interval:   cd,
+			Function:   func() { cacheNow(token, i.RoomId) },
+			LeaderOnly: true,
 		}
 
 		pf.Register()
@@ -191,9 +226,10 @@ func oncallInit(i *hal.Instance) {
 
 	go func() {
 		pf := hal.PeriodicFunc{
-			Name:     topicFuncName(i.RoomId),
-			Interval: td,
-			Function: func() { topicUpdater(token, i.RoomId, i.Broker.Name()) },
+			Name:       topicFuncName(i.RoomId),
+			Interval:   td,
+			Function:   func() { topicUpdater(token, i.RoomId, i.Broker.Name()) },
+			LeaderOnly: true,
 		}
 
 		pf.Register()
@@ -204,7 +240,7 @@ func oncallInit(i *hal.Instance) {
 }
 
 func cacheNow(token, roomId string) {
-	getOncallCache(token, true)
+	getOncallCache("pagerduty", token, nil, true)
 }
 
 // topicUpdater runs periodically to update the topic in the room
@@ -230,7 +266,12 @@ func topicUpdater(token, roomId, brokerName string) {
 		"schedule_ids[]": pref.Value,
 	}
 
-	oncalls, err := GetOncalls(token, params)
+	var oncalls []Oncall
+	err := retryWithTimeout(roomId, func() error {
+		var getErr error
+		oncalls, getErr = GetOncalls(token, params)
+		return getErr
+	})
 	if err != nil {
 		log.Printf("Failed to fetch oncalls for schedule id %q: %s", pref.Value, err)
 		return
@@ -17,29 +17,70 @@ package pagerduty
  */
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/netflix/hal-9001/hal"
+	"github.com/netflix/hal-9001/plugins/paging"
 )
 
 const PageUsage = `!page <alias> [optional message]
 
-Send an alert via Pagerduty with an optional custom message.
+Send an alert via Pagerduty (or another configured paging provider) with an optional custom message.
 
 Aliases that have a comma-separated list of service keys will result in one page going to each service key when the alias is paged.
 
+A key may be qualified with a provider name, e.g. "!page add core opsgenie:XXXXXX", to send that key through a different provider than the paging.default-provider pref (pagerduty, if unset).
+
 !page core
 !page core <message>
 !pagecore HELP ME YOU ARE MY ONLY HOPE
 
 !page add <alias> <service key>
 !page add <alias> <service key>,<service_key>,<service_key>,...
+!page schedule <alias> <schedule id>
+!page oncall <alias>
+!page ack <incident id>
+!page resolve <incident id>
 !page rm <alias>
 !page list
 `
 
+// aliasConfig is the JSON blob stored under aliasKey(alias). It's decoded
+// by decodeAliasConfig, which also understands the legacy format (a bare
+// comma-separated integration key list with no JSON framing) so existing
+// aliases keep working after this upgrade.
+type aliasConfig struct {
+	IntegrationKeys []string `json:"integration_keys,omitempty"`
+	ScheduleId      string   `json:"schedule_id,omitempty"`
+}
+
+// decodeAliasConfig parses the value stored under an alias's pref key. A
+// value that doesn't parse as aliasConfig JSON is treated as the legacy
+// raw comma-separated integration key list.
+func decodeAliasConfig(value string) aliasConfig {
+	cfg := aliasConfig{}
+	if err := json.Unmarshal([]byte(value), &cfg); err == nil {
+		return cfg
+	}
+
+	if value != "" {
+		cfg.IntegrationKeys = strings.Split(value, ",")
+	}
+
+	return cfg
+}
+
+func (cfg aliasConfig) encode() (string, error) {
+	js, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	return string(js), nil
+}
+
 const PageDefaultMessage = `your presence is requested in the chat room`
 
 func page(msg hal.Evt) {
@@ -63,6 +104,14 @@ func page(msg hal.Evt) {
 		msg.Reply(PageUsage)
 	case "add":
 		addAlias(msg, parts[2:])
+	case "schedule":
+		scheduleAlias(msg, parts[2:])
+	case "oncall":
+		oncallAlias(msg, parts[2:])
+	case "ack":
+		ackIncident(msg, parts[2:])
+	case "resolve":
+		resolveIncident(msg, parts[2:])
 	case "rm":
 		rmAlias(msg, parts[2:])
 	case "list":
@@ -84,11 +133,11 @@ func pageAlias(evt hal.Evt, parts []string) {
 		pageMessage = msgPref.Value
 	}
 
-	// map alias name to PD token via prefs
-	key := aliasKey(parts[0])
+	// map alias name to its provider-qualified key(s) via prefs
+	prefKey := aliasKey(parts[0])
 	// make sure to filter on at least room id since FindKey might find duplicate
 	// aliases from other rooms
-	pref := evt.AsPref().FindKey(key).Room(evt.RoomId).One()
+	pref := evt.AsPref().FindKey(prefKey).Room(evt.RoomId).One()
 
 	// make sure the query succeeded
 	if !pref.Success {
@@ -106,25 +155,29 @@ func pageAlias(evt hal.Evt, parts []string) {
 		return
 	}
 
-	// make sure the hal secrets are set up
-	token, err := getSecrets()
-	if err != nil {
-		evt.Error(err)
+	cfg := decodeAliasConfig(pref.Value)
+	if len(cfg.IntegrationKeys) == 0 {
+		evt.Replyf("Alias %q has no integration keys configured. Try !page add %s <pagerduty integration key>", parts[0], parts[0])
 		return
 	}
 
-	// the value can be a list of tokens, separated by commas
-	for _, svckey := range strings.Split(pref.Value, ",") {
-		// create the event and send it
-		pde := NewTrigger(svckey, pageMessage) // in ./pagerduty.go
-		resp, err := pde.Send(token)
+	defaultProvider := paging.DefaultName(evt)
+
+	for _, rawKey := range cfg.IntegrationKeys {
+		providerName, key := paging.ParseKey(rawKey, defaultProvider)
+
+		provider, err := paging.New(providerName, evt)
 		if err != nil {
-			evt.Replyf("Error while communicating with Pagerduty. %d %s", resp.StatusCode, resp.Message)
+			evt.Replyf("Error setting up paging provider %q: %s", providerName, err)
+			return
+		}
+
+		if err := provider.Page(key, pageMessage); err != nil {
+			evt.Replyf("Error while paging via %s: %s", providerName, err)
 			return
 		}
 
-		log.Printf("Pagerduty response message: %s\n", resp.Message)
-		evt.Replyf("Message sent to %s using integration key %s.", parts[0], pref.Value)
+		evt.Replyf("Message sent to %s using %s.", parts[0], providerName)
 	}
 }
 
@@ -137,19 +190,177 @@ func addAlias(msg hal.Evt, parts []string) {
 		parts = []string{parts[0], keys}
 	}
 
-	pref := msg.AsPref()
-	pref.User = "" // filled in by AsPref and unwanted
-	pref.Key = aliasKey(parts[0])
-	pref.Value = parts[1]
+	cfg := loadAliasConfig(msg, parts[0])
+	cfg.IntegrationKeys = strings.Split(parts[1], ",")
 
-	err := pref.Set()
-	if err != nil {
+	if err := saveAliasConfig(msg, parts[0], cfg); err != nil {
 		msg.Replyf("Write failed: %s", err)
 	} else {
 		msg.Replyf("Added alias: %q -> %q", parts[0], parts[1])
 	}
 }
 
+// scheduleAlias binds a Pagerduty schedule id to alias without disturbing
+// any integration keys already configured for it, so !page <alias> keeps
+// paging existing keys in addition to whoever !page oncall resolves.
+func scheduleAlias(msg hal.Evt, parts []string) {
+	if len(parts) != 2 {
+		msg.Replyf("!page schedule requires 2 arguments, e.g. !page schedule core PXXXXXX")
+		return
+	}
+
+	alias, scheduleId := parts[0], parts[1]
+
+	cfg := loadAliasConfig(msg, alias)
+	cfg.ScheduleId = scheduleId
+
+	if err := saveAliasConfig(msg, alias, cfg); err != nil {
+		msg.Replyf("Write failed: %s", err)
+	} else {
+		msg.Replyf("Alias %q now follows schedule %q", alias, scheduleId)
+	}
+}
+
+// oncallAlias looks up who's currently on call for alias's bound schedule.
+// If the alias also has integration keys configured, it pages them with a
+// message naming the on-call user(s); otherwise it just replies with the
+// name(s).
+func oncallAlias(evt hal.Evt, parts []string) {
+	if len(parts) != 1 {
+		evt.Replyf("!page oncall requires 1 argument, e.g. !page oncall core")
+		return
+	}
+
+	alias := parts[0]
+	cfg := loadAliasConfig(evt, alias)
+	if cfg.ScheduleId == "" {
+		evt.Replyf("Alias %q has no schedule configured. Try !page schedule %s <schedule id>", alias, alias)
+		return
+	}
+
+	defaultProvider := paging.DefaultName(evt)
+
+	scheduleProviderName, scheduleId := paging.ParseKey(cfg.ScheduleId, defaultProvider)
+	scheduleProvider, err := paging.New(scheduleProviderName, evt)
+	if err != nil {
+		evt.Replyf("Error setting up paging provider %q: %s", scheduleProviderName, err)
+		return
+	}
+
+	assignments, err := scheduleProvider.Oncall(scheduleId)
+	if err != nil {
+		evt.Error(err)
+		return
+	}
+
+	if len(assignments) == 0 {
+		evt.Replyf("Nobody appears to be on call on schedule %q right now.", scheduleId)
+		return
+	}
+
+	names := make([]string, len(assignments))
+	for i, a := range assignments {
+		names[i] = a.Name
+	}
+
+	if len(cfg.IntegrationKeys) == 0 {
+		evt.Replyf("%s is on call for %q.", strings.Join(names, ", "), alias)
+		return
+	}
+
+	message := fmt.Sprintf("%s: %s is on call", alias, strings.Join(names, ", "))
+	for _, rawKey := range cfg.IntegrationKeys {
+		providerName, key := paging.ParseKey(rawKey, defaultProvider)
+
+		provider, err := paging.New(providerName, evt)
+		if err != nil {
+			evt.Replyf("Error setting up paging provider %q: %s", providerName, err)
+			return
+		}
+
+		if err := provider.Page(key, message); err != nil {
+			evt.Replyf("Error while paging via %s: %s", providerName, err)
+			return
+		}
+	}
+
+	evt.Replyf("Paged %s (on call for %q).", strings.Join(names, ", "), alias)
+}
+
+// ackIncident implements !page ack <incident id>.
+func ackIncident(evt hal.Evt, parts []string) {
+	incident, err := updateIncidentFromChat(evt, parts, AckIncident)
+	if err != nil {
+		return
+	}
+
+	evt.Replyf("Acknowledged incident %s (%s).", incident.Id, incident.Title)
+}
+
+// resolveIncident implements !page resolve <incident id>.
+func resolveIncident(evt hal.Evt, parts []string) {
+	incident, err := updateIncidentFromChat(evt, parts, ResolveIncident)
+	if err != nil {
+		return
+	}
+
+	evt.Replyf("Resolved incident %s (%s).", incident.Id, incident.Title)
+}
+
+func updateIncidentFromChat(evt hal.Evt, parts []string, update func(token, fromEmail, incidentId string) (*Incident, error)) (*Incident, error) {
+	if len(parts) != 1 {
+		evt.Replyf("requires 1 argument, e.g. !page ack PXXXXXX")
+		return nil, fmt.Errorf("wrong number of arguments")
+	}
+
+	token, err := getSecrets(credentialsFor(evt))
+	if err != nil {
+		evt.Error(err)
+		return nil, err
+	}
+
+	fromEmail := hal.Secrets().Get(PagerdutyFromEmailKey)
+	if fromEmail == "" {
+		err := SecretMissingFromEmailError{}
+		evt.Error(err)
+		return nil, err
+	}
+
+	incident, err := update(token, fromEmail, parts[0])
+	if err != nil {
+		evt.Error(err)
+		return nil, err
+	}
+
+	return incident, nil
+}
+
+// loadAliasConfig loads and decodes the config currently stored for alias,
+// or a zero-value aliasConfig if it isn't configured yet.
+func loadAliasConfig(evt hal.Evt, alias string) aliasConfig {
+	pref := evt.AsPref().FindKey(aliasKey(alias)).Room(evt.RoomId).One()
+	if !pref.Success || pref.Value == "" {
+		return aliasConfig{}
+	}
+
+	return decodeAliasConfig(pref.Value)
+}
+
+// saveAliasConfig JSON-encodes cfg and stores it under aliasKey(alias).
+func saveAliasConfig(evt hal.Evt, alias string, cfg aliasConfig) error {
+	value, err := cfg.encode()
+	if err != nil {
+		return err
+	}
+
+	pref := evt.AsPref()
+	pref.User = "" // filled in by AsPref and unwanted
+	pref.Key = aliasKey(alias)
+	pref.Value = value
+
+	return pref.Set()
+}
+
 func rmAlias(msg hal.Evt, parts []string) {
 	if len(parts) != 1 {
 		msg.Replyf("!page rm requires 1 argument, e.g. !page rm sysadmins")
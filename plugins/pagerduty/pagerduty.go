@@ -97,7 +97,7 @@ func (e *Event) Send(token string) (*Response, error) {
 		return e.respond("error", err.Error()), err
 	}
 
-	resp, err := authenticatedPost(token, js)
+	resp, err := authenticatedPost(token, Endpoint, js)
 	if err != nil {
 		return e.respond("error", err.Error()), err
 	}
@@ -119,7 +119,9 @@ func (e *Event) Send(token string) (*Response, error) {
 		return &out, nil
 	} else {
 		msg := fmt.Sprintf("Server returned %d: %q", resp, string(body))
-		return e.respond("error", msg), errors.New(msg)
+		out := e.respond("error", msg)
+		out.StatusCode = resp.StatusCode
+		return out, retryAfterError(resp, errors.New(msg))
 	}
 }
 
@@ -157,3 +159,19 @@ func (c *ContextLink) GetType() string {
 func (c *ContextImage) GetType() string {
 	return "image"
 }
+
+// Send posts e to whichever Pagerduty Events API endpoint matches its
+// concrete type - the legacy v1 endpoint for *Event (ServiceKey/EventType),
+// the v2 endpoint for *EventBody (RoutingKey/DedupKey/event_action) - so
+// callers that build an event generically don't have to branch on API
+// version themselves.
+func Send(token string, e interface{}) (interface{}, error) {
+	switch v := e.(type) {
+	case *Event:
+		return v.Send(token)
+	case *EventBody:
+		return v.Send(token)
+	default:
+		return nil, fmt.Errorf("pagerduty: Send doesn't know how to send a %T", e)
+	}
+}
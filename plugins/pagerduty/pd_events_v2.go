@@ -21,12 +21,20 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"time"
+
+	"github.com/netflix/hal-9001/hal"
 )
 
 // https://v2.developer.pagerduty.com/docs/events-api-v2
 const V2EventEndpoint = `https://events.pagerduty.com/v2/enqueue`
 
+// https://support.pagerduty.com/docs/change-events
+const V2ChangeEventEndpoint = `https://events.pagerduty.com/v2/change/enqueue`
+
+var validSeverities = map[string]bool{"info": true, "warning": true, "error": true, "critical": true}
+
 // data structures for the PagerDuty Common Event Format
 type EventPayload struct {
 	Summary   string            `json:"summary"`             // high-level text
@@ -45,12 +53,18 @@ type EventImage struct {
 	Alt  string `json:"alt"`
 }
 
+type EventLink struct {
+	Href string `json:"href"`
+	Text string `json:"text,omitempty"`
+}
+
 type EventBody struct {
 	RoutingKey string       `json:"routing_key"`
 	Action     string       `json:"event_action"`        // e.g. "trigger"
 	DedupKey   string       `json:"dedup_key,omitempty"` // arbitrary key for server-side dedup
 	Payload    EventPayload `json:"payload"`
 	Images     []EventImage `json:"images"`
+	Links      []EventLink  `json:"links"`
 	Client     string       `json:"client"`     // e.g. "Scorebot/#core"
 	ClientUrl  string       `json:"client_url"` // e.g. "https://scorebot.prod.netflix.net"
 }
@@ -77,15 +91,66 @@ func NewV2Event(routingKey string) *EventBody {
 			Custom:    details,
 		},
 		Images: []EventImage{},
+		Links:  []EventLink{},
 	}
 
 	return &out
 }
 
-func (eb *EventBody) Send(token string) (EventResult, error) {
-	out := EventResult{Status: "failed"}
+// NewV2Trigger returns a v2 "trigger" event. summary and source fill in
+// the required EventPayload fields; DedupKey is left empty so PagerDuty
+// assigns one, which the caller should read back from EventResult and
+// pass to NewV2Ack/NewV2Resolve to manage the resulting incident.
+func NewV2Trigger(routingKey, summary, source string) *EventBody {
+	eb := NewV2Event(routingKey)
+	eb.Payload.Summary = summary
+	eb.Payload.Source = source
+	return eb
+}
 
-	err := eb.checkFields()
+// NewV2Ack returns a v2 "acknowledge" event for the incident identified by
+// dedupKey.
+func NewV2Ack(routingKey, dedupKey string) *EventBody {
+	eb := NewV2Event(routingKey)
+	eb.Action = "acknowledge"
+	eb.DedupKey = dedupKey
+	return eb
+}
+
+// NewV2Resolve returns a v2 "resolve" event for the incident identified by
+// dedupKey.
+func NewV2Resolve(routingKey, dedupKey string) *EventBody {
+	eb := NewV2Event(routingKey)
+	eb.Action = "resolve"
+	eb.DedupKey = dedupKey
+	return eb
+}
+
+// Send POSTs eb to the v2 events endpoint, retrying 429/5xx responses with
+// retryWithTimeout's exponential-backoff-with-jitter policy (honoring
+// Retry-After exactly when the server sends one) and auditing the outcome.
+// A 202 (event accepted but not guaranteed to open/update an incident) is
+// reported back as an error so callers get a strong signal to act on,
+// even though out.Status/DedupKey are still populated from the response.
+func (eb *EventBody) Send(token string) (out EventResult, err error) {
+	out = EventResult{Status: "failed"}
+
+	defer func() {
+		severity := hal.SeverityInfo
+		if err != nil {
+			severity = hal.SeverityError
+		}
+
+		hal.Audit(hal.AuditEntry{
+			Source:        "pagerduty",
+			Action:        eb.Action,
+			CorrelationId: eb.DedupKey,
+			Outcome:       out.Status,
+			Severity:      severity,
+		})
+	}()
+
+	err = eb.checkFields()
 	if err != nil {
 		return out, err
 	}
@@ -98,55 +163,269 @@ func (eb *EventBody) Send(token string) (EventResult, error) {
 		return out, err
 	}
 
-	resp, err := authenticatedPost(token, V2EventEndpoint, js)
+	var inconclusive error
+
+	err = retryWithTimeout("", func() error {
+		resp, perr := authenticatedPost(token, V2EventEndpoint, js)
+		if perr != nil {
+			return perr
+		}
+		defer resp.Body.Close()
+
+		out.StatusCode = resp.StatusCode
+
+		body, perr := ioutil.ReadAll(resp.Body)
+		if perr != nil {
+			return permanent(perr)
+		}
+
+		switch {
+		case resp.StatusCode == 200:
+			if perr := json.Unmarshal(body, &out); perr != nil {
+				msg := fmt.Sprintf("json.Unmarshal failed: %s", perr)
+				out.Status = "failed"
+				out.Message = msg
+				return permanent(errors.New(msg))
+			}
+			return nil
+		case resp.StatusCode == 202:
+			// 202 Accepted means they received the event but it's unclear
+			// whether it will trigger an incident. Stash the error and
+			// return success so the retry loop doesn't keep re-sending it.
+			if perr := json.Unmarshal(body, &out); perr != nil {
+				msg := fmt.Sprintf("json.Unmarshal failed: %s", perr)
+				out.Status = "failed"
+				out.Message = msg
+				return permanent(errors.New(msg))
+			}
+			inconclusive = fmt.Errorf("inconclusive response (202) from service")
+			return nil
+		case resp.StatusCode == 429 || resp.StatusCode >= 500:
+			return retryAfterError(resp, fmt.Errorf("server returned %d: %q", resp.StatusCode, string(body)))
+		default:
+			msg := fmt.Sprintf("server returned %d: %q", resp.StatusCode, string(body))
+			out.Message = msg
+			return permanent(errors.New(msg))
+		}
+	})
+
+	if err == nil && inconclusive != nil {
+		err = inconclusive
+	}
+
+	return out, err
+}
+
+// checkFields validates the fields the Events API v2 requires before
+// bothering to send the request, so callers get an immediate, specific
+// error instead of a round trip to PagerDuty followed by a 400.
+func (eb *EventBody) checkFields() error {
+	if eb.RoutingKey == "" {
+		return errors.New("pagerduty: routing_key is required")
+	}
+
+	switch eb.Action {
+	case "acknowledge", "resolve":
+		if eb.DedupKey == "" {
+			return fmt.Errorf("pagerduty: dedup_key is required for a %q event", eb.Action)
+		}
+	case "trigger":
+		if eb.Payload.Summary == "" {
+			return errors.New("pagerduty: payload.summary is required for a trigger event")
+		}
+		if eb.Payload.Source == "" {
+			return errors.New("pagerduty: payload.source is required for a trigger event")
+		}
+		if !validSeverities[eb.Payload.Severity] {
+			return fmt.Errorf("pagerduty: payload.severity %q is not valid (want info, warning, error, or critical)", eb.Payload.Severity)
+		}
+	default:
+		return fmt.Errorf("pagerduty: unknown event_action %q", eb.Action)
+	}
+
+	return nil
+}
+
+// dedupKeyKV returns the KV key a trigger's dedup_key is stored/looked up
+// under for (routingKey, source, class), so TriggerEvent/AcknowledgeEvent/
+// ResolveEvent can coalesce repeated triggers into one incident and
+// resolve it later without the caller tracking PagerDuty's dedup_key
+// itself. hal.Secrets is for credentials, not coordination state like
+// this, and hal.Prefs/hal.GetPref's room-scoped key/value store doesn't
+// fit a value that's shared across rooms and brokers -- the KV store
+// (GetKV/SetKV) is the closest real fit in this tree.
+func dedupKeyKV(routingKey, source, class string) string {
+	return fmt.Sprintf("pagerduty.dedup_key.%s.%s.%s", routingKey, source, class)
+}
+
+// TriggerEvent sends a v2 "trigger" event for (source, class), reusing the
+// dedup_key on file for this (routingKey, source, class) tuple if one
+// exists so repeated triggers coalesce into the same incident instead of
+// opening a new one every time, and persists whatever dedup_key PagerDuty
+// hands back so a later AcknowledgeEvent/ResolveEvent can find it.
+func TriggerEvent(token, routingKey, summary, source, class string) (EventResult, error) {
+	eb := NewV2Trigger(routingKey, summary, source)
+	eb.Payload.Class = class
+
+	key := dedupKeyKV(routingKey, source, class)
+	if existing, kerr := hal.GetKV(key); kerr == nil && existing != "" {
+		eb.DedupKey = existing
+	}
+
+	out, err := eb.Send(token)
+	if err == nil && out.DedupKey != "" {
+		if kerr := hal.SetKV(key, out.DedupKey, 0); kerr != nil {
+			log.Printf("pagerduty: failed to persist dedup_key for %s/%s/%s: %s", routingKey, source, class, kerr)
+		}
+	}
+
+	return out, err
+}
+
+// AcknowledgeEvent sends a v2 "acknowledge" event for the incident whose
+// dedup_key is on file for (routingKey, source, class) -- see
+// TriggerEvent.
+func AcknowledgeEvent(token, routingKey, source, class string) (EventResult, error) {
+	dedupKey, kerr := hal.GetKV(dedupKeyKV(routingKey, source, class))
+	if kerr != nil || dedupKey == "" {
+		return EventResult{Status: "failed"}, fmt.Errorf("pagerduty: no dedup_key on file for %s/%s/%s -- nothing to acknowledge", routingKey, source, class)
+	}
+
+	return NewV2Ack(routingKey, dedupKey).Send(token)
+}
+
+// ResolveEvent sends a v2 "resolve" event for the incident whose dedup_key
+// is on file for (routingKey, source, class), then forgets that dedup_key
+// so a future TriggerEvent opens a fresh incident instead of reusing the
+// one it just resolved.
+func ResolveEvent(token, routingKey, source, class string) (EventResult, error) {
+	key := dedupKeyKV(routingKey, source, class)
+
+	dedupKey, kerr := hal.GetKV(key)
+	if kerr != nil || dedupKey == "" {
+		return EventResult{Status: "failed"}, fmt.Errorf("pagerduty: no dedup_key on file for %s/%s/%s -- nothing to resolve", routingKey, source, class)
+	}
+
+	out, err := NewV2Resolve(routingKey, dedupKey).Send(token)
+	if err == nil {
+		if kerr := hal.DeleteKV(key); kerr != nil {
+			log.Printf("pagerduty: failed to forget dedup_key for %s/%s/%s: %s", routingKey, source, class, kerr)
+		}
+	}
+
+	return out, err
+}
+
+// ChangeEventPayload is the payload shape for a v2 change event -- a
+// record of a non-incident change (a deploy, a config push, etc.)
+// PagerDuty correlates against alerts for the same service. Unlike
+// EventPayload it has no severity or event_action: changes aren't
+// triggered/acknowledged/resolved, just logged.
+type ChangeEventPayload struct {
+	Summary       string            `json:"summary"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Source        string            `json:"source,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// ChangeEventBody is a v2 change event, sent to V2ChangeEventEndpoint
+// instead of V2EventEndpoint.
+type ChangeEventBody struct {
+	RoutingKey string             `json:"routing_key"`
+	Payload    ChangeEventPayload `json:"payload"`
+	Links      []EventLink        `json:"links,omitempty"`
+}
+
+// NewChangeEvent returns a v2 change event body ready for Send.
+func NewChangeEvent(routingKey, summary, source string) *ChangeEventBody {
+	return &ChangeEventBody{
+		RoutingKey: routingKey,
+		Payload: ChangeEventPayload{
+			Summary:   summary,
+			Source:    source,
+			Timestamp: time.Now().Format(time.RFC3339),
+		},
+	}
+}
+
+func (cb *ChangeEventBody) checkFields() error {
+	if cb.RoutingKey == "" {
+		return errors.New("pagerduty: routing_key is required")
+	}
+	if cb.Payload.Summary == "" {
+		return errors.New("pagerduty: payload.summary is required")
+	}
+	return nil
+}
+
+// Send POSTs cb to the change events endpoint, retrying 429/5xx with the
+// same backoff-with-jitter policy as EventBody.Send.
+func (cb *ChangeEventBody) Send(token string) (out EventResult, err error) {
+	out = EventResult{Status: "failed"}
+
+	defer func() {
+		severity := hal.SeverityInfo
+		if err != nil {
+			severity = hal.SeverityError
+		}
+
+		hal.Audit(hal.AuditEntry{
+			Source:   "pagerduty",
+			Action:   "change",
+			Target:   cb.Payload.Source,
+			Outcome:  out.Status,
+			Severity: severity,
+		})
+	}()
+
+	err = cb.checkFields()
 	if err != nil {
-		msg := fmt.Sprintf("POST failed: %s", err)
-		out.Message = msg
-		log.Println(msg)
 		return out, err
 	}
-	defer resp.Body.Close()
 
-	out.StatusCode = resp.StatusCode
-
-	body, err := ioutil.ReadAll(resp.Body)
+	js, err := json.Marshal(cb)
 	if err != nil {
+		msg := fmt.Sprintf("json.Marshal failed: %s", err)
+		out.Message = msg
 		return out, err
 	}
 
-	if resp.StatusCode == 200 {
-		err = json.Unmarshal(body, &out)
-		if err != nil {
-			msg := fmt.Sprintf("json.Unmarshal failed: %s", err)
-			out.Status = "failed"
-			out.Message = msg
-			log.Println(msg)
-			return out, err
+	err = retryWithTimeout("", func() error {
+		resp, perr := authenticatedPost(token, V2ChangeEventEndpoint, js)
+		if perr != nil {
+			return perr
 		}
-		return out, nil
-	} else if resp.StatusCode == 202 {
-		// 202 Accepted means they received the event but it's unclear whether it
-		// will trigger an incident
-		// Hopefully this will be a positive signal that a V1 key has been used...
-		err = json.Unmarshal(body, &out)
-		if err != nil {
-			msg := fmt.Sprintf("json.Unmarshal failed: %s", err)
-			out.Status = "failed"
+		defer resp.Body.Close()
+
+		out.StatusCode = resp.StatusCode
+
+		body, perr := ioutil.ReadAll(resp.Body)
+		if perr != nil {
+			return permanent(perr)
+		}
+
+		switch {
+		case resp.StatusCode == 200 || resp.StatusCode == 202:
+			if perr := json.Unmarshal(body, &out); perr != nil {
+				msg := fmt.Sprintf("json.Unmarshal failed: %s", perr)
+				out.Status = "failed"
+				out.Message = msg
+				return permanent(errors.New(msg))
+			}
+			return nil
+		case resp.StatusCode == 429 || resp.StatusCode >= 500:
+			return retryAfterError(resp, fmt.Errorf("server returned %d: %q", resp.StatusCode, string(body)))
+		default:
+			msg := fmt.Sprintf("server returned %d: %q", resp.StatusCode, string(body))
 			out.Message = msg
-			log.Println(msg)
-			return out, err
+			return permanent(errors.New(msg))
 		}
-		// return an error for 202 - it means Pagerduty isn't sure the alert
-		// is going to trigger so callers need a strong signal on that
-		return out, fmt.Errorf("Inconclusive response (202) from service.")
-	} else {
-		msg := fmt.Sprintf("Server returned %d: %q", resp, string(body))
-		out.Message = msg
-		return out, errors.New(msg)
-	}
+	})
+
+	return out, err
 }
 
-func (eb *EventBody) checkFields() error {
-	// TODO: check some fields
-	return nil
+// ChangeEvent sends a v2 change event for source.
+func ChangeEvent(token, routingKey, summary, source string) (EventResult, error) {
+	return NewChangeEvent(routingKey, summary, source).Send(token)
 }
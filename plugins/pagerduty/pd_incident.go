@@ -0,0 +1,81 @@
+package pagerduty
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// API docs: https://developer.pagerduty.com/api-reference/9d0b4b12e36f5-update-an-incident
+
+// IncidentUpdate is the body of a PUT /incidents/{id} request.
+type IncidentUpdate struct {
+	Incident IncidentStatusUpdate `json:"incident"`
+}
+
+// IncidentStatusUpdate sets an incident's status, the only field this
+// plugin needs to change.
+type IncidentStatusUpdate struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+// IncidentResponse is the REST API v2 response wrapping a single incident.
+type IncidentResponse struct {
+	Incident Incident `json:"incident"`
+}
+
+// Incident is the subset of Pagerduty's incident object this plugin needs.
+type Incident struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+	Title  string `json:"title"`
+}
+
+// AckIncident acknowledges incidentId as fromEmail, the address required
+// by Pagerduty's "From" header for any v2 write made on a user's behalf.
+func AckIncident(token, fromEmail, incidentId string) (*Incident, error) {
+	return updateIncidentStatus(token, fromEmail, incidentId, "acknowledged")
+}
+
+// ResolveIncident resolves incidentId as fromEmail.
+func ResolveIncident(token, fromEmail, incidentId string) (*Incident, error) {
+	return updateIncidentStatus(token, fromEmail, incidentId, "resolved")
+}
+
+func updateIncidentStatus(token, fromEmail, incidentId, status string) (*Incident, error) {
+	update := IncidentUpdate{
+		Incident: IncidentStatusUpdate{Type: "incident_reference", Status: status},
+	}
+
+	js, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("/incidents/%s", incidentId)
+	resp, err := authenticatedPutV2(path, token, fromEmail, js)
+	if err != nil {
+		log.Printf("PUT %s failed: %s", path, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Pagerduty returned %d updating incident %s: %s", resp.StatusCode, incidentId, data)
+	}
+
+	iresp := IncidentResponse{}
+	if err := json.Unmarshal(data, &iresp); err != nil {
+		log.Printf("json.Unmarshal failed: %s", err)
+		return nil, err
+	}
+
+	return &iresp.Incident, nil
+}
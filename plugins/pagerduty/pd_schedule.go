@@ -0,0 +1,58 @@
+package pagerduty
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// API docs: https://developer.pagerduty.com/api-reference/9d0b4b12e36f9-list-all-of-the-on-calls
+
+// OnCallsResponse is the REST API v2 response from GET /oncalls.
+type OnCallsResponse struct {
+	OnCalls []ScheduleOnCall `json:"oncalls"`
+}
+
+// ScheduleOnCall is a single on-call shift on a schedule, as returned by
+// the v2 oncalls endpoint. It's distinct from OnCall (pd_user.go), which
+// is the shape the v1 users/on_call endpoint returns.
+type ScheduleOnCall struct {
+	User     User     `json:"user"`
+	Schedule Schedule `json:"schedule"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+}
+
+// Schedule is the subset of Pagerduty's schedule object this plugin
+// needs; it's embedded in ScheduleOnCall rather than fetched separately.
+type Schedule struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// GetOnCallsForSchedule returns who is currently on call for scheduleId,
+// using the v2 oncalls endpoint filtered down to that one schedule.
+func GetOnCallsForSchedule(token, scheduleId string) ([]ScheduleOnCall, error) {
+	query := fmt.Sprintf("schedule_ids[]=%s", scheduleId)
+
+	resp, err := authenticatedGetV2("/oncalls", token, query)
+	if err != nil {
+		log.Printf("GET /oncalls?%s failed: %s", query, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ocresp := OnCallsResponse{}
+	if err := json.Unmarshal(data, &ocresp); err != nil {
+		log.Printf("json.Unmarshal failed: %s", err)
+		return nil, err
+	}
+
+	return ocresp.OnCalls, nil
+}
@@ -2,6 +2,7 @@ package pagerduty
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"time"
@@ -51,14 +52,28 @@ func GetUsersOnCall(token, domain string) ([]User, error) {
 	for {
 		url := pagedUrl("/api/v1/users/on_call", domain, offset, limit)
 
-		resp, err := authenticatedGet(url, token, "")
+		// retry through transient failures so a brief API blip doesn't
+		// interrupt a cache refresh
+		var data []byte
+		err := retryWithTimeout("", func() error {
+			resp, getErr := authenticatedGet(url, token, "")
+			if getErr != nil {
+				return getErr
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != 200 {
+				return retryAfterError(resp, fmt.Errorf("GET %s returned %d", url, resp.StatusCode))
+			}
+
+			data, getErr = ioutil.ReadAll(resp.Body)
+			return getErr
+		})
 		if err != nil {
 			log.Printf("GET %s failed: %s", url, err)
 			return users, err
 		}
 
-		data, err := ioutil.ReadAll(resp.Body)
-
 		err = json.Unmarshal(data, &oresp)
 		if err != nil {
 			log.Printf("json.Unmarshal failed: %s", err)
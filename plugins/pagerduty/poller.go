@@ -23,7 +23,11 @@ import (
 	"github.com/netflix/hal-9001/hal"
 )
 
-// TODO: add a timestamp-based cleanup for old edges/attrs/etc.
+// Tombstone GC: each ingest*() function tracks which ids it saw this pass
+// and removes any directory node of its type that wasn't seen, since that
+// means it no longer exists upstream. Conditional GETs (ETag/
+// If-Modified-Since) live in incremental.go and are meant to be adopted by
+// the ingest*() functions as their GetX() calls grow support for them.
 
 func pollerHandler(evt hal.Evt) {
 	// nothing yet - TODO: add control code, e.g. force refresh
@@ -31,9 +35,10 @@ func pollerHandler(evt hal.Evt) {
 
 func pollerInit(inst *hal.Instance) {
 	pf := hal.PeriodicFunc{
-		Name:     "pagerduty-poller",
-		Interval: time.Hour,
-		Function: ingestPagerdutyAccount,
+		Name:       "pagerduty-poller",
+		Interval:   time.Hour,
+		Function:   ingestPagerdutyAccount,
+		LeaderOnly: true,
 	}
 
 	pf.Register()
@@ -41,7 +46,7 @@ func pollerInit(inst *hal.Instance) {
 }
 
 func ingestPagerdutyAccount() {
-	token, err := getSecrets()
+	token, err := getSecrets(nil)
 	if err != nil || token == "" {
 		log.Printf("pagerduty: %s is not set up in hal.Secrets. Cannot continue.", PagerdutyTokenKey)
 		return
@@ -61,7 +66,11 @@ func ingestPDusers(token string) {
 		return
 	}
 
+	seen := make(map[string]bool, len(users))
+	defer gcTombstones("pd-user", seen)
+
 	for _, user := range users {
+		seen[user.Id] = true
 		attrs := map[string]string{
 			"pd-user-id": user.Id,
 			"name":       user.Name,
@@ -95,7 +104,11 @@ func ingestPDteams(token string) {
 		return
 	}
 
+	seen := make(map[string]bool, len(teams))
+	defer gcTombstones("pd-team", seen)
+
 	for _, team := range teams {
+		seen[team.Id] = true
 		attrs := map[string]string{
 			"pd-team-id":      team.Id,
 			"pd-team":         team.Name,
@@ -114,7 +127,11 @@ func ingestPDservices(token string) {
 		return
 	}
 
+	seen := make(map[string]bool, len(services))
+	defer gcTombstones("pd-service", seen)
+
 	for _, service := range services {
+		seen[service.Id] = true
 		attrs := map[string]string{
 			"pd-service-id":           service.Id,
 			"pd-service":              service.Name,
@@ -153,7 +170,11 @@ func ingestPDschedules(token string) {
 		return
 	}
 
+	seen := make(map[string]bool, len(schedules))
+	defer gcTombstones("pd-schedule", seen)
+
 	for _, schedule := range schedules {
+		seen[schedule.Id] = true
 		attrs := map[string]string{
 			"pd-schedule-id":      schedule.Id,
 			"pd-schedule":         schedule.Name,
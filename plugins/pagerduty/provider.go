@@ -0,0 +1,73 @@
+package pagerduty
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"github.com/netflix/hal-9001/hal"
+	"github.com/netflix/hal-9001/plugins/paging"
+)
+
+func init() {
+	paging.Register("pagerduty", newPagingProvider)
+}
+
+// pagingProvider adapts pagerduty's existing trigger/schedule machinery
+// to paging.Provider, so aliases configured with a bare (unqualified) or
+// "pagerduty:"-qualified key keep working through the same paging.New
+// dispatch page_plugin.go uses for opsgenie/webhook keys.
+type pagingProvider struct {
+	evt   hal.Evt
+	token string
+}
+
+func newPagingProvider(evt hal.Evt) (paging.Provider, error) {
+	token, err := getSecrets(credentialsFor(evt))
+	if err != nil {
+		return nil, err
+	}
+
+	return &pagingProvider{evt: evt, token: token}, nil
+}
+
+func (p *pagingProvider) Name() string { return "pagerduty" }
+
+// Page triggers an event on the integration key, retrying through
+// transient failures the same way pageAlias always has.
+func (p *pagingProvider) Page(key, msg string) error {
+	pde := NewTrigger(key, msg)
+
+	return retryWithTimeout(p.evt.RoomId, func() error {
+		_, err := pde.Send(p.token)
+		return err
+	})
+}
+
+// Oncall treats query as a Pagerduty schedule id, matching what
+// oncallAlias has always expected in an alias's ScheduleId.
+func (p *pagingProvider) Oncall(query string) ([]paging.Assignment, error) {
+	oncalls, err := GetOnCallsForSchedule(p.token, query)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]paging.Assignment, len(oncalls))
+	for i, oc := range oncalls {
+		out[i] = paging.Assignment{Name: oc.User.Name, Email: oc.User.Email}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package pagerduty
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// PageRateLimitPerMinuteKey and PageRateLimitBurstKey are the pref keys
+// pageRateLimitMiddleware reads, per user. Either unset (the GetPref
+// default below, "0") means unrestricted.
+const PageRateLimitPerMinuteKey = "page-rate-limit-per-minute"
+const PageRateLimitBurstKey = "page-rate-limit-burst"
+
+// pageBucket is a minimal token bucket: tokens refill by rate every
+// minute, capped at burst, and are spent one-per-call in take.
+type pageBucket struct {
+	tokens   float64
+	rate     float64
+	burst    float64
+	lastFill time.Time
+}
+
+func (b *pageBucket) take() bool {
+	now := time.Now()
+	b.tokens += now.Sub(b.lastFill).Minutes() * b.rate
+	b.lastFill = now
+
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// pageRateLimitMiddleware is a hal.PluginMiddleware, added to the "page"
+// plugin via Plugin.Use in Register, that throttles !page per user so one
+// person fat-fingering the command can't spam a whole escalation policy.
+// It's opt-in, configured per room with PageRateLimitPerMinuteKey/
+// PageRateLimitBurstKey -- left unset, !page is unrestricted.
+func pageRateLimitMiddleware() hal.PluginMiddleware {
+	var mut sync.Mutex
+	buckets := make(map[string]*pageBucket)
+
+	return func(next hal.PluginHandlerFunc) hal.PluginHandlerFunc {
+		return func(evt hal.Evt) {
+			ratePref := evt.AsPref().FindKey(PageRateLimitPerMinuteKey).Room(evt.RoomId).One()
+			rate, err := strconv.ParseFloat(ratePref.Value, 64)
+			if err != nil || rate <= 0 {
+				next(evt)
+				return
+			}
+
+			burstPref := evt.AsPref().FindKey(PageRateLimitBurstKey).Room(evt.RoomId).One()
+			burst, err := strconv.ParseFloat(burstPref.Value, 64)
+			if err != nil || burst <= 0 {
+				burst = 1
+			}
+
+			key := evt.RoomId + "." + evt.User
+
+			mut.Lock()
+			b, exists := buckets[key]
+			if !exists {
+				b = &pageBucket{tokens: burst, rate: rate, burst: burst, lastFill: time.Now()}
+				buckets[key] = b
+			}
+			b.rate = rate
+			b.burst = burst
+			allowed := b.take()
+			mut.Unlock()
+
+			if !allowed {
+				evt.Replyf("%s: you're paging too quickly, slow down.", evt.User)
+				return
+			}
+
+			next(evt)
+		}
+	}
+}
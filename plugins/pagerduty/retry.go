@@ -0,0 +1,165 @@
+package pagerduty
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// DefaultRetrySleep and DefaultRetryTimeout are the fallbacks retryPrefs
+// uses when the pagerduty.retry-sleep / pagerduty.retry-timeout prefs
+// aren't set. DefaultRetrySleep is also the base delay backoff grows from.
+const DefaultRetrySleep = "5s"
+const DefaultRetryTimeout = "60s"
+
+// maxRetrySleep caps how large backoff's exponential growth is allowed to
+// get, so a long retry-timeout doesn't turn into multi-hour waits between
+// attempts.
+const maxRetrySleep = 5 * time.Minute
+
+// retryableError wraps a failed attempt's error with an explicit delay to
+// wait before the next one, e.g. the Retry-After a 429 response asked for.
+// retryWithTimeout honors it instead of the backoff delay it would
+// otherwise compute.
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+
+// permanentError marks an attempt's failure as not worth retrying, e.g. a
+// 4xx other than 429 -- retryWithTimeout returns it immediately instead of
+// spending the rest of retry-timeout re-sending a request the server has
+// already rejected.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+// permanent wraps err so retryWithTimeout gives up on it immediately
+// rather than retrying until retry-timeout elapses. Returns nil unchanged.
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// backoff returns the delay before the next attempt: exponential growth
+// from sleep, doubling each attempt and capped at maxRetrySleep, with full
+// jitter (a random value between 0 and the capped delay) so a flock of
+// retrying callers doesn't hammer the API in lockstep after an outage.
+func backoff(sleep time.Duration, attempts int) time.Duration {
+	grown := sleep * time.Duration(uint(1)<<uint(attempts-1))
+	if grown > maxRetrySleep || grown <= 0 {
+		grown = maxRetrySleep
+	}
+
+	return time.Duration(rand.Int63n(int64(grown)))
+}
+
+// retryAfterError wraps err with the delay a 429 response's Retry-After
+// header asked for, or returns err unchanged if resp is nil, isn't a 429,
+// or carries no usable Retry-After.
+func retryAfterError(resp *http.Response, err error) error {
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return err
+	}
+
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return err
+	}
+
+	if secs, perr := strconv.Atoi(h); perr == nil {
+		return &retryableError{err: err, retryAfter: time.Duration(secs) * time.Second}
+	}
+
+	if when, perr := http.ParseTime(h); perr == nil {
+		return &retryableError{err: err, retryAfter: time.Until(when)}
+	}
+
+	return err
+}
+
+// retryPrefs resolves the sleep/timeout durations retryWithTimeout uses,
+// from the room-scoped pagerduty.retry-sleep / pagerduty.retry-timeout
+// prefs, falling back to DefaultRetrySleep/DefaultRetryTimeout.
+func retryPrefs(roomId string) (sleep, timeout time.Duration) {
+	sleepPref := hal.GetPref("", "", roomId, "pagerduty", "retry-sleep", DefaultRetrySleep)
+	sleep, err := time.ParseDuration(sleepPref.Value)
+	if err != nil {
+		log.Printf("pagerduty: could not parse retry-sleep pref %q, using default %s", sleepPref.Value, DefaultRetrySleep)
+		sleep, _ = time.ParseDuration(DefaultRetrySleep)
+	}
+
+	timeoutPref := hal.GetPref("", "", roomId, "pagerduty", "retry-timeout", DefaultRetryTimeout)
+	timeout, err = time.ParseDuration(timeoutPref.Value)
+	if err != nil {
+		log.Printf("pagerduty: could not parse retry-timeout pref %q, using default %s", timeoutPref.Value, DefaultRetryTimeout)
+		timeout, _ = time.ParseDuration(DefaultRetryTimeout)
+	}
+
+	return sleep, timeout
+}
+
+// retryWithTimeout calls attempt until it succeeds, returns a
+// *permanentError (see permanent), or the elapsed time exceeds the
+// pagerduty.retry-timeout pref (room-scoped via roomId, which may be "").
+// Between attempts it sleeps an exponentially growing, jittered delay (see
+// backoff) seeded from pagerduty.retry-sleep, unless attempt returns a
+// *retryableError (see retryAfterError) naming an exact delay -- e.g. a
+// 429's Retry-After -- in which case that delay is honored as-is, with no
+// jitter applied. The returned error, on final failure, names the attempt
+// count and total elapsed time.
+func retryWithTimeout(roomId string, attempt func() error) error {
+	sleep, timeout := retryPrefs(roomId)
+	start := time.Now()
+
+	for attempts := 1; ; attempts++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		if perr, ok := err.(*permanentError); ok {
+			return perr.err
+		}
+
+		elapsed := time.Since(start)
+		if elapsed > timeout {
+			return fmt.Errorf("gave up after %d attempts over %s: %s", attempts, elapsed.Round(time.Second), err)
+		}
+
+		wait := backoff(sleep, attempts)
+		if rerr, ok := err.(*retryableError); ok && rerr.retryAfter > 0 {
+			wait = rerr.retryAfter
+		}
+
+		log.Printf("pagerduty: attempt %d failed, retrying in %s: %s", attempts, wait, err)
+		time.Sleep(wait)
+	}
+}
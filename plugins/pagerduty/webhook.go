@@ -0,0 +1,367 @@
+package pagerduty
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// API docs: https://developer.pagerduty.com/docs/webhooks/v3-overview/
+
+// WebhookPath is the HTTP path Register() wires ServeWebhook up to. Point a
+// v3 webhook subscription at "<public base url>" + WebhookPath, and set
+// PagerdutyWebhookSecretKey in hal.Secrets() to the subscription's signing
+// secret.
+const WebhookPath = "/v1/pagerduty/webhook"
+
+// webhookDedupeSize bounds how many recent X-PagerDuty-Delivery-ID values
+// ServeWebhook remembers, so a retried delivery within that window is
+// dispatched at most once.
+const webhookDedupeSize = 512
+
+// webhookEnvelope is the top-level body PagerDuty POSTs for every v3
+// webhook delivery.
+type webhookEnvelope struct {
+	Event webhookEvent `json:"event"`
+}
+
+// webhookEvent is the "event" object inside a webhookEnvelope.
+type webhookEvent struct {
+	Id           string           `json:"id"`
+	EventType    string           `json:"event_type"`
+	ResourceType string           `json:"resource_type"`
+	OccurredAt   string           `json:"occurred_at"`
+	Data         webhookEventData `json:"data"`
+}
+
+// webhookEventData is deliberately a loose subset of whatever resource
+// triggered the event: for incident.* events it's the incident itself
+// (Id/Status/Title), for escalation_policy.updated and service.updated
+// it's that resource (Id), and for oncall.updated it references the
+// schedule and escalation policy whose on-call lineup changed.
+type webhookEventData struct {
+	Id               string   `json:"id"`
+	Type             string   `json:"type"`
+	Status           string   `json:"status,omitempty"`
+	Title            string   `json:"title,omitempty"`
+	Schedule         *dataRef `json:"schedule,omitempty"`
+	EscalationPolicy *dataRef `json:"escalation_policy,omitempty"`
+}
+
+// dataRef is a minimal {id,type} reference to another PagerDuty resource,
+// as embedded in several webhookEventData fields.
+type dataRef struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// WebhookHandler reacts to one delivered webhookEvent. See
+// RegisterWebhookHandler.
+type WebhookHandler func(evt webhookEvent)
+
+var webhookHandlersMut sync.Mutex
+var webhookHandlers = make(map[string][]WebhookHandler)
+
+var webhookDeliveries = newDeliveryDedupe(webhookDedupeSize)
+
+func init() {
+	RegisterWebhookHandler("incident.triggered", emitIncidentEvent)
+	RegisterWebhookHandler("incident.acknowledged", emitIncidentEvent)
+	RegisterWebhookHandler("incident.resolved", emitIncidentEvent)
+	RegisterWebhookHandler("incident.responder.added", emitIncidentEvent)
+	RegisterWebhookHandler("escalation_policy.updated", handleEscalationPolicyUpdated)
+	RegisterWebhookHandler("service.updated", handleServiceUpdated)
+	RegisterWebhookHandler("oncall.updated", handleOncallUpdated)
+}
+
+// RegisterWebhookHandler adds h to the list of handlers ServeWebhook calls
+// for deliveries whose event.event_type matches eventType. Multiple
+// handlers may be registered for the same eventType; all of them run.
+func RegisterWebhookHandler(eventType string, h WebhookHandler) {
+	webhookHandlersMut.Lock()
+	defer webhookHandlersMut.Unlock()
+
+	webhookHandlers[eventType] = append(webhookHandlers[eventType], h)
+}
+
+// ServeWebhook is the HTTP handler for PagerDuty's v3 webhook deliveries.
+// It verifies the HMAC signature, dedupes retried deliveries by
+// X-PagerDuty-Delivery-ID, and dispatches the decoded event to every
+// handler registered for its event_type.
+func ServeWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("pagerduty: failed to read webhook body: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	secret := hal.Secrets().Get(PagerdutyWebhookSecretKey)
+	if secret == "" {
+		log.Printf("pagerduty: got a webhook delivery but %q is not set in hal.Secrets, refusing it", PagerdutyWebhookSecretKey)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	sigHdr := r.Header.Get("X-PagerDuty-Signature")
+	if !verifyWebhookSignature(secret, body, sigHdr) {
+		log.Printf("pagerduty: webhook delivery %s failed signature verification", r.Header.Get("X-PagerDuty-Delivery-ID"))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	deliveryId := r.Header.Get("X-PagerDuty-Delivery-ID")
+	if deliveryId != "" && webhookDeliveries.SeenBefore(deliveryId) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	env := webhookEnvelope{}
+	if err := json.Unmarshal(body, &env); err != nil {
+		log.Printf("pagerduty: failed to decode webhook delivery %s: %s", deliveryId, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	webhookHandlersMut.Lock()
+	handlers := append([]WebhookHandler{}, webhookHandlers[env.Event.EventType]...)
+	webhookHandlersMut.Unlock()
+
+	for _, h := range handlers {
+		h(env.Event)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifyWebhookSignature checks sigHdr -- the comma-separated
+// "v1=<hex hmac-sha256>" list PagerDuty sends in X-PagerDuty-Signature --
+// against an HMAC of body computed with secret. A subscription may list
+// more than one signature during secret rotation, so any match counts.
+func verifyWebhookSignature(secret string, body []byte, sigHdr string) bool {
+	if sigHdr == "" {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	for _, part := range strings.Split(sigHdr, ",") {
+		sig := strings.TrimPrefix(strings.TrimSpace(part), "v1=")
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleOncallUpdated invalidates just the affected schedule's entries in
+// the oncall cache and, for any room whose topic-updater-schedule-id pref
+// matches, refreshes the topic right away instead of waiting for
+// topicUpdater's next periodic run.
+func handleOncallUpdated(evt webhookEvent) {
+	if evt.Data.Schedule == nil || evt.Data.Schedule.Id == "" {
+		log.Printf("pagerduty: oncall.updated webhook had no schedule reference, ignoring")
+		return
+	}
+
+	token, err := getSecrets(nil)
+	if err != nil {
+		log.Printf("pagerduty: ignoring oncall.updated webhook: %s", err)
+		return
+	}
+
+	scheduleId := evt.Data.Schedule.Id
+	invalidateOncallsForSchedule(token, scheduleId)
+	refreshTopicsForSchedule(token, scheduleId)
+}
+
+// handleEscalationPolicyUpdated invalidates just the affected policy's
+// entries in the oncall cache.
+func handleEscalationPolicyUpdated(evt webhookEvent) {
+	if evt.Data.Id == "" {
+		return
+	}
+
+	token, err := getSecrets(nil)
+	if err != nil {
+		log.Printf("pagerduty: ignoring escalation_policy.updated webhook: %s", err)
+		return
+	}
+
+	invalidateOncallsForPolicy(token, evt.Data.Id)
+}
+
+// handleServiceUpdated forces a full cache refresh. Unlike schedule and
+// escalation policy changes, a service update doesn't say which policy it
+// points at now, so there's nothing to target.
+func handleServiceUpdated(evt webhookEvent) {
+	token, err := getSecrets(nil)
+	if err != nil {
+		log.Printf("pagerduty: ignoring service.updated webhook: %s", err)
+		return
+	}
+
+	cacheNow(token, "")
+}
+
+// invalidateOncallsForSchedule re-fetches just scheduleId's on-call entries
+// and patches them into the CacheKey cache in place of a full refetch.
+func invalidateOncallsForSchedule(token, scheduleId string) {
+	var fresh []Oncall
+	err := retryWithTimeout("", func() error {
+		var getErr error
+		fresh, getErr = GetOncalls(token, map[string]string{"schedule_ids[]": scheduleId})
+		return getErr
+	})
+	if err != nil {
+		log.Printf("pagerduty: webhook-triggered refresh of schedule %q failed, leaving the existing cache in place: %s", scheduleId, err)
+		return
+	}
+
+	patchOncallCache(fresh, func(o Oncall) bool {
+		return o.Schedule.Id == scheduleId
+	})
+}
+
+// invalidateOncallsForPolicy re-fetches just policyId's on-call entries and
+// patches them into the CacheKey cache in place of a full refetch.
+func invalidateOncallsForPolicy(token, policyId string) {
+	var fresh []Oncall
+	err := retryWithTimeout("", func() error {
+		var getErr error
+		fresh, getErr = GetOncalls(token, map[string]string{"escalation_policy_ids[]": policyId})
+		return getErr
+	})
+	if err != nil {
+		log.Printf("pagerduty: webhook-triggered refresh of escalation policy %q failed, leaving the existing cache in place: %s", policyId, err)
+		return
+	}
+
+	patchOncallCache(fresh, func(o Oncall) bool {
+		return o.EscalationPolicy.Id == policyId
+	})
+}
+
+// patchOncallCache replaces whichever entries of the cached []Oncall behind
+// CacheKey match stale with fresh, leaving everything else untouched. If
+// nothing is cached yet, it seeds the cache with fresh alone; a subsequent
+// "!oncall cache-now" or the fallback poll backfills the rest.
+func patchOncallCache(fresh []Oncall, stale func(Oncall) bool) {
+	oncalls := []Oncall{}
+	if hal.Cache().Exists(CacheKey) {
+		hal.Cache().Get(CacheKey, &oncalls)
+	}
+
+	kept := make([]Oncall, 0, len(oncalls)+len(fresh))
+	for _, o := range oncalls {
+		if !stale(o) {
+			kept = append(kept, o)
+		}
+	}
+	kept = append(kept, fresh...)
+
+	hal.Cache().Set(CacheKey, &kept, cacheExpire)
+}
+
+// refreshTopicsForSchedule immediately re-runs topicUpdater for every room
+// whose pagerduty/topic-updater-schedule-id pref names scheduleId.
+func refreshTopicsForSchedule(token, scheduleId string) {
+	for _, inst := range oncallInstances() {
+		pref := hal.GetPref("", inst.BrokerName(), inst.RoomId, "pagerduty", "topic-updater-schedule-id", "-")
+		if pref.Value != scheduleId {
+			continue
+		}
+
+		go topicUpdater(token, inst.RoomId, inst.BrokerName())
+	}
+}
+
+// emitIncidentEvent re-publishes an incident webhook as a hal.Evt into
+// every room running the oncall plugin, so other plugins' regexes (e.g. an
+// audit logger, or a team-specific bridge) can react to it without polling
+// PagerDuty themselves.
+func emitIncidentEvent(evt webhookEvent) {
+	action := strings.TrimPrefix(evt.EventType, "incident.")
+	body := fmt.Sprintf("pagerduty: incident %s %s: %s", evt.Data.Id, action, evt.Data.Title)
+
+	for _, inst := range oncallInstances() {
+		out := hal.Evt{
+			Body:    body,
+			Room:    inst.RoomId,
+			RoomId:  inst.RoomId,
+			Time:    time.Now(),
+			Brokers: hal.Brokers{inst.Broker},
+		}
+
+		hal.Router().Inject(&out)
+	}
+}
+
+// oncallInstances returns the registered instances of the "oncall" plugin,
+// i.e. the rooms a webhook-driven cache update or incident event should
+// reach.
+func oncallInstances() []*hal.Instance {
+	out := make([]*hal.Instance, 0)
+
+	for _, inst := range hal.PluginRegistry().InstanceList() {
+		if inst.Plugin.Name == "oncall" {
+			out = append(out, inst)
+		}
+	}
+
+	return out
+}
+
+// deliveryDedupe is a bounded FIFO set of recently seen delivery ids, used
+// to collapse PagerDuty's at-least-once webhook retries down to a single
+// dispatch.
+type deliveryDedupe struct {
+	mut   sync.Mutex
+	seen  map[string]struct{}
+	order []string
+	max   int
+}
+
+func newDeliveryDedupe(max int) *deliveryDedupe {
+	return &deliveryDedupe{
+		seen: make(map[string]struct{}),
+		max:  max,
+	}
+}
+
+// SeenBefore reports whether id was already passed to SeenBefore, and
+// records it for next time. The oldest id is evicted once more than max
+// are being tracked.
+func (d *deliveryDedupe) SeenBefore(id string) bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if _, exists := d.seen[id]; exists {
+		return true
+	}
+
+	d.seen[id] = struct{}{}
+	d.order = append(d.order, id)
+
+	if len(d.order) > d.max {
+		oldest := d.order[0]
+		d.order = d.order[1:]
+		delete(d.seen, oldest)
+	}
+
+	return false
+}
@@ -0,0 +1,137 @@
+package paging
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// OpsGenieAPIKeyKey is the hal.Secrets key holding an OpsGenie API
+// integration key.
+const OpsGenieAPIKeyKey = "opsgenie.api-key"
+
+const opsGenieBaseURL = "https://api.opsgenie.com"
+const opsGenieTimeout = 10 * time.Second
+
+func init() {
+	Register("opsgenie", NewOpsGenieProvider)
+}
+
+type opsGenieProvider struct {
+	apiKey string
+}
+
+// NewOpsGenieProvider reads OpsGenieAPIKeyKey from hal.Secrets, the same
+// convention pagerduty.PagerdutyTokenKey uses.
+func NewOpsGenieProvider(evt hal.Evt) (Provider, error) {
+	key := hal.Secrets().Get(OpsGenieAPIKeyKey)
+	if key == "" {
+		return nil, fmt.Errorf("%s is not set up in hal.Secrets", OpsGenieAPIKeyKey)
+	}
+
+	return &opsGenieProvider{apiKey: key}, nil
+}
+
+func (o *opsGenieProvider) Name() string { return "opsgenie" }
+
+type opsGenieAlertRequest struct {
+	Message string `json:"message"`
+	Alias   string `json:"alias"`
+}
+
+// Page creates an OpsGenie alert. OpsGenie routes alerts to responders
+// via its own team/schedule configuration, so key is used as the alert's
+// alias -- the same value teams would hand to `!page add` -- rather than
+// an integration key the way PagerDuty's Page does.
+func (o *opsGenieProvider) Page(key, msg string) error {
+	body, err := json.Marshal(opsGenieAlertRequest{Message: msg, Alias: key})
+	if err != nil {
+		return err
+	}
+
+	resp, err := o.do("POST", "/v2/alerts", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("OpsGenie POST /v2/alerts returned %d: %s", resp.StatusCode, string(data))
+	}
+
+	return nil
+}
+
+type opsGenieOnCallResponse struct {
+	Data struct {
+		OnCallRecipients []string `json:"onCallRecipients"`
+	} `json:"data"`
+}
+
+// Oncall asks OpsGenie who's on call for the named schedule (query is an
+// OpsGenie schedule name or id).
+func (o *opsGenieProvider) Oncall(query string) ([]Assignment, error) {
+	resp, err := o.do("GET", "/v2/schedules/"+query+"/on-calls", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("OpsGenie GET /v2/schedules/%s/on-calls returned %d: %s", query, resp.StatusCode, string(data))
+	}
+
+	var oresp opsGenieOnCallResponse
+	if err := json.NewDecoder(resp.Body).Decode(&oresp); err != nil {
+		return nil, err
+	}
+
+	out := make([]Assignment, len(oresp.Data.OnCallRecipients))
+	for i, name := range oresp.Data.OnCallRecipients {
+		out[i] = Assignment{Name: name}
+	}
+
+	return out, nil
+}
+
+func (o *opsGenieProvider) do(method, path string, body []byte) (*http.Response, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	} else {
+		reader = bytes.NewReader([]byte{})
+	}
+
+	req, err := http.NewRequest(method, opsGenieBaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+o.apiKey)
+
+	client := http.Client{Timeout: opsGenieTimeout}
+	return client.Do(req)
+}
@@ -0,0 +1,59 @@
+// Package paging is the provider-agnostic half of hal's paging subsystem:
+// a small interface that PagerDuty, OpsGenie, a generic webhook, or
+// anything else capable of "send an alert"/"who's on call" can implement,
+// plus a registry so plugins/pagerduty's !page and !oncall commands can
+// dispatch to whichever one an alias names instead of being wired
+// directly to PagerDuty.
+package paging
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import "github.com/netflix/hal-9001/hal"
+
+// Provider is one paging backend: something !page can hand an alert to
+// and !oncall can ask "who's responsible right now". Implementations are
+// constructed fresh per dispatch by a Factory (see Register) so they can
+// carry whatever per-room/per-user credentials the call needs without the
+// registry having to cache and invalidate them itself.
+type Provider interface {
+	// Name identifies the provider, e.g. "pagerduty", "opsgenie",
+	// "webhook" -- matches the key it was Register()ed under.
+	Name() string
+
+	// Page sends msg as an alert to whatever alias/key/channel key
+	// identifies within this provider.
+	Page(key, msg string) error
+
+	// Oncall returns who's currently on call for query (a schedule name
+	// or id; the exact meaning is provider-specific). Providers that have
+	// no way to answer this (e.g. a one-way webhook) return an error
+	// saying so.
+	Oncall(query string) ([]Assignment, error)
+}
+
+// Assignment is one person (or, for providers that page machines/queues
+// instead of humans, one responsible party) currently on call.
+type Assignment struct {
+	Name  string
+	Email string
+}
+
+// Factory builds a Provider scoped to evt, called once per !page/!oncall
+// dispatch so it can read the room/user/secrets it needs (e.g.
+// PagerDuty's per-user OAuth token via credentialsFor) without the
+// registry holding state across calls.
+type Factory func(evt hal.Evt) (Provider, error)
@@ -0,0 +1,105 @@
+package paging
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// DefaultProviderKey is the pref an alias with no "provider:" prefix on
+// its key falls back to, room-scoped the same way every other pagerduty
+// pref is. DefaultProviderName ("pagerduty") keeps existing deployments
+// working unchanged if the pref is never set.
+const DefaultProviderKey = "paging.default-provider"
+const DefaultProviderName = "pagerduty"
+
+var (
+	mut       sync.Mutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a provider available under name, e.g. from an init()
+// in the package that implements it (see plugins/pagerduty/provider.go,
+// plugins/paging/opsgenie.go, plugins/paging/webhook.go).
+func Register(name string, f Factory) {
+	mut.Lock()
+	defer mut.Unlock()
+
+	factories[name] = f
+}
+
+// Names returns every registered provider name, sorted, for !page list
+// and similar diagnostics.
+func Names() []string {
+	mut.Lock()
+	defer mut.Unlock()
+
+	out := make([]string, 0, len(factories))
+	for name := range factories {
+		out = append(out, name)
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// New builds the provider registered as name, scoped to evt.
+func New(name string, evt hal.Evt) (Provider, error) {
+	mut.Lock()
+	f, ok := factories[name]
+	mut.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no paging provider registered as %q (have: %s)", name, strings.Join(Names(), ", "))
+	}
+
+	return f(evt)
+}
+
+// DefaultName resolves DefaultProviderKey for evt's room, falling back to
+// DefaultProviderName.
+func DefaultName(evt hal.Evt) string {
+	pref := evt.AsPref().FindKey(DefaultProviderKey).Room(evt.RoomId).One()
+	if pref.Success && pref.Value != "" {
+		return pref.Value
+	}
+
+	return DefaultProviderName
+}
+
+// ParseKey splits a "provider:key" alias value into its provider name and
+// bare key. A value with no recognized provider prefix -- including every
+// existing PagerDuty integration key, which never contains ":" -- falls
+// back to def, so upgrading to multi-provider aliases doesn't require
+// rewriting any already-configured alias.
+func ParseKey(value, def string) (provider, key string) {
+	mut.Lock()
+	defer mut.Unlock()
+
+	if i := strings.Index(value, ":"); i > 0 {
+		if _, ok := factories[value[:i]]; ok {
+			return value[:i], value[i+1:]
+		}
+	}
+
+	return def, value
+}
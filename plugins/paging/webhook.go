@@ -0,0 +1,88 @@
+package paging
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// WebhookURLKey is the room-scoped pref holding the URL the webhook
+// provider POSTs to, for in-house paging tools that don't speak
+// PagerDuty or OpsGenie.
+const WebhookURLKey = "paging.webhook.url"
+
+const webhookTimeout = 10 * time.Second
+
+func init() {
+	Register("webhook", NewWebhookProvider)
+}
+
+// WebhookEnvelope is the JSON body POSTed to WebhookURLKey's URL for
+// every Page call.
+type WebhookEnvelope struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+type webhookProvider struct {
+	url string
+}
+
+// NewWebhookProvider reads WebhookURLKey for evt's room and returns a
+// Provider that POSTs a WebhookEnvelope there on Page. It has no way to
+// answer Oncall -- there's no standard "who's on call" response to
+// expect back from an arbitrary URL -- so that always errors.
+func NewWebhookProvider(evt hal.Evt) (Provider, error) {
+	pref := evt.AsPref().FindKey(WebhookURLKey).Room(evt.RoomId).One()
+	if !pref.Success || pref.Value == "" {
+		return nil, fmt.Errorf("%s is not configured for this room", WebhookURLKey)
+	}
+
+	return &webhookProvider{url: pref.Value}, nil
+}
+
+func (w *webhookProvider) Name() string { return "webhook" }
+
+func (w *webhookProvider) Page(key, msg string) error {
+	body, err := json.Marshal(WebhookEnvelope{Key: key, Message: msg})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook POST to %s returned %d", w.url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (w *webhookProvider) Oncall(query string) ([]Assignment, error) {
+	return nil, fmt.Errorf("the webhook provider has no way to answer !oncall -- it only receives, it doesn't query back")
+}
@@ -20,10 +20,12 @@ package pluginmgr
 
 import (
 	"bytes"
+	dbsql "database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/codegangsta/cli"
@@ -42,14 +44,23 @@ Examples:
 !plugin attach <plugin> --room <room>
 !plugin attach --regex ^!foo <plugin> <room>
 !plugin detach <plugin> <room>
+!plugin detach <plugin> --id <id>
 !plugin group list
 !plugin group add <group_name> <plugin_name>
 !plugin group del <group_name> <plugin_name>
+!plugin group attach <group_name> --room <room>
+!plugin group detach <group_name> --room <room>
+!plugin group save <group_name> --room <room>
+!plugin install <ref>
+!plugin install <ref> --grant <hash>
+!plugin upgrade <plugin> <new_ref>
 
 e.g.
 !plugin attach uptime --room CORE
 !plugin detach uptime --room CORE
 !plugin save
+!plugin install https://plugins.example.com/uptime/manifest.json
+!plugin upgrade uptime https://plugins.example.com/uptime/manifest-v2.json
 `
 
 const PluginGroupTable = `
@@ -68,6 +79,53 @@ type PluginGroupRow struct {
 
 type PluginGroup []*PluginGroupRow
 
+// GrantedPluginTable records the privilege hash a user explicitly granted
+// for a remote plugin ref (see installPlugin), so "!plugin install <ref>"
+// without a --grant always requires a fresh review rather than trusting a
+// prior grant silently.
+const GrantedPluginTable = `
+CREATE TABLE IF NOT EXISTS granted_plugins (
+    ref             VARCHAR(191),
+    privileges_hash VARCHAR(64),
+    granted_by      VARCHAR(191),
+    ts              TIMESTAMP,
+    PRIMARY KEY(ref)
+)`
+
+type GrantedPluginRow struct {
+	Ref            string    `json:"ref"`
+	PrivilegesHash string    `json:"privileges_hash"`
+	GrantedBy      string    `json:"granted_by"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+func (gpr *GrantedPluginRow) Save() error {
+	sql := `INSERT INTO granted_plugins
+	        (ref, privileges_hash, granted_by, ts) VALUES (?, ?, ?, ?)
+	        ON DUPLICATE KEY UPDATE privileges_hash=VALUES(privileges_hash),
+	                                 granted_by=VALUES(granted_by), ts=VALUES(ts)`
+
+	db := hal.SqlDB()
+	_, err := db.Exec(sql, &gpr.Ref, &gpr.PrivilegesHash, &gpr.GrantedBy, &gpr.Timestamp)
+	return err
+}
+
+// GetGrantedPlugin returns the grant previously recorded for ref (see
+// installPluginRef), or an error (including dbsql.ErrNoRows) if ref has
+// never been granted.
+func GetGrantedPlugin(ref string) (*GrantedPluginRow, error) {
+	gpr := GrantedPluginRow{}
+	sql := `SELECT ref, privileges_hash, granted_by, ts FROM granted_plugins WHERE ref=?`
+
+	db := hal.SqlDB()
+	err := db.QueryRow(sql, ref).Scan(&gpr.Ref, &gpr.PrivilegesHash, &gpr.GrantedBy, &gpr.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gpr, nil
+}
+
 // Register makes this plugin available to the system.
 func Register() {
 	plugin := hal.Plugin{
@@ -79,8 +137,18 @@ func Register() {
 	plugin.Register()
 
 	hal.SqlInit(PluginGroupTable)
+	hal.SqlInit(GrantedPluginTable)
+
+	// bridge InstanceAttached/InstanceDetached/PluginEnabled/PluginUpgraded
+	// (and every other PluginEvent) into the Audit() pipeline, so operators
+	// get a durable trail of who attached/detached/installed/upgraded what
+	// -- see hal.StartPluginEventAuditing and hal.SQLAuditSink.
+	hal.StartPluginEventAuditing()
 
 	http.HandleFunc("/v1/plugins", httpPlugins)
+	http.HandleFunc(pluginsPathPrefix, httpPluginByName)
+	http.HandleFunc(pluginGroupsPath, httpPluginGroups)
+	http.HandleFunc(pluginGroupsPathPrefix, httpPluginGroupAttach)
 }
 
 func pluginmgr(evt hal.Evt) {
@@ -92,7 +160,7 @@ func pluginmgr(evt hal.Evt) {
 	pr := hal.PluginRegistry()
 
 	for _, p := range pr.PluginList() {
-		var name, room, regex string
+		var name, room, regex, id string
 		name = p.Name
 
 		attachCmd := cli.Command{
@@ -129,9 +197,14 @@ func pluginmgr(evt hal.Evt) {
 					Destination: &room,      // should be safe to use this again...
 					Usage:       "the room to detach from",
 				},
+				cli.StringFlag{
+					Name:        "id",
+					Destination: &id,
+					Usage:       "detach only the instance with this id, disambiguating multiple instances of this plugin in one room",
+				},
 			},
 			Action: func(c *cli.Context) {
-				detachPlugin(c, &evt, room, name)
+				detachPlugin(c, &evt, room, name, id)
 			},
 		}
 
@@ -167,13 +240,32 @@ func pluginmgr(evt hal.Evt) {
 			Usage:       "attach a plugin to a room (creates an instance)",
 			Subcommands: attachCmds, // composed above
 		},
-		// for now, plugins are restricted to one instance per room to avoid having to
-		// generate and manage some kind of ID, which will probably get added later
+		// each instance has its own generated Id (see hal.Instance.Id), so a
+		// room can have more than one instance of the same plugin; --id picks
+		// a specific one when a plain plugin name would be ambiguous
 		{
 			Name:        "detach",
 			Usage:       "detach a plugin from a room",
 			Subcommands: detachCmds,
 		},
+		{
+			Name:      "install",
+			Usage:     "review (and, with --grant, confirm) a remote plugin manifest's privileges",
+			ArgsUsage: "<ref>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "grant",
+					Usage: "the privileges hash shown by a prior 'plugin install <ref>', confirming they're reviewed",
+				},
+			},
+			Action: func(c *cli.Context) { installPlugin(c, &evt) },
+		},
+		{
+			Name:      "upgrade",
+			Usage:     "replace a registered plugin with a new version, preserving its instances",
+			ArgsUsage: "<plugin> <new_ref>",
+			Action:    func(c *cli.Context) { upgradePlugin(c, &evt) },
+		},
 		{
 			Name:  "group",
 			Usage: "manage plugin groups",
@@ -193,6 +285,66 @@ func pluginmgr(evt hal.Evt) {
 					Usage:  "del <group_name> <plugin_name>",
 					Action: func(c *cli.Context) { delGroupPlugin(c, &evt) },
 				},
+				{
+					Name:      "attach",
+					Usage:     "attach <group_name> --room <room>",
+					ArgsUsage: "<group_name>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "room",
+							Value: evt.RoomId, // default to the room where the command originated
+							Usage: "the room to attach every plugin in the group to",
+						},
+					},
+					Action: func(c *cli.Context) {
+						args := c.Args()
+						if len(args) != 1 {
+							evt.Replyf("group attach requires exactly one argument, <group_name>, got %d", len(args))
+							return
+						}
+						attachGroupPlugin(c, &evt, args[0], c.String("room"))
+					},
+				},
+				{
+					Name:      "detach",
+					Usage:     "detach <group_name> --room <room>",
+					ArgsUsage: "<group_name>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "room",
+							Value: evt.RoomId, // default to the room where the command originated
+							Usage: "the room to detach every plugin in the group from",
+						},
+					},
+					Action: func(c *cli.Context) {
+						args := c.Args()
+						if len(args) != 1 {
+							evt.Replyf("group detach requires exactly one argument, <group_name>, got %d", len(args))
+							return
+						}
+						detachGroupPlugin(c, &evt, args[0], c.String("room"))
+					},
+				},
+				{
+					Name:      "save",
+					Usage:     "save <group_name> --room <room>",
+					ArgsUsage: "<group_name>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "room",
+							Value: evt.RoomId, // default to the room where the command originated
+							Usage: "the room whose current instances to snapshot into the group",
+						},
+					},
+					Action: func(c *cli.Context) {
+						args := c.Args()
+						if len(args) != 1 {
+							evt.Replyf("group save requires exactly one argument, <group_name>, got %d", len(args))
+							return
+						}
+						saveGroupPlugin(c, &evt, args[0], c.String("room"))
+					},
+				},
 			},
 		},
 	}
@@ -224,12 +376,13 @@ func listPlugins(c *cli.Context, evt *hal.Evt) {
 }
 
 func listInstances(c *cli.Context, evt *hal.Evt) {
-	hdr := []string{"Plugin Name", "Broker", "Room", "RE"}
+	hdr := []string{"Id", "Plugin Name", "Broker", "Room", "RE"}
 	rows := [][]string{}
 	pr := hal.PluginRegistry()
 
 	for _, inst := range pr.InstanceList() {
 		row := []string{
+			inst.Id,
 			inst.Plugin.Name,
 			inst.Broker.Name(),
 			inst.RoomId,
@@ -266,41 +419,432 @@ func roomToId(evt *hal.Evt, room string) string {
 }
 
 func attachPlugin(c *cli.Context, evt *hal.Evt, room, pluginName, regex string) {
+	roomId := roomToId(evt, room)
+
+	inst, err := attachPluginRef(evt, roomId, pluginName, regex)
+	if err != nil {
+		evt.Replyf("Failed to launch plugin '%s' in room id '%s': %s", pluginName, roomId, err)
+		return
+	}
+
+	evt.Replyf("Launched an instance of plugin: '%s' in room id '%s' (id %s)", inst.Plugin.Name, roomId, inst.Id)
+}
+
+// attachPluginRef creates and registers an instance of pluginName in
+// roomId (using regex as the instance's filter, or the plugin's own
+// default when regex is ""), publishing InstanceAttached on success. It's
+// the shared core behind "!plugin attach", group attach, and the
+// POST /v1/plugin-groups/{name}/attach REST endpoint.
+func attachPluginRef(evt *hal.Evt, roomId, pluginName, regex string) (*hal.Instance, error) {
 	pr := hal.PluginRegistry()
 	plugin, err := pr.GetPlugin(pluginName)
 	if err != nil {
-		evt.Replyf("No such plugin: '%s'", plugin)
-		return
+		return nil, fmt.Errorf("no such plugin: '%s'", pluginName)
 	}
 
-	roomId := roomToId(evt, room)
 	inst := plugin.Instance(roomId, evt.Broker)
 	inst.RoomId = roomId
-	inst.Regex = regex
-	err = inst.Register()
+	if regex != "" {
+		inst.Regex = regex
+	}
+
+	if err := inst.Register(); err != nil {
+		return nil, err
+	}
+
+	hal.Events().Publish(hal.PluginEvent{
+		Type:      hal.InstanceAttached,
+		Plugin:    inst.Plugin,
+		Instance:  inst,
+		Broker:    evt.BrokerName(),
+		ActorUser: evt.From,
+		ActorRoom: roomId,
+	})
+
+	return inst, nil
+}
+
+func detachPlugin(c *cli.Context, evt *hal.Evt, room, plugin, id string) {
+	roomId := roomToId(evt, room)
+
+	n, err := detachPluginRef(evt, roomId, plugin, id)
 	if err != nil {
-		evt.Replyf("Failed to launch plugin '%s' in room id '%s': %s", plugin, roomId, err)
+		evt.Replyf("%s", err)
+		return
+	}
 
-	} else {
-		evt.Replyf("Launched an instance of plugin: '%s' in room id '%s'", plugin, roomId)
+	if n == 0 {
+		evt.Replyf("no instance of %q found in room %q", plugin, room)
+		return
 	}
+
+	evt.Replyf("%q/%q unregistered", room, plugin)
 }
 
-func detachPlugin(c *cli.Context, evt *hal.Evt, room, plugin string) {
+// detachPluginRef unregisters the instance(s) of pluginName in roomId and
+// returns how many were found, publishing InstanceDetached for each. If id
+// is non-empty, only the instance with that Id is considered -- the way
+// to disambiguate when more than one instance of pluginName is attached
+// to roomId (see hal.Instance.Id). Otherwise it's an error for more than
+// one to match, since there would be no way to tell the caller which one
+// they meant. It's the shared core behind "!plugin detach" and group
+// detach.
+func detachPluginRef(evt *hal.Evt, roomId, pluginName, id string) (int, error) {
 	pr := hal.PluginRegistry()
-	roomId := roomToId(evt, room)
-	instances := pr.FindInstances(roomId, evt.BrokerName(), plugin)
 
-	// there should be only one, for now just log if that is not the case
-	if len(instances) > 1 {
-		log.Printf("FindInstances(%q, %q) returned %d instances. Expected 0 or 1.",
-			room, plugin, len(instances))
+	var instances []*hal.Instance
+	if id != "" {
+		if inst := pr.FindInstanceById(id); inst != nil {
+			instances = []*hal.Instance{inst}
+		}
+	} else {
+		instances = pr.FindInstances(roomId, evt.BrokerName(), pluginName)
+		if len(instances) > 1 {
+			return 0, fmt.Errorf("%d instances of %q are attached to %q; use --id to pick one (see '!plugin instances')",
+				len(instances), pluginName, roomId)
+		}
 	}
 
 	for _, inst := range instances {
 		inst.Unregister()
-		evt.Replyf("%q/%q unregistered", room, plugin)
+
+		hal.Events().Publish(hal.PluginEvent{
+			Type:      hal.InstanceDetached,
+			Plugin:    inst.Plugin,
+			Instance:  inst,
+			Broker:    evt.BrokerName(),
+			ActorUser: evt.From,
+			ActorRoom: roomId,
+		})
+	}
+
+	return len(instances), nil
+}
+
+// attachGroupPlugin implements "!plugin group attach <group_name> --room
+// <room>": attaches every plugin in the named group to room, reporting a
+// per-plugin status row rather than aborting the whole batch if one
+// attach fails -- see attachGroupToRoom, shared with the
+// POST /v1/plugin-groups/{name}/attach REST endpoint.
+func attachGroupPlugin(c *cli.Context, evt *hal.Evt, groupName, room string) {
+	roomId := roomToId(evt, room)
+
+	rows, err := attachGroupToRoom(evt, groupName, roomId)
+	if err != nil {
+		evt.Error(err)
+		return
+	}
+
+	evt.ReplyTable([]string{"Plugin", "Status"}, rows)
+}
+
+// attachGroupToRoom attaches every plugin in groupName to roomId, one
+// instance per plugin, continuing past a failed attach instead of
+// aborting the batch -- a config-management tool driving a bulk rollout
+// needs to know which plugins actually landed, not just the first error.
+func attachGroupToRoom(evt *hal.Evt, groupName, roomId string) ([][]string, error) {
+	pgs, err := GetPluginGroup(groupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up group %q: %s", groupName, err)
+	}
+
+	if len(pgs) == 0 {
+		return nil, fmt.Errorf("group %q has no plugins (see '!plugin group add')", groupName)
+	}
+
+	rows := make([][]string, 0, len(pgs))
+	for _, pgr := range pgs {
+		if _, err := attachPluginRef(evt, roomId, pgr.Plugin, ""); err != nil {
+			rows = append(rows, []string{pgr.Plugin, fmt.Sprintf("failed: %s", err)})
+		} else {
+			rows = append(rows, []string{pgr.Plugin, "attached"})
+		}
+	}
+
+	return rows, nil
+}
+
+// detachGroupPlugin implements "!plugin group detach <group_name> --room
+// <room>", the reverse of attachGroupPlugin.
+func detachGroupPlugin(c *cli.Context, evt *hal.Evt, groupName, room string) {
+	roomId := roomToId(evt, room)
+
+	rows, err := detachGroupFromRoom(evt, groupName, roomId)
+	if err != nil {
+		evt.Error(err)
+		return
+	}
+
+	evt.ReplyTable([]string{"Plugin", "Status"}, rows)
+}
+
+// detachGroupFromRoom detaches every plugin in groupName from roomId,
+// reporting "not attached" rather than failing for any plugin that had no
+// instance there.
+func detachGroupFromRoom(evt *hal.Evt, groupName, roomId string) ([][]string, error) {
+	pgs, err := GetPluginGroup(groupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up group %q: %s", groupName, err)
+	}
+
+	if len(pgs) == 0 {
+		return nil, fmt.Errorf("group %q has no plugins (see '!plugin group add')", groupName)
+	}
+
+	rows := make([][]string, 0, len(pgs))
+	for _, pgr := range pgs {
+		n, err := detachPluginRef(evt, roomId, pgr.Plugin, "")
+		switch {
+		case err != nil:
+			rows = append(rows, []string{pgr.Plugin, fmt.Sprintf("failed: %s", err)})
+		case n > 0:
+			rows = append(rows, []string{pgr.Plugin, "detached"})
+		default:
+			rows = append(rows, []string{pgr.Plugin, "not attached"})
+		}
+	}
+
+	return rows, nil
+}
+
+// saveGroupPlugin implements "!plugin group save <group_name> --room
+// <room>": snapshots every plugin currently attached to room into
+// group_name, so a later "!plugin group attach" can reproduce this room's
+// configuration elsewhere.
+func saveGroupPlugin(c *cli.Context, evt *hal.Evt, groupName, room string) {
+	roomId := roomToId(evt, room)
+	pr := hal.PluginRegistry()
+
+	saved := 0
+	for _, inst := range pr.InstanceList() {
+		if inst.RoomId != roomId {
+			continue
+		}
+
+		pgr := PluginGroupRow{
+			Group:     groupName,
+			Plugin:    inst.Plugin.Name,
+			Timestamp: time.Now(),
+		}
+
+		if err := pgr.Save(); err != nil {
+			evt.Replyf("failed to save %q into group %q: %s", pgr.Plugin, pgr.Group, err)
+			return
+		}
+
+		saved++
+	}
+
+	evt.Replyf("saved %d plugin(s) from room %q into group %q", saved, roomId, groupName)
+}
+
+// installPlugin implements "!plugin install <ref> [--grant <hash>]". With
+// no --grant, it fetches ref's manifest and previews the privileges it
+// declares without installing anything. With a matching --grant, it
+// records the grant and installs -- see installPluginRef for the shared
+// logic behind both this and POST /v1/plugins.
+func installPlugin(c *cli.Context, evt *hal.Evt) {
+	args := c.Args()
+	if len(args) != 1 {
+		evt.Replyf("install requires exactly one argument, <ref>, got %d", len(args))
+		return
+	}
+
+	msg, err := installPluginRef(args[0], c.String("grant"), evt.From)
+	if err != nil {
+		evt.Error(err)
+		return
+	}
+
+	evt.Reply(msg)
+}
+
+// installPluginRef fetches the PluginManifest at ref and either previews
+// its declared Privileges (grant == "") or, when grant matches
+// Privileges.Hash(), records the grant as grantedBy and installs it --
+// mirroring the Docker plugin install flow of a separate privileges
+// preview before the pull is confirmed. The plugin named by the manifest
+// must already be compiled into this hal process (there's no dynamic code
+// loading here, only remote review/approval of a plugin's declared
+// access) -- installPluginRef refuses to proceed if it isn't.
+func installPluginRef(ref, grant, grantedBy string) (string, error) {
+	manifest, err := hal.FetchManifestURL(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch manifest from %q: %s", ref, err)
+	}
+
+	hash := manifest.Privileges.Hash()
+
+	if grant == "" {
+		return previewPrivileges(manifest, ref, hash), nil
+	}
+
+	if grant != hash {
+		return "", fmt.Errorf("privileges for %q have changed since they were last reviewed (hash was %s, is now %s) -- re-run '!plugin install %s' without --grant to review again", ref, grant, hash, ref)
+	}
+
+	plugin := hal.PluginRegistry().GetPlugin(manifest.Name)
+	if plugin == nil {
+		return "", fmt.Errorf("plugin %q named by %q isn't compiled into this hal build -- granting privileges can't load new code, only approve a plugin that's already present", manifest.Name, ref)
+	}
+
+	if err := manifest.Validate(plugin); err != nil {
+		return "", err
+	}
+
+	plugin.Manifest = manifest
+
+	if _, err := hal.PluginRegistry().InstallFromURL(ref, manifest.Digest); err != nil {
+		return "", fmt.Errorf("privileges granted, but recording the manifest failed: %s", err)
+	}
+
+	gpr := GrantedPluginRow{
+		Ref:            ref,
+		PrivilegesHash: hash,
+		GrantedBy:      grantedBy,
+		Timestamp:      time.Now(),
 	}
+	if err := gpr.Save(); err != nil {
+		return "", fmt.Errorf("privileges granted and installed, but recording the grant failed: %s", err)
+	}
+
+	hal.Events().Publish(hal.PluginEvent{
+		Type:      hal.PluginEnabled,
+		Plugin:    plugin,
+		ActorUser: grantedBy,
+	})
+
+	return fmt.Sprintf("granted and installed %q from %q (privileges hash %s)", manifest.Name, ref, hash), nil
+}
+
+// previewPrivileges renders the privileges a manifest declares, plus the
+// --grant hash that confirms a user reviewed exactly that set.
+func previewPrivileges(manifest *hal.PluginManifest, ref, hash string) string {
+	p := manifest.Privileges
+	rows := [][]string{
+		{"Brokers", strings.Join(p.Brokers, ", ")},
+		{"SQL access", fmt.Sprintf("%t", p.SQL)},
+		{"HTTP handler prefixes", strings.Join(p.HTTPPrefixes, ", ")},
+		{"External network hosts", strings.Join(p.NetworkHosts, ", ")},
+	}
+
+	out := hal.Utf8Table([]string{"Privilege", "Declared Access"}, rows)
+	out += fmt.Sprintf("\n\nTo accept these privileges and install %q, run:\n!plugin install %s --grant %s", manifest.Name, ref, hash)
+
+	return out
+}
+
+// upgradePlugin implements "!plugin upgrade <plugin> <new_ref>".
+func upgradePlugin(c *cli.Context, evt *hal.Evt) {
+	args := c.Args()
+	if len(args) != 2 {
+		evt.Replyf("upgrade requires exactly two arguments, <plugin> <new_ref>, got %d", len(args))
+		return
+	}
+
+	msg, err := upgradePluginRef(args[0], args[1], evt.From)
+	if err != nil {
+		evt.Error(err)
+		return
+	}
+
+	evt.Reply(msg)
+}
+
+// upgradePluginRef atomically replaces the registered plugin named name
+// with the one described by newRef's manifest, preserving every existing
+// instance's channel, regex, and settings: it snapshots the plugin's
+// instances, Unregisters them, swaps the *hal.Plugin via ReplacePlugin,
+// then re-Registers an instance per snapshot entry against the new
+// version -- rolling back to the old plugin and instances if any step
+// past the swap fails. newRef's privileges must already have been
+// granted via installPluginRef; if they've changed since that grant, the
+// upgrade refuses until the new privileges are reviewed and re-granted,
+// matching "docker plugin upgrade"'s behavior when a newer image asks
+// for more than the previous one did. actorUser identifies who requested
+// the upgrade, recorded on the PluginUpgraded event this publishes on
+// success.
+func upgradePluginRef(name, newRef, actorUser string) (string, error) {
+	pr := hal.PluginRegistry()
+
+	oldPlugin := pr.GetPlugin(name)
+	if oldPlugin == nil {
+		return "", fmt.Errorf("no plugin named %q is registered", name)
+	}
+
+	manifest, err := hal.FetchManifestURL(newRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch manifest from %q: %s", newRef, err)
+	}
+
+	if manifest.Name != name {
+		return "", fmt.Errorf("manifest at %q describes plugin %q, not %q", newRef, manifest.Name, name)
+	}
+
+	hash := manifest.Privileges.Hash()
+
+	granted, err := GetGrantedPlugin(newRef)
+	if err == dbsql.ErrNoRows {
+		return "", fmt.Errorf("%q hasn't been reviewed yet -- run '!plugin install %s' to review its privileges before upgrading", newRef, newRef)
+	} else if err != nil {
+		return "", fmt.Errorf("failed to check the grant for %q: %s", newRef, err)
+	} else if granted.PrivilegesHash != hash {
+		return "", fmt.Errorf("privileges for %q have changed since the last grant -- run '!plugin install %s' to review and re-grant before upgrading", newRef, newRef)
+	}
+
+	newPlugin := *oldPlugin
+	newPlugin.Manifest = manifest
+
+	instances := make([]*hal.Instance, 0)
+	for _, inst := range pr.InstanceList() {
+		if inst.Plugin.Name == name {
+			instances = append(instances, inst)
+		}
+	}
+
+	for _, inst := range instances {
+		if err := inst.Unregister(); err != nil {
+			return "", fmt.Errorf("aborting upgrade: failed to unregister instance in %q: %s", inst.ChannelId, err)
+		}
+	}
+
+	if err := pr.ReplacePlugin(name, &newPlugin); err != nil {
+		for _, inst := range instances {
+			inst.Register()
+		}
+		return "", fmt.Errorf("aborting upgrade: %s", err)
+	}
+
+	reRegistered := make([]*hal.Instance, 0, len(instances))
+	for _, old := range instances {
+		inst := newPlugin.Instance(old.ChannelId)
+		inst.Regex = old.Regex
+		inst.Settings = old.Settings
+
+		if err := inst.Register(); err != nil {
+			// rollback: drop whatever came up against the new plugin,
+			// swap the old plugin back in, and restore its instances
+			for _, ri := range reRegistered {
+				ri.Unregister()
+			}
+			pr.ReplacePlugin(name, oldPlugin)
+			for _, old := range instances {
+				old.Register()
+			}
+
+			return "", fmt.Errorf("aborting upgrade: failed to re-register instance in %q against the new version: %s", old.ChannelId, err)
+		}
+
+		reRegistered = append(reRegistered, inst)
+	}
+
+	hal.Events().Publish(hal.PluginEvent{
+		Type:      hal.PluginUpgraded,
+		Plugin:    &newPlugin,
+		ActorUser: actorUser,
+	})
+
+	return fmt.Sprintf("upgraded %q to the version described by %q, preserving %d instance(s)", name, newRef, len(instances)), nil
 }
 
 func GetPluginGroup(group string) (PluginGroup, error) {
@@ -415,12 +959,228 @@ func delGroupPlugin(c *cli.Context, evt *hal.Evt) {
 	}
 }
 
+// apiTokenKey is the pref key holding the bearer token required for
+// POST /v1/plugins (installing a plugin over REST, same access a remote
+// attacker would want). There's no default, so that endpoint is disabled
+// until a token is configured -- see checkAPIToken.
+const apiTokenKey = "pluginmgr-api-token"
+
+// pluginsPathPrefix is the path under which /v1/plugins serves
+// single-plugin requests (see pluginNameFromPath/httpPluginByName).
+const pluginsPathPrefix = "/v1/plugins/"
+
+// pluginGroupsPath is GET /v1/plugin-groups, the full plugin_groups list.
+const pluginGroupsPath = "/v1/plugin-groups"
+
+// pluginGroupsPathPrefix is the path under which /v1/plugin-groups serves
+// per-group requests, currently only .../{name}/attach (see
+// pluginGroupNameFromAttachPath/httpPluginGroupAttach).
+const pluginGroupsPathPrefix = "/v1/plugin-groups/"
+
+// httpPlugins is the http handler for pluginmgr's REST API:
+//
+//	GET  /v1/plugins                    - list registered plugins
+//	POST /v1/plugins                    - review or (with a "grant" field) install a
+//	                                       remote plugin manifest; JSON body {"ref": "...", "grant": "..."}
+//	PUT  /v1/plugins/{name}             - upgrade plugin {name} to the version
+//	                                       described by a JSON body {"ref": "..."}
+//	GET  /v1/plugin-groups              - list plugin_groups rows (see httpPluginGroups)
+//	POST /v1/plugin-groups/{name}/attach - attach every plugin in group {name} to a
+//	                                       room named by JSON body {"room": "..."}
+//
+// POST and PUT require "Authorization: Bearer <token>" matching the
+// pluginmgr-api-token pref.
 func httpPlugins(w http.ResponseWriter, r *http.Request) {
-	pr := hal.PluginRegistry()
-	plugins := pr.PluginList()
-	js, err := json.Marshal(plugins)
+	switch r.Method {
+	case http.MethodGet:
+		pr := hal.PluginRegistry()
+		plugins := pr.PluginList()
+		js, err := json.Marshal(plugins)
+		if err != nil {
+			log.Fatalf("Failed to marshal plugin list to JSON: %s", err)
+		}
+		w.Write(js)
+	case http.MethodPost:
+		httpInstallPlugin(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// httpInstallPlugin implements POST /v1/plugins.
+func httpInstallPlugin(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIToken(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Ref   string `json:"ref"`
+		Grant string `json:"grant"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	grantedBy := r.Header.Get("X-Hal-User")
+	if grantedBy == "" {
+		grantedBy = "http"
+	}
+
+	msg, err := installPluginRef(req.Ref, req.Grant, grantedBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"result": msg})
+}
+
+// pluginNameFromPath extracts the {name} from /v1/plugins/{name}.
+func pluginNameFromPath(path string) string {
+	return strings.TrimPrefix(path, pluginsPathPrefix)
+}
+
+// httpPluginByName implements PUT /v1/plugins/{name}, upgrading {name} to
+// the version described by a JSON body {"ref": "..."} -- see
+// upgradePluginRef.
+func httpPluginByName(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAPIToken(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := pluginNameFromPath(r.URL.Path)
+	if name == "" {
+		http.Error(w, "PUT requires /v1/plugins/{name}", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Ref string `json:"ref"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	actorUser := r.Header.Get("X-Hal-User")
+	if actorUser == "" {
+		actorUser = "http"
+	}
+
+	msg, err := upgradePluginRef(name, req.Ref, actorUser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"result": msg})
+}
+
+// httpPluginGroups implements GET /v1/plugin-groups: every plugin_groups
+// row, the same data "!plugin group list" renders as a table.
+func httpPluginGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pgs, err := GetPluginGroup("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(pgs)
 	if err != nil {
-		log.Fatalf("Failed to marshal plugin list to JSON: %s", err)
+		log.Fatalf("Failed to marshal plugin group list to JSON: %s", err)
 	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(js)
 }
+
+// pluginGroupNameFromAttachPath extracts {name} from
+// /v1/plugin-groups/{name}/attach, or "" if the path doesn't have that
+// shape.
+func pluginGroupNameFromAttachPath(path string) string {
+	name := strings.TrimPrefix(path, pluginGroupsPathPrefix)
+	name = strings.TrimSuffix(name, "/attach")
+
+	if name == path || name == "" {
+		return ""
+	}
+
+	return name
+}
+
+// httpPluginGroupAttach implements POST /v1/plugin-groups/{name}/attach,
+// JSON body {"room": "..."}: attaches every plugin in group {name} to
+// room, reporting a per-plugin status row the same way
+// "!plugin group attach" does -- see attachGroupToRoom. This is the REST
+// equivalent a config-management tool would use to drive a bulk rollout.
+func httpPluginGroupAttach(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAPIToken(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := pluginGroupNameFromAttachPath(r.URL.Path)
+	if name == "" {
+		http.Error(w, "POST requires /v1/plugin-groups/{name}/attach", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Room string `json:"room"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	actorUser := r.Header.Get("X-Hal-User")
+	if actorUser == "" {
+		actorUser = "http"
+	}
+
+	evt := &hal.Evt{RoomId: req.Room, From: actorUser}
+
+	rows, err := attachGroupToRoom(evt, name, req.Room)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rows)
+}
+
+// checkAPIToken requires a "Bearer <token>" Authorization header matching
+// the pluginmgr-api-token pref. The install/upgrade endpoints are
+// considered disabled (and every request rejected) until that pref is set.
+func checkAPIToken(r *http.Request) bool {
+	pref := hal.GetPref("", "", "", NAME, apiTokenKey, "")
+	if !pref.Success || pref.Value == "" {
+		return false
+	}
+
+	return r.Header.Get("Authorization") == "Bearer "+pref.Value
+}
@@ -18,8 +18,12 @@ package prefmgr
  */
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/netflix/hal-9001/hal"
 )
@@ -32,6 +36,15 @@ const HELP = `Listing keys with no filter will list all keys visible to the acti
 !prefs list --user USER --room CHANNEL --plugin PLUGIN --key KEY --def DEFAULT
 `
 
+// prefsPathPrefix is the path under which /v1/prefs serves single-record
+// operations, e.g. GET/DELETE /v1/prefs/42.
+const prefsPathPrefix = "/v1/prefs/"
+
+// apiTokenKey is the pref key checked by checkAPIToken. It's looked up with
+// no room/user/broker so it's set globally, e.g. "!pref set --plugin prefmgr
+// --key prefmgr-api-token --value <token>".
+const apiTokenKey = "prefmgr-api-token"
+
 var cli *hal.Cmd
 
 func init() {
@@ -84,7 +97,11 @@ func Register() {
 
 // prefmgr is called when someone executes !pref in the chat system
 func prefmgr(evt hal.Evt) {
-	req := cli.Process(evt.BodyAsArgv())
+	req, err := cli.Process(evt.BodyAsArgv())
+	if err != nil {
+		evt.Reply(err.Error())
+		return
+	}
 
 	switch req.SubCmdToken() {
 	case "set":
@@ -185,6 +202,153 @@ func cliRm(req *hal.CmdInst, evt *hal.Evt) {
 	}
 }
 
-// httpPrefs is the http handler for returning preferences as JSON
+// httpPrefs is the http handler for driving prefmgr over REST so that
+// external services (dashboards, config-sync tools, etc.) can manage hal's
+// preferences without going through chat:
+//
+//	GET    /v1/prefs?user=&room=&broker=&plugin=&key=  - list, filtered
+//	GET    /v1/prefs/{id}                              - a single record
+//	POST   /v1/prefs                                   - set a pref (JSON body)
+//	DELETE /v1/prefs/{id}                               - remove a pref
+//
+// Every request must carry "Authorization: Bearer <token>" matching the
+// prefmgr-api-token pref (see apiTokenKey/checkAPIToken). There's no default
+// token, so the API is disabled until one is configured.
 func httpPrefs(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIToken(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, hasId := prefIdFromPath(r.URL.Path)
+
+	switch r.Method {
+	case http.MethodGet:
+		if hasId {
+			httpGetPref(w, id)
+		} else {
+			httpListPrefs(w, r)
+		}
+	case http.MethodPost:
+		httpSetPref(w, r)
+	case http.MethodDelete:
+		if !hasId {
+			http.Error(w, "DELETE requires /v1/prefs/{id}", http.StatusBadRequest)
+			return
+		}
+		httpRmPref(w, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// checkAPIToken requires a "Bearer <token>" Authorization header matching
+// the prefmgr-api-token pref. The API is considered disabled (and every
+// request rejected) until that pref is set.
+func checkAPIToken(r *http.Request) bool {
+	pref := hal.GetPref("", "", "", NAME, apiTokenKey, "")
+	if !pref.Success || pref.Value == "" {
+		return false
+	}
+
+	return r.Header.Get("Authorization") == "Bearer "+pref.Value
+}
+
+// prefIdFromPath extracts the {id} from /v1/prefs/{id}, if present.
+func prefIdFromPath(path string) (id int, ok bool) {
+	if !strings.HasPrefix(path, prefsPathPrefix) {
+		return 0, false
+	}
+
+	idStr := strings.TrimPrefix(path, prefsPathPrefix)
+	if idStr == "" {
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// resolveWildcard mirrors cmd2pref's handling of '*' for the chat command.
+// REST requests have no originating room/user/broker to resolve '*'
+// against, so here it just means "no filter", i.e. FindPrefs' own wildcard
+// value.
+func resolveWildcard(v string) string {
+	if v == "*" {
+		return ""
+	}
+	return v
+}
+
+// httpListPrefs implements GET /v1/prefs?user=&room=&broker=&plugin=&key=
+func httpListPrefs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	prefs := hal.FindPrefs(
+		resolveWildcard(q.Get("user")),
+		resolveWildcard(q.Get("broker")),
+		resolveWildcard(q.Get("room")),
+		resolveWildcard(q.Get("plugin")),
+		resolveWildcard(q.Get("key")),
+	)
+
+	writeJSON(w, prefs)
+}
+
+// httpGetPref implements GET /v1/prefs/{id}
+func httpGetPref(w http.ResponseWriter, id int) {
+	for _, p := range hal.FindPrefs("", "", "", "", "") {
+		if p.Id == id {
+			writeJSON(w, p)
+			return
+		}
+	}
+
+	http.Error(w, "not found", http.StatusNotFound)
+}
+
+// httpSetPref implements POST /v1/prefs, setting a pref from a JSON body
+// shaped like hal.Pref.
+func httpSetPref(w http.ResponseWriter, r *http.Request) {
+	var p hal.Pref
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	p.Room = resolveWildcard(p.Room)
+	p.User = resolveWildcard(p.User)
+	p.Broker = resolveWildcard(p.Broker)
+	p.Plugin = resolveWildcard(p.Plugin)
+
+	if err := p.Set(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to set pref: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, p.GetPrefs())
+}
+
+// httpRmPref implements DELETE /v1/prefs/{id}
+func httpRmPref(w http.ResponseWriter, id int) {
+	if err := hal.RmPrefId(id); err != nil {
+		http.Error(w, fmt.Sprintf("failed to delete pref %d: %s", id, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON encodes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("prefmgr: failed to encode JSON response: %s", err)
+	}
 }
@@ -17,6 +17,11 @@ type RosterUser struct {
 	Timestamp time.Time `json: timestamp`
 }
 
+// ROSTER_TABLE is MySQL-specific DDL, passed to hal.GetStorage().Init() in
+// Register(). The mysql driver applies it as-is; a deployment running
+// hal.storage.driver=postgres or sqlite needs its own CREATE TABLE
+// statement with equivalent columns, and the etcd/boltdb drivers ignore it
+// entirely since they have no schema.
 const ROSTER_TABLE = `
 CREATE TABLE IF NOT EXISTS roster (
 	broker VARCHAR(64) NOT NULL,
@@ -45,28 +50,17 @@ func Register(gb hal.GenericBroker) {
 	}
 	rostercmd.Register()
 
-	hal.SqlInit(ROSTER_TABLE)
+	if err := hal.GetStorage().Init(ROSTER_TABLE); err != nil {
+		log.Printf("roster storage init failed: %s", err)
+	}
 
 	http.HandleFunc("/v1/roster", webroster)
 }
 
 // rostertracker is called for every message. It grabs the user and current
-// time and throws it into the db for later use.
+// time and throws it into storage for later use.
 func rostertracker(msg hal.Evt) {
-	db := hal.SqlDB()
-
-	sql := `INSERT INTO roster
-	          (broker, user, room, ts)
-	        VALUES (?,?,?,?)
-	        ON DUPLICATE KEY
-	        UPDATE broker=?, user=?, room=?, ts=?`
-
-	params := []interface{}{
-		msg.Broker.Name(), msg.User, msg.Room, msg.Time,
-		msg.Broker.Name(), msg.User, msg.Room, msg.Time,
-	}
-
-	_, err := db.Exec(sql, params...)
+	err := hal.GetStorage().UpsertRoster(msg.Broker.Name(), msg.User, msg.Room, msg.Time)
 	if err != nil {
 		log.Printf("roster_tracker write failed: %s", err)
 	}
@@ -109,35 +103,20 @@ func webroster(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetRoster() ([]*RosterUser, error) {
-	db := hal.SqlDB()
-
-	sql := `SELECT broker, user, room,
-	               UNIX_TIMESTAMP(ts) AS ts
-	               FROM roster
-	               ORDER BY ts DESC`
-
-	rows, err := db.Query(sql)
+	entries, err := hal.GetStorage().GetRoster()
 	if err != nil {
 		log.Printf("Roster query failed: %s\n", err)
 		return nil, err
 	}
-	defer rows.Close()
-
-	rus := []*RosterUser{}
-
-	for rows.Next() {
-		ru := RosterUser{}
 
-		var ts int64
-		err = rows.Scan(&ru.Broker, &ru.User, &ru.Room, &ts)
-		if err != nil {
-			log.Printf("Row iteration failed: %s\n", err)
-			return nil, err
+	rus := make([]*RosterUser, len(entries))
+	for i, e := range entries {
+		rus[i] = &RosterUser{
+			Broker:    e.Broker,
+			User:      e.User,
+			Room:      e.Room,
+			Timestamp: e.Timestamp,
 		}
-
-		ru.Timestamp = time.Unix(ts, 0)
-
-		rus = append(rus, &ru)
 	}
 
 	return rus, nil
@@ -0,0 +1,126 @@
+// Package tokenmgr exposes hal/httpauth's token minting as a chat command,
+// so an operator can hand out a narrow, revocable URL (e.g. an on-call
+// dashboard fetching the archive JSON) without touching hal.Secrets()
+// directly.
+package tokenmgr
+
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"strings"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+	"github.com/netflix/hal-9001/hal/httpauth"
+)
+
+// cli is the "!token issue ..." command tree. See init() and
+// tokenIssueCmd.
+var cli *hal.Cmd
+
+func init() {
+	cli = hal.NewCmd("token", true).SetUsage("mint scoped bearer tokens for hal's HTTP endpoints")
+
+	issue := cli.AddSubCmd("issue").SetUsage("issue a token, narrowed by any combination of the caveats below")
+	issue.AddKVParam("ttl", true).SetUsage("how long the token is valid, e.g. '1h', '30m'")
+	issue.AddKVParam("method", false).SetUsage("comma-separated allowed HTTP methods, e.g. 'GET' (default: any)")
+	issue.AddKVParam("path", false).SetUsage("comma-separated allowed path prefixes, e.g. '/v1/archive' (default: any)")
+	issue.AddKVParam("room", false).SetUsage("comma-separated allowed rooms (default: any)")
+	issue.AddKVParam("broker", false).SetUsage("comma-separated allowed brokers, e.g. 'slack' (default: any)")
+}
+
+// Register installs the "!token" command.
+func Register() {
+	cmd := hal.Plugin{
+		Name:  "token_issue",
+		Func:  tokenCmd,
+		Regex: "^[[:space:]]*!token",
+	}
+	cmd.Register()
+}
+
+// tokenCmd is called when someone executes !token in the chat system.
+func tokenCmd(evt hal.Evt) {
+	inst, err := cli.ProcessString(evt.Body)
+	if err != nil {
+		evt.Reply(err.Error())
+		return
+	}
+
+	if inst.HelpRequested() {
+		evt.Reply(inst.Help().String())
+		return
+	}
+
+	switch inst.SubCmdToken() {
+	case "issue":
+		tokenIssueCmd(evt, inst.SubCmdInst())
+	default:
+		evt.Reply(inst.Help().String())
+	}
+}
+
+// tokenIssueCmd implements "!token issue ..." by translating the parsed
+// parameters into httpauth.Caveats and replying with the signed token.
+func tokenIssueCmd(evt hal.Evt, sc *hal.SubCmdInst) {
+	ttlStr := kvParamString(sc, "ttl")
+
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil {
+		evt.Replyf("invalid --ttl %q: %s", ttlStr, err)
+		return
+	}
+
+	c := httpauth.Caveats{
+		Expiry:  time.Now().Add(ttl),
+		Methods: kvParamList(sc, "method"),
+		Paths:   kvParamList(sc, "path"),
+		Rooms:   kvParamList(sc, "room"),
+		Brokers: kvParamList(sc, "broker"),
+	}
+
+	token, err := httpauth.Issue(c)
+	if err != nil {
+		evt.Replyf("could not issue token: %s", err)
+		return
+	}
+
+	evt.Replyf("token (expires %s): %s", c.Expiry.Format(time.RFC3339), token)
+}
+
+// kvParamString returns key's value, or "" if it wasn't set -- sc's kv
+// params are all optional, so GetKVParamInst's panic-on-missing isn't
+// appropriate here.
+func kvParamString(sc *hal.SubCmdInst, key string) string {
+	if pi := sc.GetKVParamInstIfSet(key); pi != nil {
+		return pi.Value()
+	}
+
+	return ""
+}
+
+// kvParamList splits key's comma-separated value into a slice, or returns
+// nil if it wasn't set, matching httpauth.Caveats' "empty means no
+// restriction" convention.
+func kvParamList(sc *hal.SubCmdInst, key string) []string {
+	v := kvParamString(sc, key)
+	if v == "" {
+		return nil
+	}
+
+	return strings.Split(v, ",")
+}